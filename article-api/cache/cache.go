@@ -0,0 +1,394 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// Store is a key value store for serialized cache entries
+type Store interface {
+	// Get returns the value stored under key and whether it was found
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key for ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the given keys
+	Delete(ctx context.Context, keys ...string) error
+	// DeletePrefix removes every key starting with prefix
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "article_api",
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Cache lookups by kind of entry and result (hit, miss, or bypass for clients reading their writes).",
+	}, []string{"kind", "result"})
+
+	evictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "article_api",
+		Subsystem: "cache",
+		Name:      "evictions_total",
+		Help:      "Entries evicted from the in-process cache because it was full.",
+	})
+)
+
+// Kinds of cache entries, used as key prefixes and metric labels
+const (
+	kindArticle  = "article"
+	kindTag      = "tag"
+	kindRelated  = "related"
+	kindModified = "modified"
+)
+
+// Options configures the lifetime of cached entries
+type Options struct {
+	// how long an article is cached
+	ArticleTTL time.Duration
+	// how long tag summaries (article ids and related tags) are cached
+	TagTTL time.Duration
+	// max time of a load shared by concurrent misses, which outlives the
+	// requests waiting for it
+	LoadTimeout time.Duration
+}
+
+// ArticlesCache is a data.ArticlesData that serves reads from a Store,
+// falling back to the wrapped backend on a miss, and invalidates the
+// affected entries on writes
+type ArticlesCache struct {
+	next  data.ArticlesData
+	store Store
+	l     *zap.Logger
+	o     Options
+	group singleflight.Group
+}
+
+// pinner is implemented by backends sending the reads of the clients who
+// just wrote to the primary, like data.ArticlesDb with replicas. Entries may
+// have been filled from a replica that has not caught up with the write, so
+// those reads bypass them.
+type pinner interface {
+	Pinned(ctx context.Context) bool
+}
+
+// pinned reports whether the reads of the client in ctx must go to the
+// backend
+func (c *ArticlesCache) pinned(ctx context.Context) bool {
+	p, ok := c.next.(pinner)
+	return ok && p.Pinned(ctx)
+}
+
+// New wraps next with a cache kept in store
+func New(l *zap.Logger, next data.ArticlesData, store Store, o Options) *ArticlesCache {
+	return &ArticlesCache{next: next, store: store, l: l, o: o}
+}
+
+// lookup returns the entry stored under key into v, or loads it with load
+// and stores it. Concurrent misses for the same key share a single load,
+// which is not cancelled with the request that started it, the others
+// still waiting for it, but times out after LoadTimeout. Each request stops
+// waiting once its own context is done. Pinned clients load on their own
+// from the primary, and refresh the entry with what they read.
+func (c *ArticlesCache) lookup(ctx context.Context, kind string, key string, ttl time.Duration, v interface{}, load func(ctx context.Context) (interface{}, error)) error {
+	if c.pinned(ctx) {
+		requests.WithLabelValues(kind, "bypass").Inc()
+		res, err := load(ctx)
+		if err != nil {
+			return err
+		}
+		b, err := c.keep(ctx, key, ttl, res)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, v)
+	}
+	if c.cached(ctx, kind, key, v) {
+		return nil
+	}
+
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(detached{ctx}, c.o.LoadTimeout)
+		defer cancel()
+		res, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return c.keep(ctx, key, ttl, res)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return res.Err
+		}
+		return json.Unmarshal(res.Val.([]byte), v)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// detached is a context with the values of its parent, such as its logger
+// and trace, but neither its deadline nor its cancellation
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+
+// cached reads the entry stored under key into v and reports whether it was
+// found, never for pinned clients
+func (c *ArticlesCache) cached(ctx context.Context, kind string, key string, v interface{}) bool {
+	if c.pinned(ctx) {
+		requests.WithLabelValues(kind, "bypass").Inc()
+		return false
+	}
+	b, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		logging.FromContext(ctx, c.l).Warn("Cache lookup failed", zap.String("key", key), zap.Error(err))
+	}
+	if ok && json.Unmarshal(b, v) == nil {
+		requests.WithLabelValues(kind, "hit").Inc()
+		return true
+	}
+	requests.WithLabelValues(kind, "miss").Inc()
+	return false
+}
+
+// keep stores v under key for ttl and returns it serialized
+func (c *ArticlesCache) keep(ctx context.Context, key string, ttl time.Duration, v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.store.Set(ctx, key, b, ttl); err != nil {
+		logging.FromContext(ctx, c.l).Warn("Cache store failed", zap.String("key", key), zap.Error(err))
+	}
+	return b, nil
+}
+
+func articleKey(id int) string {
+	return kindArticle + ":" + strconv.Itoa(id)
+}
+
+func tagKey(tag string, date string) string {
+	return kindTag + ":" + tag + ":" + date
+}
+
+func modifiedKey(tag string, date string) string {
+	return kindModified + ":" + tag + ":" + date
+}
+
+func relatedPrefix(tag string) string {
+	return kindRelated + ":" + tag + ":"
+}
+
+// relatedKey identifies the related tags of tag across the given articles,
+// the ids are hashed to keep keys short
+func relatedKey(tag string, articles []int) string {
+	ids := make([]string, len(articles))
+	for i, id := range articles {
+		ids[i] = strconv.Itoa(id)
+	}
+	sum := sha1.Sum([]byte(strings.Join(ids, ",")))
+	return relatedPrefix(tag) + hex.EncodeToString(sum[:])
+}
+
+func (c *ArticlesCache) GetArticleByID(ctx context.Context, id int) (*data.Article, error) {
+	a := &data.Article{}
+	err := c.lookup(ctx, kindArticle, articleKey(id), c.o.ArticleTTL, a, func(ctx context.Context) (interface{}, error) {
+		return c.next.GetArticleByID(ctx, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetArticlesByIDs serves the cached articles and loads the others from the
+// backend in a single call, caching them
+func (c *ArticlesCache) GetArticlesByIDs(ctx context.Context, ids []int) ([]data.Article, error) {
+	found := map[int]data.Article{}
+	var missing []int
+	for _, id := range ids {
+		var a data.Article
+		if c.cached(ctx, kindArticle, articleKey(id), &a) {
+			found[id] = a
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		as, err := c.next.GetArticlesByIDs(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range as {
+			found[a.ID] = a
+			c.keep(ctx, articleKey(a.ID), c.o.ArticleTTL, a)
+		}
+	}
+
+	as := make([]data.Article, 0, len(found))
+	for _, a := range found {
+		as = append(as, a)
+	}
+	sort.Slice(as, func(i, j int) bool { return as[i].ID < as[j].ID })
+	return as, nil
+}
+
+func (c *ArticlesCache) GetArticlesForTagAndDate(ctx context.Context, tag string, date string) ([]int, error) {
+	t := &data.Tag{}
+	err := c.lookup(ctx, kindTag, tagKey(tag, date), c.o.TagTTL, t, func(ctx context.Context) (interface{}, error) {
+		ids, err := c.next.GetArticlesForTagAndDate(ctx, tag, date)
+		if err != nil {
+			return nil, err
+		}
+		return &data.Tag{Tag: tag, Count: len(ids), Articles: ids}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.Articles, nil
+}
+
+// GetArticlesForTagsAndDates serves the cached tag summaries and loads the
+// others from the backend in a single call, caching them
+func (c *ArticlesCache) GetArticlesForTagsAndDates(ctx context.Context, keys []data.TagDate) ([][]int, error) {
+	ids := make([][]int, len(keys))
+	var missing []data.TagDate
+	var at []int
+	for i, k := range keys {
+		t := &data.Tag{}
+		if c.cached(ctx, kindTag, tagKey(k.Tag, k.Date), t) {
+			ids[i] = t.Articles
+		} else {
+			missing = append(missing, k)
+			at = append(at, i)
+		}
+	}
+	if len(missing) > 0 {
+		loaded, err := c.next.GetArticlesForTagsAndDates(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for j, k := range missing {
+			ids[at[j]] = loaded[j]
+			c.keep(ctx, tagKey(k.Tag, k.Date), c.o.TagTTL, &data.Tag{Tag: k.Tag, Count: len(loaded[j]), Articles: loaded[j]})
+		}
+	}
+	return ids, nil
+}
+
+func (c *ArticlesCache) GetRelatedTagsForTag(ctx context.Context, tag string, articles []int) ([]string, error) {
+	t := &data.Tag{}
+	err := c.lookup(ctx, kindRelated, relatedKey(tag, articles), c.o.TagTTL, t, func(ctx context.Context) (interface{}, error) {
+		related, err := c.next.GetRelatedTagsForTag(ctx, tag, articles)
+		if err != nil {
+			return nil, err
+		}
+		return &data.Tag{Tag: tag, RelatedTags: related}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t.RelatedTags, nil
+}
+
+func (c *ArticlesCache) GetTagLastModified(ctx context.Context, tag string, date string) (time.Time, error) {
+	var t time.Time
+	err := c.lookup(ctx, kindModified, modifiedKey(tag, date), c.o.TagTTL, &t, func(ctx context.Context) (interface{}, error) {
+		return c.next.GetTagLastModified(ctx, tag, date)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t, nil
+}
+
+// AddArticle adds the article to the backend and drops the tag summaries
+// of every tag of the article
+func (c *ArticlesCache) AddArticle(ctx context.Context, ar data.Article) error {
+	err := c.next.AddArticle(ctx, ar)
+	if err != nil {
+		return err
+	}
+	c.invalidate(ctx, ar)
+	return nil
+}
+
+// AddArticles adds the articles to the backend and drops the cached entries
+// of every one of them
+func (c *ArticlesCache) AddArticles(ctx context.Context, ars []data.Article) ([]int, error) {
+	ids, err := c.next.AddArticles(ctx, ars)
+	if err != nil {
+		return nil, err
+	}
+	for i, ar := range ars {
+		ar.ID = ids[i]
+		c.invalidate(ctx, ar)
+	}
+	return ids, nil
+}
+
+// ExportArticles streams the articles from the backend, exports are not
+// cached
+func (c *ArticlesCache) ExportArticles(ctx context.Context, f data.ExportFilter, fn func(data.Article) error) error {
+	return c.next.ExportArticles(ctx, f, fn)
+}
+
+// ImportArticles imports the articles into the backend and drops every
+// cached entry, since imports may replace any article
+func (c *ArticlesCache) ImportArticles(ctx context.Context, next func() (data.Article, error)) (int, error) {
+	n, err := c.next.ImportArticles(ctx, next)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.store.DeletePrefix(ctx, ""); err != nil {
+		logging.FromContext(ctx, c.l).Warn("Cache invalidation failed", zap.Error(err))
+	}
+	return n, nil
+}
+
+// invalidate drops the cached entries that change when ar is written
+func (c *ArticlesCache) invalidate(ctx context.Context, ar data.Article) {
+	l := logging.FromContext(ctx, c.l)
+
+	var keys []string
+	if ar.ID != 0 {
+		keys = append(keys, articleKey(ar.ID))
+	}
+
+	// tag summaries are looked up by date as YYYYMMDD
+	date := ar.Date
+	if d, err := time.Parse("2006-01-02", ar.Date); err == nil {
+		date = d.Format("20060102")
+	}
+	for _, t := range ar.Tags {
+		keys = append(keys, tagKey(t, date), modifiedKey(t, date))
+		if err := c.store.DeletePrefix(ctx, relatedPrefix(t)); err != nil {
+			l.Warn("Cache invalidation failed", zap.String("tag", t), zap.Error(err))
+		}
+	}
+
+	if err := c.store.Delete(ctx, keys...); err != nil {
+		l.Warn("Cache invalidation failed", zap.Strings("keys", keys), zap.Error(err))
+	}
+}
+
+func (c *ArticlesCache) Close() {
+	c.next.Close()
+}
@@ -0,0 +1,325 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+var testOptions = Options{ArticleTTL: time.Minute, TagTTL: time.Minute, LoadTimeout: time.Second}
+
+func TestArticlesCacheHit(t *testing.T) {
+	article := &data.Article{ID: 1, Title: "Article1", Body: "Body", Date: "2023-04-05", Tags: []string{"health"}}
+
+	mockdb := new(mocks.ArticlesData)
+	mockdb.On("GetArticleByID", mock.Anything, 1).Return(article, nil).Once()
+	mockdb.On("GetArticlesForTagAndDate", mock.Anything, "health", "20230405").Return([]int{1, 2}, nil).Once()
+	mockdb.On("GetRelatedTagsForTag", mock.Anything, "health", []int{1, 2}).Return([]string{"fitness"}, nil).Once()
+
+	c := New(zap.NewNop(), mockdb, NewLRU(100), testOptions)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		a, err := c.GetArticleByID(ctx, 1)
+		if err != nil || !reflect.DeepEqual(a, article) {
+			t.Errorf("expected article %v but got %v, %v", article, a, err)
+		}
+		ids, err := c.GetArticlesForTagAndDate(ctx, "health", "20230405")
+		if err != nil || !reflect.DeepEqual(ids, []int{1, 2}) {
+			t.Errorf("expected ids [1 2] but got %v, %v", ids, err)
+		}
+		related, err := c.GetRelatedTagsForTag(ctx, "health", []int{1, 2})
+		if err != nil || !reflect.DeepEqual(related, []string{"fitness"}) {
+			t.Errorf("expected related tags [fitness] but got %v, %v", related, err)
+		}
+	}
+
+	// every backend call was made exactly once
+	mockdb.AssertExpectations(t)
+}
+
+func TestArticlesCacheInvalidation(t *testing.T) {
+	article := data.Article{Title: "Article3", Body: "Body", Date: "2023-04-05", Tags: []string{"health", "yoga"}}
+
+	mockdb := new(mocks.ArticlesData)
+	mockdb.On("GetArticlesForTagAndDate", mock.Anything, "health", "20230405").Return([]int{1}, nil).Once()
+	mockdb.On("GetArticlesForTagAndDate", mock.Anything, "science", "20230405").Return([]int{2}, nil).Once()
+	mockdb.On("GetRelatedTagsForTag", mock.Anything, "health", []int{1}).Return([]string{"fitness"}, nil).Once()
+	mockdb.On("AddArticle", mock.Anything, article).Return(nil)
+	mockdb.On("GetArticlesForTagAndDate", mock.Anything, "health", "20230405").Return([]int{1, 3}, nil).Once()
+	mockdb.On("GetRelatedTagsForTag", mock.Anything, "health", []int{1}).Return([]string{"fitness", "yoga"}, nil).Once()
+
+	c := New(zap.NewNop(), mockdb, NewLRU(100), testOptions)
+	ctx := context.Background()
+
+	c.GetArticlesForTagAndDate(ctx, "health", "20230405")
+	c.GetArticlesForTagAndDate(ctx, "science", "20230405")
+	c.GetRelatedTagsForTag(ctx, "health", []int{1})
+
+	if err := c.AddArticle(ctx, article); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tags of the new article are reloaded, other tags are still cached
+	ids, _ := c.GetArticlesForTagAndDate(ctx, "health", "20230405")
+	if !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Errorf("expected reloaded ids [1 3] but got %v", ids)
+	}
+	related, _ := c.GetRelatedTagsForTag(ctx, "health", []int{1})
+	if !reflect.DeepEqual(related, []string{"fitness", "yoga"}) {
+		t.Errorf("expected reloaded related tags but got %v", related)
+	}
+	c.GetArticlesForTagAndDate(ctx, "science", "20230405")
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestArticlesCacheBulkInvalidation(t *testing.T) {
+	articles := []data.Article{
+		{Title: "Article4", Body: "Body", Date: "2023-04-05", Tags: []string{"health"}},
+		{Title: "Article5", Body: "Body", Date: "2023-04-06", Tags: []string{"science"}},
+	}
+
+	mockdb := new(mocks.ArticlesData)
+	mockdb.On("GetArticlesForTagAndDate", mock.Anything, "health", "20230405").Return([]int{1}, nil).Once()
+	mockdb.On("GetArticlesForTagAndDate", mock.Anything, "science", "20230405").Return([]int{2}, nil).Once()
+	mockdb.On("AddArticles", mock.Anything, articles).Return([]int{4, 5}, nil)
+	mockdb.On("GetArticlesForTagAndDate", mock.Anything, "health", "20230405").Return([]int{1, 4}, nil).Once()
+
+	c := New(zap.NewNop(), mockdb, NewLRU(100), testOptions)
+	ctx := context.Background()
+
+	c.GetArticlesForTagAndDate(ctx, "health", "20230405")
+	c.GetArticlesForTagAndDate(ctx, "science", "20230405")
+
+	ids, err := c.AddArticles(ctx, articles)
+	if err != nil || !reflect.DeepEqual(ids, []int{4, 5}) {
+		t.Fatalf("expected ids [4 5] but got %v, %v", ids, err)
+	}
+
+	// only the summaries of the tags and dates written are reloaded
+	ids, _ = c.GetArticlesForTagAndDate(ctx, "health", "20230405")
+	if !reflect.DeepEqual(ids, []int{1, 4}) {
+		t.Errorf("expected reloaded ids [1 4] but got %v", ids)
+	}
+	c.GetArticlesForTagAndDate(ctx, "science", "20230405")
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestArticlesCacheBatch(t *testing.T) {
+	article1 := &data.Article{ID: 1, Title: "Article1", Date: "2023-04-05", Tags: []string{"health"}}
+	article2 := data.Article{ID: 2, Title: "Article2", Date: "2023-04-05", Tags: []string{"science"}}
+	health := data.TagDate{Tag: "health", Date: "20230405"}
+	science := data.TagDate{Tag: "science", Date: "20230405"}
+
+	mockdb := new(mocks.ArticlesData)
+	mockdb.On("GetArticleByID", mock.Anything, 1).Return(article1, nil).Once()
+	mockdb.On("GetArticlesForTagAndDate", mock.Anything, "health", "20230405").Return([]int{1}, nil).Once()
+	// only the entries missing from the cache are loaded, in one call
+	mockdb.On("GetArticlesByIDs", mock.Anything, []int{2, 3}).Return([]data.Article{article2}, nil).Once()
+	mockdb.On("GetArticlesForTagsAndDates", mock.Anything, []data.TagDate{science}).Return([][]int{{2}}, nil).Once()
+	// article 3 does not exist, so it is looked up again
+	mockdb.On("GetArticlesByIDs", mock.Anything, []int{3}).Return([]data.Article{}, nil).Once()
+
+	c := New(zap.NewNop(), mockdb, NewLRU(100), testOptions)
+	ctx := context.Background()
+	c.GetArticleByID(ctx, 1)
+	c.GetArticlesForTagAndDate(ctx, "health", "20230405")
+
+	for i := 0; i < 2; i++ {
+		as, err := c.GetArticlesByIDs(ctx, []int{2, 1, 3})
+		if err != nil || len(as) != 2 || as[0].ID != 1 || as[1].ID != 2 {
+			t.Errorf("expected articles 1 and 2 but got %v, %v", as, err)
+		}
+		ids, err := c.GetArticlesForTagsAndDates(ctx, []data.TagDate{science, health})
+		if err != nil || !reflect.DeepEqual(ids, [][]int{{2}, {1}}) {
+			t.Errorf("expected ids [[2] [1]] but got %v, %v", ids, err)
+		}
+	}
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestArticlesCacheSingleflight(t *testing.T) {
+	article := &data.Article{ID: 1, Title: "Article1"}
+
+	mockdb := new(mocks.ArticlesData)
+	mockdb.On("GetArticleByID", mock.Anything, 1).After(50*time.Millisecond).Return(article, nil).Once()
+
+	c := New(zap.NewNop(), mockdb, NewLRU(100), testOptions)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetArticleByID(context.Background(), 1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestArticlesCacheSingleflightCancel(t *testing.T) {
+	article := &data.Article{ID: 1, Title: "Article1"}
+	release := make(chan struct{})
+	loaded := make(chan error, 1)
+
+	mockdb := new(mocks.ArticlesData)
+	mockdb.On("GetArticleByID", mock.Anything, 1).Return(
+		func(ctx context.Context, id int) (*data.Article, error) {
+			<-release
+			_, deadline := ctx.Deadline()
+			if !deadline {
+				loaded <- errors.New("the load has no deadline")
+			}
+			loaded <- ctx.Err()
+			return article, nil
+		}).Once()
+
+	c := New(zap.NewNop(), mockdb, NewLRU(100), testOptions)
+
+	// the first request starts the load and goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.GetArticleByID(ctx, 1)
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	second := make(chan error, 1)
+	go func() {
+		a, err := c.GetArticleByID(context.Background(), 1)
+		if err == nil && a.Title != article.Title {
+			err = errors.New("got article " + a.Title)
+		}
+		second <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("Expected the cancelled request to stop waiting, got %v", err)
+	}
+	close(release)
+	if err := <-loaded; err != nil {
+		t.Errorf("Expected the load to outlive the first request, got %v", err)
+	}
+	if err := <-second; err != nil {
+		t.Errorf("Expected the waiting request to get the article, got %v", err)
+	}
+	mockdb.AssertExpectations(t)
+}
+
+type clientKey struct{}
+
+// pinnedBackend pins the clients who write to the primary, like
+// data.ArticlesDb with replicas, the reads of the others going to a replica
+type pinnedBackend struct {
+	*mocks.ArticlesData
+	mu     sync.Mutex
+	pinned map[string]bool
+}
+
+func (b *pinnedBackend) AddArticle(ctx context.Context, ar data.Article) error {
+	b.mu.Lock()
+	b.pinned[ctx.Value(clientKey{}).(string)] = true
+	b.mu.Unlock()
+	return b.ArticlesData.AddArticle(ctx, ar)
+}
+
+func (b *pinnedBackend) Pinned(ctx context.Context) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pinned[ctx.Value(clientKey{}).(string)]
+}
+
+// TestArticlesCachePinned has a client read its write after another client
+// cached what a lagging replica returned
+func TestArticlesCachePinned(t *testing.T) {
+	article := data.Article{Title: "Article3", Body: "Body", Date: "2023-04-05", Tags: []string{"health"}}
+	writer := context.WithValue(context.Background(), clientKey{}, "writer")
+	other := context.WithValue(context.Background(), clientKey{}, "other")
+	fromClient := func(client string) interface{} {
+		return mock.MatchedBy(func(ctx context.Context) bool { return ctx.Value(clientKey{}) == client })
+	}
+	health := []data.TagDate{{Tag: "health", Date: "20230405"}}
+
+	mockdb := new(mocks.ArticlesData)
+	mockdb.On("AddArticle", mock.Anything, article).Return(nil).Once()
+	// the replica has not caught up with the write yet
+	mockdb.On("GetArticlesForTagAndDate", fromClient("other"), "health", "20230405").Return([]int{1}, nil).Once()
+	// the primary has
+	mockdb.On("GetArticlesForTagAndDate", fromClient("writer"), "health", "20230405").Return([]int{1, 3}, nil).Once()
+	mockdb.On("GetArticlesForTagsAndDates", fromClient("writer"), health).Return([][]int{{1, 3}}, nil).Once()
+
+	c := New(zap.NewNop(), &pinnedBackend{ArticlesData: mockdb, pinned: map[string]bool{}}, NewLRU(100), testOptions)
+
+	if err := c.AddArticle(writer, article); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := c.GetArticlesForTagAndDate(other, "health", "20230405"); !reflect.DeepEqual(ids, []int{1}) {
+		t.Fatalf("expected the replica's ids [1] but got %v", ids)
+	}
+
+	// the writer reads its write, from the primary rather than the cache
+	if ids, _ := c.GetArticlesForTagAndDate(writer, "health", "20230405"); !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Errorf("expected the writer to read ids [1 3] but got %v", ids)
+	}
+	if ids, _ := c.GetArticlesForTagsAndDates(writer, health); !reflect.DeepEqual(ids, [][]int{{1, 3}}) {
+		t.Errorf("expected the writer to read ids [[1 3]] but got %v", ids)
+	}
+
+	// and refreshed the entry for the others
+	if ids, _ := c.GetArticlesForTagAndDate(other, "health", "20230405"); !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Errorf("expected the refreshed ids [1 3] but got %v", ids)
+	}
+
+	mockdb.AssertExpectations(t)
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	c := NewLRU(2)
+	c.now = func() time.Time { return now }
+
+	c.Set(ctx, "a", []byte("1"), 0)
+	c.Set(ctx, "b", []byte("2"), time.Second)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("3"), 0)
+
+	// b was the least recently used entry
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Errorf("expected b to be evicted")
+	}
+	if _, ok, _ := c.Get(ctx, "a"); !ok {
+		t.Errorf("expected a to be kept")
+	}
+
+	c.Set(ctx, "d", []byte("4"), time.Second)
+	now = now.Add(2 * time.Second)
+	if _, ok, _ := c.Get(ctx, "d"); ok {
+		t.Errorf("expected d to be expired")
+	}
+
+	c.Set(ctx, "related:x:1", []byte("1"), 0)
+	c.DeletePrefix(ctx, "related:x:")
+	if _, ok, _ := c.Get(ctx, "related:x:1"); ok {
+		t.Errorf("expected prefix to be deleted")
+	}
+}
@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/redis"
+	"go.uber.org/zap"
+)
+
+// RedisStore is a Store kept in a Redis protocol server shared by every
+// replica of the API
+type RedisStore struct {
+	c      *redis.Client
+	prefix string
+}
+
+// NewRedisStore creates a store keeping its entries under keys starting with prefix
+func NewRedisStore(c *redis.Client, prefix string) *RedisStore {
+	return &RedisStore{c: c, prefix: prefix}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	v, err := redis.String(s.c.Do(ctx, "GET", s.prefix+key))
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(v), true, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []interface{}{"SET", s.prefix + key, value}
+	if ms := ttl.Milliseconds(); ms > 0 {
+		args = append(args, "PX", ms)
+	}
+	_, err := s.c.Do(ctx, args...)
+	return err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := []interface{}{"DEL"}
+	for _, k := range keys {
+		args = append(args, s.prefix+k)
+	}
+	_, err := s.c.Do(ctx, args...)
+	return err
+}
+
+// DeletePrefix scans for the matching keys and deletes them batch by batch
+func (s *RedisStore) DeletePrefix(ctx context.Context, prefix string) error {
+	cursor := "0"
+	for {
+		reply, err := s.c.Do(ctx, "SCAN", cursor, "MATCH", s.prefix+prefix+"*", "COUNT", 100)
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return redis.Error("unexpected SCAN reply")
+		}
+		cursor, _ = parts[0].(string)
+		keys, _ := parts[1].([]interface{})
+
+		if len(keys) != 0 {
+			if _, err := s.c.Do(ctx, append([]interface{}{"DEL"}, keys...)...); err != nil {
+				return err
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// invalidation is broadcast to every replica when entries are deleted. All
+// is set when every entry is, since the empty prefix is omitted.
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+	All    bool     `json:"all,omitempty"`
+}
+
+// Tiered is a Store keeping entries in a local store in front of a shared
+// one. Deletions are applied to both and broadcast over a Redis channel so
+// that every replica drops the entries from its local store too.
+type Tiered struct {
+	local    Store
+	shared   Store
+	localTTL time.Duration
+	c        *redis.Client
+	channel  string
+	id       string
+	l        *zap.Logger
+}
+
+// NewTiered creates a tiered store and starts listening for invalidations
+// from other replicas until ctx is done. Entries found in the shared store
+// are kept locally for at most localTTL.
+func NewTiered(ctx context.Context, l *zap.Logger, local Store, shared Store, localTTL time.Duration, c *redis.Client, channel string) (*Tiered, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	t := &Tiered{
+		local:    local,
+		shared:   shared,
+		localTTL: localTTL,
+		c:        c,
+		channel:  channel,
+		id:       hex.EncodeToString(id),
+		l:        l,
+	}
+	if err := c.Subscribe(ctx, channel, t.receive); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Tiered) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if v, ok, _ := t.local.Get(ctx, key); ok {
+		return v, true, nil
+	}
+	v, ok, err := t.shared.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	t.local.Set(ctx, key, v, t.localTTL)
+	return v, true, nil
+}
+
+func (t *Tiered) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	localTTL := t.localTTL
+	if ttl > 0 && ttl < localTTL {
+		localTTL = ttl
+	}
+	t.local.Set(ctx, key, value, localTTL)
+	return t.shared.Set(ctx, key, value, ttl)
+}
+
+func (t *Tiered) Delete(ctx context.Context, keys ...string) error {
+	t.local.Delete(ctx, keys...)
+	if err := t.shared.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	return t.broadcast(ctx, invalidation{Origin: t.id, Keys: keys})
+}
+
+func (t *Tiered) DeletePrefix(ctx context.Context, prefix string) error {
+	t.local.DeletePrefix(ctx, prefix)
+	if err := t.shared.DeletePrefix(ctx, prefix); err != nil {
+		return err
+	}
+	return t.broadcast(ctx, invalidation{Origin: t.id, Prefix: prefix, All: prefix == ""})
+}
+
+func (t *Tiered) broadcast(ctx context.Context, inv invalidation) error {
+	b, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	_, err = t.c.Do(ctx, "PUBLISH", t.channel, b)
+	return err
+}
+
+// receive applies an invalidation broadcast by another replica
+func (t *Tiered) receive(payload string) {
+	var inv invalidation
+	if err := json.Unmarshal([]byte(payload), &inv); err != nil {
+		t.l.Warn("Ignoring malformed cache invalidation", zap.Error(err))
+		return
+	}
+	if inv.Origin == t.id {
+		return
+	}
+
+	ctx := context.Background()
+	if len(inv.Keys) != 0 {
+		t.local.Delete(ctx, inv.Keys...)
+	}
+	if inv.Prefix != "" || inv.All {
+		t.local.DeletePrefix(ctx, inv.Prefix)
+	}
+}
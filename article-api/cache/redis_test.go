@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/sg83/go-microservice/article-api/redis"
+	"github.com/sg83/go-microservice/article-api/redis/redistest"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func TestRedisStore(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c := redis.New(redis.Options{Addr: s.Addr()})
+	defer c.Close()
+	store := NewRedisStore(c, "test:")
+	ctx := context.Background()
+
+	store.Set(ctx, "article:1", []byte(`{"id":1}`), time.Minute)
+	store.Set(ctx, "related:health:abc", []byte(`{}`), time.Minute)
+	store.Set(ctx, "related:science:abc", []byte(`{}`), time.Minute)
+
+	v, ok, err := store.Get(ctx, "article:1")
+	if err != nil || !ok || string(v) != `{"id":1}` {
+		t.Errorf("expected stored article but got %q, %v, %v", v, ok, err)
+	}
+
+	if err := store.DeletePrefix(ctx, "related:health:"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "article:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := s.Keys()
+	if len(keys) != 1 || keys[0] != "test:related:science:abc" {
+		t.Errorf("expected only the unrelated key to be left but got %v", keys)
+	}
+}
+
+// TestTieredInvalidation runs two replicas sharing a server, a write on one
+// must drop the entry cached locally by the other
+func TestTieredInvalidation(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newReplica := func(db data.ArticlesData) *ArticlesCache {
+		c := redis.New(redis.Options{Addr: s.Addr()})
+		t.Cleanup(func() { c.Close() })
+		tiered, err := NewTiered(ctx, zap.NewNop(), NewLRU(100), NewRedisStore(c, "test:"), time.Minute, c, "invalidate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return New(zap.NewNop(), db, tiered, testOptions)
+	}
+
+	article := data.Article{Title: "Article3", Body: "Body", Date: "2023-04-05", Tags: []string{"health"}}
+
+	db1 := new(mocks.ArticlesData)
+	db1.On("GetArticlesForTagAndDate", mock.Anything, "health", "20230405").Return([]int{1}, nil).Once()
+	db1.On("GetArticlesForTagAndDate", mock.Anything, "health", "20230405").Return([]int{1, 3}, nil).Once()
+	db2 := new(mocks.ArticlesData)
+	db2.On("AddArticle", mock.Anything, article).Return(nil)
+
+	r1 := newReplica(db1)
+	r2 := newReplica(db2)
+
+	// replica 1 caches the summary locally and in the shared store
+	if ids, _ := r1.GetArticlesForTagAndDate(ctx, "health", "20230405"); len(ids) != 1 {
+		t.Fatalf("expected 1 article but got %v", ids)
+	}
+
+	// replica 2 writes, replica 1 must reload
+	if err := r2.AddArticle(ctx, article); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ids, _ := r1.GetArticlesForTagAndDate(ctx, "health", "20230405")
+		if len(ids) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stale entry was not invalidated, got %v", ids)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	db1.AssertExpectations(t)
+	db2.AssertExpectations(t)
+}
+
+// TestTieredInvalidateAll runs two tiered stores sharing a server, deleting
+// every entry on one must empty the local store of the other
+func TestTieredInvalidateAll(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newTiered := func(local Store) *Tiered {
+		c := redis.New(redis.Options{Addr: s.Addr()})
+		t.Cleanup(func() { c.Close() })
+		tiered, err := NewTiered(ctx, zap.NewNop(), local, NewRedisStore(c, "test:"), time.Minute, c, "invalidate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tiered
+	}
+
+	local2 := NewLRU(100)
+	t1, t2 := newTiered(NewLRU(100)), newTiered(local2)
+
+	if err := t1.Set(ctx, "article:1", []byte(`{"id":1}`), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// t2 keeps the entry found in the shared store locally
+	if _, ok, _ := t2.Get(ctx, "article:1"); !ok {
+		t.Fatal("expected the entry in the shared store")
+	}
+
+	if err := t1.DeletePrefix(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok, _ := local2.Get(ctx, "article:1"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the local entry was not invalidated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
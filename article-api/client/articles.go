@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/export"
+	"github.com/sg83/go-microservice/article-api/utils"
+)
+
+// Modes of CreateArticles
+const (
+	// every article is added, or none is
+	BulkAtomic = "atomic"
+	// the valid articles are added
+	BulkBestEffort = "best-effort"
+)
+
+// GetArticle returns the article with id
+func (c *Client) GetArticle(ctx context.Context, id int) (*data.Article, error) {
+	var a data.Article
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: "/articles/" + strconv.Itoa(id)}, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// CreateArticle adds a, its id is set by the server
+func (c *Client) CreateArticle(ctx context.Context, a data.Article) error {
+	r, err := jsonRequest(http.MethodPost, "/articles", a)
+	if err != nil {
+		return err
+	}
+	c.idempotentWrite(&r)
+	return c.getJSON(ctx, r, nil)
+}
+
+// CreateArticles adds the articles at once, every one or none of them in
+// the atomic mode and the valid ones in the best-effort mode. The report
+// tells the outcome of every article, it is also returned with the *Error
+// of a 422 Unprocessable Entity when some articles are invalid in the
+// atomic mode, and with an *Error when the server could not read every
+// article after adding some in the best-effort mode. Bulk imports take no
+// Idempotency-Key, so they are only retried after a 429.
+func (c *Client) CreateArticles(ctx context.Context, as []data.Article, mode string) (*data.BulkReport, error) {
+	if as == nil {
+		as = []data.Article{}
+	}
+	r, err := jsonRequest(http.MethodPost, "/articles:bulk", as)
+	if err != nil {
+		return nil, err
+	}
+	if mode != "" {
+		r.query = url.Values{"mode": {mode}}
+	}
+	r.expect = []int{http.StatusUnprocessableEntity}
+
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		var report data.BulkReport
+		if err := decodeJSON(resp.Body, &report); err != nil {
+			return nil, err
+		}
+		if report.Error != "" {
+			return &report, &Error{utils.Problem{Status: resp.StatusCode, Title: "The request was not read entirely", Detail: report.Error}}
+		}
+		return &report, nil
+	}
+
+	// the server answers with a problem when the body cannot be read
+	if resp.Header.Get("Content-Type") == utils.ProblemContentType {
+		return nil, decodeError(resp)
+	}
+	var report data.BulkReport
+	if err := decodeJSON(resp.Body, &report); err != nil {
+		return nil, err
+	}
+	return &report, &Error{utils.Problem{
+		Status: resp.StatusCode,
+		Title:  "Some articles are invalid",
+		Detail: strconv.Itoa(report.Failed) + " articles failed validation and none was added",
+	}}
+}
+
+// GetTagSummary returns the summary of tag on date, formatted as YYYYMMDD
+func (c *Client) GetTagSummary(ctx context.Context, tag, date string) (*data.Tag, error) {
+	var t data.Tag
+	path := "/tags/" + url.PathEscape(tag) + "/" + url.PathEscape(date)
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: path}, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListArticles calls fn with each of the articles matching f, in id order,
+// as they are read from the admin export, and stops at the first error fn
+// returns. It is not a paginated listing: it needs a key granting the admin
+// scope, and reads every matching article unless fn stops it.
+func (c *Client) ListArticles(ctx context.Context, f data.ExportFilter, fn func(data.Article) error) error {
+	rc, err := c.Export(ctx, f, export.NDJSON)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	r := export.NewReader(rc, export.NDJSON)
+	for {
+		a, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+}
+
+// Export returns the articles matching f in format, to be closed by the
+// caller. It needs a key granting the admin scope.
+func (c *Client) Export(ctx context.Context, f data.ExportFilter, format export.Format) (io.ReadCloser, error) {
+	q := url.Values{"format": {string(format)}}
+	if f.From != "" {
+		q.Set("from", f.From)
+	}
+	if f.To != "" {
+		q.Set("to", f.To)
+	}
+	if f.Tag != "" {
+		q.Set("tag", f.Tag)
+	}
+	resp, err := c.do(ctx, request{method: http.MethodGet, path: "/export", query: q, accept: format.ContentType()})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Import adds the articles of the export r, in the format f, replacing the
+// stored articles with the same ids. It needs a key granting the admin
+// scope. The request is not retried as r cannot be read again. If some
+// articles are invalid none is imported, and the report listing them is
+// returned along with an *Error.
+func (c *Client) Import(ctx context.Context, r io.Reader, f export.Format) (*data.ImportReport, error) {
+	req := request{method: http.MethodPost, path: "/import", stream: r, contentType: f.ContentType()}
+	req.expect = []int{http.StatusUnprocessableEntity}
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnprocessableEntity && resp.Header.Get("Content-Type") == utils.ProblemContentType {
+		return nil, decodeError(resp)
+	}
+
+	var report data.ImportReport
+	if err := decodeJSON(resp.Body, &report); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		return &report, &Error{utils.Problem{
+			Status: resp.StatusCode,
+			Title:  "Some articles are invalid",
+			Detail: strconv.Itoa(report.Failed) + " articles failed validation and none was imported",
+		}}
+	}
+	return &report, nil
+}
+
+// idempotentWrite sends r with an Idempotency-Key, so that it is retried,
+// when the client is configured to
+func (c *Client) idempotentWrite(r *request) {
+	if !c.o.IdempotentWrites {
+		return
+	}
+	r.header = http.Header{"Idempotency-Key": {idempotencyKey()}}
+	r.idempotent = true
+}
@@ -0,0 +1,261 @@
+// Package client is a Go client of the REST API of the article service.
+//
+//	c, err := client.New("http://localhost:8080", client.Options{APIKey: key})
+//	if err != nil {
+//		return err
+//	}
+//	a, err := c.GetArticle(ctx, 1)
+//
+// Requests that are safe to send again, reads and deletes, are retried with
+// exponential backoff when they fail to reach the server or get a 429, 502,
+// 503 or 504 response. Other requests are only retried after a 429, which
+// the server sends before handling them. Errors returned by the API are
+// *Error, holding the problem document of the response.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/internal/backoff"
+)
+
+const (
+	userAgent = "article-api-client"
+	// apiVersion is the prefix of the version of the REST API called, whose
+	// models are those of the data package
+	apiVersion = "/v1"
+
+	defaultMaxRetries     = 3
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+)
+
+// Options configure a Client
+type Options struct {
+	// sent in the X-API-Key header, the admin routes need a key granting
+	// the admin scope
+	APIKey string
+	// the client requests are sent with, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// retries of a failed request, 3 when zero and none when negative
+	MaxRetries int
+	// delay before the first retry, doubled on every retry up to MaxBackoff,
+	// 100ms and 5s when zero. A Retry-After header sent by the server is
+	// waited for instead, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// send an Idempotency-Key with the creation of single articles so that
+	// they are retried like reads, for servers with idempotency keys enabled
+	IdempotentWrites bool
+}
+
+// Client calls the API of an article service
+type Client struct {
+	base string
+	o    Options
+}
+
+// New returns a client of the API served at baseURL, such as
+// http://localhost:8080
+func New(baseURL string, o Options) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("client: base URL %q is not an http or https URL", baseURL)
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = defaultInitialBackoff
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = defaultMaxBackoff
+	}
+	return &Client{base: strings.TrimSuffix(u.String(), "/") + apiVersion, o: o}, nil
+}
+
+// request is a call of the API
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	// JSON body, sent again by retries
+	body []byte
+	// body that cannot be sent again, the request is not retried
+	stream      io.Reader
+	contentType string
+	accept      string
+	// statuses returned with the response rather than as an *Error, on top
+	// of the 2xx ones
+	expect []int
+	// the request can be sent again
+	idempotent bool
+	header     http.Header
+}
+
+// jsonRequest returns a request sending v as JSON
+func jsonRequest(method, path string, v interface{}) (request, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return request{}, err
+	}
+	return request{method: method, path: path, body: b, contentType: "application/json"}, nil
+}
+
+// do sends r, retrying it while it fails with a transient error, and
+// returns the response, to be closed by the caller, or an *Error
+func (c *Client) do(ctx context.Context, r request) (*http.Response, error) {
+	target := c.base + r.path
+	if len(r.query) != 0 {
+		target += "?" + r.query.Encode()
+	}
+	idempotent := r.idempotent || r.method == http.MethodGet || r.method == http.MethodDelete
+	if r.stream != nil {
+		idempotent = false
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := c.newRequest(ctx, r, target)
+		if err != nil {
+			return nil, err
+		}
+		retry := r.stream == nil && c.o.MaxRetries > 0 && attempt < c.o.MaxRetries
+
+		resp, err := c.o.HTTPClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || !retry || !idempotent {
+				return nil, err
+			}
+			if err := backoff.Sleep(ctx, backoff.Delay(attempt, c.o.InitialBackoff, c.o.MaxBackoff)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode < 300 || expected(r.expect, resp.StatusCode) {
+			return resp, nil
+		}
+		if retry && retryable(resp.StatusCode, idempotent) {
+			delay := backoff.Delay(attempt, c.o.InitialBackoff, c.o.MaxBackoff)
+			if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				delay = d
+				if delay > c.o.MaxBackoff {
+					delay = c.o.MaxBackoff
+				}
+			}
+			// drained so that the connection is reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, maxErrorBody))
+			resp.Body.Close()
+			if err := backoff.Sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+}
+
+func (c *Client) newRequest(ctx context.Context, r request, target string) (*http.Request, error) {
+	var body io.Reader = r.stream
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range r.header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("User-Agent", userAgent)
+	accept := r.accept
+	if accept == "" {
+		accept = "application/json"
+	}
+	req.Header.Set("Accept", accept)
+	if r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
+	if c.o.APIKey != "" {
+		req.Header.Set("X-API-Key", c.o.APIKey)
+	}
+	return req, nil
+}
+
+// getJSON sends r and decodes the JSON body of its response into v
+func (c *Client) getJSON(ctx context.Context, r request, v interface{}) error {
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return decodeJSON(resp.Body, v)
+}
+
+// decodeJSON decodes the JSON body of a response into v
+func decodeJSON(body io.Reader, v interface{}) error {
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return fmt.Errorf("client: decoding the response: %w", err)
+	}
+	return nil
+}
+
+// idempotencyKey returns a random key for the Idempotency-Key header
+func idempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func expected(statuses []int, status int) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable reports whether a request that got status can be sent again.
+// Too many requests are refused before they are handled, the other
+// statuses may come from a request that was handled.
+func retryable(status int, idempotent bool) bool {
+	switch status {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header given in seconds
+func retryAfter(v string) (time.Duration, bool) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
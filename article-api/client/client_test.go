@@ -0,0 +1,379 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/config"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/export"
+	"github.com/sg83/go-microservice/article-api/handlers"
+	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/sg83/go-microservice/article-api/server"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+const adminKey = "admin-key"
+
+var article = data.Article{ID: 1, Title: "Title 1", Date: "2016-09-22", Body: "Body 1", Tags: []string{"health", "fitness"}}
+
+// newServer returns a server routing the requests to the handlers of the
+// API on db like the service does, with wrap in front of them
+func newServer(t *testing.T, db *mocks.ArticlesData, wrap func(http.Handler) http.Handler) *httptest.Server {
+	l := zap.NewNop()
+	v := data.NewValidation()
+	root, err := server.NewRouter(l, config.Default(), server.Routes{
+		Articles: handlers.NewArticles(l, db, v),
+		Webhooks: handlers.NewWebhooks(l, &mocks.WebhooksData{}, v),
+		Health:   handlers.NewHealth(l),
+		APIKeys:  handlers.NewAPIKeys(map[string][]string{adminKey: {handlers.ScopeAdmin}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var h http.Handler = root
+	if wrap != nil {
+		h = wrap(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newClient(t *testing.T, srv *httptest.Server, o Options) *Client {
+	if o.InitialBackoff == 0 {
+		o.InitialBackoff = time.Millisecond
+	}
+	c, err := New(srv.URL, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGetArticle(t *testing.T) {
+	tt := []struct {
+		name   string
+		id     int
+		setup  func(db *mocks.ArticlesData)
+		want   *data.Article
+		status int
+	}{
+		{
+			name:  "found",
+			id:    1,
+			setup: func(db *mocks.ArticlesData) { db.On("GetArticleByID", mock.Anything, 1).Return(&article, nil) },
+			want:  &article,
+		},
+		{
+			name: "not found",
+			id:   2,
+			setup: func(db *mocks.ArticlesData) {
+				db.On("GetArticleByID", mock.Anything, 2).Return(nil, &data.NotFoundError{Resource: "article", Key: "2"})
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "failing, not retried",
+			id:   3,
+			setup: func(db *mocks.ArticlesData) {
+				db.On("GetArticleByID", mock.Anything, 3).Return(nil, errors.New("connection refused")).Once()
+			},
+			status: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &mocks.ArticlesData{}
+			tc.setup(db)
+			c := newClient(t, newServer(t, db, nil), Options{})
+
+			got, err := c.GetArticle(context.Background(), tc.id)
+			if tc.status != 0 {
+				var e *Error
+				if !errors.As(err, &e) || e.Status != tc.status || e.Title == "" {
+					t.Fatalf("got error %v, want a %d problem", err, tc.status)
+				}
+				if IsNotFound(err) != (tc.status == http.StatusNotFound) {
+					t.Errorf("IsNotFound is %v for %v", IsNotFound(err), err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got.Title != tc.want.Title || got.ID != tc.want.ID || len(got.Tags) != len(tc.want.Tags) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateArticle(t *testing.T) {
+	tt := []struct {
+		name    string
+		article data.Article
+		setup   func(db *mocks.ArticlesData)
+		status  int
+		field   string
+	}{
+		{
+			name:    "created",
+			article: data.Article{Title: "Title", Date: "2016-09-22", Body: "Body", Tags: []string{"health"}},
+			setup: func(db *mocks.ArticlesData) {
+				db.On("AddArticle", mock.Anything, mock.MatchedBy(func(a data.Article) bool { return a.Title == "Title" })).Return(nil).Once()
+			},
+		},
+		{
+			name:    "invalid",
+			article: data.Article{Date: "2016-09-22", Body: "Body"},
+			status:  http.StatusUnprocessableEntity,
+			field:   "title",
+		},
+		{
+			name:    "conflict",
+			article: data.Article{Title: "Title", Date: "2016-09-22", Body: "Body"},
+			setup: func(db *mocks.ArticlesData) {
+				db.On("AddArticle", mock.Anything, mock.Anything).Return(&data.ConflictError{Resource: "article", Reason: "duplicate title"}).Once()
+			},
+			status: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &mocks.ArticlesData{}
+			if tc.setup != nil {
+				tc.setup(db)
+			}
+			c := newClient(t, newServer(t, db, nil), Options{})
+
+			err := c.CreateArticle(context.Background(), tc.article)
+			if tc.status == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				var e *Error
+				if !errors.As(err, &e) || e.Status != tc.status {
+					t.Fatalf("got error %v, want a %d problem", err, tc.status)
+				}
+				if tc.field != "" && (len(e.Errors) == 0 || e.Errors[0].Field != tc.field) {
+					t.Errorf("got field errors %+v, want %s", e.Errors, tc.field)
+				}
+				if IsConflict(err) != (tc.status == http.StatusConflict) {
+					t.Errorf("IsConflict is %v for %v", IsConflict(err), err)
+				}
+			}
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateArticles(t *testing.T) {
+	valid := data.Article{Title: "Title", Date: "2016-09-22", Body: "Body"}
+	db := &mocks.ArticlesData{}
+	db.On("AddArticles", mock.Anything, []data.Article{valid, valid}).Return([]int{7, 8}, nil).Once()
+	c := newClient(t, newServer(t, db, nil), Options{})
+
+	report, err := c.CreateArticles(context.Background(), []data.Article{valid, valid}, BulkAtomic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 2 || report.Items[1].ID != 8 {
+		t.Errorf("got report %+v", report)
+	}
+
+	// in the atomic mode nothing is added when an article is invalid
+	report, err = c.CreateArticles(context.Background(), []data.Article{valid, {Title: "Title"}}, BulkAtomic)
+	var e *Error
+	if !errors.As(err, &e) || e.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("got error %v, want a 422", err)
+	}
+	if report == nil || report.Failed != 1 || report.Items[1].Status != "invalid" {
+		t.Errorf("got report %+v", report)
+	}
+	db.AssertExpectations(t)
+}
+
+func TestGetTagSummary(t *testing.T) {
+	db := &mocks.ArticlesData{}
+	db.On("GetArticlesForTagAndDate", mock.Anything, "health", "20160922").Return([]int{1, 2}, nil)
+	db.On("GetTagLastModified", mock.Anything, "health", "20160922").Return(time.Time{}, nil)
+	db.On("GetRelatedTagsForTag", mock.Anything, "health", []int{1, 2}).Return([]string{"fitness"}, nil)
+	c := newClient(t, newServer(t, db, nil), Options{})
+
+	tag, err := c.GetTagSummary(context.Background(), "health", "20160922")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Tag != "health" || tag.Count != 2 || len(tag.RelatedTags) != 1 {
+		t.Errorf("got %+v", tag)
+	}
+
+	_, err = c.GetTagSummary(context.Background(), "health", "2016-09-22")
+	if e := (*Error)(nil); !errors.As(err, &e) || e.Status != http.StatusBadRequest {
+		t.Errorf("got error %v for an invalid date, want a 400", err)
+	}
+}
+
+func TestAdminCalls(t *testing.T) {
+	db := &mocks.ArticlesData{}
+	db.On("ExportArticles", mock.Anything, data.ExportFilter{Tag: "health"}, mock.Anything).Return(
+		func(ctx context.Context, f data.ExportFilter, fn func(data.Article) error) error {
+			return fn(article)
+		})
+	db.On("ImportArticles", mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, next func() (data.Article, error)) (int, error) {
+			n := 0
+			for {
+				_, err := next()
+				if err == io.EOF {
+					return n, nil
+				}
+				if err != nil {
+					return 0, err
+				}
+				n++
+			}
+		})
+	srv := newServer(t, db, nil)
+
+	c := newClient(t, srv, Options{APIKey: adminKey})
+	var as []data.Article
+	err := c.ListArticles(context.Background(), data.ExportFilter{Tag: "health"}, func(a data.Article) error {
+		as = append(as, a)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(as) != 1 || as[0].Title != article.Title {
+		t.Errorf("got %+v", as)
+	}
+	stop := errors.New("stop")
+	if err := c.ListArticles(context.Background(), data.ExportFilter{Tag: "health"}, func(data.Article) error { return stop }); err != stop {
+		t.Errorf("got error %v, want the error of the callback", err)
+	}
+	report, err := c.Import(context.Background(), strings.NewReader(`{"id":1,"title":"Title 1","date":"2016-09-22","body":"Body 1"}`+"\n"), export.NDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 1 {
+		t.Errorf("got report %+v", report)
+	}
+	report, err = c.Import(context.Background(), strings.NewReader(`{"id":1,"title":"Title 1","date":"2016-09-22"}`+"\n"), export.NDJSON)
+	if e := (*Error)(nil); !errors.As(err, &e) || e.Status != http.StatusUnprocessableEntity {
+		t.Errorf("got error %v importing an invalid article, want a 422", err)
+	}
+	if report == nil || report.Failed != 1 || len(report.Items) != 1 {
+		t.Errorf("got report %+v", report)
+	}
+
+	anonymous := newClient(t, srv, Options{})
+	err = anonymous.ListArticles(context.Background(), data.ExportFilter{}, func(data.Article) error { return nil })
+	if e := (*Error)(nil); !errors.As(err, &e) || e.Status != http.StatusUnauthorized {
+		t.Errorf("got error %v without a key, want a 401", err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tt := []struct {
+		name    string
+		o       Options
+		create  bool
+		status  int
+		fail    int
+		calls   int
+		wantErr bool
+	}{
+		{name: "read retried", status: http.StatusServiceUnavailable, fail: 2, calls: 3},
+		{name: "read retried until the limit", o: Options{MaxRetries: 2}, status: http.StatusBadGateway, fail: 5, calls: 3, wantErr: true},
+		{name: "retries disabled", o: Options{MaxRetries: -1}, status: http.StatusServiceUnavailable, fail: 1, calls: 1, wantErr: true},
+		{name: "write not retried", create: true, status: http.StatusServiceUnavailable, fail: 1, calls: 1, wantErr: true},
+		{name: "rate limited write retried", create: true, status: http.StatusTooManyRequests, fail: 1, calls: 2},
+		{name: "idempotent write retried", o: Options{IdempotentWrites: true}, create: true, status: http.StatusServiceUnavailable, fail: 2, calls: 3},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &mocks.ArticlesData{}
+			db.On("GetArticleByID", mock.Anything, 1).Return(&article, nil)
+			db.On("AddArticle", mock.Anything, mock.Anything).Return(nil)
+
+			var mu sync.Mutex
+			calls := 0
+			keys := map[string]bool{}
+			flaky := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mu.Lock()
+					calls++
+					n := calls
+					keys[r.Header.Get(handlers.IdempotencyKeyHeader)] = true
+					mu.Unlock()
+					if n <= tc.fail {
+						w.Header().Set("Retry-After", "0")
+						http.Error(w, "try again", tc.status)
+						return
+					}
+					next.ServeHTTP(w, r)
+				})
+			}
+			c := newClient(t, newServer(t, db, flaky), tc.o)
+
+			var err error
+			if tc.create {
+				err = c.CreateArticle(context.Background(), data.Article{Title: "Title", Date: "2016-09-22", Body: "Body"})
+			} else {
+				_, err = c.GetArticle(context.Background(), 1)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			if calls != tc.calls {
+				t.Errorf("got %d calls, want %d", calls, tc.calls)
+			}
+			if tc.o.IdempotentWrites && (len(keys) != 1 || keys[""]) {
+				t.Errorf("got idempotency keys %v, want the same one on every attempt", keys)
+			}
+		})
+	}
+}
+
+func TestRetriesCancelled(t *testing.T) {
+	srv := newServer(t, &mocks.ArticlesData{}, func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+		})
+	})
+	c := newClient(t, srv, Options{InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetArticle(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want the deadline of the context", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, u := range []string{"localhost:8080", "ftp://example.com", "http://"} {
+		if _, err := New(u, Options{}); err == nil {
+			t.Errorf("%s: got no error", u)
+		}
+	}
+	c, err := New("https://example.com/api/", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.base != "https://example.com/api/v1" {
+		t.Errorf("got base %s", c.base)
+	}
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/sg83/go-microservice/article-api/utils"
+)
+
+// maxErrorBody bounds the part of an error response that is read
+const maxErrorBody = 64 << 10
+
+// Error is an error response of the API. Its problem document is decoded
+// from the body, or made up from the status when the response does not
+// carry one, as when a proxy answered.
+type Error struct {
+	utils.Problem
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("article-api: %d %s", e.Status, e.Title)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	for _, fe := range e.Errors {
+		msg += fmt.Sprintf("; %s: %s", fe.Field, fe.Message)
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is a 404 Not Found response
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsConflict reports whether err is a 409 Conflict response
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+func hasStatus(err error, status int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == status
+}
+
+// decodeError returns the *Error of resp
+func decodeError(resp *http.Response) error {
+	e := &Error{}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch {
+	case mt == utils.ProblemContentType || mt == "application/json":
+		if err := json.Unmarshal(body, &e.Problem); err != nil {
+			e.Detail = strings.TrimSpace(string(body))
+		}
+	case len(body) != 0:
+		e.Detail = strings.TrimSpace(string(body))
+	}
+	e.Status = resp.StatusCode
+	if e.Title == "" {
+		e.Title = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
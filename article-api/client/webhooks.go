@@ -0,0 +1,39 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/sg83/go-microservice/article-api/data"
+)
+
+// ListWebhooks returns every webhook, without their secrets. It needs a key
+// granting the admin scope.
+func (c *Client) ListWebhooks(ctx context.Context) ([]data.Webhook, error) {
+	var whs []data.Webhook
+	if err := c.getJSON(ctx, request{method: http.MethodGet, path: "/webhooks"}, &whs); err != nil {
+		return nil, err
+	}
+	return whs, nil
+}
+
+// CreateWebhook registers wh and returns it with its id and secret. It needs
+// a key granting the admin scope.
+func (c *Client) CreateWebhook(ctx context.Context, wh data.Webhook) (*data.Webhook, error) {
+	r, err := jsonRequest(http.MethodPost, "/webhooks", wh)
+	if err != nil {
+		return nil, err
+	}
+	var created data.Webhook
+	if err := c.getJSON(ctx, r, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// DeleteWebhook removes the webhook with id. It needs a key granting the
+// admin scope.
+func (c *Client) DeleteWebhook(ctx context.Context, id int) error {
+	return c.getJSON(ctx, request{method: http.MethodDelete, path: "/webhooks/" + strconv.Itoa(id)}, nil)
+}
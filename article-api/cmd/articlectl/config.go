@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultEndpoint = "http://localhost:8080"
+
+// config is where the API is and how to authenticate to it, read from the
+// config file, then the environment, then the flags
+type config struct {
+	// base URL of the API
+	Endpoint string `yaml:"endpoint"`
+	// sent in the X-API-Key header
+	APIKey string `yaml:"api_key"`
+	// file holding the API key, read when api_key is empty
+	APIKeyFile string `yaml:"api_key_file"`
+}
+
+// defaultConfigPath returns the config file read when none is given,
+// articlectl/config.yaml in the user config directory
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "articlectl", "config.yaml")
+}
+
+// loadConfig reads the config file at path, which may only be missing when
+// it is the default one, and applies the environment to it
+func loadConfig(path string, explicit bool, getenv func(string) string) (*config, error) {
+	c := &config{Endpoint: defaultEndpoint}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && !explicit:
+		case err != nil:
+			return nil, err
+		default:
+			if err := yaml.Unmarshal(b, c); err != nil {
+				return nil, fmt.Errorf("config file %s: %w", path, err)
+			}
+		}
+	}
+	if v := getenv("ARTICLECTL_ENDPOINT"); v != "" {
+		c.Endpoint = v
+	}
+	if v := getenv("ARTICLECTL_API_KEY"); v != "" {
+		c.APIKey = v
+	}
+	return c, nil
+}
+
+// apiKey returns the API key of c, reading its file if needed
+func (c *config) apiKey() (string, error) {
+	if c.APIKey != "" || c.APIKeyFile == "" {
+		return c.APIKey, nil
+	}
+	b, err := os.ReadFile(c.APIKeyFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
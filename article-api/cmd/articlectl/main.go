@@ -0,0 +1,421 @@
+// Command articlectl works with the article API from a terminal.
+//
+//	articlectl [flags] get <id>
+//	articlectl [flags] create --file article.json [--mode atomic|best-effort]
+//	articlectl [flags] list [--from YYYY-MM-DD] [--to YYYY-MM-DD] [--tag tag]
+//	articlectl [flags] tags summary <tag> <YYYYMMDD>
+//	articlectl [flags] import --file export.ndjson [--format ndjson|csv|tar.gz]
+//	articlectl [flags] export [--format ndjson|csv|tar.gz] [--file path] [--from ...] [--to ...] [--tag ...]
+//
+// The endpoint and the API key are read from the config file,
+// articlectl/config.yaml in the user config directory unless --config is
+// given, then from ARTICLECTL_ENDPOINT and ARTICLECTL_API_KEY, then from the
+// flags:
+//
+//	endpoint: https://articles.example.com
+//	api_key_file: /home/me/.articles-key
+//
+// Results are printed as a table, or as JSON or YAML with --output.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/sg83/go-microservice/article-api/client"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/export"
+)
+
+const usage = `usage: articlectl [flags] <command> [flags] [args]
+
+commands:
+  get <id>                      print an article
+  create --file <path>          add the article of a JSON file, or the articles
+                                of a JSON array or NDJSON file at once
+  list                          print the articles, needs an admin key
+  tags summary <tag> <date>     print the summary of a tag on a YYYYMMDD date
+  import --file <path>          import an export, needs an admin key
+  export                        write an export, needs an admin key
+
+flags, also accepted after the command:
+`
+
+// errUsage is returned for invalid command lines, after printing the usage
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Getenv, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// cli holds the settings shared by the commands
+type cli struct {
+	configPath string
+	endpoint   string
+	apiKey     string
+	output     string
+
+	getenv func(string) string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// run runs the command line args and returns the exit code
+func run(ctx context.Context, args []string, getenv func(string) string, stdin io.Reader, stdout, stderr io.Writer) int {
+	c := &cli{output: outputTable, getenv: getenv, stdin: stdin, stdout: stdout, stderr: stderr}
+	if p := getenv("ARTICLECTL_CONFIG"); p != "" {
+		c.configPath = p
+	}
+
+	fs := c.flagSet("articlectl")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	err := c.dispatch(ctx, fs.Args())
+	switch {
+	case errors.Is(err, errUsage):
+		return 2
+	case err != nil:
+		fmt.Fprintln(stderr, "articlectl:", err)
+		return 1
+	}
+	return 0
+}
+
+// flagSet returns a flag set with the shared flags, so that they can be
+// given before or after the command
+func (c *cli) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.StringVar(&c.configPath, "config", c.configPath, "config file, defaults to "+defaultConfigPath())
+	fs.StringVar(&c.endpoint, "endpoint", c.endpoint, "base URL of the API, defaults to "+defaultEndpoint)
+	fs.StringVar(&c.apiKey, "api-key", c.apiKey, "API key sent in the X-API-Key header")
+	fs.StringVar(&c.output, "output", c.output, "output format: table, json or yaml")
+	fs.StringVar(&c.output, "o", c.output, "shorthand for -output")
+	fs.Usage = func() {
+		fmt.Fprint(c.stderr, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func (c *cli) dispatch(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(c.stderr, usage)
+		return errUsage
+	}
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "get":
+		return c.get(ctx, args)
+	case "create":
+		return c.create(ctx, args)
+	case "list":
+		return c.list(ctx, args)
+	case "tags":
+		if len(args) == 0 || args[0] != "summary" {
+			fmt.Fprintln(c.stderr, "usage: articlectl tags summary <tag> <date>")
+			return errUsage
+		}
+		return c.tagSummary(ctx, args[1:])
+	case "import":
+		return c.importArticles(ctx, args)
+	case "export":
+		return c.exportArticles(ctx, args)
+	}
+	fmt.Fprintf(c.stderr, "unknown command %q\n\n%s", cmd, usage)
+	return errUsage
+}
+
+// parse parses the flags of a command, which takes nargs arguments
+func (c *cli) parse(fs *flag.FlagSet, args []string, nargs int, synopsis string) ([]string, error) {
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: articlectl %s\n", synopsis)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return nil, errUsage
+	}
+	switch c.output {
+	case outputTable, outputJSON, outputYAML:
+	default:
+		return nil, fmt.Errorf("output %q is not one of table, json or yaml", c.output)
+	}
+	return fs.Args(), nil
+}
+
+// client returns a client of the configured endpoint
+func (c *cli) client() (*client.Client, error) {
+	path, explicit := c.configPath, c.configPath != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	cfg, err := loadConfig(path, explicit, c.getenv)
+	if err != nil {
+		return nil, err
+	}
+	if c.endpoint != "" {
+		cfg.Endpoint = c.endpoint
+	}
+	if c.apiKey != "" {
+		cfg.APIKey = c.apiKey
+	}
+	key, err := cfg.apiKey()
+	if err != nil {
+		return nil, err
+	}
+	return client.New(cfg.Endpoint, client.Options{APIKey: key})
+}
+
+func (c *cli) printer() *printer {
+	return &printer{w: c.stdout, format: c.output}
+}
+
+func (c *cli) get(ctx context.Context, args []string) error {
+	args, err := c.parse(c.flagSet("get"), args, 1, "get <id>")
+	if err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("article id %q is not a number", args[0])
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	a, err := api.GetArticle(ctx, id)
+	if err != nil {
+		return err
+	}
+	return c.printer().print(a, articlesTable([]data.Article{*a}))
+}
+
+func (c *cli) create(ctx context.Context, args []string) error {
+	fs := c.flagSet("create")
+	file := fs.String("file", "", "JSON or NDJSON file of the articles, - for the standard input")
+	mode := fs.String("mode", client.BulkAtomic, "when creating several articles, atomic adds every article or none and best-effort adds the valid ones")
+	if _, err := c.parse(fs, args, 0, "create --file <path> [--mode atomic|best-effort]"); err != nil {
+		return err
+	}
+	if *file == "" {
+		fs.Usage()
+		return errUsage
+	}
+	as, err := c.readArticles(*file)
+	if err != nil {
+		return err
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+
+	if len(as) == 1 {
+		if err := api.CreateArticle(ctx, as[0]); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.stderr, "article created")
+		return nil
+	}
+	report, err := api.CreateArticles(ctx, as, *mode)
+	if report != nil {
+		if perr := c.printer().print(report, bulkTable(report)); perr != nil {
+			return perr
+		}
+	}
+	return err
+}
+
+// readArticles reads a JSON article, a JSON array of articles or NDJSON
+// articles from the file at path
+func (c *cli) readArticles(path string) ([]data.Article, error) {
+	b, err := c.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var as []data.Article
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+		if err := json.Unmarshal(b, &as); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		for {
+			var a data.Article
+			err := dec.Decode(&a)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			as = append(as, a)
+		}
+	}
+	if len(as) == 0 {
+		return nil, fmt.Errorf("%s holds no articles", path)
+	}
+	return as, nil
+}
+
+func (c *cli) readFile(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(c.stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// filterFlags adds the flags selecting articles to fs
+func filterFlags(fs *flag.FlagSet) *data.ExportFilter {
+	f := &data.ExportFilter{}
+	fs.StringVar(&f.From, "from", "", "only articles dated on or after this YYYY-MM-DD date")
+	fs.StringVar(&f.To, "to", "", "only articles dated on or before this YYYY-MM-DD date")
+	fs.StringVar(&f.Tag, "tag", "", "only articles with this tag")
+	return f
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	fs := c.flagSet("list")
+	f := filterFlags(fs)
+	if _, err := c.parse(fs, args, 0, "list [--from YYYY-MM-DD] [--to YYYY-MM-DD] [--tag tag]"); err != nil {
+		return err
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	return c.printer().printArticles(func(fn func(data.Article) error) error {
+		return api.ListArticles(ctx, *f, fn)
+	})
+}
+
+func (c *cli) tagSummary(ctx context.Context, args []string) error {
+	args, err := c.parse(c.flagSet("tags summary"), args, 2, "tags summary <tag> <YYYYMMDD>")
+	if err != nil {
+		return err
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	t, err := api.GetTagSummary(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return c.printer().print(t, tagTable(t))
+}
+
+// formatFlag adds the flag of the format of an export to fs
+func formatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", "", "ndjson, csv or tar.gz, guessed from the file extension by default and ndjson otherwise")
+}
+
+// exportFormat returns the format given, or the one of the extension of
+// path
+func exportFormat(format, path string) (export.Format, error) {
+	if format != "" {
+		return export.ParseFormat(format)
+	}
+	switch {
+	case strings.HasSuffix(path, ".csv"):
+		return export.CSV, nil
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return export.TarGz, nil
+	}
+	return export.NDJSON, nil
+}
+
+func (c *cli) importArticles(ctx context.Context, args []string) error {
+	fs := c.flagSet("import")
+	file := fs.String("file", "", "the export to import, - for the standard input")
+	format := formatFlag(fs)
+	if _, err := c.parse(fs, args, 0, "import --file <path> [--format ndjson|csv|tar.gz]"); err != nil {
+		return err
+	}
+	if *file == "" {
+		fs.Usage()
+		return errUsage
+	}
+	f, err := exportFormat(*format, *file)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = c.stdin
+	if *file != "-" {
+		fh, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		r = bufio.NewReader(fh)
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	report, err := api.Import(ctx, r, f)
+	if report != nil {
+		if perr := c.printer().print(report, importTable(report)); perr != nil {
+			return perr
+		}
+	}
+	return err
+}
+
+func (c *cli) exportArticles(ctx context.Context, args []string) (err error) {
+	fs := c.flagSet("export")
+	file := fs.String("file", "-", "where to write the export, - for the standard output")
+	format := formatFlag(fs)
+	filter := filterFlags(fs)
+	if _, err := c.parse(fs, args, 0, "export [--format ndjson|csv|tar.gz] [--file path] [--from YYYY-MM-DD] [--to YYYY-MM-DD] [--tag tag]"); err != nil {
+		return err
+	}
+	f, err := exportFormat(*format, *file)
+	if err != nil {
+		return err
+	}
+	api, err := c.client()
+	if err != nil {
+		return err
+	}
+	rc, err := api.Export(ctx, *filter, f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w := c.stdout
+	if *file != "-" {
+		fh, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := fh.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = fh
+	}
+	_, err = io.Copy(w, rc)
+	return err
+}
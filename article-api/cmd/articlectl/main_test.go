@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	apiconfig "github.com/sg83/go-microservice/article-api/config"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/handlers"
+	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/sg83/go-microservice/article-api/server"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+const adminKey = "admin-key"
+
+var article = data.Article{ID: 1, Title: "Title 1", Date: "2016-09-22", Body: "Body 1", Tags: []string{"health", "fitness"}}
+
+// newServer returns a server routing the requests to the handlers of the
+// API on db like the service does
+func newServer(t *testing.T, db *mocks.ArticlesData) *httptest.Server {
+	l := zap.NewNop()
+	v := data.NewValidation()
+	root, err := server.NewRouter(l, apiconfig.Default(), server.Routes{
+		Articles: handlers.NewArticles(l, db, v),
+		Webhooks: handlers.NewWebhooks(l, &mocks.WebhooksData{}, v),
+		Health:   handlers.NewHealth(l),
+		APIKeys:  handlers.NewAPIKeys(map[string][]string{adminKey: {handlers.ScopeAdmin}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(root)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	// keep the config file of the user out of the tests
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	articleFile := filepath.Join(dir, "article.json")
+	if err := os.WriteFile(articleFile, []byte(`{"title":"Title 2","date":"2016-09-23","body":"Body 2","tags":["health"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name   string
+		args   []string
+		stdin  string
+		setup  func(db *mocks.ArticlesData)
+		code   int
+		stdout []string
+		stderr string
+	}{
+		{
+			name:   "get table",
+			args:   []string{"get", "1"},
+			setup:  func(db *mocks.ArticlesData) { db.On("GetArticleByID", mock.Anything, 1).Return(&article, nil) },
+			stdout: []string{"ID  DATE        TITLE    TAGS", "1   2016-09-22  Title 1  health,fitness"},
+		},
+		{
+			name:   "get json",
+			args:   []string{"-o", "json", "get", "1"},
+			setup:  func(db *mocks.ArticlesData) { db.On("GetArticleByID", mock.Anything, 1).Return(&article, nil) },
+			stdout: []string{`"title": "Title 1"`},
+		},
+		{
+			name:   "get yaml after the command",
+			args:   []string{"get", "-o", "yaml", "1"},
+			setup:  func(db *mocks.ArticlesData) { db.On("GetArticleByID", mock.Anything, 1).Return(&article, nil) },
+			stdout: []string{"title: Title 1", "tags:\n    - health"},
+		},
+		{
+			name: "get missing",
+			args: []string{"get", "2"},
+			setup: func(db *mocks.ArticlesData) {
+				db.On("GetArticleByID", mock.Anything, 2).Return(nil, &data.NotFoundError{Resource: "article", Key: "2"})
+			},
+			code:   1,
+			stderr: "articlectl: article-api: 404",
+		},
+		{
+			name:   "get invalid id",
+			args:   []string{"get", "one"},
+			code:   1,
+			stderr: `article id "one" is not a number`,
+		},
+		{
+			name: "create from file",
+			args: []string{"create", "--file", articleFile},
+			setup: func(db *mocks.ArticlesData) {
+				db.On("AddArticle", mock.Anything, mock.MatchedBy(func(a data.Article) bool { return a.Title == "Title 2" })).Return(nil)
+			},
+			stderr: "article created",
+		},
+		{
+			name:  "create several from stdin",
+			args:  []string{"create", "--file", "-"},
+			stdin: `{"title":"Title 2","date":"2016-09-23","body":"Body 2","tags":["health"]}` + "\n" + `{"title":"Title 3","date":"2016-09-23","body":"Body 3","tags":["health"]}`,
+			setup: func(db *mocks.ArticlesData) {
+				db.On("AddArticles", mock.Anything, mock.Anything).Return([]int{2, 3}, nil)
+			},
+			stdout: []string{"0      created  2", "1      created  3", "created 2, failed 0 (atomic mode)"},
+		},
+		{
+			name: "list",
+			args: []string{"--api-key", adminKey, "list", "--tag", "health"},
+			setup: func(db *mocks.ArticlesData) {
+				db.On("ExportArticles", mock.Anything, data.ExportFilter{Tag: "health"}, mock.Anything).Return(
+					func(ctx context.Context, f data.ExportFilter, fn func(data.Article) error) error {
+						return fn(article)
+					})
+			},
+			stdout: []string{"1   2016-09-22  Title 1  health,fitness"},
+		},
+		{
+			name:  "import invalid articles",
+			args:  []string{"--api-key", adminKey, "import", "--file", "-"},
+			stdin: `{"id":1,"title":"Title 1","date":"2016-09-22","body":"Body 1"}` + "\n" + `{"id":2,"title":"Title 2","date":"2016-09-22"}`,
+			setup: func(db *mocks.ArticlesData) {
+				db.On("ImportArticles", mock.Anything, mock.Anything).Return(
+					func(ctx context.Context, next func() (data.Article, error)) (int, error) {
+						for {
+							if _, err := next(); err != nil {
+								return 0, err
+							}
+						}
+					})
+			},
+			code:   1,
+			stdout: []string{"1      invalid  2   the article has invalid fields; body: body is a required field", "imported 0, failed 1"},
+			stderr: "article-api: 422",
+		},
+		{
+			name:   "list without an admin key",
+			args:   []string{"list"},
+			code:   1,
+			stderr: "article-api: 401",
+		},
+		{
+			name:   "no command",
+			code:   2,
+			stderr: "usage: articlectl",
+		},
+		{
+			name:   "unknown command",
+			args:   []string{"delete", "1"},
+			code:   2,
+			stderr: `unknown command "delete"`,
+		},
+		{
+			name:   "missing argument",
+			args:   []string{"tags", "summary", "health"},
+			code:   2,
+			stderr: "usage: articlectl tags summary",
+		},
+		{
+			name:   "unknown output",
+			args:   []string{"-o", "xml", "get", "1"},
+			code:   1,
+			stderr: `output "xml" is not one of table, json or yaml`,
+		},
+		{
+			name:   "missing config file",
+			args:   []string{"--config", filepath.Join(dir, "missing.yaml"), "get", "1"},
+			code:   1,
+			stderr: "missing.yaml",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &mocks.ArticlesData{}
+			if tc.setup != nil {
+				tc.setup(db)
+			}
+			srv := newServer(t, db)
+			env := map[string]string{"ARTICLECTL_ENDPOINT": srv.URL}
+
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), tc.args, func(k string) string { return env[k] }, strings.NewReader(tc.stdin), &stdout, &stderr)
+			if code != tc.code {
+				t.Fatalf("got exit code %d, want %d; stderr: %s", code, tc.code, stderr.String())
+			}
+			for _, s := range tc.stdout {
+				if !strings.Contains(stdout.String(), s) {
+					t.Errorf("stdout %q does not contain %q", stdout.String(), s)
+				}
+			}
+			if !strings.Contains(stderr.String(), tc.stderr) {
+				t.Errorf("stderr %q does not contain %q", stderr.String(), tc.stderr)
+			}
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("endpoint: https://articles.example.com\napi_key_file: "+keyFile+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name     string
+		path     string
+		explicit bool
+		env      map[string]string
+		endpoint string
+		key      string
+		err      bool
+	}{
+		{name: "file", path: path, explicit: true, endpoint: "https://articles.example.com", key: "secret"},
+		{name: "env overrides the file", path: path, env: map[string]string{"ARTICLECTL_ENDPOINT": "http://other", "ARTICLECTL_API_KEY": "k"}, endpoint: "http://other", key: "k"},
+		{name: "missing default file", path: filepath.Join(dir, "missing.yaml"), endpoint: defaultEndpoint},
+		{name: "missing explicit file", path: filepath.Join(dir, "missing.yaml"), explicit: true, err: true},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := loadConfig(tc.path, tc.explicit, func(k string) string { return tc.env[k] })
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := c.apiKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Endpoint != tc.endpoint || key != tc.key {
+				t.Errorf("got endpoint %q and key %q, want %q and %q", c.Endpoint, key, tc.endpoint, tc.key)
+			}
+		})
+	}
+}
+
+func TestPrintArticles(t *testing.T) {
+	other := data.Article{ID: 2, Title: "Title 2", Date: "2016-09-23", Body: "Body 2"}
+	for _, format := range []string{outputTable, outputJSON, outputYAML} {
+		for _, as := range [][]data.Article{{}, {article}, {article, other}} {
+			// streamed, the articles are printed as the whole list is
+			var want, got bytes.Buffer
+			if err := (&printer{w: &want, format: format}).print(as, articlesTable(as)); err != nil {
+				t.Fatal(err)
+			}
+			err := (&printer{w: &got, format: format}).printArticles(func(fn func(data.Article) error) error {
+				for _, a := range as {
+					if err := fn(a); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() {
+				t.Errorf("%s of %d articles: got\n%s\nwant\n%s", format, len(as), got.String(), want.String())
+			}
+		}
+	}
+}
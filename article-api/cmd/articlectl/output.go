@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// maxTitleLen bounds the titles shown in tables
+const maxTitleLen = 60
+
+// tableBlock is the number of rows of a streamed table aligned together
+const tableBlock = 100
+
+// printer writes the results of commands in an output format
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+// print writes v as JSON or YAML, named as in the API, or as the table
+// written by table
+func (p *printer) print(v interface{}, table func(tw *tabwriter.Writer)) error {
+	switch p.format {
+	case outputJSON:
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", b)
+		return err
+	case outputYAML:
+		b, err := toYAML(v)
+		if err != nil {
+			return err
+		}
+		_, err = p.w.Write(b)
+		return err
+	default:
+		tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+		table(tw)
+		return tw.Flush()
+	}
+}
+
+// printArticles writes the articles list calls its function with as they
+// come, rather than holding them: as a JSON or YAML list, or as a table
+// whose columns are aligned by blocks of tableBlock rows
+func (p *printer) printArticles(list func(fn func(data.Article) error) error) error {
+	n := 0
+	switch p.format {
+	case outputJSON:
+		err := list(func(a data.Article) error {
+			// indented as an item of the list
+			b, err := json.MarshalIndent(a, "  ", "  ")
+			if err != nil {
+				return err
+			}
+			sep := ",\n  "
+			if n == 0 {
+				sep = "[\n  "
+			}
+			n++
+			_, err = fmt.Fprintf(p.w, "%s%s", sep, b)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		end := "\n]\n"
+		if n == 0 {
+			end = "[]\n"
+		}
+		_, err = io.WriteString(p.w, end)
+		return err
+	case outputYAML:
+		err := list(func(a data.Article) error {
+			b, err := toYAML([]data.Article{a})
+			if err != nil {
+				return err
+			}
+			n++
+			_, err = p.w.Write(b)
+			return err
+		})
+		if err != nil || n > 0 {
+			return err
+		}
+		b, err := toYAML([]data.Article{})
+		if err != nil {
+			return err
+		}
+		_, err = p.w.Write(b)
+		return err
+	default:
+		tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, articlesHeader)
+		err := list(func(a data.Article) error {
+			articleRow(tw, a)
+			n++
+			if n%tableBlock == 0 {
+				return tw.Flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tw.Flush()
+	}
+}
+
+// toYAML converts v to YAML through its JSON encoding, so that fields keep
+// their JSON names and order
+func toYAML(v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		return nil, err
+	}
+	blockStyle(&n)
+	return yaml.Marshal(&n)
+}
+
+// blockStyle clears the flow and quoting styles of the JSON decoded into n,
+// the encoder still quotes the strings that need it
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// articlesHeader is the header of the tables of articles
+const articlesHeader = "ID\tDATE\tTITLE\tTAGS"
+
+func articlesTable(as []data.Article) func(tw *tabwriter.Writer) {
+	return func(tw *tabwriter.Writer) {
+		fmt.Fprintln(tw, articlesHeader)
+		for _, a := range as {
+			articleRow(tw, a)
+		}
+	}
+}
+
+// articleRow writes a as a row of a table of articles
+func articleRow(tw *tabwriter.Writer, a data.Article) {
+	fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", a.ID, a.Date, truncate(a.Title, maxTitleLen), strings.Join(a.Tags, ","))
+}
+
+func tagTable(t *data.Tag) func(tw *tabwriter.Writer) {
+	return func(tw *tabwriter.Writer) {
+		ids := make([]string, len(t.Articles))
+		for i, id := range t.Articles {
+			ids[i] = strconv.Itoa(id)
+		}
+		fmt.Fprintln(tw, "TAG\tCOUNT\tARTICLES\tRELATED TAGS")
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", t.Tag, t.Count, strings.Join(ids, ","), strings.Join(t.RelatedTags, ","))
+	}
+}
+
+func bulkTable(r *data.BulkReport) func(tw *tabwriter.Writer) {
+	return func(tw *tabwriter.Writer) {
+		itemRows(tw, r.Items)
+		fmt.Fprintf(tw, "\ncreated %d, failed %d (%s mode)\n", r.Created, r.Failed, r.Mode)
+	}
+}
+
+func importTable(r *data.ImportReport) func(tw *tabwriter.Writer) {
+	return func(tw *tabwriter.Writer) {
+		if r.Failed == 0 {
+			fmt.Fprintln(tw, "IMPORTED")
+			fmt.Fprintf(tw, "%d\n", r.Imported)
+			return
+		}
+		itemRows(tw, r.Items)
+		fmt.Fprintf(tw, "\nimported %d, failed %d\n", r.Imported, r.Failed)
+	}
+}
+
+// itemRows writes the items of a bulk or import report
+func itemRows(tw *tabwriter.Writer, items []data.BulkItem) {
+	fmt.Fprintln(tw, "INDEX\tSTATUS\tID\tERROR")
+	for _, it := range items {
+		id := ""
+		if it.ID != 0 {
+			id = strconv.Itoa(it.ID)
+		}
+		msg := it.Error
+		for _, fe := range it.Errors {
+			msg += "; " + fe.Field + ": " + fe.Message
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", it.Index, it.Status, id, msg)
+	}
+}
+
+// truncate shortens s to n runes
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,1045 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds all the settings of the API server
+type Config struct {
+	Server      Server      `yaml:"server" toml:"server"`
+	Database    Database    `yaml:"database" toml:"database"`
+	Log         Log         `yaml:"log" toml:"log"`
+	CORS        CORS        `yaml:"cors" toml:"cors"`
+	Tracing     Tracing     `yaml:"tracing" toml:"tracing"`
+	Cache       Cache       `yaml:"cache" toml:"cache"`
+	Redis       Redis       `yaml:"redis" toml:"redis"`
+	RateLimit   RateLimit   `yaml:"rate_limit" toml:"rate_limit"`
+	Idempotency Idempotency `yaml:"idempotency" toml:"idempotency"`
+	Webhooks    Webhooks    `yaml:"webhooks" toml:"webhooks"`
+	Events      Events      `yaml:"events" toml:"events"`
+	GRPC        GRPC        `yaml:"grpc" toml:"grpc"`
+	GraphQL     GraphQL     `yaml:"graphql" toml:"graphql"`
+	Docs        Docs        `yaml:"docs" toml:"docs"`
+	Legacy      Legacy      `yaml:"legacy_routes" toml:"legacy_routes"`
+	Auth        Auth        `yaml:"auth" toml:"auth"`
+}
+
+// Server holds the settings of the HTTP server
+type Server struct {
+	// address the server listens on
+	Address string `yaml:"address" toml:"address"`
+	// address the metrics are served on, apart from the API, empty to not
+	// serve them
+	MetricsAddress string `yaml:"metrics_address" toml:"metrics_address"`
+	// max time to read request from the client
+	ReadTimeout Duration `yaml:"read_timeout" toml:"read_timeout"`
+	// max time to write response to the client
+	WriteTimeout Duration `yaml:"write_timeout" toml:"write_timeout"`
+	// max time for connections using TCP Keep-Alive
+	IdleTimeout Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// max time to wait for in flight requests on shutdown
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// time to keep serving after readiness starts failing on shutdown, for
+	// load balancers to stop sending requests, 0 to stop at once
+	ShutdownDelay Duration `yaml:"shutdown_delay" toml:"shutdown_delay"`
+	// compress responses with gzip or brotli when the client accepts it
+	Compression bool `yaml:"compression" toml:"compression"`
+	// responses smaller than this many bytes are sent uncompressed
+	CompressionMinSize int `yaml:"compression_min_size" toml:"compression_min_size"`
+	// IPs or CIDR ranges of the proxies whose X-Forwarded-For header is
+	// believed when identifying clients
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// max size of request bodies, per route
+	BodyLimits BodyLimits `yaml:"body_limits" toml:"body_limits"`
+}
+
+// BodyLimits holds the max size of the request body of every route
+// accepting one, larger requests are rejected with 413
+type BodyLimits struct {
+	// POST /articles
+	Articles ByteSize `yaml:"articles" toml:"articles"`
+	// POST /articles:bulk
+	Bulk ByteSize `yaml:"bulk" toml:"bulk"`
+	// POST /import
+	Import ByteSize `yaml:"import" toml:"import"`
+}
+
+// Database holds the settings of the postgres connection. Either DSN or the
+// individual connection parts must be set.
+type Database struct {
+	DSN          string `yaml:"dsn" toml:"dsn"`
+	DSNFile      string `yaml:"dsn_file" toml:"dsn_file"`
+	Host         string `yaml:"host" toml:"host"`
+	Port         string `yaml:"port" toml:"port"`
+	User         string `yaml:"user" toml:"user"`
+	Password     string `yaml:"password" toml:"password"`
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+	Name         string `yaml:"name" toml:"name"`
+	// max number of open connections, 0 means unlimited
+	MaxOpenConns int `yaml:"max_open_conns" toml:"max_open_conns"`
+	// max number of idle connections kept in the pool
+	MaxIdleConns int `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	// max time a connection may be reused, 0 means forever
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	// max time a connection may sit idle in the pool, 0 means forever
+	ConnMaxIdleTime Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+
+	// one of disable, require, verify-ca or verify-full
+	SSLMode     string `yaml:"sslmode" toml:"sslmode"`
+	SSLRootCert string `yaml:"sslrootcert" toml:"sslrootcert"`
+	SSLCert     string `yaml:"sslcert" toml:"sslcert"`
+	SSLKey      string `yaml:"sslkey" toml:"sslkey"`
+
+	// how long to keep retrying to connect on startup
+	ConnectTimeout Duration `yaml:"connect_timeout" toml:"connect_timeout"`
+	// first and max delay between retries, the delay doubles on every attempt
+	RetryInitialBackoff Duration `yaml:"retry_initial_backoff" toml:"retry_initial_backoff"`
+	RetryMaxBackoff     Duration `yaml:"retry_max_backoff" toml:"retry_max_backoff"`
+	// number of times a read is retried after a transient error
+	QueryRetries int `yaml:"query_retries" toml:"query_retries"`
+
+	// connection strings of read replicas, reads are spread across them
+	Replicas []string `yaml:"replicas" toml:"replicas"`
+	// how often replicas are pinged to check they are usable
+	ReplicaCheckInterval Duration `yaml:"replica_check_interval" toml:"replica_check_interval"`
+	// how long a client reads from the primary after it writes, 0 disables it
+	ReadYourWrites Duration `yaml:"read_your_writes" toml:"read_your_writes"`
+}
+
+// Log holds the logger settings
+type Log struct {
+	// one of debug, info, warn or error
+	Level string `yaml:"level" toml:"level"`
+}
+
+// CORS holds the cross origin settings
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+}
+
+// Tracing holds the OpenTelemetry settings
+type Tracing struct {
+	// one of none, stdout or otlp
+	Exporter string `yaml:"exporter" toml:"exporter"`
+}
+
+// Cache holds the settings of the read cache
+type Cache struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// max number of entries kept in process
+	MaxEntries int `yaml:"max_entries" toml:"max_entries"`
+	// how long articles are cached
+	ArticleTTL Duration `yaml:"article_ttl" toml:"article_ttl"`
+	// how long tag summaries are cached
+	TagTTL Duration `yaml:"tag_ttl" toml:"tag_ttl"`
+	// max time of a database read shared by concurrent misses
+	LoadTimeout Duration `yaml:"load_timeout" toml:"load_timeout"`
+	// memory keeps entries in process only, redis shares them between
+	// replicas through the server configured in the redis section
+	Backend string `yaml:"backend" toml:"backend"`
+	// with the redis backend, how long entries are also kept in process
+	LocalTTL Duration `yaml:"local_ttl" toml:"local_ttl"`
+	// channel on which replicas broadcast invalidations
+	Channel string `yaml:"channel" toml:"channel"`
+}
+
+// Redis holds the settings of the Redis protocol server shared by replicas
+type Redis struct {
+	// host:port of the server
+	Address      string   `yaml:"address" toml:"address"`
+	Password     string   `yaml:"password" toml:"password"`
+	PasswordFile string   `yaml:"password_file" toml:"password_file"`
+	DB           int      `yaml:"db" toml:"db"`
+	PoolSize     int      `yaml:"pool_size" toml:"pool_size"`
+	Timeout      Duration `yaml:"timeout" toml:"timeout"`
+}
+
+// RateLimit holds the settings of the per client rate limiting
+type RateLimit struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// memory keeps the buckets in process, redis shares them between
+	// replicas through the server configured in the redis section
+	Backend string `yaml:"backend" toml:"backend"`
+	// limit of the article and tag summary reads
+	Read Limit `yaml:"read" toml:"read"`
+	// limit of the article writes
+	Write Limit `yaml:"write" toml:"write"`
+}
+
+// Idempotency holds the settings of the Idempotency-Key support of writes
+type Idempotency struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// memory keeps the responses in process, redis shares them between
+	// replicas through the server configured in the redis section
+	Backend string `yaml:"backend" toml:"backend"`
+	// how long responses are kept for retries
+	TTL Duration `yaml:"ttl" toml:"ttl"`
+}
+
+// Webhooks holds the settings of the delivery of article events to the
+// registered webhooks
+type Webhooks struct {
+	// deliver events from this instance, events are recorded either way
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// time between polls for due deliveries
+	Interval Duration `yaml:"interval" toml:"interval"`
+	// max time a webhook has to answer
+	Timeout Duration `yaml:"timeout" toml:"timeout"`
+	// max number of deliveries attempted at once
+	BatchSize int `yaml:"batch_size" toml:"batch_size"`
+	// attempts of a delivery before it is dead lettered
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts"`
+	// delay before the first retry, doubled on every retry up to max_backoff
+	InitialBackoff Duration `yaml:"initial_backoff" toml:"initial_backoff"`
+	MaxBackoff     Duration `yaml:"max_backoff" toml:"max_backoff"`
+	// how long delivered events are kept in the outbox, forever when zero,
+	// pruned even when webhooks are disabled
+	Retention Duration `yaml:"retention" toml:"retention"`
+}
+
+// Events holds the settings of the event stream
+type Events struct {
+	// serve GET /events
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// time between polls for the events recorded by other instances, those
+	// of this instance are streamed as soon as they are committed
+	PollInterval Duration `yaml:"poll_interval" toml:"poll_interval"`
+	// time between comments keeping idle streams open through proxies
+	KeepAlive Duration `yaml:"keep_alive" toml:"keep_alive"`
+}
+
+// GRPC holds the settings of the gRPC server
+type GRPC struct {
+	// serve the gRPC API, on its own address
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Address string `yaml:"address" toml:"address"`
+}
+
+// GraphQL holds the settings of the GraphQL endpoint
+type GraphQL struct {
+	// serve /graphql
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// deepest nesting of fields a query may have
+	MaxDepth int `yaml:"max_depth" toml:"max_depth"`
+	// highest complexity a query may have, every field counting 1 and the
+	// fields under a list 10 times
+	MaxComplexity int `yaml:"max_complexity" toml:"max_complexity"`
+}
+
+// Docs holds the settings of the API documentation
+type Docs struct {
+	// serve /openapi.json and /docs
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// where the docs page loads Swagger UI from, the directory of the
+	// swagger-ui-dist package of the embedded version, or empty for the copy
+	// embedded in the binary
+	SwaggerUIURL string `yaml:"swagger_ui_url" toml:"swagger_ui_url"`
+}
+
+// Legacy holds the settings of the unversioned routes, deprecated aliases
+// of the /v1 routes
+type Legacy struct {
+	// serve the routes without the /v1 prefix
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// dates, formatted as YYYY-MM-DD, sent in the Deprecation and Sunset
+	// headers of their responses; no Sunset header is sent when it is empty
+	Deprecated string `yaml:"deprecated" toml:"deprecated"`
+	Sunset     string `yaml:"sunset" toml:"sunset"`
+}
+
+// Dates returns the parsed deprecation and sunset dates, the latter zero
+// when it is not set
+func (l Legacy) Dates() (deprecated, sunset time.Time, err error) {
+	if deprecated, err = time.Parse("2006-01-02", l.Deprecated); err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("legacy_routes.deprecated %q is not a YYYY-MM-DD date", l.Deprecated)
+	}
+	if l.Sunset == "" {
+		return deprecated, time.Time{}, nil
+	}
+	if sunset, err = time.Parse("2006-01-02", l.Sunset); err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("legacy_routes.sunset %q is not a YYYY-MM-DD date", l.Sunset)
+	}
+	return deprecated, sunset, nil
+}
+
+// Auth holds the API keys granting access to the restricted routes
+type Auth struct {
+	Keys []APIKey `yaml:"keys" toml:"keys"`
+}
+
+// APIKey is a key clients send in the X-API-Key header, with the scopes it
+// grants
+type APIKey struct {
+	// name of the key, e.g. its owner
+	Name    string `yaml:"name" toml:"name"`
+	Key     string `yaml:"key" toml:"key"`
+	KeyFile string `yaml:"key_file" toml:"key_file"`
+	// admin grants exports, imports and webhook management
+	Scopes []string `yaml:"scopes" toml:"scopes"`
+}
+
+// scopes are the scopes API keys may grant
+var scopes = []string{"admin"}
+
+// Limit is a token bucket holding up to Burst requests, refilled with
+// Requests tokens every Period
+type Limit struct {
+	Requests int      `yaml:"requests" toml:"requests"`
+	Period   Duration `yaml:"period" toml:"period"`
+	Burst    int      `yaml:"burst" toml:"burst"`
+}
+
+// Duration is a time.Duration that is read from strings such as "5s"
+type Duration time.Duration
+
+// UnmarshalText parses the duration, it is used by both the YAML and TOML decoders
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText formats the duration as a string
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// ByteSize is a number of bytes that is read from strings such as "64KiB"
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+// UnmarshalText parses a number of bytes with an optional unit suffix
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	s, mult := strings.TrimSpace(string(text)), int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", text)
+	}
+	*b = ByteSize(n * mult)
+	return nil
+}
+
+// MarshalText formats the size in bytes
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatInt(int64(b), 10)), nil
+}
+
+// Default returns the configuration used when nothing else is specified
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Address:            ":8080",
+			MetricsAddress:     ":8081",
+			ReadTimeout:        Duration(5 * time.Second),
+			WriteTimeout:       Duration(10 * time.Second),
+			IdleTimeout:        Duration(120 * time.Second),
+			ShutdownTimeout:    Duration(30 * time.Second),
+			ShutdownDelay:      Duration(5 * time.Second),
+			Compression:        true,
+			CompressionMinSize: 1024,
+			BodyLimits: BodyLimits{
+				Articles: 128 << 10,
+				Bulk:     16 << 20,
+				Import:   1 << 30,
+			},
+		},
+		Database: Database{
+			Host:                 "localhost",
+			Port:                 "5432",
+			MaxOpenConns:         10,
+			MaxIdleConns:         5,
+			ConnMaxLifetime:      Duration(30 * time.Minute),
+			ConnMaxIdleTime:      Duration(5 * time.Minute),
+			SSLMode:              "disable",
+			ConnectTimeout:       Duration(60 * time.Second),
+			RetryInitialBackoff:  Duration(500 * time.Millisecond),
+			RetryMaxBackoff:      Duration(10 * time.Second),
+			QueryRetries:         2,
+			ReplicaCheckInterval: Duration(5 * time.Second),
+			ReadYourWrites:       Duration(5 * time.Second),
+		},
+		Log:     Log{Level: "info"},
+		CORS:    CORS{AllowedOrigins: []string{"*"}},
+		Tracing: Tracing{Exporter: "none"},
+		Cache: Cache{
+			Enabled:     true,
+			MaxEntries:  10000,
+			ArticleTTL:  Duration(5 * time.Minute),
+			TagTTL:      Duration(time.Minute),
+			LoadTimeout: Duration(10 * time.Second),
+			Backend:     "memory",
+			LocalTTL:    Duration(10 * time.Second),
+			Channel:     "article-api:cache:invalidate",
+		},
+		Redis: Redis{
+			Address:  "localhost:6379",
+			PoolSize: 10,
+			Timeout:  Duration(3 * time.Second),
+		},
+		RateLimit: RateLimit{
+			Enabled: true,
+			Backend: "memory",
+			Read:    Limit{Requests: 20, Period: Duration(time.Second), Burst: 40},
+			Write:   Limit{Requests: 60, Period: Duration(time.Minute), Burst: 10},
+		},
+		Idempotency: Idempotency{
+			Enabled: true,
+			Backend: "memory",
+			TTL:     Duration(24 * time.Hour),
+		},
+		Webhooks: Webhooks{
+			Enabled:        true,
+			Interval:       Duration(time.Second),
+			Timeout:        Duration(10 * time.Second),
+			BatchSize:      50,
+			MaxAttempts:    10,
+			InitialBackoff: Duration(10 * time.Second),
+			MaxBackoff:     Duration(time.Hour),
+			Retention:      Duration(7 * 24 * time.Hour),
+		},
+		Events: Events{
+			Enabled:      true,
+			PollInterval: Duration(2 * time.Second),
+			KeepAlive:    Duration(15 * time.Second),
+		},
+		GRPC: GRPC{
+			Enabled: true,
+			Address: ":9090",
+		},
+		GraphQL: GraphQL{
+			Enabled:       true,
+			MaxDepth:      10,
+			MaxComplexity: 1000,
+		},
+		Docs: Docs{
+			Enabled:      true,
+			SwaggerUIURL: "",
+		},
+		Legacy: Legacy{
+			Enabled: true,
+			// the day the /v1 routes were introduced
+			Deprecated: "2026-10-19",
+		},
+	}
+}
+
+// setting is a single value that can be set from the environment or the
+// command line, overriding the config file
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	apply func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"API_ADDR", "addr", "address to listen on", func(c *Config, v string) error {
+		c.Server.Address = v
+		return nil
+	}},
+	{"API_METRICS_ADDR", "metrics-addr", "address to serve the metrics on, empty to not serve them", func(c *Config, v string) error {
+		c.Server.MetricsAddress = v
+		return nil
+	}},
+	{"API_READ_TIMEOUT", "read-timeout", "max time to read a request", durationSetter(func(c *Config) *Duration { return &c.Server.ReadTimeout })},
+	{"API_WRITE_TIMEOUT", "write-timeout", "max time to write a response", durationSetter(func(c *Config) *Duration { return &c.Server.WriteTimeout })},
+	{"API_IDLE_TIMEOUT", "idle-timeout", "max time to keep idle connections", durationSetter(func(c *Config) *Duration { return &c.Server.IdleTimeout })},
+	{"API_SHUTDOWN_TIMEOUT", "shutdown-timeout", "max time to wait for requests on shutdown", durationSetter(func(c *Config) *Duration { return &c.Server.ShutdownTimeout })},
+	{"API_SHUTDOWN_DELAY", "shutdown-delay", "time to keep serving after readiness fails on shutdown", durationSetter(func(c *Config) *Duration { return &c.Server.ShutdownDelay })},
+	{"API_COMPRESSION", "compression", "compress responses with gzip or brotli", boolSetter(func(c *Config) *bool { return &c.Server.Compression })},
+	{"API_COMPRESSION_MIN_SIZE", "compression-min-size", "min response size in bytes to compress", intSetter(func(c *Config) *int { return &c.Server.CompressionMinSize })},
+	{"DATABASE_URL", "db-dsn", "postgres connection string", func(c *Config, v string) error {
+		c.Database.DSN = v
+		return nil
+	}},
+	{"DATABASE_URL_FILE", "db-dsn-file", "file containing the postgres connection string", func(c *Config, v string) error {
+		c.Database.DSNFile = v
+		return nil
+	}},
+	{"POSTGRES_URL", "db-host", "postgres host", func(c *Config, v string) error {
+		c.Database.Host = v
+		return nil
+	}},
+	{"POSTGRES_PORT", "db-port", "postgres port", func(c *Config, v string) error {
+		c.Database.Port = v
+		return nil
+	}},
+	{"POSTGRES_USER", "db-user", "postgres user", func(c *Config, v string) error {
+		c.Database.User = v
+		return nil
+	}},
+	{"POSTGRES_PASSWORD", "", "", func(c *Config, v string) error {
+		c.Database.Password = v
+		return nil
+	}},
+	{"POSTGRES_PASSWORD_FILE", "db-password-file", "file containing the postgres password", func(c *Config, v string) error {
+		c.Database.PasswordFile = v
+		return nil
+	}},
+	{"POSTGRES_DB", "db-name", "postgres database name", func(c *Config, v string) error {
+		c.Database.Name = v
+		return nil
+	}},
+	{"API_DB_MAX_OPEN_CONNS", "db-max-open-conns", "max open database connections", intSetter(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"API_DB_MAX_IDLE_CONNS", "db-max-idle-conns", "max idle database connections", intSetter(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"API_DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "max time a database connection is reused", durationSetter(func(c *Config) *Duration { return &c.Database.ConnMaxLifetime })},
+	{"API_DB_CONN_MAX_IDLE_TIME", "db-conn-max-idle-time", "max time a database connection stays idle", durationSetter(func(c *Config) *Duration { return &c.Database.ConnMaxIdleTime })},
+	{"POSTGRES_SSLMODE", "db-sslmode", "postgres sslmode (disable, require, verify-ca, verify-full)", func(c *Config, v string) error {
+		c.Database.SSLMode = v
+		return nil
+	}},
+	{"POSTGRES_SSLROOTCERT", "db-sslrootcert", "CA certificate used to verify the postgres server", func(c *Config, v string) error {
+		c.Database.SSLRootCert = v
+		return nil
+	}},
+	{"POSTGRES_SSLCERT", "db-sslcert", "client certificate for postgres", func(c *Config, v string) error {
+		c.Database.SSLCert = v
+		return nil
+	}},
+	{"POSTGRES_SSLKEY", "db-sslkey", "client key for postgres", func(c *Config, v string) error {
+		c.Database.SSLKey = v
+		return nil
+	}},
+	{"API_DB_CONNECT_TIMEOUT", "db-connect-timeout", "how long to retry connecting to the database on startup", durationSetter(func(c *Config) *Duration { return &c.Database.ConnectTimeout })},
+	{"API_DB_RETRY_INITIAL_BACKOFF", "db-retry-initial-backoff", "first delay between database retries", durationSetter(func(c *Config) *Duration { return &c.Database.RetryInitialBackoff })},
+	{"API_DB_RETRY_MAX_BACKOFF", "db-retry-max-backoff", "max delay between database retries", durationSetter(func(c *Config) *Duration { return &c.Database.RetryMaxBackoff })},
+	{"API_DB_QUERY_RETRIES", "db-query-retries", "retries of reads failing with transient errors", intSetter(func(c *Config) *int { return &c.Database.QueryRetries })},
+	{"POSTGRES_REPLICA_URLS", "db-replicas", "comma separated connection strings of read replicas", func(c *Config, v string) error {
+		c.Database.Replicas = splitList(v)
+		return nil
+	}},
+	{"API_DB_READ_YOUR_WRITES", "db-read-your-writes", "how long a client reads from the primary after writing", durationSetter(func(c *Config) *Duration { return &c.Database.ReadYourWrites })},
+	{"API_LOG_LEVEL", "log-level", "log level (debug, info, warn, error)", func(c *Config, v string) error {
+		c.Log.Level = v
+		return nil
+	}},
+	{"API_CORS_ORIGINS", "cors-origins", "comma separated list of allowed CORS origins", func(c *Config, v string) error {
+		c.CORS.AllowedOrigins = splitList(v)
+		return nil
+	}},
+	{"API_CACHE_ENABLED", "cache-enabled", "cache article and tag summary reads", boolSetter(func(c *Config) *bool { return &c.Cache.Enabled })},
+	{"API_CACHE_MAX_ENTRIES", "cache-max-entries", "max number of cached entries", intSetter(func(c *Config) *int { return &c.Cache.MaxEntries })},
+	{"API_CACHE_ARTICLE_TTL", "cache-article-ttl", "how long articles are cached", durationSetter(func(c *Config) *Duration { return &c.Cache.ArticleTTL })},
+	{"API_CACHE_TAG_TTL", "cache-tag-ttl", "how long tag summaries are cached", durationSetter(func(c *Config) *Duration { return &c.Cache.TagTTL })},
+	{"API_CACHE_LOAD_TIMEOUT", "cache-load-timeout", "max time of a database read shared by concurrent misses", durationSetter(func(c *Config) *Duration { return &c.Cache.LoadTimeout })},
+	{"API_CACHE_BACKEND", "cache-backend", "cache backend (memory, redis)", func(c *Config, v string) error {
+		c.Cache.Backend = v
+		return nil
+	}},
+	{"API_TRUSTED_PROXIES", "trusted-proxies", "comma separated list of trusted proxy IPs or CIDR ranges", func(c *Config, v string) error {
+		c.Server.TrustedProxies = splitList(v)
+		return nil
+	}},
+	{"API_BODY_LIMIT_ARTICLES", "body-limit-articles", "max size of POST /articles request bodies, e.g. 128KiB", func(c *Config, v string) error {
+		return c.Server.BodyLimits.Articles.UnmarshalText([]byte(v))
+	}},
+	{"API_BODY_LIMIT_BULK", "body-limit-bulk", "max size of POST /articles:bulk request bodies, e.g. 16MiB", func(c *Config, v string) error {
+		return c.Server.BodyLimits.Bulk.UnmarshalText([]byte(v))
+	}},
+	{"API_BODY_LIMIT_IMPORT", "body-limit-import", "max size of POST /import request bodies, e.g. 1GiB", func(c *Config, v string) error {
+		return c.Server.BodyLimits.Import.UnmarshalText([]byte(v))
+	}},
+	{"API_RATELIMIT_ENABLED", "ratelimit-enabled", "rate limit the requests of every client", boolSetter(func(c *Config) *bool { return &c.RateLimit.Enabled })},
+	{"API_RATELIMIT_BACKEND", "ratelimit-backend", "rate limit backend (memory, redis)", func(c *Config, v string) error {
+		c.RateLimit.Backend = v
+		return nil
+	}},
+	{"API_RATELIMIT_READ_REQUESTS", "ratelimit-read-requests", "reads allowed per period", intSetter(func(c *Config) *int { return &c.RateLimit.Read.Requests })},
+	{"API_RATELIMIT_READ_PERIOD", "ratelimit-read-period", "period of the read limit", durationSetter(func(c *Config) *Duration { return &c.RateLimit.Read.Period })},
+	{"API_RATELIMIT_READ_BURST", "ratelimit-read-burst", "max burst of reads", intSetter(func(c *Config) *int { return &c.RateLimit.Read.Burst })},
+	{"API_RATELIMIT_WRITE_REQUESTS", "ratelimit-write-requests", "writes allowed per period", intSetter(func(c *Config) *int { return &c.RateLimit.Write.Requests })},
+	{"API_RATELIMIT_WRITE_PERIOD", "ratelimit-write-period", "period of the write limit", durationSetter(func(c *Config) *Duration { return &c.RateLimit.Write.Period })},
+	{"API_RATELIMIT_WRITE_BURST", "ratelimit-write-burst", "max burst of writes", intSetter(func(c *Config) *int { return &c.RateLimit.Write.Burst })},
+	{"API_IDEMPOTENCY_ENABLED", "idempotency-enabled", "honour Idempotency-Key headers on writes", boolSetter(func(c *Config) *bool { return &c.Idempotency.Enabled })},
+	{"API_IDEMPOTENCY_BACKEND", "idempotency-backend", "idempotency backend (memory, redis)", func(c *Config, v string) error {
+		c.Idempotency.Backend = v
+		return nil
+	}},
+	{"API_IDEMPOTENCY_TTL", "idempotency-ttl", "how long responses to idempotent requests are kept", durationSetter(func(c *Config) *Duration { return &c.Idempotency.TTL })},
+	{"API_WEBHOOKS_ENABLED", "webhooks-enabled", "deliver article events to webhooks", boolSetter(func(c *Config) *bool { return &c.Webhooks.Enabled })},
+	{"API_WEBHOOKS_TIMEOUT", "webhooks-timeout", "max time a webhook has to answer", durationSetter(func(c *Config) *Duration { return &c.Webhooks.Timeout })},
+	{"API_WEBHOOKS_MAX_ATTEMPTS", "webhooks-max-attempts", "attempts of a webhook delivery before it is dead lettered", intSetter(func(c *Config) *int { return &c.Webhooks.MaxAttempts })},
+	{"API_WEBHOOKS_RETENTION", "webhooks-retention", "how long delivered events are kept", durationSetter(func(c *Config) *Duration { return &c.Webhooks.Retention })},
+	{"API_EVENTS_ENABLED", "events-enabled", "serve the event stream", boolSetter(func(c *Config) *bool { return &c.Events.Enabled })},
+	{"API_EVENTS_POLL_INTERVAL", "events-poll-interval", "time between polls for events of other instances", durationSetter(func(c *Config) *Duration { return &c.Events.PollInterval })},
+	{"API_GRPC_ENABLED", "grpc-enabled", "serve the gRPC API", boolSetter(func(c *Config) *bool { return &c.GRPC.Enabled })},
+	{"API_GRPC_ADDR", "grpc-addr", "address the gRPC API listens on", func(c *Config, v string) error {
+		c.GRPC.Address = v
+		return nil
+	}},
+	{"API_GRAPHQL_ENABLED", "graphql-enabled", "serve the GraphQL endpoint", boolSetter(func(c *Config) *bool { return &c.GraphQL.Enabled })},
+	{"API_GRAPHQL_MAX_DEPTH", "graphql-max-depth", "deepest nesting of fields of a GraphQL query", intSetter(func(c *Config) *int { return &c.GraphQL.MaxDepth })},
+	{"API_GRAPHQL_MAX_COMPLEXITY", "graphql-max-complexity", "highest complexity of a GraphQL query", intSetter(func(c *Config) *int { return &c.GraphQL.MaxComplexity })},
+	{"API_DOCS_ENABLED", "docs-enabled", "serve the OpenAPI document and the docs page", boolSetter(func(c *Config) *bool { return &c.Docs.Enabled })},
+	{"API_DOCS_SWAGGER_UI_URL", "docs-swagger-ui-url", "where the docs page loads Swagger UI from", func(c *Config, v string) error {
+		c.Docs.SwaggerUIURL = v
+		return nil
+	}},
+	{"API_LEGACY_ROUTES_ENABLED", "legacy-routes-enabled", "serve the deprecated unversioned routes", boolSetter(func(c *Config) *bool { return &c.Legacy.Enabled })},
+	{"API_LEGACY_ROUTES_DEPRECATED", "legacy-routes-deprecated", "date the unversioned routes were deprecated on, YYYY-MM-DD", func(c *Config, v string) error {
+		c.Legacy.Deprecated = v
+		return nil
+	}},
+	{"API_LEGACY_ROUTES_SUNSET", "legacy-routes-sunset", "date the unversioned routes will be removed on, YYYY-MM-DD", func(c *Config, v string) error {
+		c.Legacy.Sunset = v
+		return nil
+	}},
+	{"API_ADMIN_KEYS", "", "", func(c *Config, v string) error {
+		for _, k := range splitList(v) {
+			c.Auth.Keys = append(c.Auth.Keys, APIKey{Name: "API_ADMIN_KEYS", Key: k, Scopes: []string{"admin"}})
+		}
+		return nil
+	}},
+	{"REDIS_ADDR", "redis-addr", "host:port of the redis server", func(c *Config, v string) error {
+		c.Redis.Address = v
+		return nil
+	}},
+	{"REDIS_PASSWORD", "", "", func(c *Config, v string) error {
+		c.Redis.Password = v
+		return nil
+	}},
+	{"REDIS_PASSWORD_FILE", "redis-password-file", "file containing the redis password", func(c *Config, v string) error {
+		c.Redis.PasswordFile = v
+		return nil
+	}},
+	{"REDIS_DB", "redis-db", "redis database number", intSetter(func(c *Config) *int { return &c.Redis.DB })},
+	{"OTEL_TRACES_EXPORTER", "trace-exporter", "trace exporter (none, stdout, otlp)", func(c *Config, v string) error {
+		c.Tracing.Exporter = v
+		return nil
+	}},
+}
+
+func durationSetter(field func(c *Config) *Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		return field(c).UnmarshalText([]byte(v))
+	}
+}
+
+func intSetter(field func(c *Config) *int) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func boolSetter(field func(c *Config) *bool) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	return items
+}
+
+// Load builds the configuration by layering, from lowest to highest
+// precedence, the defaults, the config file given by the -config flag or the
+// API_CONFIG variable, the environment and the command line arguments.
+// Secrets referenced by *_file settings are read last and the result is
+// validated.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("article-api", flag.ContinueOnError)
+	configFile := fs.String("config", getenv("API_CONFIG"), "path to a YAML or TOML config file")
+	flagValues := map[string]*string{}
+	for _, s := range settings {
+		if s.flag != "" {
+			flagValues[s.flag] = fs.String(s.flag, "", s.usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := Default()
+
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.apply(c, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if e := s.apply(c, *flagValues[f.Name]); e != nil {
+					err = fmt.Errorf("-%s: %w", f.Name, e)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.readSecrets(); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile merges the YAML or TOML file at path into c, the format is
+// chosen from the file extension
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, c)
+	case ".toml":
+		err = toml.Unmarshal(b, c)
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// readSecrets replaces secrets with the content of the files they reference
+func (c *Config) readSecrets() error {
+	for _, s := range []struct {
+		file  string
+		value *string
+	}{
+		{c.Database.DSNFile, &c.Database.DSN},
+		{c.Database.PasswordFile, &c.Database.Password},
+		{c.Redis.PasswordFile, &c.Redis.Password},
+	} {
+		if s.file == "" {
+			continue
+		}
+		b, err := os.ReadFile(s.file)
+		if err != nil {
+			return fmt.Errorf("reading secret: %w", err)
+		}
+		*s.value = strings.TrimSpace(string(b))
+	}
+	for i, k := range c.Auth.Keys {
+		if k.KeyFile == "" {
+			continue
+		}
+		b, err := os.ReadFile(k.KeyFile)
+		if err != nil {
+			return fmt.Errorf("reading API key %s: %w", k.Name, err)
+		}
+		c.Auth.Keys[i].Key = strings.TrimSpace(string(b))
+	}
+	return nil
+}
+
+// Validate checks that the configuration is usable
+func (c *Config) Validate() error {
+	var errs []string
+
+	if c.Server.Address == "" {
+		errs = append(errs, "server.address is required")
+	}
+	if c.Server.CompressionMinSize < 0 {
+		errs = append(errs, "server.compression_min_size must not be negative")
+	}
+	if c.Server.BodyLimits.Articles <= 0 {
+		errs = append(errs, "server.body_limits.articles must be positive")
+	}
+	if c.Server.BodyLimits.Bulk <= 0 {
+		errs = append(errs, "server.body_limits.bulk must be positive")
+	}
+	if c.Server.BodyLimits.Import <= 0 {
+		errs = append(errs, "server.body_limits.import must be positive")
+	}
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"database.connect_timeout", c.Database.ConnectTimeout},
+		{"database.retry_initial_backoff", c.Database.RetryInitialBackoff},
+		{"database.retry_max_backoff", c.Database.RetryMaxBackoff},
+		{"cache.article_ttl", c.Cache.ArticleTTL},
+		{"cache.tag_ttl", c.Cache.TagTTL},
+		{"cache.load_timeout", c.Cache.LoadTimeout},
+		{"cache.local_ttl", c.Cache.LocalTTL},
+		{"redis.timeout", c.Redis.Timeout},
+	} {
+		if d.value <= 0 {
+			errs = append(errs, d.name+" must be positive")
+		}
+	}
+	if c.Server.ShutdownDelay < 0 {
+		errs = append(errs, "server.shutdown_delay must not be negative")
+	}
+
+	if c.Database.DSN == "" {
+		if c.Database.Host == "" || c.Database.User == "" || c.Database.Name == "" {
+			errs = append(errs, "database.dsn or database.host, database.user and database.name are required")
+		}
+		if _, err := strconv.Atoi(c.Database.Port); err != nil {
+			errs = append(errs, fmt.Sprintf("database.port %q is not a number", c.Database.Port))
+		}
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		errs = append(errs, "database pool sizes must not be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, "database.max_idle_conns must not exceed database.max_open_conns")
+	}
+	if c.Database.ConnMaxLifetime < 0 || c.Database.ConnMaxIdleTime < 0 {
+		errs = append(errs, "database connection lifetimes must not be negative")
+	}
+	if len(c.Database.Replicas) != 0 && c.Database.ReplicaCheckInterval <= 0 {
+		errs = append(errs, "database.replica_check_interval must be positive")
+	}
+	if c.Database.ReadYourWrites < 0 {
+		errs = append(errs, "database.read_your_writes must not be negative")
+	}
+	if c.Database.RetryMaxBackoff < c.Database.RetryInitialBackoff {
+		errs = append(errs, "database.retry_initial_backoff must be at most database.retry_max_backoff")
+	}
+	if c.Database.QueryRetries < 0 {
+		errs = append(errs, "database.query_retries must not be negative")
+	}
+	switch c.Database.SSLMode {
+	case "disable", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Sprintf("database.sslmode %q is not one of disable, require, verify-ca or verify-full", c.Database.SSLMode))
+	}
+	if (c.Database.SSLCert == "") != (c.Database.SSLKey == "") {
+		errs = append(errs, "database.sslcert and database.sslkey must be set together")
+	}
+
+	if c.Cache.MaxEntries <= 0 {
+		errs = append(errs, "cache.max_entries must be positive")
+	}
+	switch c.Cache.Backend {
+	case "memory":
+	case "redis":
+		if c.Cache.Channel == "" {
+			errs = append(errs, "cache.channel is required with the redis backend")
+		}
+		if c.Redis.Address == "" {
+			errs = append(errs, "redis.address is required with the redis cache backend")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("cache.backend %q is not one of memory or redis", c.Cache.Backend))
+	}
+	if c.Redis.PoolSize <= 0 {
+		errs = append(errs, "redis.pool_size must be positive")
+	}
+
+	for _, p := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			errs = append(errs, fmt.Sprintf("server.trusted_proxies: %q is not an IP or CIDR range", p))
+		}
+	}
+
+	if c.RateLimit.Enabled {
+		switch c.RateLimit.Backend {
+		case "memory":
+		case "redis":
+			if c.Redis.Address == "" {
+				errs = append(errs, "redis.address is required with the redis rate limit backend")
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("rate_limit.backend %q is not one of memory or redis", c.RateLimit.Backend))
+		}
+		for _, l := range []struct {
+			name  string
+			value Limit
+		}{
+			{"rate_limit.read", c.RateLimit.Read},
+			{"rate_limit.write", c.RateLimit.Write},
+		} {
+			if l.value.Requests <= 0 || l.value.Period <= 0 || l.value.Burst <= 0 {
+				errs = append(errs, l.name+" requests, period and burst must be positive")
+			}
+		}
+	}
+
+	if c.Idempotency.Enabled {
+		switch c.Idempotency.Backend {
+		case "memory":
+		case "redis":
+			if c.Redis.Address == "" {
+				errs = append(errs, "redis.address is required with the redis idempotency backend")
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("idempotency.backend %q is not one of memory or redis", c.Idempotency.Backend))
+		}
+		if c.Idempotency.TTL <= 0 {
+			errs = append(errs, "idempotency.ttl must be positive")
+		}
+	}
+
+	if c.Webhooks.Enabled {
+		if c.Webhooks.Interval <= 0 || c.Webhooks.Timeout <= 0 {
+			errs = append(errs, "webhooks.interval and webhooks.timeout must be positive")
+		}
+		if c.Webhooks.BatchSize <= 0 || c.Webhooks.MaxAttempts <= 0 {
+			errs = append(errs, "webhooks.batch_size and webhooks.max_attempts must be positive")
+		}
+		if c.Webhooks.InitialBackoff <= 0 || c.Webhooks.MaxBackoff < c.Webhooks.InitialBackoff {
+			errs = append(errs, "webhooks.initial_backoff must be positive and at most webhooks.max_backoff")
+		}
+		if c.Webhooks.Retention < 0 {
+			errs = append(errs, "webhooks.retention must not be negative")
+		}
+	}
+
+	if c.Events.Enabled && (c.Events.PollInterval <= 0 || c.Events.KeepAlive <= 0) {
+		errs = append(errs, "events.poll_interval and events.keep_alive must be positive")
+	}
+
+	if c.GRPC.Enabled {
+		switch c.GRPC.Address {
+		case "":
+			errs = append(errs, "grpc.address is required")
+		case c.Server.Address:
+			errs = append(errs, "grpc.address must differ from server.address")
+		}
+	}
+	if m := c.Server.MetricsAddress; m != "" && (m == c.Server.Address || c.GRPC.Enabled && m == c.GRPC.Address) {
+		errs = append(errs, "server.metrics_address must differ from server.address and grpc.address")
+	}
+
+	if c.GraphQL.Enabled && (c.GraphQL.MaxDepth <= 0 || c.GraphQL.MaxComplexity <= 0) {
+		errs = append(errs, "graphql.max_depth and graphql.max_complexity must be positive")
+	}
+
+	if c.Docs.Enabled && c.Docs.SwaggerUIURL != "" {
+		if u, err := url.Parse(c.Docs.SwaggerUIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, "docs.swagger_ui_url must be empty or an http or https URL")
+		}
+	}
+
+	if c.Legacy.Enabled {
+		deprecated, sunset, err := c.Legacy.Dates()
+		switch {
+		case err != nil:
+			errs = append(errs, err.Error())
+		case !sunset.IsZero() && !sunset.After(deprecated):
+			errs = append(errs, "legacy_routes.sunset must be after legacy_routes.deprecated")
+		}
+	}
+
+	seen := map[string]bool{}
+	for i, k := range c.Auth.Keys {
+		name := k.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		switch {
+		case k.Key == "":
+			errs = append(errs, fmt.Sprintf("auth.keys %s: key or key_file is required", name))
+		case seen[k.Key]:
+			errs = append(errs, fmt.Sprintf("auth.keys %s: key is listed twice", name))
+		}
+		seen[k.Key] = true
+		if len(k.Scopes) == 0 {
+			errs = append(errs, fmt.Sprintf("auth.keys %s: scopes are required", name))
+		}
+		for _, sc := range k.Scopes {
+			if !contains(scopes, sc) {
+				errs = append(errs, fmt.Sprintf("auth.keys %s: scope %q is not one of %s", name, sc, strings.Join(scopes, ", ")))
+			}
+		}
+	}
+
+	if _, err := c.LogLevel(); err != nil {
+		errs = append(errs, "log.level: "+err.Error())
+	}
+
+	for _, o := range c.CORS.AllowedOrigins {
+		if o == "" {
+			errs = append(errs, "cors.allowed_origins must not contain empty origins")
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Sprintf("tracing.exporter %q is not one of none, stdout or otlp", c.Tracing.Exporter))
+	}
+
+	if len(errs) != 0 {
+		return errors.New("invalid configuration: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// LogLevel returns the parsed log level
+func (c *Config) LogLevel() (zapcore.Level, error) {
+	return zapcore.ParseLevel(c.Log.Level)
+}
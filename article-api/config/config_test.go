@@ -0,0 +1,292 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlFile := filepath.Join(dir, "config.yaml")
+	os.WriteFile(yamlFile, []byte(`
+server:
+  address: ":9000"
+  read_timeout: 7s
+  shutdown_delay: 10s
+  body_limits:
+    articles: 64KiB
+database:
+  host: db.internal
+  user: file-user
+  name: articles
+log:
+  level: debug
+`), 0o600)
+
+	tomlFile := filepath.Join(dir, "config.toml")
+	os.WriteFile(tomlFile, []byte(`
+[server]
+address = ":9100"
+read_timeout = "8s"
+
+[server.body_limits]
+articles = 65536
+
+[database]
+host = "db.internal"
+user = "file-user"
+name = "articles"
+`), 0o600)
+
+	secret := filepath.Join(dir, "password")
+	os.WriteFile(secret, []byte("s3cret\n"), 0o600)
+
+	env := map[string]string{
+		"POSTGRES_USER":            "env-user",
+		"POSTGRES_PASSWORD_FILE":   secret,
+		"API_CORS_ORIGINS":         "https://a.example, https://b.example",
+		"API_DB_RETRY_MAX_BACKOFF": "2s",
+	}
+	getenv := func(k string) string { return env[k] }
+
+	tt := []struct {
+		name    string
+		args    []string
+		address string
+		read    time.Duration
+		delay   time.Duration
+		level   string
+	}{
+		{
+			name:    "yaml file overrides defaults",
+			args:    []string{"-config", yamlFile},
+			address: ":9000",
+			read:    7 * time.Second,
+			delay:   10 * time.Second,
+			level:   "debug",
+		},
+		{
+			name:    "toml file overrides defaults",
+			args:    []string{"-config", tomlFile},
+			address: ":9100",
+			read:    8 * time.Second,
+			delay:   5 * time.Second,
+			level:   "info",
+		},
+		{
+			name:    "flags override file",
+			args:    []string{"-config", yamlFile, "-addr", ":9200", "-log-level", "warn", "-shutdown-delay", "0s"},
+			address: ":9200",
+			read:    7 * time.Second,
+			level:   "warn",
+		},
+	}
+
+	for _, tc := range tt {
+		c, err := Load(tc.args, getenv)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if c.Server.Address != tc.address {
+			t.Errorf("%s: expected address %s but got %s", tc.name, tc.address, c.Server.Address)
+		}
+		if time.Duration(c.Server.ReadTimeout) != tc.read {
+			t.Errorf("%s: expected read timeout %v but got %v", tc.name, tc.read, time.Duration(c.Server.ReadTimeout))
+		}
+		if time.Duration(c.Server.ShutdownDelay) != tc.delay {
+			t.Errorf("%s: expected shutdown delay %v but got %v", tc.name, tc.delay, time.Duration(c.Server.ShutdownDelay))
+		}
+		if c.Server.BodyLimits.Articles != 64<<10 {
+			t.Errorf("%s: expected articles body limit 64KiB but got %d", tc.name, c.Server.BodyLimits.Articles)
+		}
+		if c.Log.Level != tc.level {
+			t.Errorf("%s: expected log level %s but got %s", tc.name, tc.level, c.Log.Level)
+		}
+		// the environment overrides the file, secrets are read from files
+		if c.Database.User != "env-user" || c.Database.Password != "s3cret" {
+			t.Errorf("%s: unexpected database credentials %s:%s", tc.name, c.Database.User, c.Database.Password)
+		}
+		if time.Duration(c.Database.RetryMaxBackoff) != 2*time.Second {
+			t.Errorf("%s: expected database retry max backoff 2s but got %v", tc.name, time.Duration(c.Database.RetryMaxBackoff))
+		}
+		if len(c.CORS.AllowedOrigins) != 2 || c.CORS.AllowedOrigins[1] != "https://b.example" {
+			t.Errorf("%s: unexpected CORS origins %v", tc.name, c.CORS.AllowedOrigins)
+		}
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	tt := []struct {
+		text string
+		size ByteSize
+		err  bool
+	}{
+		{text: "512", size: 512},
+		{text: "512B", size: 512},
+		{text: "64KiB", size: 64 << 10},
+		{text: "2 MiB", size: 2 << 20},
+		{text: "1MB", size: 1000000},
+		{text: "-1", err: true},
+		{text: "1.5MiB", err: true},
+		{text: "lots", err: true},
+	}
+
+	for _, tc := range tt {
+		var b ByteSize
+		err := b.UnmarshalText([]byte(tc.text))
+		if (err != nil) != tc.err || b != tc.size {
+			t.Errorf("%q: expected %d (error %v) but got %d (%v)", tc.text, tc.size, tc.err, b, err)
+		}
+	}
+}
+
+func TestAuthKeys(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "backup-key")
+	os.WriteFile(keyFile, []byte("from-file\n"), 0o600)
+	configFile := filepath.Join(dir, "config.yaml")
+	os.WriteFile(configFile, []byte(`
+auth:
+  keys:
+    - name: backup
+      key_file: `+keyFile+`
+      scopes: [admin]
+`), 0o600)
+
+	env := map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_ADMIN_KEYS": "k1, k2"}
+	c, err := Load([]string{"-config", configFile}, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, k := range c.Auth.Keys {
+		if len(k.Scopes) != 1 || k.Scopes[0] != "admin" {
+			t.Errorf("Expected key %s to grant admin, got %v", k.Name, k.Scopes)
+		}
+		keys = append(keys, k.Key)
+	}
+	if strings.Join(keys, ",") != "from-file,k1,k2" {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+
+	tt := []struct {
+		name string
+		env  map[string]string
+		args []string
+		err  string
+	}{
+		{
+			name: "missing database settings",
+			env:  map[string]string{},
+			err:  "database.dsn",
+		},
+		{
+			name: "bad log level",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db"},
+			args: []string{"-log-level", "loud"},
+			err:  "log.level",
+		},
+		{
+			name: "bad duration",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_READ_TIMEOUT": "soon"},
+			err:  "API_READ_TIMEOUT",
+		},
+		{
+			name: "negative shutdown delay",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_SHUTDOWN_DELAY": "-1s"},
+			err:  "server.shutdown_delay",
+		},
+		{
+			name: "negative database backoff",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db"},
+			args: []string{"-db-retry-initial-backoff", "-1s"},
+			err:  "database.retry_initial_backoff must be positive",
+		},
+		{
+			name: "database backoff above its max",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_DB_RETRY_MAX_BACKOFF": "100ms"},
+			err:  "database.retry_initial_backoff must be at most database.retry_max_backoff",
+		},
+		{
+			name: "idle pool larger than open pool",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_DB_MAX_OPEN_CONNS": "2"},
+			err:  "max_idle_conns",
+		},
+		{
+			name: "bad trusted proxy",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_TRUSTED_PROXIES": "10.0.0.0/8,proxy.local"},
+			err:  "server.trusted_proxies",
+		},
+		{
+			name: "zero rate limit",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_RATELIMIT_WRITE_BURST": "0"},
+			err:  "rate_limit.write",
+		},
+		{
+			name: "unknown idempotency backend",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_IDEMPOTENCY_BACKEND": "disk"},
+			err:  "idempotency.backend",
+		},
+		{
+			name: "no webhook attempts",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_WEBHOOKS_MAX_ATTEMPTS": "0"},
+			err:  "webhooks.batch_size and webhooks.max_attempts",
+		},
+		{
+			name: "no event polling",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_EVENTS_POLL_INTERVAL": "0s"},
+			err:  "events.poll_interval",
+		},
+		{
+			name: "metrics on the grpc address",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db"},
+			args: []string{"-metrics-addr", ":9090"},
+			err:  "server.metrics_address must differ",
+		},
+		{
+			name: "grpc on the http address",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_GRPC_ADDR": ":8080"},
+			err:  "grpc.address must differ",
+		},
+		{
+			name: "unbounded graphql queries",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_GRAPHQL_MAX_DEPTH": "0"},
+			err:  "graphql.max_depth",
+		},
+		{
+			name: "relative swagger ui url",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_DOCS_SWAGGER_UI_URL": "/swagger-ui"},
+			err:  "docs.swagger_ui_url",
+		},
+		{
+			name: "invalid legacy sunset",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_LEGACY_ROUTES_SUNSET": "01/04/2027"},
+			err:  "legacy_routes.sunset",
+		},
+		{
+			name: "legacy sunset before deprecation",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_LEGACY_ROUTES_SUNSET": "2026-01-01"},
+			err:  "must be after legacy_routes.deprecated",
+		},
+		{
+			name: "duplicate admin key",
+			env:  map[string]string{"DATABASE_URL": "postgres://localhost/db", "API_ADMIN_KEYS": "k1,k1"},
+			err:  "listed twice",
+		},
+	}
+
+	for _, tc := range tt {
+		_, err := Load(tc.args, func(k string) string { return tc.env[k] })
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error mentioning %q but got %v", tc.name, tc.err, err)
+		}
+	}
+}
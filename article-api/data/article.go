@@ -1,5 +1,7 @@
 package data
 
+import "time"
+
 type Article struct {
 	// Unique identifier for the article
 	//
@@ -10,21 +12,30 @@ type Article struct {
 	//
 	// required: true
 	// max length: 500
-	Title string `json:"title" validate:"required"`
+	Title string `json:"title" validate:"required,max=500"`
 
-	// the date of the article
+	// the date of the article, formatted as YYYY-MM-DD
 	//
 	// required: true
-	Date string `json:"date"`
+	// pattern: ^\d{4}-\d{2}-\d{2}$
+	Date string `json:"date" validate:"required,date"`
 
 	// the body for this article
 	//
 	// required: true
 	// max length: 10000
-	Body string `json:"body" validate:"required"`
+	Body string `json:"body" validate:"required,max=10000"`
 
-	// the tags for the article
+	// the tags for the article, each made of up to 32 letters, digits,
+	// hyphens and underscores
 	//
 	// required: false
-	Tags []string `json:"tags"`
+	// max items: 10
+	// unique: true
+	Tags []string `json:"tags" validate:"max=10,unique,dive,tag"`
+
+	// when the article was last written, set by the database
+	//
+	// read only: true
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
 }
@@ -1,42 +1,53 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"os"
+	"net"
+	"net/url"
+	"strconv"
 	"time"
 
-	"github.com/joho/godotenv"
-
 	"github.com/lib/pq"
 
+	"github.com/sg83/go-microservice/article-api/config"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+var tracer = tracing.Tracer("github.com/sg83/go-microservice/article-api/data")
+
 type ArticlesDb struct {
 	postgres *sql.DB
 	l        *zap.Logger
+	c        config.Database
+	rs       *replicaSet
+	// called after commits recording events
+	listeners []func()
 }
 
 type ArticlesData interface {
-	GetArticleByID(id int) (*Article, error)
-	AddArticle(ar Article) error
-	GetArticlesForTagAndDate(tag string, date string) ([]int, error)
-	GetRelatedTagsForTag(tag string, articles []int) ([]string, error)
+	GetArticleByID(ctx context.Context, id int) (*Article, error)
+	GetArticlesByIDs(ctx context.Context, ids []int) ([]Article, error)
+	AddArticle(ctx context.Context, ar Article) error
+	AddArticles(ctx context.Context, ars []Article) ([]int, error)
+	GetArticlesForTagAndDate(ctx context.Context, tag string, date string) ([]int, error)
+	GetArticlesForTagsAndDates(ctx context.Context, keys []TagDate) ([][]int, error)
+	GetRelatedTagsForTag(ctx context.Context, tag string, articles []int) ([]string, error)
+	GetTagLastModified(ctx context.Context, tag string, date string) (time.Time, error)
+	ExportArticles(ctx context.Context, f ExportFilter, fn func(Article) error) error
+	ImportArticles(ctx context.Context, next func() (Article, error)) (int, error)
 	Close()
 }
 
-// a struct to hold all the db connection information
-type connection struct {
-	Host     string
-	Port     string
-	User     string
-	Password string
-	DBName   string
-}
-
-func NewDB(l *zap.Logger) *ArticlesDb {
-	artdb := &ArticlesDb{nil, l}
+func NewDB(l *zap.Logger, c config.Database) *ArticlesDb {
+	artdb := &ArticlesDb{postgres: nil, l: l, c: c}
 	err := artdb.init()
 	if err != nil {
 		l.Fatal("Could not initialize database", zap.Error(err))
@@ -47,142 +58,473 @@ func NewDB(l *zap.Logger) *ArticlesDb {
 
 // InitDB initializes the database connection
 func (db *ArticlesDb) init() error {
-	err := godotenv.Load("config/.env")
-
+	var err error
+	db.postgres, err = sql.Open("postgres", connToString(db.c))
 	if err != nil {
-		db.l.Error("Error loading .env file", zap.Error(err))
+		db.l.Error("Error connecting to database", zap.Error(err))
 		return err
 	}
+	db.configurePool(db.postgres)
 
-	connInfo := connection{
-		Host:     os.Getenv("POSTGRES_URL"),
-		Port:     os.Getenv("POSTGRES_PORT"),
-		User:     os.Getenv("POSTGRES_USER"),
-		Password: os.Getenv("POSTGRES_PASSWORD"),
-		DBName:   os.Getenv("POSTGRES_DB"),
+	// Ping the database until a connection is established
+	err = db.connect(context.Background())
+	if err != nil {
+		db.l.Error("Could not Ping database", zap.Error(err))
+		return err
 	}
 
-	// Replace the connection string with your PostgreSQL connection details
-	db.postgres, err = sql.Open("postgres", connToString(connInfo))
+	db.l.Info("Connected to the database")
+
+	err = db.Migrate(context.Background())
 	if err != nil {
-		db.l.Error("Error connecting to database", zap.Error(err))
+		db.l.Error("Could not migrate database", zap.Error(err))
 		return err
 	}
 
-	// Ping the database to ensure a connection is established
-	err = db.postgres.Ping()
+	err = db.openReplicas()
 	if err != nil {
-		db.l.Error("Could not Ping database", zap.Error(err))
+		db.l.Error("Error connecting to replicas", zap.Error(err))
 		return err
 	}
 
-	db.l.Info("Connected to the database")
 	return nil
 }
 
-// Take our connection struct and convert to a string for our db connection info
-func connToString(info connection) string {
-	return fmt.Sprintf("postgres://%v:%v@%v:%v/%v?sslmode=disable",
-		info.User, info.Password, info.Host, info.Port, info.DBName)
+// configurePool applies the pool settings to a connection pool
+func (db *ArticlesDb) configurePool(p *sql.DB) {
+	p.SetMaxOpenConns(db.c.MaxOpenConns)
+	p.SetMaxIdleConns(db.c.MaxIdleConns)
+	p.SetConnMaxLifetime(time.Duration(db.c.ConnMaxLifetime))
+	p.SetConnMaxIdleTime(time.Duration(db.c.ConnMaxIdleTime))
+}
+
+// Ping checks that the database is reachable
+func (db *ArticlesDb) Ping(ctx context.Context) error {
+	return db.postgres.PingContext(ctx)
+}
+
+// Take our connection settings and convert to a string for our db connection info,
+// a configured DSN is used as is
+func connToString(info config.Database) string {
+	if info.DSN != "" {
+		return info.DSN
+	}
+	q := url.Values{}
+	q.Set("sslmode", info.SSLMode)
+	for k, v := range map[string]string{
+		"sslrootcert": info.SSLRootCert,
+		"sslcert":     info.SSLCert,
+		"sslkey":      info.SSLKey,
+	} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(info.User, info.Password),
+		Host:     net.JoinHostPort(info.Host, info.Port),
+		Path:     info.Name,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
 }
 
-func (db *ArticlesDb) GetArticleByID(id int) (*Article, error) {
-	var a Article
-	db.l.Info("Get article ", zap.Int("id :", id))
+// startSpan starts a client span for a query against the articles database
+func startSpan(ctx context.Context, name string, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBStatement(query)),
+	)
+}
 
-	err := db.postgres.QueryRow("SELECT * FROM articles WHERE id = $1", id).Scan(&a.ID, &a.Title, &a.Body, &a.Date, pq.Array(&a.Tags))
+// log returns the request-scoped logger found in ctx, falling back to the db logger
+func (db *ArticlesDb) log(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, db.l)
+}
 
+func (db *ArticlesDb) GetArticleByID(ctx context.Context, id int) (a *Article, err error) {
+	query := "SELECT id, title, date, body, tags, updated_at FROM articles WHERE id = $1"
+	ctx, span := startSpan(ctx, "ArticlesDb.GetArticleByID", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	l := db.log(ctx)
+	l.Info("Get article ", zap.Int("id :", id))
+
+	a = &Article{}
+	var updated time.Time
+	err = db.retryRead(ctx, func(q *sql.DB) error {
+		return q.QueryRowContext(ctx, query, id).Scan(&a.ID, &a.Title, &a.Date, &a.Body, pq.Array(&a.Tags), &updated)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		l.Info("Article not found")
+		return nil, &NotFoundError{Resource: "article", Key: strconv.Itoa(id)}
+	}
 	if err != nil {
-		db.l.Error(err.Error())
+		l.Error(err.Error())
 		return nil, err
 	}
+	a.UpdatedAt = &updated
 
-	db.l.Info("Get article success")
-	return &a, nil
+	l.Info("Get article success")
+	return a, nil
 }
 
-// AddProduct adds a new product to the database
-func (db *ArticlesDb) AddArticle(ar Article) error {
-	db.l.Info("Add new article ", zap.String("title :", ar.Title))
+// GetArticlesByIDs returns the articles with the given ids in a single
+// query, in id order, leaving out the ids of no article
+func (db *ArticlesDb) GetArticlesByIDs(ctx context.Context, ids []int) (as []Article, err error) {
+	query := "SELECT id, title, date, body, tags, updated_at FROM articles WHERE id = ANY($1::int[]) ORDER BY id"
+	ctx, span := startSpan(ctx, "ArticlesDb.GetArticlesByIDs", query)
+	span.SetAttributes(attribute.Int("article.count", len(ids)))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	l := db.log(ctx)
+	err = db.retryRead(ctx, func(q *sql.DB) error {
+		as = nil
+		rows, err := q.QueryContext(ctx, query, pq.Array(ids))
+		if err != nil {
+			l.Error("sql query failed", zap.Error(err))
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				a       Article
+				updated time.Time
+			)
+			if err := rows.Scan(&a.ID, &a.Title, &a.Date, &a.Body, pq.Array(&a.Tags), &updated); err != nil {
+				l.Error("row scan failed", zap.Error(err))
+				return err
+			}
+			a.UpdatedAt = &updated
+			as = append(as, a)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return as, nil
+}
 
+// AddProduct adds a new product to the database
+func (db *ArticlesDb) AddArticle(ctx context.Context, ar Article) (err error) {
 	query := `insert into articles(id, title, date, body, tags) values(nextval('articles_id_seq'), $1, $2, $3, $4) returning id`
+	ctx, span := startSpan(ctx, "ArticlesDb.AddArticle", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	l := db.log(ctx)
+	l.Info("Add new article ", zap.String("title :", ar.Title))
+
+	tx, err := db.postgres.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
 
 	var id int
-	err := db.postgres.QueryRow(query, ar.Title, ar.Date, ar.Body, pq.Array(ar.Tags)).Scan(&id)
+	err = tx.QueryRowContext(ctx, query, ar.Title, ar.Date, ar.Body, pq.Array(ar.Tags)).Scan(&id)
 	if err != nil {
-		db.l.Error("DB Query failed ", zap.Error(err))
+		l.Error("DB Query failed ", zap.Error(err))
+		return classifyWriteError("article", err)
+	}
+	if err = recordEvents(ctx, tx, EventArticleCreated, []int{id}); err != nil {
+		l.Error("Recording article event failed ", zap.Error(err))
 		return err
 	}
+	if err = tx.Commit(); err != nil {
+		l.Error("Commit failed ", zap.Error(err))
+		return classifyWriteError("article", err)
+	}
+	db.wrote(ctx)
+	db.recorded()
 
-	db.l.Info("Inserted article \n", zap.Int("Id", id))
+	l.Info("Inserted article \n", zap.Int("Id", id))
 	return nil
 }
 
-func (db *ArticlesDb) Close() {
-	db.postgres.Close()
-}
+// AddArticles adds the articles in a single transaction, streaming them with
+// COPY, and returns their ids in order. Either every article is added or
+// none is.
+func (db *ArticlesDb) AddArticles(ctx context.Context, ars []Article) (ids []int, err error) {
+	if len(ars) == 0 {
+		return nil, nil
+	}
+	query := pq.CopyIn("articles", "id", "title", "date", "body", "tags")
+	ctx, span := startSpan(ctx, "ArticlesDb.AddArticles", query)
+	span.SetAttributes(attribute.Int("articles.count", len(ars)))
+	defer func() { tracing.EndSpan(span, err) }()
 
-func (db *ArticlesDb) GetArticlesForTagAndDate(tag string, d string) ([]int, error) {
-	db.l.Info("GetArticlesForTagAndDate", zap.String("date: ", d))
-	date, err := time.Parse("20060102", d)
+	l := db.log(ctx)
+	l.Info("Add new articles", zap.Int("count", len(ars)))
+
+	tx, err := db.postgres.BeginTx(ctx, nil)
 	if err != nil {
-		db.l.Error("Could not parse date")
 		return nil, err
 	}
-	rows, err := db.postgres.Query("SELECT id FROM articles WHERE $1 = ANY(tags) AND date = $2", tag, date.Format("2006-01-02"))
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// COPY cannot return the ids it generates, reserve them upfront
+	ids, err = reserveIDs(ctx, tx, len(ars))
+	if err != nil {
+		l.Error("Reserving article ids failed", zap.Error(err))
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		l.Error("DB Query failed ", zap.Error(err))
+		return nil, err
+	}
+	defer stmt.Close()
+	for i, ar := range ars {
+		if _, err = stmt.ExecContext(ctx, ids[i], ar.Title, ar.Date, ar.Body, pq.Array(ar.Tags)); err != nil {
+			l.Error("Copying article failed ", zap.Error(err))
+			return nil, classifyWriteError("article", err)
+		}
+	}
+	// flush the rows, this is when constraints are checked
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		l.Error("Copying articles failed ", zap.Error(err))
+		return nil, classifyWriteError("article", err)
+	}
+	if err = recordEvents(ctx, tx, EventArticleCreated, ids); err != nil {
+		l.Error("Recording article events failed ", zap.Error(err))
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		l.Error("Commit failed ", zap.Error(err))
+		return nil, classifyWriteError("article", err)
+	}
+	db.wrote(ctx)
+	db.recorded()
+
+	l.Info("Inserted articles", zap.Int("count", len(ids)))
+	return ids, nil
+}
+
+// reserveIDs takes n ids from the articles sequence
+func reserveIDs(ctx context.Context, tx *sql.Tx, n int) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT nextval('articles_id_seq') FROM generate_series(1, $1)", n)
 	if err != nil {
-		db.l.Error("sql query failed", zap.Error(err))
 		return nil, err
 	}
 	defer rows.Close()
 
-	var ids []int
+	ids := make([]int, 0, n)
 	for rows.Next() {
 		var id int
-		err := rows.Scan(&id)
-		if err != nil {
-			db.l.Error("error scanning row", zap.Error(err))
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		db.l.Info("Article id", zap.Int("id ", id))
-
 		ids = append(ids, id)
 	}
 	if err := rows.Err(); err != nil {
-		db.l.Error("error scanning:", zap.Error(err))
+		return nil, err
+	}
+	if len(ids) != n {
+		return nil, fmt.Errorf("reserved %d article ids instead of %d", len(ids), n)
+	}
+	return ids, nil
+}
+
+func (db *ArticlesDb) Close() {
+	if db.rs != nil {
+		db.rs.close()
+	}
+	db.postgres.Close()
+}
+
+func (db *ArticlesDb) GetArticlesForTagAndDate(ctx context.Context, tag string, d string) (ids []int, err error) {
+	query := "SELECT id FROM articles WHERE $1 = ANY(tags) AND date = $2"
+	ctx, span := startSpan(ctx, "ArticlesDb.GetArticlesForTagAndDate", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	l := db.log(ctx)
+	l.Info("GetArticlesForTagAndDate", zap.String("date: ", d))
+	date, err := parseDate(d)
+	if err != nil {
+		l.Error("Could not parse date")
+		return nil, err
+	}
+	err = db.retryRead(ctx, func(q *sql.DB) error {
+		ids = nil
+		rows, err := q.QueryContext(ctx, query, tag, date.Format("2006-01-02"))
+		if err != nil {
+			l.Error("sql query failed", zap.Error(err))
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int
+			err := rows.Scan(&id)
+			if err != nil {
+				l.Error("error scanning row", zap.Error(err))
+				return err
+			}
+			l.Info("Article id", zap.Int("id ", id))
+
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			l.Error("error scanning:", zap.Error(err))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return ids, nil
 }
 
-func (db *ArticlesDb) GetRelatedTagsForTag(tag string, articles []int) ([]string, error) {
-	var tags []string
+// GetArticlesForTagsAndDates returns the ids of the articles with each tag
+// on its date (YYYYMMDD), in the order of keys, in a single query
+func (db *ArticlesDb) GetArticlesForTagsAndDates(ctx context.Context, keys []TagDate) (ids [][]int, err error) {
+	// dates are compared as YYYY-MM-DD text, the type of articles.date
+	query := `SELECT k.i, a.id FROM unnest($1::text[], $2::text[]) WITH ORDINALITY AS k(tag, date, i)
+JOIN articles a ON k.tag = ANY(a.tags) AND a.date = k.date
+ORDER BY k.i, a.id`
+	ctx, span := startSpan(ctx, "ArticlesDb.GetArticlesForTagsAndDates", query)
+	span.SetAttributes(attribute.Int("tag.count", len(keys)))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	l := db.log(ctx)
+	tags := make([]string, len(keys))
+	dates := make([]string, len(keys))
+	for i, k := range keys {
+		date, err := parseDate(k.Date)
+		if err != nil {
+			return nil, err
+		}
+		tags[i], dates[i] = k.Tag, date.Format("2006-01-02")
+	}
+
+	err = db.retryRead(ctx, func(q *sql.DB) error {
+		ids = make([][]int, len(keys))
+		rows, err := q.QueryContext(ctx, query, pq.Array(tags), pq.Array(dates))
+		if err != nil {
+			l.Error("sql query failed", zap.Error(err))
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var i, id int
+			if err := rows.Scan(&i, &id); err != nil {
+				l.Error("row scan failed", zap.Error(err))
+				return err
+			}
+			ids[i-1] = append(ids[i-1], id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// parseDate parses a date formatted as YYYYMMDD
+func parseDate(d string) (time.Time, error) {
+	date, err := time.Parse("20060102", d)
+	if err != nil {
+		return time.Time{}, &InvalidError{Reason: fmt.Sprintf("date %q is not formatted as YYYYMMDD", d), Err: err}
+	}
+	return date, nil
+}
+
+// GetTagLastModified returns when the most recently written article with
+// the tag on the given date (YYYYMMDD) was written, or the zero time if there
+// is none
+func (db *ArticlesDb) GetTagLastModified(ctx context.Context, tag string, d string) (modified time.Time, err error) {
+	query := "SELECT max(updated_at) FROM articles WHERE $1 = ANY(tags) AND date = $2"
+	ctx, span := startSpan(ctx, "ArticlesDb.GetTagLastModified", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	l := db.log(ctx)
+	date, err := parseDate(d)
+	if err != nil {
+		l.Error("Could not parse date")
+		return time.Time{}, err
+	}
+
+	var t sql.NullTime
+	err = db.retryRead(ctx, func(q *sql.DB) error {
+		return q.QueryRowContext(ctx, query, tag, date.Format("2006-01-02")).Scan(&t)
+	})
+	if err != nil {
+		l.Error("sql query failed", zap.Error(err))
+		return time.Time{}, err
+	}
+	return t.Time, nil
+}
+
+func (db *ArticlesDb) GetRelatedTagsForTag(ctx context.Context, tag string, articles []int) (tags []string, err error) {
+	ctx, span := tracer.Start(ctx, "ArticlesDb.GetRelatedTagsForTag")
+	defer func() { tracing.EndSpan(span, err) }()
+
 	for _, id := range articles {
-		rows, err := db.postgres.Query("SELECT tags FROM articles WHERE id = $1 AND $2 = ANY(tags)", id, tag)
+		related, err := db.getTagsForArticle(ctx, tag, id)
 		if err != nil {
-			db.l.Error("sql query failed", zap.Error(err))
 			return nil, err
 		}
+		for _, t := range related {
+			if t != tag && !contains(tags, t) {
+				tags = append(tags, t)
+			}
+		}
+	}
+
+	return tags, nil
+}
+
+// getTagsForArticle returns the tags of the article with the given id if it is tagged with tag
+func (db *ArticlesDb) getTagsForArticle(ctx context.Context, tag string, id int) (tags []string, err error) {
+	query := "SELECT tags FROM articles WHERE id = $1 AND $2 = ANY(tags)"
+	ctx, span := startSpan(ctx, "ArticlesDb.getTagsForArticle", query)
+	span.SetAttributes(attribute.Int("article.id", id))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	l := db.log(ctx)
+	err = db.retryRead(ctx, func(q *sql.DB) error {
+		tags = nil
+		rows, err := q.QueryContext(ctx, query, id, tag)
+		if err != nil {
+			l.Error("sql query failed", zap.Error(err))
+			return err
+		}
 		defer rows.Close()
 
 		for rows.Next() {
 			var tagArr []string
 			err := rows.Scan(pq.Array(&tagArr))
 			if err != nil {
-				db.l.Error("row scan failed", zap.Error(err))
-				return nil, err
-			}
-			for _, t := range tagArr {
-				if t != tag && !contains(tags, t) {
-					tags = append(tags, t)
-				}
+				l.Error("row scan failed", zap.Error(err))
+				return err
 			}
+			tags = append(tags, tagArr...)
 		}
 		if err := rows.Err(); err != nil {
-			db.l.Error("Errors scanning rows", zap.Error(err))
-			return nil, err
+			l.Error("Errors scanning rows", zap.Error(err))
+			return err
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return tags, nil
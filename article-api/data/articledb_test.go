@@ -0,0 +1,115 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// recordingConnector connects to a fake database that records the queries
+// it gets and answers them with rows, or fails them with err
+type recordingConnector struct {
+	queries []string
+	args    [][]driver.NamedValue
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+func (c *recordingConnector) Connect(context.Context) (driver.Conn, error) {
+	return recordingConn{c}, nil
+}
+func (c *recordingConnector) Driver() driver.Driver { return nil }
+
+type recordingConn struct{ c *recordingConnector }
+
+func (recordingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (recordingConn) Close() error                        { return nil }
+func (recordingConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (rc recordingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rc.c.queries = append(rc.c.queries, query)
+	rc.c.args = append(rc.c.args, args)
+	if rc.c.err != nil {
+		return nil, rc.c.err
+	}
+	return &fakeRows{columns: rc.c.columns, rows: rc.c.rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// columnTypes returns the types of the columns of a table created by the
+// migrations, in upper case
+func columnTypes(t *testing.T, table string) map[string]string {
+	b, err := os.ReadFile("migrations/0001_create_articles.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS ` + table + ` \((.*?)\n\);`).FindSubmatch(b)
+	if create == nil {
+		t.Fatalf("table %s is not created", table)
+	}
+	types := map[string]string{}
+	for _, l := range strings.Split(string(create[1]), "\n") {
+		if f := strings.Fields(strings.TrimSpace(l)); len(f) >= 2 {
+			types[f[0]] = strings.ToUpper(strings.TrimSuffix(f[1], ","))
+		}
+	}
+	return types
+}
+
+func TestGetArticlesForTagsAndDates(t *testing.T) {
+	c := &recordingConnector{
+		columns: []string{"i", "id"},
+		rows:    [][]driver.Value{{int64(1), int64(3)}, {int64(1), int64(4)}, {int64(3), int64(5)}},
+	}
+	db := &ArticlesDb{postgres: sql.OpenDB(c), l: zap.NewNop()}
+
+	ids, err := db.GetArticlesForTagsAndDates(context.Background(), []TagDate{
+		{Tag: "health", Date: "20160922"},
+		{Tag: "science", Date: "20160922"},
+		{Tag: "health", Date: "20160923"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]int{{3, 4}, nil, {5}}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got ids %v, want %v", ids, want)
+	}
+
+	// the dates are compared with articles.date, text that Postgres does
+	// not compare with dates
+	if typ := columnTypes(t, "articles")["date"]; typ != "VARCHAR" && typ != "TEXT" {
+		t.Fatalf("articles.date is %s, update the query", typ)
+	}
+	if !strings.Contains(c.queries[0], "$2::text[]") {
+		t.Errorf("the dates are not cast to text[] in %s", c.queries[0])
+	}
+	if got, want := c.args[0][1].Value, `{"2016-09-22","2016-09-22","2016-09-23"}`; got != want {
+		t.Errorf("got dates %v, want %s", got, want)
+	}
+}
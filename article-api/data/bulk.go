@@ -0,0 +1,39 @@
+package data
+
+import "github.com/sg83/go-microservice/article-api/utils"
+
+// BulkItem is the outcome of one article of a bulk import, or of an
+// invalid article of an import
+type BulkItem struct {
+	// position of the article in the request, from 0
+	Index int `json:"index"`
+	// created, invalid, failed or skipped
+	Status string `json:"status"`
+	// id of the created article, or of the imported one
+	ID int `json:"id,omitempty"`
+	// why the article was not created
+	Error string `json:"error,omitempty"`
+	// the fields that failed validation
+	Errors []utils.FieldError `json:"errors,omitempty"`
+}
+
+// BulkReport is the response to a bulk import
+type BulkReport struct {
+	Mode    string     `json:"mode"`
+	Created int        `json:"created"`
+	Failed  int        `json:"failed"`
+	Items   []BulkItem `json:"items"`
+	// why the request could not be read past its last item, after some
+	// articles were already added in the best-effort mode
+	Error string `json:"error,omitempty"`
+}
+
+// ImportReport is the response to an import
+type ImportReport struct {
+	// number of articles added or replaced
+	Imported int `json:"imported"`
+	// number of invalid articles, none being imported if there is any
+	Failed int `json:"failed,omitempty"`
+	// the first invalid articles
+	Items []BulkItem `json:"items,omitempty"`
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// NotFoundError is returned when the requested resource does not exist
+type NotFoundError struct {
+	// kind of resource, e.g. article
+	Resource string
+	// key it was looked up by
+	Key string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %q not found", e.Resource, e.Key)
+}
+
+// ConflictError is returned when a write conflicts with the stored data
+type ConflictError struct {
+	Resource string
+	Reason   string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s conflicts with existing data: %s", e.Resource, e.Reason)
+}
+
+// InvalidError is returned when the input of an operation is rejected
+type InvalidError struct {
+	Reason string
+	// the error the input was rejected with, if any
+	Err error
+}
+
+func (e *InvalidError) Error() string {
+	return e.Reason
+}
+
+func (e *InvalidError) Unwrap() error {
+	return e.Err
+}
+
+// classifyWriteError converts the constraint violations reported by
+// postgres for a write of resource into domain errors
+func classifyWriteError(resource string, err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch {
+	case pqErr.Code == "23505": // unique_violation
+		return &ConflictError{Resource: resource, Reason: pqErr.Message}
+	case pqErr.Code.Class() == "22", // data_exception
+		pqErr.Code == "21000", // cardinality_violation, e.g. an id written twice
+		pqErr.Code == "23502", // not_null_violation
+		pqErr.Code == "23514": // check_violation
+		return &InvalidError{Reason: pqErr.Message, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/tracing"
+)
+
+// outboxGapWait is how long readers wait for an event id missing from the
+// outbox before skipping it. Ids are taken as events are inserted, without
+// serializing the writes, so a transaction may commit its events after one
+// that took higher ids. Once the events after a missing id are older than
+// this, its transaction is taken to have been rolled back, or the event to
+// have been pruned.
+const outboxGapWait = 5 * time.Second
+
+// OnEvents registers fn to be called after every commit that recorded
+// article events. fn must not block.
+func (db *ArticlesDb) OnEvents(fn func()) {
+	db.listeners = append(db.listeners, fn)
+}
+
+// recorded tells the listeners that events were committed
+func (db *ArticlesDb) recorded() {
+	for _, fn := range db.listeners {
+		fn()
+	}
+}
+
+// GetEventsSince returns up to limit events recorded after the event with
+// id after, in id order. The events are cut short before an id missing for
+// less than outboxGapWait, which may still be committing, so that callers
+// reading on from the last event they got do not skip it. Events are read
+// from the primary, as replicas may not have all of them yet.
+func (db *ArticlesDb) GetEventsSince(ctx context.Context, after int64, limit int) (es []Event, err error) {
+	query := `SELECT id, type, created_at, payload, created_at < clock_timestamp() - $3 * interval '1 millisecond'
+FROM outbox WHERE id > $1 ORDER BY id LIMIT $2`
+	ctx, span := startSpan(ctx, "ArticlesDb.GetEventsSince", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rows, err := db.postgres.QueryContext(ctx, query, after, limit, outboxGapWait.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	next := after + 1
+	for rows.Next() {
+		var e Event
+		var settled bool
+		if err := rows.Scan(&e.ID, &e.Type, &e.CreatedAt, &e.Data, &settled); err != nil {
+			return nil, err
+		}
+		if e.ID != next && !settled {
+			break
+		}
+		es = append(es, e)
+		next = e.ID + 1
+	}
+	return es, rows.Err()
+}
+
+// LastEventID returns the id of the last event recorded more than
+// outboxGapWait ago, or 0 if there is none. The ids before the more recent
+// events may still be committing, GetEventsSince reads on from there.
+func (db *ArticlesDb) LastEventID(ctx context.Context) (id int64, err error) {
+	query := "SELECT COALESCE(max(id), 0) FROM outbox WHERE created_at < clock_timestamp() - $1 * interval '1 millisecond'"
+	ctx, span := startSpan(ctx, "ArticlesDb.LastEventID", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	err = db.postgres.QueryRowContext(ctx, query, outboxGapWait.Milliseconds()).Scan(&id)
+	return id, err
+}
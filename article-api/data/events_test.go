@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestGetEventsSince(t *testing.T) {
+	created := time.Date(2023, 4, 5, 10, 4, 11, 0, time.UTC)
+	// event returns the row of an event, settled when it was recorded more
+	// than outboxGapWait ago
+	event := func(id int64, settled bool) []driver.Value {
+		return []driver.Value{id, EventArticleCreated, created, []byte(`{}`), settled}
+	}
+
+	tt := []struct {
+		name  string
+		after int64
+		rows  [][]driver.Value
+		ids   []int64
+	}{
+		{
+			name:  "contiguous",
+			after: 1,
+			rows:  [][]driver.Value{event(2, false), event(3, false)},
+			ids:   []int64{2, 3},
+		},
+		{
+			// id 3 may still be committing
+			name:  "recent gap",
+			after: 1,
+			rows:  [][]driver.Value{event(2, false), event(4, false), event(5, false)},
+			ids:   []int64{2},
+		},
+		{
+			name:  "recent gap first",
+			after: 1,
+			rows:  [][]driver.Value{event(3, false)},
+		},
+		{
+			// id 3 was rolled back or pruned
+			name:  "settled gap",
+			after: 1,
+			rows:  [][]driver.Value{event(2, true), event(4, true), event(5, false)},
+			ids:   []int64{2, 4, 5},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &recordingConnector{columns: []string{"id", "type", "created_at", "payload", "settled"}, rows: tc.rows}
+			db := &ArticlesDb{postgres: sql.OpenDB(c), l: zap.NewNop()}
+
+			es, err := db.GetEventsSince(context.Background(), tc.after, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int64
+			for _, e := range es {
+				ids = append(ids, e.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.ids) {
+				t.Errorf("got events %v, want %v", ids, tc.ids)
+			}
+		})
+	}
+}
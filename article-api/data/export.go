@@ -0,0 +1,200 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sg83/go-microservice/article-api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// ExportFilter selects the articles to export, empty fields match every
+// article
+type ExportFilter struct {
+	// first and last dates, formatted as YYYY-MM-DD
+	From string
+	To   string
+	Tag  string
+}
+
+// ExportArticles calls fn with every article matching f, in id order,
+// streaming them from a single query so that the export is consistent.
+// It stops at the first error returned by fn. The query is not retried
+// since articles may already have been sent.
+func (db *ArticlesDb) ExportArticles(ctx context.Context, f ExportFilter, fn func(Article) error) (err error) {
+	query := `SELECT id, title, date, body, tags, updated_at FROM articles
+WHERE ($1 = '' OR date >= $1) AND ($2 = '' OR date <= $2) AND ($3 = '' OR $3 = ANY(tags))
+ORDER BY id`
+	ctx, span := startSpan(ctx, "ArticlesDb.ExportArticles", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	l := db.log(ctx)
+	for _, d := range []string{f.From, f.To} {
+		if _, err := time.Parse("2006-01-02", d); d != "" && err != nil {
+			return &InvalidError{Reason: "date " + strconv.Quote(d) + " is not formatted as YYYY-MM-DD", Err: err}
+		}
+	}
+
+	q, _ := db.reader(ctx)
+	rows, err := q.QueryContext(ctx, query, f.From, f.To, f.Tag)
+	if err != nil {
+		l.Error("sql query failed", zap.Error(err))
+		return err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var (
+			a       Article
+			updated time.Time
+		)
+		if err := rows.Scan(&a.ID, &a.Title, &a.Date, &a.Body, pq.Array(&a.Tags), &updated); err != nil {
+			l.Error("row scan failed", zap.Error(err))
+			return err
+		}
+		a.UpdatedAt = &updated
+		if err := fn(a); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		l.Error("Errors scanning rows", zap.Error(err))
+		return err
+	}
+
+	span.SetAttributes(attribute.Int("articles.count", n))
+	l.Info("Exported articles", zap.Int("count", n))
+	return nil
+}
+
+// ImportArticles adds the articles returned by next until it returns
+// io.EOF, keeping their ids and last write times, in a single transaction.
+// Articles with the id of a stored one replace it, so that importing an
+// export restores it exactly. It returns the number of articles imported.
+func (db *ArticlesDb) ImportArticles(ctx context.Context, next func() (Article, error)) (n int, err error) {
+	query := `INSERT INTO articles (id, title, date, body, tags, updated_at)
+SELECT id, title, date, body, tags, updated_at FROM articles_import
+ON CONFLICT (id) DO UPDATE SET title = excluded.title, date = excluded.date, body = excluded.body,
+tags = excluded.tags, updated_at = excluded.updated_at
+RETURNING id, xmax = 0`
+	ctx, span := startSpan(ctx, "ArticlesDb.ImportArticles", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	l := db.log(ctx)
+	tx, err := db.postgres.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// articles are copied to a temporary table first since COPY cannot
+	// update the stored ones
+	if _, err = tx.ExecContext(ctx, "CREATE TEMP TABLE articles_import (LIKE articles INCLUDING DEFAULTS) ON COMMIT DROP"); err != nil {
+		l.Error("Creating import table failed", zap.Error(err))
+		return 0, err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("articles_import", "id", "title", "date", "body", "tags", "updated_at"))
+	if err != nil {
+		l.Error("DB Query failed ", zap.Error(err))
+		return 0, err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for {
+		var a Article
+		a, err = next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if a.ID <= 0 {
+			err = &InvalidError{Reason: "article " + strconv.Itoa(n+1) + " has no id"}
+			return 0, err
+		}
+		updated := now
+		if a.UpdatedAt != nil {
+			updated = *a.UpdatedAt
+		}
+		if _, err = stmt.ExecContext(ctx, a.ID, a.Title, a.Date, a.Body, pq.Array(a.Tags), updated); err != nil {
+			l.Error("Copying article failed ", zap.Error(err))
+			return 0, classifyWriteError("article", err)
+		}
+		n++
+	}
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		l.Error("Copying articles failed ", zap.Error(err))
+		return 0, classifyWriteError("article", err)
+	}
+
+	created, updated, err := upsertImported(ctx, tx, query)
+	if err != nil {
+		l.Error("Importing articles failed ", zap.Error(err))
+		return 0, classifyWriteError("article", err)
+	}
+	if err = recordEvents(ctx, tx, EventArticleCreated, created); err != nil {
+		l.Error("Recording article events failed ", zap.Error(err))
+		return 0, err
+	}
+	if err = recordEvents(ctx, tx, EventArticleUpdated, updated); err != nil {
+		l.Error("Recording article events failed ", zap.Error(err))
+		return 0, err
+	}
+	// new articles must not be given the imported ids
+	if _, err = tx.ExecContext(ctx, `SELECT setval('articles_id_seq', GREATEST(max(id), (SELECT last_value FROM articles_id_seq)))
+FROM articles HAVING max(id) IS NOT NULL`); err != nil {
+		l.Error("Updating the id sequence failed ", zap.Error(err))
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		l.Error("Commit failed ", zap.Error(err))
+		return 0, classifyWriteError("article", err)
+	}
+	db.wrote(ctx)
+	db.recorded()
+
+	span.SetAttributes(attribute.Int("articles.count", n))
+	l.Info("Imported articles", zap.Int("count", n))
+	return n, nil
+}
+
+// upsertImported runs the query moving the imported articles to the
+// articles table, and returns the ids of the articles it added and of
+// those it replaced
+func upsertImported(ctx context.Context, tx *sql.Tx, query string) (created []int, updated []int, err error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id       int
+			inserted bool
+		)
+		// xmax is only zero on rows that were inserted rather than updated
+		if err := rows.Scan(&id, &inserted); err != nil {
+			return nil, nil, err
+		}
+		if inserted {
+			created = append(created, id)
+		} else {
+			updated = append(updated, id)
+		}
+	}
+	return created, updated, rows.Err()
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS articles (
+  id SERIAL not null unique,
+  title VARCHAR(500) not null,
+  date VARCHAR not null,
+  body TEXT not null,
+  tags TEXT[],
+  primary key(id)
+);
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// replica is a read only copy of the primary database
+type replica struct {
+	name    string
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+type clientKey struct{}
+
+// WithClient returns a copy of ctx identifying the client making the
+// request, writes made with this context pin the client to the primary for
+// the read your writes window
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+func clientFrom(ctx context.Context) string {
+	c, _ := ctx.Value(clientKey{}).(string)
+	return c
+}
+
+// replicaSet routes reads across the healthy replicas round robin and
+// remembers which clients recently wrote
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint32
+
+	// client -> time until which its reads go to the primary
+	pins   sync.Map
+	window time.Duration
+
+	done chan struct{}
+}
+
+// openReplicas connects to every replica dsn with the same pool settings as
+// the primary and runs a first health check
+func (db *ArticlesDb) openReplicas() error {
+	db.rs = &replicaSet{
+		window: time.Duration(db.c.ReadYourWrites),
+		done:   make(chan struct{}),
+	}
+
+	for i, dsn := range db.c.Replicas {
+		rdb, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return err
+		}
+		db.configurePool(rdb)
+		db.rs.replicas = append(db.rs.replicas, &replica{name: replicaName(i), db: rdb})
+	}
+
+	if len(db.rs.replicas) != 0 {
+		db.checkReplicas(context.Background())
+		go db.watchReplicas()
+	}
+	return nil
+}
+
+func replicaName(i int) string {
+	return "replica-" + strconv.Itoa(i)
+}
+
+// watchReplicas checks the replicas periodically until the db is closed
+func (db *ArticlesDb) watchReplicas() {
+	t := time.NewTicker(time.Duration(db.c.ReplicaCheckInterval))
+	defer t.Stop()
+
+	for {
+		select {
+		case <-db.rs.done:
+			return
+		case <-t.C:
+			db.checkReplicas(context.Background())
+			db.rs.expirePins(time.Now())
+		}
+	}
+}
+
+// checkReplicas pings every replica and records whether it answered
+func (db *ArticlesDb) checkReplicas(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(db.c.ReplicaCheckInterval))
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, r := range db.rs.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+			err := r.db.PingContext(ctx)
+			db.setReplicaHealth(r, err)
+		}(r)
+	}
+	wg.Wait()
+}
+
+func (db *ArticlesDb) setReplicaHealth(r *replica, err error) {
+	healthy := err == nil
+	if r.healthy.Swap(healthy) != healthy {
+		if healthy {
+			db.l.Info("Replica is healthy", zap.String("replica", r.name))
+		} else {
+			db.l.Warn("Replica is unhealthy", zap.String("replica", r.name), zap.Error(err))
+		}
+	}
+}
+
+// reader returns the database to run a read on: the next healthy replica,
+// or the primary if there is none or the client recently wrote
+func (db *ArticlesDb) reader(ctx context.Context) (*sql.DB, *replica) {
+	if db.rs == nil || len(db.rs.replicas) == 0 || db.rs.pinned(clientFrom(ctx), time.Now()) {
+		return db.postgres, nil
+	}
+
+	n := len(db.rs.replicas)
+	start := int(db.rs.next.Add(1))
+	for i := 0; i < n; i++ {
+		r := db.rs.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return r.db, r
+		}
+	}
+	return db.postgres, nil
+}
+
+// wrote pins the client in ctx to the primary for the read your writes window
+func (db *ArticlesDb) wrote(ctx context.Context) {
+	client := clientFrom(ctx)
+	if db.rs == nil || len(db.rs.replicas) == 0 || db.rs.window <= 0 || client == "" {
+		return
+	}
+	db.rs.pins.Store(client, time.Now().Add(db.rs.window))
+}
+
+// Pinned reports whether the reads of the client in ctx go to the primary
+// because it wrote within the read your writes window, so that layers in
+// front of the database know not to answer them from what replicas returned
+func (db *ArticlesDb) Pinned(ctx context.Context) bool {
+	return db.rs != nil && len(db.rs.replicas) != 0 && db.rs.pinned(clientFrom(ctx), time.Now())
+}
+
+func (rs *replicaSet) pinned(client string, now time.Time) bool {
+	if client == "" {
+		return false
+	}
+	until, ok := rs.pins.Load(client)
+	return ok && now.Before(until.(time.Time))
+}
+
+func (rs *replicaSet) expirePins(now time.Time) {
+	rs.pins.Range(func(k, v any) bool {
+		if !now.Before(v.(time.Time)) {
+			rs.pins.Delete(k)
+		}
+		return true
+	})
+}
+
+func (rs *replicaSet) close() {
+	close(rs.done)
+	for _, r := range rs.replicas {
+		r.db.Close()
+	}
+}
@@ -0,0 +1,75 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// stubConnector is a connector that never connects, reader only needs
+// distinct pools to route to
+type stubConnector struct{}
+
+func (stubConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, errors.New("not connected")
+}
+
+func (stubConnector) Driver() driver.Driver { return nil }
+
+func TestReader(t *testing.T) {
+	primary := sql.OpenDB(stubConnector{})
+	r0 := &replica{name: "replica-0", db: sql.OpenDB(stubConnector{})}
+	r1 := &replica{name: "replica-1", db: sql.OpenDB(stubConnector{})}
+	r0.healthy.Store(true)
+	r1.healthy.Store(true)
+
+	db := &ArticlesDb{
+		postgres: primary,
+		l:        zap.NewNop(),
+		rs:       &replicaSet{replicas: []*replica{r0, r1}, window: time.Minute},
+	}
+	ctx := context.Background()
+
+	// reads alternate between the healthy replicas
+	first, _ := db.reader(ctx)
+	second, _ := db.reader(ctx)
+	if first == primary || second == primary || first == second {
+		t.Errorf("expected reads to be spread across both replicas")
+	}
+
+	// an unhealthy replica is skipped
+	r0.healthy.Store(false)
+	for i := 0; i < 4; i++ {
+		if q, _ := db.reader(ctx); q != r1.db {
+			t.Errorf("expected reads to go to the healthy replica")
+		}
+	}
+
+	// without any healthy replica reads go to the primary
+	r1.healthy.Store(false)
+	if q, _ := db.reader(ctx); q != primary {
+		t.Errorf("expected reads to fall back to the primary")
+	}
+
+	// a client that wrote reads from the primary until the window passes
+	r0.healthy.Store(true)
+	writer := WithClient(ctx, "client-a")
+	other := WithClient(ctx, "client-b")
+	db.wrote(writer)
+	if q, _ := db.reader(writer); q != primary || !db.Pinned(writer) {
+		t.Errorf("expected the writing client to be pinned to the primary")
+	}
+	if q, _ := db.reader(other); q != r0.db || db.Pinned(other) {
+		t.Errorf("expected other clients to keep reading from replicas")
+	}
+
+	db.rs.expirePins(time.Now().Add(2 * time.Minute))
+	if q, _ := db.reader(writer); q != r0.db || db.Pinned(writer) {
+		t.Errorf("expected the pin to expire")
+	}
+}
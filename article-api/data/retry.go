@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sg83/go-microservice/article-api/internal/backoff"
+	"go.uber.org/zap"
+)
+
+// readRetryBackoff is the first delay between retries of a failed read
+const readRetryBackoff = 50 * time.Millisecond
+
+// isTransient reports whether err is a connection level failure or a
+// conflict that may succeed if the statement is run again
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08": // connection exception
+			return true
+		}
+		switch pqErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryRead runs the idempotent read fn against a replica or the primary,
+// retrying it up to the configured number of times while it fails with
+// transient errors. A replica failing with a transient error is taken out
+// of rotation until its next successful health check.
+func (db *ArticlesDb) retryRead(ctx context.Context, fn func(q *sql.DB) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		q, r := db.reader(ctx)
+		err = fn(q)
+		if !isTransient(err) {
+			return err
+		}
+		if r != nil {
+			db.setReplicaHealth(r, err)
+		}
+		if attempt >= db.c.QueryRetries {
+			return err
+		}
+
+		d := backoff.Delay(attempt, readRetryBackoff, time.Duration(db.c.RetryMaxBackoff))
+		db.log(ctx).Warn("Retrying read after transient error",
+			zap.Error(err), zap.Int("attempt", attempt+1), zap.Duration("backoff", d))
+		if backoff.Sleep(ctx, d) != nil {
+			return err
+		}
+	}
+}
+
+// connect pings the database until it answers, backing off exponentially
+// between attempts, and gives up once the connect timeout has passed
+func (db *ArticlesDb) connect(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(db.c.ConnectTimeout))
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		err := db.postgres.PingContext(ctx)
+		if err == nil {
+			return nil
+		}
+
+		d := backoff.Delay(attempt, time.Duration(db.c.RetryInitialBackoff), time.Duration(db.c.RetryMaxBackoff))
+		db.l.Warn("Database not ready, retrying",
+			zap.Error(err), zap.Int("attempt", attempt+1), zap.Duration("backoff", d))
+		if backoff.Sleep(ctx, d) != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sg83/go-microservice/article-api/config"
+	"go.uber.org/zap"
+)
+
+func TestIsTransient(t *testing.T) {
+
+	tt := []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{sql.ErrNoRows, false},
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("query: %w", driver.ErrBadConn), true},
+		{&pq.Error{Code: "08006"}, true},  // connection_failure
+		{&pq.Error{Code: "57P03"}, true},  // cannot_connect_now
+		{&pq.Error{Code: "40001"}, true},  // serialization_failure
+		{&pq.Error{Code: "23505"}, false}, // unique_violation
+		{errors.New("boom"), false},
+	}
+
+	for _, tc := range tt {
+		if got := isTransient(tc.err); got != tc.transient {
+			t.Errorf("isTransient(%v) = %v, expected %v", tc.err, got, tc.transient)
+		}
+	}
+}
+
+func TestRetryRead(t *testing.T) {
+	db := &ArticlesDb{l: zap.NewNop(), c: config.Database{QueryRetries: 2, RetryMaxBackoff: config.Duration(time.Millisecond)}}
+
+	tt := []struct {
+		name  string
+		errs  []error
+		calls int
+		err   error
+	}{
+		{"succeeds first time", []error{nil}, 1, nil},
+		{"recovers from transient error", []error{driver.ErrBadConn, nil}, 2, nil},
+		{"gives up after retries", []error{driver.ErrBadConn, driver.ErrBadConn, driver.ErrBadConn, nil}, 3, driver.ErrBadConn},
+		{"does not retry permanent error", []error{sql.ErrNoRows, nil}, 1, sql.ErrNoRows},
+	}
+
+	for _, tc := range tt {
+		calls := 0
+		err := db.retryRead(context.Background(), func(q *sql.DB) error {
+			err := tc.errs[calls]
+			calls++
+			return err
+		})
+		if calls != tc.calls || err != tc.err {
+			t.Errorf("%s: expected %d calls and error %v but got %d calls and %v", tc.name, tc.calls, tc.err, calls, err)
+		}
+	}
+}
@@ -1,5 +1,18 @@
 package data
 
+import (
+	"regexp"
+	"strconv"
+)
+
+var tagDate = regexp.MustCompile(`^(20[0-2][0-3]|1[2-9]|[2-9]\d)(\d{2})(0[1-9]|1[0-2])(0[1-9]|[12]\d|3[01])$`)
+
+// ValidTagDate reports whether date is a valid day of a tag summary,
+// formatted as YYYYMMDD
+func ValidTagDate(date string) bool {
+	return tagDate.MatchString(date)
+}
+
 type Tag struct {
 	// Tag name
 	Tag string `json:"tag"`
@@ -10,3 +23,31 @@ type Tag struct {
 	// List of tags that are on the articles that the current tag is on for the same day.
 	RelatedTags []string `json:"related_tags"`
 }
+
+// TagV2 is the summary of a tag served by the /v2 routes, whose article ids
+// are strings as the README has always documented them
+type TagV2 struct {
+	// Tag name
+	Tag string `json:"tag"`
+	// Number of articles having the tag for that day.
+	Count int `json:"count"`
+	// List of ids for the last 10 articles entered for that day.
+	Articles []string `json:"articles"`
+	// List of tags that are on the articles that the current tag is on for the same day.
+	RelatedTags []string `json:"related_tags"`
+}
+
+// V2 returns the /v2 representation of t
+func (t Tag) V2() TagV2 {
+	ids := make([]string, len(t.Articles))
+	for i, id := range t.Articles {
+		ids[i] = strconv.Itoa(id)
+	}
+	return TagV2{Tag: t.Tag, Count: t.Count, Articles: ids, RelatedTags: t.RelatedTags}
+}
+
+// TagDate identifies the summary of a tag on a date, formatted as YYYYMMDD
+type TagDate struct {
+	Tag  string
+	Date string
+}
@@ -0,0 +1,196 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sg83/go-microservice/article-api/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var (
+	spanRecorder = tracetest.NewSpanRecorder()
+	installSpans sync.Once
+)
+
+// recordSpans propagates W3C trace context for the test and returns a
+// function listing the spans ended since. The package tracer delegates to the
+// first global provider only, so a single recorder serves every test.
+func recordSpans(t *testing.T) func() []sdktrace.ReadOnlySpan {
+	installSpans.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(prev) })
+
+	start := len(spanRecorder.Ended())
+	return func() []sdktrace.ReadOnlySpan { return spanRecorder.Ended()[start:] }
+}
+
+// spanAttributes returns the attributes of s by key
+func spanAttributes(s sdktrace.ReadOnlySpan) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range s.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	ended := recordSpans(t)
+
+	c := &recordingConnector{
+		columns: []string{"id", "title", "date", "body", "tags", "updated_at"},
+		rows:    [][]driver.Value{{int64(1), "Title 1", "2016-09-22", "Body 1", []byte("{health}"), time.Now()}},
+	}
+	db := &ArticlesDb{postgres: sql.OpenDB(c), l: zap.NewNop()}
+
+	sm := mux.NewRouter()
+	sm.Use(tracing.Middleware())
+	sm.HandleFunc("/v1/articles/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := db.GetArticleByID(r.Context(), 1); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/articles/1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range ended() {
+		spans[s.Name()] = s
+	}
+	if len(spans) != 2 {
+		t.Fatalf("got spans %v, want the request and its query", spans)
+	}
+	server, query := spans["/v1/articles/{id}"], spans["ArticlesDb.GetArticleByID"]
+	if server == nil || server.SpanKind() != trace.SpanKindServer {
+		t.Fatalf("got request span %v, want a server span named after the route", server)
+	}
+	if query == nil || query.SpanKind() != trace.SpanKindClient {
+		t.Fatalf("got query span %v, want a client span", query)
+	}
+	if query.Parent().SpanID() != server.SpanContext().SpanID() || query.SpanContext().TraceID() != server.SpanContext().TraceID() {
+		t.Errorf("the query span is not a child of the request span")
+	}
+	attrs := spanAttributes(query)
+	if attrs[string(semconv.DBSystemKey)] != "postgresql" || attrs[string(semconv.DBStatementKey)] != c.queries[0] {
+		t.Errorf("got query span attributes %v", attrs)
+	}
+}
+
+func TestTracingContinuesIncomingTrace(t *testing.T) {
+	ended := recordSpans(t)
+
+	sm := mux.NewRouter()
+	sm.Use(tracing.Middleware())
+	sm.HandleFunc("/v1/articles/{id}", func(http.ResponseWriter, *http.Request) {})
+
+	const traceID, spanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	r := httptest.NewRequest(http.MethodGet, "/v1/articles/1", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-"+spanID+"-01")
+	sm.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := ended()
+	if len(spans) != 1 {
+		t.Fatalf("got spans %v, want the request", spans)
+	}
+	server := spans[0]
+	if got := server.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("got trace id %s, want the caller's %s", got, traceID)
+	}
+	if got := server.Parent().SpanID().String(); got != spanID || !server.Parent().IsRemote() {
+		t.Errorf("got parent span %s, want the caller's remote span %s", got, spanID)
+	}
+	if !server.SpanContext().IsSampled() {
+		t.Errorf("the request span is not sampled as the caller asked")
+	}
+}
+
+func TestTracingRelatedTags(t *testing.T) {
+	ended := recordSpans(t)
+
+	c := &recordingConnector{
+		columns: []string{"tags"},
+		rows:    [][]driver.Value{{[]byte("{health,fitness}")}},
+	}
+	db := &ArticlesDb{postgres: sql.OpenDB(c), l: zap.NewNop()}
+	if _, err := db.GetRelatedTagsForTag(context.Background(), "health", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	var parent sdktrace.ReadOnlySpan
+	var children []sdktrace.ReadOnlySpan
+	for _, s := range ended() {
+		switch s.Name() {
+		case "ArticlesDb.GetRelatedTagsForTag":
+			parent = s
+		case "ArticlesDb.getTagsForArticle":
+			children = append(children, s)
+		}
+	}
+	if parent == nil || len(children) != 2 {
+		t.Fatalf("got spans %v, want the lookup and a query for each article", ended())
+	}
+	for i, s := range children {
+		if s.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("the query span of article %d is not a child of the lookup span", i+1)
+		}
+		attrs := spanAttributes(s)
+		if got, want := attrs["article.id"], strconv.Itoa(i+1); got != want {
+			t.Errorf("got article.id %s, want %s", got, want)
+		}
+		if attrs[string(semconv.DBStatementKey)] != c.queries[i] {
+			t.Errorf("got query span attributes %v", attrs)
+		}
+	}
+}
+
+func TestTracingFailedQuery(t *testing.T) {
+	ended := recordSpans(t)
+
+	c := &recordingConnector{err: errors.New("relation \"articles\" does not exist")}
+	db := &ArticlesDb{postgres: sql.OpenDB(c), l: zap.NewNop()}
+	if _, err := db.GetArticleByID(context.Background(), 1); err == nil {
+		t.Fatal("got no error")
+	}
+
+	spans := ended()
+	if len(spans) != 1 {
+		t.Fatalf("got spans %v, want the query", spans)
+	}
+	query := spans[0]
+	if query.Status().Code != codes.Error || query.Status().Description != c.err.Error() {
+		t.Errorf("got status %v, want an error status with the query error", query.Status())
+	}
+	events := query.Events()
+	if len(events) != 1 || events[0].Name != semconv.ExceptionEventName {
+		t.Fatalf("got events %v, want the error recorded", events)
+	}
+	attrs := map[string]string{}
+	for _, kv := range events[0].Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs[string(semconv.ExceptionMessageKey)] != c.err.Error() {
+		t.Errorf("got exception attributes %v", attrs)
+	}
+}
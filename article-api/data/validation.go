@@ -1,28 +1,57 @@
 package data
 
 import (
-	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
 
-	"github.com/go-playground/validator"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/fr"
+	ut "github.com/go-playground/universal-translator"
+	"gopkg.in/go-playground/validator.v9"
+	en_translations "gopkg.in/go-playground/validator.v9/translations/en"
+	fr_translations "gopkg.in/go-playground/validator.v9/translations/fr"
 )
 
-// ValidationError wraps the validators FieldError
+// ValidationError describes a field that failed validation
 type ValidationError struct {
 	validator.FieldError
+	message string
+}
+
+// Path is the location of the field in the JSON document, e.g. tags[1]
+func (v ValidationError) Path() string {
+	ns := v.Namespace()
+	// drop the name of the validated struct
+	if i := strings.IndexByte(ns, '.'); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+// Rule is the validation rule the field failed, e.g. required or max
+func (v ValidationError) Rule() string {
+	return v.Tag()
+}
+
+// Message explains the failure to the client, in the language asked for
+func (v ValidationError) Message() string {
+	return v.message
 }
 
 func (v ValidationError) Error() string {
-	return fmt.Sprintf(
-		"Key: '%s' Error: Field validation for '%s' failed on the '%s' tag",
-		v.Namespace(),
-		v.Field(),
-		v.Tag(),
-	)
+	return v.message
 }
 
 // ValidationErrors is a collection of ValidationError
 type ValidationErrors []ValidationError
 
+func (v ValidationErrors) Error() string {
+	return strings.Join(v.Errors(), "; ")
+}
+
 // Errors converts the slice into a string slice
 func (v ValidationErrors) Errors() []string {
 	errs := []string{}
@@ -33,18 +62,124 @@ func (v ValidationErrors) Errors() []string {
 	return errs
 }
 
-// Validation contains
+// Validation contains the validator and the translators of its messages
 type Validation struct {
 	validate *validator.Validate
+	uni      *ut.UniversalTranslator
+}
+
+// dateFormat is the layout of article dates
+const dateFormat = "2006-01-02"
+
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// rules are the validation rules added to the built in ones
+var rules = map[string]validator.Func{
+	// a date formatted as YYYY-MM-DD
+	"date": func(fl validator.FieldLevel) bool {
+		_, err := time.Parse(dateFormat, fl.Field().String())
+		return err == nil
+	},
+	// a tag, safe to use as a path segment
+	"tag": func(fl validator.FieldLevel) bool {
+		return tagPattern.MatchString(fl.Field().String())
+	},
+	// an absolute http or https URL
+	"http_url": func(fl validator.FieldLevel) bool {
+		u, err := url.Parse(fl.Field().String())
+		return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+	},
+	// the type of an article event
+	"event": func(fl validator.FieldLevel) bool {
+		for _, t := range EventTypes {
+			if fl.Field().String() == t {
+				return true
+			}
+		}
+		return false
+	},
 }
 
-// NewValidation creates a new Validation type
+// messages of the added rules, and of the built in rules the default
+// translations lack, by locale. {0} is the name of the field.
+var messages = map[string]map[string]string{
+	"en": {
+		"date":     "{0} must be a valid date formatted as YYYY-MM-DD",
+		"tag":      "{0} must be 1 to 32 letters, digits, hyphens or underscores",
+		"http_url": "{0} must be an absolute http or https URL",
+		"event":    "{0} must be article.created or article.updated",
+	},
+	"fr": {
+		"date":     "{0} doit être une date valide au format AAAA-MM-JJ",
+		"tag":      "{0} doit contenir de 1 à 32 lettres, chiffres, tirets ou tirets bas",
+		"unique":   "{0} doit contenir des valeurs uniques",
+		"http_url": "{0} doit être une URL http ou https absolue",
+		"event":    "{0} doit être article.created ou article.updated",
+	},
+}
+
+// NewValidation creates a new Validation type. Fields are named after their
+// JSON name and messages are available in English and French.
 func NewValidation() *Validation {
 	validate := validator.New()
-	return &Validation{validate}
+	validate.RegisterTagNameFunc(jsonName)
+	for tag, fn := range rules {
+		if err := validate.RegisterValidation(tag, fn); err != nil {
+			panic("registering validation rule " + tag + ": " + err.Error())
+		}
+	}
+
+	english, french := en.New(), fr.New()
+	uni := ut.New(english, english, french)
+	for locale, register := range map[string]func(*validator.Validate, ut.Translator) error{
+		english.Locale(): en_translations.RegisterDefaultTranslations,
+		french.Locale():  fr_translations.RegisterDefaultTranslations,
+	} {
+		trans, _ := uni.GetTranslator(locale)
+		if err := register(validate, trans); err != nil {
+			panic("registering " + locale + " validation messages: " + err.Error())
+		}
+		for tag, msg := range messages[locale] {
+			if err := registerMessage(validate, trans, tag, msg); err != nil {
+				panic("registering " + locale + " validation messages: " + err.Error())
+			}
+		}
+	}
+
+	return &Validation{validate, uni}
 }
 
-func (v *Validation) Validate(i interface{}) ValidationErrors {
+// registerMessage translates failures of the rule tag with msg
+func registerMessage(v *validator.Validate, trans ut.Translator, tag string, msg string) error {
+	return v.RegisterTranslation(tag, trans,
+		func(ut ut.Translator) error {
+			return ut.Add(tag, msg, true)
+		},
+		func(ut ut.Translator, fe validator.FieldError) string {
+			t, err := ut.T(fe.Tag(), fe.Field())
+			if err != nil {
+				return fe.(error).Error()
+			}
+			return t
+		},
+	)
+}
+
+// jsonName returns the name of the field in JSON documents
+func jsonName(f reflect.StructField) string {
+	name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	}
+	return name
+}
+
+// Validate validates i, with messages in the first of the given locales
+// that is supported, or else in English
+func (v *Validation) Validate(i interface{}, locales ...string) ValidationErrors {
 	var errs validator.ValidationErrors
 
 	err := v.validate.Struct(i)
@@ -57,11 +192,12 @@ func (v *Validation) Validate(i interface{}) ValidationErrors {
 		return nil
 	}
 
+	trans, _ := v.uni.FindTranslator(locales...)
+
 	var returnErrs []ValidationError
 	for _, err := range errs {
-		fmt.Printf("Validation error for param: %s", err.Param())
 		// cast the FieldError into ValidationError and append to the slice
-		ve := ValidationError{err}
+		ve := ValidationError{err, err.Translate(trans)}
 		returnErrs = append(returnErrs, ve)
 	}
 
@@ -0,0 +1,213 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	v := NewValidation()
+
+	tt := []struct {
+		name    string
+		article Article
+		locales []string
+		fields  []string
+		rules   []string
+		message string
+	}{
+		{
+			name:    "valid",
+			article: Article{Title: "Title", Body: "Body", Date: "2016-09-22"},
+		},
+		{
+			name:    "missing title",
+			article: Article{Body: "Body", Date: "2016-09-22"},
+			fields:  []string{"title"},
+			rules:   []string{"required"},
+			message: "title is a required field",
+		},
+		{
+			name:    "missing title and body",
+			article: Article{Date: "2016-09-22"},
+			fields:  []string{"title", "body"},
+			rules:   []string{"required", "required"},
+			message: "title is a required field",
+		},
+		{
+			name:    "missing date",
+			article: Article{Title: "Title", Body: "Body"},
+			fields:  []string{"date"},
+			rules:   []string{"required"},
+			message: "date is a required field",
+		},
+		{
+			name:    "invalid date",
+			article: Article{Title: "Title", Body: "Body", Date: "22/09/2016"},
+			fields:  []string{"date"},
+			rules:   []string{"date"},
+			message: "date must be a valid date formatted as YYYY-MM-DD",
+		},
+		{
+			name:    "impossible date",
+			article: Article{Title: "Title", Body: "Body", Date: "2016-02-30"},
+			fields:  []string{"date"},
+			rules:   []string{"date"},
+			message: "date must be a valid date formatted as YYYY-MM-DD",
+		},
+		{
+			name:    "title too long",
+			article: Article{Title: strings.Repeat("a", 501), Body: "Body", Date: "2016-09-22"},
+			fields:  []string{"title"},
+			rules:   []string{"max"},
+			message: "title must be a maximum of 500 characters in length",
+		},
+		{
+			name:    "body too long",
+			article: Article{Title: "Title", Body: strings.Repeat("é", 10001), Date: "2016-09-22"},
+			fields:  []string{"body"},
+			rules:   []string{"max"},
+			message: "body must be a maximum of 10,000 characters in length",
+		},
+		{
+			name:    "longest title and body",
+			article: Article{Title: strings.Repeat("a", 500), Body: strings.Repeat("é", 10000), Date: "2016-09-22"},
+		},
+		{
+			name:    "too many tags",
+			article: Article{Title: "Title", Body: "Body", Date: "2016-09-22", Tags: strings.Split("a,b,c,d,e,f,g,h,i,j,k", ",")},
+			fields:  []string{"tags"},
+			rules:   []string{"max"},
+			message: "tags must contain at maximum 10 items",
+		},
+		{
+			name:    "duplicate tags",
+			article: Article{Title: "Title", Body: "Body", Date: "2016-09-22", Tags: []string{"health", "fitness", "health"}},
+			fields:  []string{"tags"},
+			rules:   []string{"unique"},
+			message: "tags must contain unique values",
+		},
+		{
+			name:    "invalid tags",
+			article: Article{Title: "Title", Body: "Body", Date: "2016-09-22", Tags: []string{"health", "has/slash", "", strings.Repeat("t", 33)}},
+			fields:  []string{"tags[1]", "tags[2]", "tags[3]"},
+			rules:   []string{"tag", "tag", "tag"},
+			message: "tags[1] must be 1 to 32 letters, digits, hyphens or underscores",
+		},
+		{
+			name:    "valid tags",
+			article: Article{Title: "Title", Body: "Body", Date: "2016-09-22", Tags: []string{"health", "Sci-Fi", "web_3", strings.Repeat("t", 32)}},
+		},
+		{
+			name:    "duplicate tags in french",
+			article: Article{Title: "Title", Body: "Body", Date: "2016-09-22", Tags: []string{"health", "health"}},
+			locales: []string{"fr"},
+			fields:  []string{"tags"},
+			rules:   []string{"unique"},
+			message: "tags doit contenir des valeurs uniques",
+		},
+		{
+			name:    "french",
+			article: Article{Body: "Body", Date: "2016-09-22"},
+			locales: []string{"de", "fr"},
+			fields:  []string{"title"},
+			rules:   []string{"required"},
+			message: "title est un champ obligatoire",
+		},
+		{
+			name:    "unsupported locale falls back to english",
+			article: Article{Body: "Body", Date: "2016-09-22"},
+			locales: []string{"de"},
+			fields:  []string{"title"},
+			rules:   []string{"required"},
+			message: "title is a required field",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := v.Validate(&tc.article, tc.locales...)
+			if len(errs) != len(tc.fields) {
+				t.Fatalf("Expected %d errors but got %v", len(tc.fields), errs)
+			}
+			for i, e := range errs {
+				if e.Path() != tc.fields[i] || e.Rule() != tc.rules[i] {
+					t.Errorf("Expected field %s to fail %s but got %s failing %s", tc.fields[i], tc.rules[i], e.Path(), e.Rule())
+				}
+			}
+			if len(errs) != 0 && errs[0].Message() != tc.message {
+				t.Errorf("Expected message %q but got %q", tc.message, errs[0].Message())
+			}
+		})
+	}
+}
+
+func TestValidateWebhook(t *testing.T) {
+	v := NewValidation()
+
+	tt := []struct {
+		name    string
+		webhook Webhook
+		fields  []string
+		rules   []string
+	}{
+		{
+			name:    "valid",
+			webhook: Webhook{URL: "https://example.com/hooks/articles", Events: []string{EventArticleCreated}},
+		},
+		{
+			name:    "every event",
+			webhook: Webhook{URL: "http://localhost:9000"},
+		},
+		{
+			name:    "missing url",
+			webhook: Webhook{},
+			fields:  []string{"url"},
+			rules:   []string{"required"},
+		},
+		{
+			name:    "relative url",
+			webhook: Webhook{URL: "/hooks"},
+			fields:  []string{"url"},
+			rules:   []string{"http_url"},
+		},
+		{
+			name:    "other scheme",
+			webhook: Webhook{URL: "ftp://example.com/hooks"},
+			fields:  []string{"url"},
+			rules:   []string{"http_url"},
+		},
+		{
+			name:    "unknown event",
+			webhook: Webhook{URL: "https://example.com", Events: []string{EventArticleUpdated, "article.read"}},
+			fields:  []string{"events[1]"},
+			rules:   []string{"event"},
+		},
+		{
+			name:    "duplicate events",
+			webhook: Webhook{URL: "https://example.com", Events: []string{EventArticleCreated, EventArticleCreated}},
+			fields:  []string{"events"},
+			rules:   []string{"unique"},
+		},
+		{
+			name:    "short secret",
+			webhook: Webhook{URL: "https://example.com", Secret: "secret"},
+			fields:  []string{"secret"},
+			rules:   []string{"min"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := v.Validate(&tc.webhook)
+			if len(errs) != len(tc.fields) {
+				t.Fatalf("Expected %d errors but got %v", len(tc.fields), errs)
+			}
+			for i, e := range errs {
+				if e.Path() != tc.fields[i] || e.Rule() != tc.rules[i] {
+					t.Errorf("Expected field %s to fail %s but got %s failing %s", tc.fields[i], tc.rules[i], e.Path(), e.Rule())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sg83/go-microservice/article-api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// Types of the article events recorded in the outbox
+const (
+	EventArticleCreated = "article.created"
+	// an import replaced a stored article
+	EventArticleUpdated = "article.updated"
+)
+
+// EventTypes are the types of events webhooks can subscribe to
+var EventTypes = []string{EventArticleCreated, EventArticleUpdated}
+
+// Statuses of webhook deliveries
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// the delivery failed too many times and is no longer attempted
+	DeliveryDead = "dead"
+)
+
+// Webhook is a URL article events are posted to
+type Webhook struct {
+	ID int `json:"id"`
+
+	// the http or https URL events are posted to
+	//
+	// required: true
+	URL string `json:"url" validate:"required,max=2048,http_url"`
+
+	// the event types delivered, every type when empty
+	//
+	// required: false
+	Events []string `json:"events" validate:"unique,dive,event"`
+
+	// the key deliveries are signed with, generated when empty. It is only
+	// returned when the webhook is created.
+	//
+	// required: false
+	Secret string `json:"secret,omitempty" validate:"omitempty,min=16,max=256"`
+
+	// read only: true
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// Event is an article event, posted as is to webhooks
+type Event struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	// the article as stored by the write
+	Data json.RawMessage `json:"data"`
+}
+
+// Delivery is the delivery of an event to a webhook
+type Delivery struct {
+	ID        int64     `json:"id"`
+	WebhookID int       `json:"webhook_id"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Event     Event     `json:"event"`
+
+	// where to deliver the event, set on claimed deliveries
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// WebhooksData manages the webhooks and their failed deliveries
+type WebhooksData interface {
+	AddWebhook(ctx context.Context, wh *Webhook) error
+	GetWebhooks(ctx context.Context) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	GetDeadLetters(ctx context.Context, limit int) ([]Delivery, error)
+	RetryDeadLetter(ctx context.Context, id int64) error
+}
+
+// recordEvents adds an event of type typ for each of the articles to the
+// outbox, along with its deliveries to the subscribed webhooks. It must be
+// called in the transaction writing the articles, after the write.
+func recordEvents(ctx context.Context, tx *sql.Tx, typ string, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	// events are inserted last, just before the commit, and stamped with
+	// the time of the insert rather than of the start of the transaction so
+	// that readers can tell how long a missing id may still be committing,
+	// see outboxGapWait
+	_, err := tx.ExecContext(ctx, `WITH e AS (
+  INSERT INTO outbox (type, article_id, payload, created_at)
+  SELECT $1::text, a.id, jsonb_build_object('id', a.id, 'title', a.title, 'date', a.date, 'body', a.body,
+    'tags', a.tags, 'updated_at', a.updated_at), clock_timestamp()
+  FROM articles a WHERE a.id = ANY($2::int[]) ORDER BY a.id
+  RETURNING id
+)
+INSERT INTO webhook_deliveries (event_id, webhook_id)
+SELECT e.id, w.id FROM e CROSS JOIN webhooks w
+WHERE cardinality(w.events) = 0 OR $1::text = ANY(w.events)`, typ, pq.Array(ids))
+	return err
+}
+
+// AddWebhook stores wh and sets its id and creation time. Only events
+// recorded afterwards are delivered to it.
+func (db *ArticlesDb) AddWebhook(ctx context.Context, wh *Webhook) (err error) {
+	query := "INSERT INTO webhooks (url, secret, events) VALUES ($1, $2, $3) RETURNING id, created_at"
+	ctx, span := startSpan(ctx, "ArticlesDb.AddWebhook", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	events := wh.Events
+	if events == nil {
+		events = []string{}
+	}
+	var created time.Time
+	err = db.postgres.QueryRowContext(ctx, query, wh.URL, wh.Secret, pq.Array(events)).Scan(&wh.ID, &created)
+	if err != nil {
+		db.log(ctx).Error("DB Query failed ", zap.Error(err))
+		return classifyWriteError("webhook", err)
+	}
+	wh.CreatedAt = &created
+
+	db.log(ctx).Info("Added webhook", zap.Int("id", wh.ID), zap.String("url", wh.URL))
+	return nil
+}
+
+// GetWebhooks returns every webhook in id order, without their secrets
+func (db *ArticlesDb) GetWebhooks(ctx context.Context) (whs []Webhook, err error) {
+	query := "SELECT id, url, events, created_at FROM webhooks ORDER BY id"
+	ctx, span := startSpan(ctx, "ArticlesDb.GetWebhooks", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rows, err := db.postgres.QueryContext(ctx, query)
+	if err != nil {
+		db.log(ctx).Error("sql query failed", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	whs = []Webhook{}
+	for rows.Next() {
+		var (
+			wh      Webhook
+			created time.Time
+		)
+		if err := rows.Scan(&wh.ID, &wh.URL, pq.Array(&wh.Events), &created); err != nil {
+			return nil, err
+		}
+		wh.CreatedAt = &created
+		whs = append(whs, wh)
+	}
+	return whs, rows.Err()
+}
+
+// DeleteWebhook removes the webhook and its pending deliveries
+func (db *ArticlesDb) DeleteWebhook(ctx context.Context, id int) (err error) {
+	query := "DELETE FROM webhooks WHERE id = $1"
+	ctx, span := startSpan(ctx, "ArticlesDb.DeleteWebhook", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	res, err := db.postgres.ExecContext(ctx, query, id)
+	if err != nil {
+		db.log(ctx).Error("DB Query failed ", zap.Error(err))
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return &NotFoundError{Resource: "webhook", Key: strconv.Itoa(id)}
+	}
+
+	db.log(ctx).Info("Deleted webhook", zap.Int("id", id))
+	return nil
+}
+
+// GetDeadLetters returns up to limit deliveries that are no longer
+// attempted, the most recently failed first
+func (db *ArticlesDb) GetDeadLetters(ctx context.Context, limit int) (ds []Delivery, err error) {
+	query := `SELECT d.id, d.webhook_id, d.status, d.attempts, d.last_error, d.updated_at, e.id, e.type, e.created_at, e.payload
+FROM webhook_deliveries d JOIN outbox e ON e.id = d.event_id
+WHERE d.status = 'dead' ORDER BY d.updated_at DESC, d.id DESC LIMIT $1`
+	ctx, span := startSpan(ctx, "ArticlesDb.GetDeadLetters", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rows, err := db.postgres.QueryContext(ctx, query, limit)
+	if err != nil {
+		db.log(ctx).Error("sql query failed", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	ds = []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Status, &d.Attempts, &d.LastError, &d.UpdatedAt,
+			&d.Event.ID, &d.Event.Type, &d.Event.CreatedAt, &d.Event.Data); err != nil {
+			return nil, err
+		}
+		ds = append(ds, d)
+	}
+	return ds, rows.Err()
+}
+
+// RetryDeadLetter queues the dead delivery again, with a fresh number of
+// attempts
+func (db *ArticlesDb) RetryDeadLetter(ctx context.Context, id int64) (err error) {
+	query := `UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = now(), updated_at = now()
+WHERE id = $1 AND status = 'dead'`
+	ctx, span := startSpan(ctx, "ArticlesDb.RetryDeadLetter", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	res, err := db.postgres.ExecContext(ctx, query, id)
+	if err != nil {
+		db.log(ctx).Error("DB Query failed ", zap.Error(err))
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return &NotFoundError{Resource: "dead letter", Key: strconv.FormatInt(id, 10)}
+	}
+	return nil
+}
+
+// ClaimDeliveries returns up to n pending deliveries that are due, and
+// postpones their next attempt by lease so that they are not claimed again
+// while being delivered, by this instance or another one. Deliveries whose
+// outcome is not recorded within the lease are attempted again.
+func (db *ArticlesDb) ClaimDeliveries(ctx context.Context, n int, lease time.Duration) (ds []Delivery, err error) {
+	query := `UPDATE webhook_deliveries d SET next_attempt_at = now() + interval '1 millisecond' * $2::float8
+FROM outbox e, webhooks w
+WHERE d.id IN (
+  SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= now()
+  ORDER BY next_attempt_at, id LIMIT $1 FOR UPDATE SKIP LOCKED
+) AND e.id = d.event_id AND w.id = d.webhook_id
+RETURNING d.id, d.webhook_id, d.status, d.attempts, d.last_error, d.updated_at, e.id, e.type, e.created_at, e.payload, w.url, w.secret`
+	ctx, span := startSpan(ctx, "ArticlesDb.ClaimDeliveries", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	rows, err := db.postgres.QueryContext(ctx, query, n, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d Delivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Status, &d.Attempts, &d.LastError, &d.UpdatedAt,
+			&d.Event.ID, &d.Event.Type, &d.Event.CreatedAt, &d.Event.Data, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		ds = append(ds, d)
+	}
+	span.SetAttributes(attribute.Int("deliveries.count", len(ds)))
+	return ds, rows.Err()
+}
+
+// CompleteDelivery records the successful attempt of the delivery
+func (db *ArticlesDb) CompleteDelivery(ctx context.Context, id int64) (err error) {
+	query := `UPDATE webhook_deliveries SET status = 'delivered', attempts = attempts + 1, last_error = '', updated_at = now()
+WHERE id = $1`
+	ctx, span := startSpan(ctx, "ArticlesDb.CompleteDelivery", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	_, err = db.postgres.ExecContext(ctx, query, id)
+	return err
+}
+
+// FailDelivery records the failed attempt of the delivery, which is
+// attempted again at retryAt, or dead lettered when retryAt is zero
+func (db *ArticlesDb) FailDelivery(ctx context.Context, id int64, reason string, retryAt time.Time) (err error) {
+	query := `UPDATE webhook_deliveries SET status = $3, attempts = attempts + 1, last_error = $2,
+next_attempt_at = COALESCE($4, next_attempt_at), updated_at = now()
+WHERE id = $1`
+	ctx, span := startSpan(ctx, "ArticlesDb.FailDelivery", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	status, next := DeliveryPending, sql.NullTime{Time: retryAt, Valid: true}
+	if retryAt.IsZero() {
+		status, next = DeliveryDead, sql.NullTime{}
+	}
+	_, err = db.postgres.ExecContext(ctx, query, id, reason, status, next)
+	return err
+}
+
+// PruneEvents removes the events recorded before t that have no delivery
+// left to attempt or retry, and returns how many were removed
+func (db *ArticlesDb) PruneEvents(ctx context.Context, t time.Time) (n int64, err error) {
+	query := `DELETE FROM outbox e WHERE e.created_at < $1 AND NOT EXISTS (
+  SELECT 1 FROM webhook_deliveries d WHERE d.event_id = e.id AND d.status <> 'delivered'
+)`
+	ctx, span := startSpan(ctx, "ArticlesDb.PruneEvents", query)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	res, err := db.postgres.ExecContext(ctx, query, t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,284 @@
+// Package events fans the article events recorded in the outbox out to the
+// subscribers of this instance, such as the clients of the event stream.
+//
+// The Bus tails the outbox, waking up whenever a write of this instance
+// commits events and polling for those of other instances. Since events are
+// read in id order, waiting for the ids that may still be committing, a
+// subscriber that knows the id of the last event it got can replay the ones
+// it missed from the outbox and then follow the live ones without gaps or
+// duplicates.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sg83/go-microservice/article-api/data"
+	"go.uber.org/zap"
+)
+
+const (
+	// pageSize is the number of events read from the outbox at once
+	pageSize = 500
+	// bufferSize is the number of events a subscriber may lag behind
+	// before it is dropped
+	bufferSize = 256
+)
+
+var subscribers = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "article_api",
+	Subsystem: "events",
+	Name:      "subscribers",
+	Help:      "Subscribers of the in-process event bus, such as open event streams.",
+})
+
+// Source reads the recorded events
+type Source interface {
+	GetEventsSince(ctx context.Context, after int64, limit int) ([]data.Event, error)
+	LastEventID(ctx context.Context) (int64, error)
+}
+
+// Event is an article event along with the tags of its article
+type Event struct {
+	data.Event
+	Tags []string `json:"-"`
+}
+
+// newEvent reads the tags of the article of e
+func newEvent(e data.Event) Event {
+	var a struct {
+		Tags []string `json:"tags"`
+	}
+	json.Unmarshal(e.Data, &a)
+	return Event{e, a.Tags}
+}
+
+// Matches reports whether the article of the event has one of the tags,
+// every event matching when there are none
+func (e Event) Matches(tags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	for _, t := range tags {
+		for _, et := range e.Tags {
+			if t == et {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	// C receives the matching events in id order. It is closed when the
+	// subscriber lags too far behind, the missed events can then be
+	// replayed from the last one received.
+	C <-chan Event
+	// Start is the id of the last event published before the subscription
+	Start int64
+
+	c    chan Event
+	tags []string
+}
+
+// Bus publishes the recorded events to its subscribers
+type Bus struct {
+	l    *zap.Logger
+	src  Source
+	poll time.Duration
+	wake chan struct{}
+	// closed once the last recorded event is known, and once the bus is
+	// closed
+	ready chan struct{}
+	done  chan struct{}
+
+	mu     sync.Mutex
+	last   int64
+	subs   map[*Subscription]bool
+	closed bool
+}
+
+// ErrClosed is returned when subscribing to a closed bus
+var ErrClosed = errors.New("events: bus closed")
+
+// NewBus returns a Bus reading the events of src, polling it every poll
+func NewBus(l *zap.Logger, src Source, poll time.Duration) *Bus {
+	return &Bus{
+		l:     l,
+		src:   src,
+		poll:  poll,
+		wake:  make(chan struct{}, 1),
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+		subs:  map[*Subscription]bool{},
+	}
+}
+
+// Notify wakes the bus up to publish newly committed events, it does not
+// block
+func (b *Bus) Notify() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes the events recorded from now on until ctx is done
+func (b *Bus) Run(ctx context.Context) {
+	t := time.NewTicker(b.poll)
+	defer t.Stop()
+
+	started := false
+	for {
+		if !started {
+			last, err := b.src.LastEventID(ctx)
+			if err == nil {
+				b.mu.Lock()
+				b.last, started = last, true
+				b.mu.Unlock()
+				close(b.ready)
+			} else if ctx.Err() == nil {
+				b.l.Error("Reading the last event failed", zap.Error(err))
+			}
+		}
+		if started {
+			if err := b.publishNew(ctx); err != nil && ctx.Err() == nil {
+				b.l.Error("Reading events failed", zap.Error(err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			b.Close()
+			return
+		case <-b.wake:
+		case <-t.C:
+		}
+	}
+}
+
+// publishNew publishes the events recorded since the last one published
+func (b *Bus) publishNew(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		last := b.last
+		b.mu.Unlock()
+
+		es, err := b.src.GetEventsSince(ctx, last, pageSize)
+		if err != nil {
+			return err
+		}
+		for _, e := range es {
+			b.publish(newEvent(e))
+		}
+		if len(es) < pageSize {
+			return nil
+		}
+	}
+}
+
+// publish sends e to the subscribers it matches, dropping those that lag
+// too far behind
+func (b *Bus) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.last = e.ID
+	for s := range b.subs {
+		if !e.Matches(s.tags) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			b.l.Info("Dropping lagging subscriber", zap.Int64("event", e.ID))
+			b.remove(s)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events of articles with one of
+// the tags, or to every event when there are none. It waits for the bus to
+// know the last recorded event, or for ctx to be done.
+func (b *Bus) Subscribe(ctx context.Context, tags []string) (*Subscription, error) {
+	select {
+	case <-b.ready:
+	case <-b.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c := make(chan Event, bufferSize)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+
+	s := &Subscription{C: c, Start: b.last, c: c, tags: tags}
+	b.subs[s] = true
+	subscribers.Inc()
+	return s, nil
+}
+
+// Unsubscribe stops the delivery of events to s
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(s)
+}
+
+// remove closes and forgets s, b.mu must be held
+func (b *Bus) remove(s *Subscription) {
+	if b.subs[s] {
+		delete(b.subs, s)
+		close(s.c)
+		subscribers.Dec()
+	}
+}
+
+// Close ends every subscription and refuses new ones, e.g. so that event
+// streams do not hold up the shutdown of the server
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+	for s := range b.subs {
+		b.remove(s)
+	}
+}
+
+// Replay calls fn with the events after the event with id after, up to and
+// including the one with id until, that match the tags
+func (b *Bus) Replay(ctx context.Context, after, until int64, tags []string, fn func(Event) error) error {
+	for after < until {
+		es, err := b.src.GetEventsSince(ctx, after, pageSize)
+		if err != nil {
+			return err
+		}
+		if len(es) == 0 {
+			return nil
+		}
+		for _, de := range es {
+			if de.ID > until {
+				return nil
+			}
+			if e := newEvent(de); e.Matches(tags) {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			after = de.ID
+		}
+	}
+	return nil
+}
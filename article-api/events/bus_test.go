@@ -0,0 +1,216 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"go.uber.org/zap"
+)
+
+// outbox is an in-memory Source
+type outbox struct {
+	mu     sync.Mutex
+	events []data.Event
+}
+
+func (o *outbox) add(tags ...string) int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	id := int64(len(o.events) + 1)
+	payload, _ := json.Marshal(map[string]interface{}{"id": id, "tags": tags})
+	o.events = append(o.events, data.Event{ID: id, Type: data.EventArticleCreated, CreatedAt: time.Now(), Data: payload})
+	return id
+}
+
+func (o *outbox) GetEventsSince(ctx context.Context, after int64, limit int) ([]data.Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var es []data.Event
+	for _, e := range o.events {
+		if e.ID > after && len(es) < limit {
+			es = append(es, e)
+		}
+	}
+	return es, nil
+}
+
+func (o *outbox) LastEventID(ctx context.Context) (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return int64(len(o.events)), nil
+}
+
+// next returns the next event of s, failing the test if none comes
+func next(t *testing.T, s *Subscription) Event {
+	t.Helper()
+	select {
+	case e, ok := <-s.C:
+		if !ok {
+			t.Fatal("Subscription closed")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event received")
+	}
+	return Event{}
+}
+
+// startBus runs a bus over o until the test ends
+func startBus(t *testing.T, o *outbox, poll time.Duration) *Bus {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewBus(zap.NewNop(), o, poll)
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return b
+}
+
+func TestBus(t *testing.T) {
+	o := &outbox{}
+	o.add("health")
+	// the bus is only woken up by Notify
+	b := startBus(t, o, time.Hour)
+
+	all, err := b.Subscribe(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	health, _ := b.Subscribe(context.Background(), []string{"health", "fitness"})
+	science, _ := b.Subscribe(context.Background(), []string{"science"})
+	if all.Start != 1 {
+		t.Errorf("Expected the subscription to start after event 1 but got %d", all.Start)
+	}
+
+	o.add("health")
+	o.add("science", "fitness")
+	o.add()
+	b.Notify()
+
+	for _, tc := range []struct {
+		name string
+		sub  *Subscription
+		ids  []int64
+	}{
+		{"all", all, []int64{2, 3, 4}},
+		{"health", health, []int64{2, 3}},
+		{"science", science, []int64{3}},
+	} {
+		for _, id := range tc.ids {
+			if e := next(t, tc.sub); e.ID != id {
+				t.Errorf("%s: expected event %d but got %d", tc.name, id, e.ID)
+			}
+		}
+	}
+	select {
+	case e := <-science.C:
+		t.Errorf("Unexpected event %d", e.ID)
+	default:
+	}
+
+	// unsubscribed subscribers get nothing more
+	b.Unsubscribe(health)
+	if _, ok := <-health.C; ok {
+		t.Error("Expected the subscription to be closed")
+	}
+
+	// lagging subscribers are dropped, other ones are not
+	for i := 0; i <= bufferSize; i++ {
+		o.add("science")
+	}
+	b.Notify()
+	for i := 0; i <= bufferSize; i++ {
+		next(t, all)
+	}
+	n := 0
+	for range science.C {
+		n++
+	}
+	if n != bufferSize {
+		t.Errorf("Expected %d events before the lagging subscriber was dropped but got %d", bufferSize, n)
+	}
+}
+
+func TestBusPolls(t *testing.T) {
+	o := &outbox{}
+	b := startBus(t, o, 10*time.Millisecond)
+	s, err := b.Subscribe(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// events committed by other instances are found by polling
+	id := o.add()
+	if e := next(t, s); e.ID != id {
+		t.Errorf("Expected event %d but got %d", id, e.ID)
+	}
+}
+
+func TestBusClose(t *testing.T) {
+	b := startBus(t, &outbox{}, time.Hour)
+	s, err := b.Subscribe(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Close()
+	if _, ok := <-s.C; ok {
+		t.Error("Expected the subscription to be closed")
+	}
+	if _, err := b.Subscribe(context.Background(), nil); err != ErrClosed {
+		t.Errorf("Expected ErrClosed but got %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	o := &outbox{}
+	for i := 0; i < pageSize+10; i++ {
+		tag := "even"
+		if i%2 == 1 {
+			tag = "odd"
+		}
+		o.add(tag)
+	}
+	b := NewBus(zap.NewNop(), o, time.Hour)
+
+	tt := []struct {
+		after, until int64
+		tags         []string
+		first, last  int64
+		count        int
+	}{
+		{0, int64(pageSize + 10), nil, 1, int64(pageSize + 10), pageSize + 10},
+		{5, 8, nil, 6, 8, 3},
+		{495, 505, []string{"odd"}, 496, 504, 5},
+		{8, 8, nil, 0, 0, 0},
+		{9, 5, nil, 0, 0, 0},
+	}
+	for _, tc := range tt {
+		t.Run(fmt.Sprintf("%d-%d", tc.after, tc.until), func(t *testing.T) {
+			var got []int64
+			err := b.Replay(context.Background(), tc.after, tc.until, tc.tags, func(e Event) error {
+				got = append(got, e.ID)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tc.count {
+				t.Fatalf("Expected %d events but got %d", tc.count, len(got))
+			}
+			if tc.count > 0 && (got[0] != tc.first || got[len(got)-1] != tc.last) {
+				t.Errorf("Expected events %d to %d but got %d to %d", tc.first, tc.last, got[0], got[len(got)-1])
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// Package export encodes articles for backups and migrations, as newline
+// delimited JSON, CSV, or a gzipped tar archive of JSON files, and decodes
+// them back unchanged. Articles are written and read one at a time so that
+// exports of any size can be streamed.
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/utils"
+)
+
+// Format is an encoding of a sequence of articles
+type Format string
+
+const (
+	// NDJSON is one JSON article per line
+	NDJSON Format = "ndjson"
+	// CSV has a header and one article per record, with tags as a JSON array
+	CSV Format = "csv"
+	// TarGz is a gzipped tar archive holding one JSON file per article
+	TarGz Format = "tar.gz"
+)
+
+// Formats lists the supported formats
+var Formats = []Format{NDJSON, CSV, TarGz}
+
+// ParseFormat returns the format named s
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("format %q is not one of ndjson, csv or tar.gz", s)
+}
+
+// ContentType is the media type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case TarGz:
+		return "application/gzip"
+	}
+	return "application/x-ndjson"
+}
+
+// FormatOf returns the format of a body of the given media type
+func FormatOf(contentType string) (Format, bool) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	for _, f := range Formats {
+		if ft, _, _ := mime.ParseMediaType(f.ContentType()); ft == mt {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// header is the first record of CSV exports
+var header = []string{"id", "title", "date", "body", "tags", "updated_at"}
+
+// Writer encodes articles
+type Writer interface {
+	Write(a data.Article) error
+	// Close ends the export, it does not close the underlying writer
+	Close() error
+}
+
+// NewWriter returns a writer encoding articles to w in format f
+func NewWriter(w io.Writer, f Format) Writer {
+	switch f {
+	case CSV:
+		return &csvWriter{w: csv.NewWriter(w)}
+	case TarGz:
+		gz := gzip.NewWriter(w)
+		return &tarWriter{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	return &ndjsonWriter{w: w}
+}
+
+type ndjsonWriter struct {
+	w io.Writer
+}
+
+func (n *ndjsonWriter) Write(a data.Article) error {
+	return utils.ToJSON(a, n.w)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (c *csvWriter) Write(a data.Article) error {
+	if !c.wroteHeader {
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+		c.wroteHeader = true
+	}
+	tags, err := json.Marshal(a.Tags)
+	if err != nil {
+		return err
+	}
+	updated := ""
+	if a.UpdatedAt != nil {
+		updated = a.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	return c.w.Write([]string{strconv.Itoa(a.ID), a.Title, a.Date, a.Body, string(tags), updated})
+}
+
+func (c *csvWriter) Close() error {
+	if !c.wroteHeader {
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type tarWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarWriter) Write(a data.Article) error {
+	var b bytes.Buffer
+	if err := utils.ToJSON(a, &b); err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     fileName(a.ID),
+		Mode:     0o644,
+		Size:     int64(b.Len()),
+		Typeflag: tar.TypeReg,
+	}
+	if a.UpdatedAt != nil {
+		hdr.ModTime = *a.UpdatedAt
+	}
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := t.tw.Write(b.Bytes())
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
+
+// fileName is the name of the file of an article in archives
+func fileName(id int) string {
+	return "articles/" + strconv.Itoa(id) + ".json"
+}
+
+// Reader decodes articles. Invalid input is reported as a
+// *data.InvalidError locating the culprit, errors reading the underlying
+// reader may be returned as is.
+type Reader interface {
+	// Read returns the next article, or io.EOF after the last one
+	Read() (data.Article, error)
+}
+
+// NewReader returns a reader decoding the articles encoded in r in format f
+func NewReader(r io.Reader, f Format) Reader {
+	switch f {
+	case CSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = len(header)
+		return &csvReader{r: cr}
+	case TarGz:
+		return &tarReader{r: r}
+	}
+	return &ndjsonReader{r: utils.NewLinesReader(r)}
+}
+
+// invalid reports an error in the article found at where
+func invalid(where string, err error) error {
+	return &data.InvalidError{Reason: where + ": " + err.Error(), Err: err}
+}
+
+type ndjsonReader struct {
+	r    utils.ValueReader
+	line int
+}
+
+func (n *ndjsonReader) Read() (data.Article, error) {
+	var a data.Article
+	raw, err := n.r.Next()
+	if err != nil {
+		return a, err
+	}
+	n.line++
+	if err := utils.FromJSON(&a, bytes.NewReader(raw)); err != nil {
+		return a, invalid(fmt.Sprintf("article %d", n.line), err)
+	}
+	return a, nil
+}
+
+type csvReader struct {
+	r          *csv.Reader
+	readHeader bool
+}
+
+func (c *csvReader) Read() (data.Article, error) {
+	var a data.Article
+	if !c.readHeader {
+		rec, err := c.r.Read()
+		if err == io.EOF {
+			return a, invalid("csv", errors.New("the header is missing"))
+		}
+		if err != nil {
+			return a, c.error(err)
+		}
+		if strings.Join(rec, ",") != strings.Join(header, ",") {
+			return a, invalid("csv", fmt.Errorf("the header must be %s", strings.Join(header, ",")))
+		}
+		c.readHeader = true
+	}
+
+	rec, err := c.r.Read()
+	if err != nil {
+		return a, c.error(err)
+	}
+	line, _ := c.r.FieldPos(0)
+	where := "csv line " + strconv.Itoa(line)
+
+	if a.ID, err = strconv.Atoi(rec[0]); err != nil {
+		return a, invalid(where, fmt.Errorf("id %q is not a number", rec[0]))
+	}
+	a.Title, a.Date, a.Body = rec[1], rec[2], rec[3]
+	if err := json.Unmarshal([]byte(rec[4]), &a.Tags); err != nil {
+		return a, invalid(where, fmt.Errorf("tags are not a JSON array of strings: %w", err))
+	}
+	if rec[5] != "" {
+		t, err := time.Parse(time.RFC3339Nano, rec[5])
+		if err != nil {
+			return a, invalid(where, fmt.Errorf("updated_at %q is not an RFC 3339 time", rec[5]))
+		}
+		a.UpdatedAt = &t
+	}
+	return a, nil
+}
+
+// error describes an error reading a record
+func (c *csvReader) error(err error) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return invalid("csv", err)
+	}
+	return err
+}
+
+type tarReader struct {
+	r  io.Reader
+	tr *tar.Reader
+}
+
+func (t *tarReader) Read() (data.Article, error) {
+	var a data.Article
+	if t.tr == nil {
+		gz, err := gzip.NewReader(t.r)
+		if err != nil {
+			return a, invalid("archive", err)
+		}
+		t.tr = tar.NewReader(gz)
+	}
+
+	for {
+		hdr, err := t.tr.Next()
+		if err == io.EOF {
+			return a, io.EOF
+		}
+		if err != nil {
+			return a, invalid("archive", err)
+		}
+		// directories and other files are ignored
+		if hdr.Typeflag != tar.TypeReg || path.Ext(hdr.Name) != ".json" {
+			continue
+		}
+		if err := utils.FromJSON(&a, t.tr); err != nil {
+			return a, invalid("archive file "+hdr.Name, err)
+		}
+		return a, nil
+	}
+}
@@ -0,0 +1,122 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+)
+
+func TestRoundTrip(t *testing.T) {
+	updated := time.Date(2023, 4, 5, 10, 4, 11, 123456000, time.FixedZone("", 2*60*60))
+	articles := []data.Article{
+		{ID: 1, Title: "Potato chips", Date: "2016-09-22", Body: "line one\nline two, with \"quotes\"", Tags: []string{"health", "science"}, UpdatedAt: &updated},
+		{ID: 7, Title: "No tags", Date: "2016-09-23", Body: "body", Tags: []string{}, UpdatedAt: &updated},
+		{ID: 9, Title: "Null tags, never written", Date: "2016-09-24", Body: "body"},
+	}
+
+	for _, f := range Formats {
+		t.Run(string(f), func(t *testing.T) {
+			var b bytes.Buffer
+			w := NewWriter(&b, f)
+			for _, a := range articles {
+				if err := w.Write(a); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var got []data.Article
+			r := NewReader(&b, f)
+			for {
+				a, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, a)
+			}
+
+			if len(got) != len(articles) {
+				t.Fatalf("Expected %d articles but got %d", len(articles), len(got))
+			}
+			for i := range articles {
+				want, a := articles[i], got[i]
+				if (want.UpdatedAt == nil) != (a.UpdatedAt == nil) || (a.UpdatedAt != nil && !a.UpdatedAt.Equal(*want.UpdatedAt)) {
+					t.Errorf("Expected updated at %v but got %v", want.UpdatedAt, a.UpdatedAt)
+				}
+				want.UpdatedAt, a.UpdatedAt = nil, nil
+				if !reflect.DeepEqual(a, want) {
+					t.Errorf("Expected %#v but got %#v", want, a)
+				}
+			}
+		})
+	}
+}
+
+func TestEmptyExport(t *testing.T) {
+	for _, f := range Formats {
+		var b bytes.Buffer
+		if err := NewWriter(&b, f).Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewReader(&b, f).Read(); err != io.EOF {
+			t.Errorf("%s: expected no article but got %v", f, err)
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	tt := []struct {
+		name  string
+		f     Format
+		input string
+		where string
+	}{
+		{"unknown field", NDJSON, `{"id": 1, "title": "t"}` + "\n" + `{"id": 2, "author": "me"}`, "article 2: unknown field"},
+		{"wrong header", CSV, "id,title,date,body,tags,modified\n", "csv: the header must be"},
+		{"wrong field count", CSV, "id,title,date,body,tags,updated_at\n1,t\n", "csv: record on line 2"},
+		{"bad id", CSV, "id,title,date,body,tags,updated_at\nx,t,2016-09-22,b,[],\n", "csv line 2: id"},
+		{"bad tags", CSV, "id,title,date,body,tags,updated_at\n1,t,2016-09-22,b,health,\n", "csv line 2: tags"},
+		{"not gzipped", TarGz, "plain text", "archive: gzip"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tc.input), tc.f)
+			var err error
+			for err == nil {
+				_, err = r.Read()
+			}
+			var ie *data.InvalidError
+			if !errors.As(err, &ie) || !strings.HasPrefix(ie.Reason, tc.where) {
+				t.Errorf("Expected an invalid error starting with %q but got %v", tc.where, err)
+			}
+		})
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	for ct, want := range map[string]Format{
+		"application/x-ndjson":    NDJSON,
+		"text/csv":                CSV,
+		"text/csv; charset=utf-8": CSV,
+		"application/gzip":        TarGz,
+	} {
+		if f, ok := FormatOf(ct); !ok || f != want {
+			t.Errorf("Expected %s to be %s but got %q", ct, want, f)
+		}
+	}
+	if _, ok := FormatOf("application/json"); ok {
+		t.Error("Expected application/json not to be an export format")
+	}
+}
@@ -0,0 +1,94 @@
+package graphql
+
+import "github.com/vektah/gqlparser/v2/ast"
+
+// ListFactor is the number of items a list is assumed to have when
+// computing the complexity of a query
+const ListFactor = 10
+
+// maxComplexity caps the complexity computed, so that it cannot overflow
+const maxComplexity = 1 << 40
+
+// Complexity returns the sum of the costs of the fields of a validated
+// selection set, those under lists counting ListFactor times. Every field
+// costs 1 but __typename, fields selected more than once under the same
+// name are counted once, and those skipped by @skip or @include with vars
+// are not counted.
+func Complexity(set ast.SelectionSet, vars map[string]interface{}) int {
+	c := 0
+	for _, f := range collect(set, vars) {
+		if f.def == nil || f.def.Name == "__typename" {
+			continue
+		}
+		sub := Complexity(f.sels, vars)
+		if f.def.Type.Elem != nil {
+			sub *= ListFactor
+		}
+		c += 1 + sub
+		if c > maxComplexity {
+			return maxComplexity
+		}
+	}
+	return c
+}
+
+// collected is a field of a selection set with the selections of every time
+// it is selected
+type collected struct {
+	def  *ast.FieldDefinition
+	sels ast.SelectionSet
+}
+
+// collect returns the fields of set by response name, in the order they are
+// first selected, flattening fragments
+func collect(set ast.SelectionSet, vars map[string]interface{}) []*collected {
+	var fields []*collected
+	byName := map[string]*collected{}
+
+	var walk func(set ast.SelectionSet)
+	walk = func(set ast.SelectionSet) {
+		for _, sel := range set {
+			switch sel := sel.(type) {
+			case *ast.Field:
+				if skipped(sel.Directives, vars) {
+					continue
+				}
+				f, ok := byName[sel.Alias]
+				if !ok {
+					f = &collected{def: sel.Definition}
+					byName[sel.Alias] = f
+					fields = append(fields, f)
+				}
+				f.sels = append(f.sels, sel.SelectionSet...)
+			case *ast.InlineFragment:
+				if !skipped(sel.Directives, vars) {
+					walk(sel.SelectionSet)
+				}
+			case *ast.FragmentSpread:
+				if !skipped(sel.Directives, vars) && sel.Definition != nil {
+					walk(sel.Definition.SelectionSet)
+				}
+			}
+		}
+	}
+	walk(set)
+	return fields
+}
+
+// skipped reports whether @skip or @include leave a selection out
+func skipped(dirs ast.DirectiveList, vars map[string]interface{}) bool {
+	for _, d := range dirs {
+		cond, _ := d.ArgumentMap(vars)["if"].(bool)
+		switch d.Name {
+		case "skip":
+			if cond {
+				return true
+			}
+		case "include":
+			if !cond {
+				return true
+			}
+		}
+	}
+	return false
+}
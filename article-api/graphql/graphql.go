@@ -0,0 +1,106 @@
+// Package graphql executes GraphQL queries with
+// github.com/graph-gophers/graphql-go, within limits on their depth and
+// complexity, and batches the loads of their resolvers.
+//
+// Resolvers run concurrently, those of the fields of every object of a list
+// at the same time, so that the loads they ask a Loader for within a short
+// wait of each other are fetched in a single batch instead of once per
+// object.
+package graphql
+
+import (
+	"context"
+
+	graphqlgo "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/errors"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// maxParallelism is the largest number of resolvers of a query running at
+// once, as many as the ids a loader fetches in a batch
+const maxParallelism = 100
+
+// Request is a GraphQL request, as sent in the body of a POST
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Response is the result of a request. Data is absent when the request could
+// not be executed, because it is malformed, invalid or over the limits, and
+// null when a field that cannot be null failed at the root of the query.
+type Response = graphqlgo.Response
+
+// Limits bound the cost of the queries a schema executes
+type Limits struct {
+	// MaxDepth is the deepest nesting of fields, unbounded when zero
+	MaxDepth int
+	// MaxComplexity is the highest sum of the costs of the fields of a
+	// query, those under a list being counted ListFactor times. It is
+	// unbounded when zero.
+	MaxComplexity int
+}
+
+// Schema executes the queries of a schema within limits
+type Schema struct {
+	exec *graphqlgo.Schema
+	// the same schema, to compute the complexity of queries
+	types  *ast.Schema
+	limits Limits
+}
+
+// NewSchema parses the schema sdl, whose query fields are resolved by the
+// methods of resolver as graph-gophers/graphql-go does
+func NewSchema(sdl string, resolver interface{}, limits Limits) (*Schema, error) {
+	exec, err := graphqlgo.ParseSchema(sdl, resolver,
+		graphqlgo.MaxDepth(limits.MaxDepth),
+		graphqlgo.MaxParallelism(maxParallelism),
+	)
+	if err != nil {
+		return nil, err
+	}
+	types, err := gqlparser.LoadSchema(&ast.Source{Name: "schema", Input: sdl})
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{exec: exec, types: types, limits: limits}, nil
+}
+
+// MustNewSchema is like NewSchema but panics if the schema is invalid, for
+// schemas known when the program is written
+func MustNewSchema(sdl string, resolver interface{}, limits Limits) *Schema {
+	s, err := NewSchema(sdl, resolver, limits)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Execute validates the query of req against the schema and, when it is
+// valid and within the limits, executes it
+func (s *Schema) Execute(ctx context.Context, req Request) *Response {
+	if s.limits.MaxComplexity > 0 {
+		// the errors of the query, the depth limit included, come first
+		if errs := s.exec.ValidateWithVariables(req.Query, req.Variables); len(errs) != 0 {
+			return &Response{Errors: errs}
+		}
+		if doc, errs := gqlparser.LoadQuery(s.types, req.Query); errs == nil {
+			if op := doc.Operations.ForName(req.OperationName); op != nil {
+				if c := Complexity(op.SelectionSet, req.Variables); c > s.limits.MaxComplexity {
+					return &Response{Errors: []*errors.QueryError{
+						errors.Errorf("The query has a complexity of %d, over the limit of %d.", c, s.limits.MaxComplexity),
+					}}
+				}
+			}
+		}
+	}
+	return s.exec.Exec(ctx, req.Query, req.OperationName, req.Variables)
+}
+
+// Executed reports whether the request of resp was executed, even if some
+// of its fields failed
+func Executed(resp *Response) bool {
+	return resp.Data != nil
+}
@@ -0,0 +1,197 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// testSDL is the test schema, the children of node n being nodes 10n to
+// 10n+2 of those from 1 to 99
+const testSDL = `
+schema {
+  query: Query
+}
+type Query {
+  node(id: Int!): Node
+}
+type Node {
+  id: Int!
+  name: String!
+  children: [Node!]!
+}
+`
+
+type loaderKey struct{}
+
+// testContext returns a context holding a node loader recording the ids of
+// each of its batches in batches
+func testContext(batches *[][]int) context.Context {
+	var mu sync.Mutex
+	l := NewLoader(10*time.Millisecond, 100, func(ids []int) (map[int]int, error) {
+		sorted := append([]int(nil), ids...)
+		sort.Ints(sorted)
+		mu.Lock()
+		*batches = append(*batches, sorted)
+		mu.Unlock()
+		m := map[int]int{}
+		for _, id := range ids {
+			if id >= 1 && id <= 99 {
+				m[id] = id
+			}
+		}
+		return m, nil
+	})
+	return context.WithValue(context.Background(), loaderKey{}, l)
+}
+
+type testQuery struct{}
+
+func (*testQuery) Node(ctx context.Context, args struct{ ID int32 }) (*testNode, error) {
+	id, ok, err := ctx.Value(loaderKey{}).(*Loader[int, int]).Load(ctx, int(args.ID))
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &testNode{id}, nil
+}
+
+type testNode struct{ id int }
+
+func (n *testNode) ID() int32    { return int32(n.id) }
+func (n *testNode) Name() string { return "node " + strconv.Itoa(n.id) }
+
+func (n *testNode) Children(ctx context.Context) ([]*testNode, error) {
+	ids := []int{n.id * 10, n.id*10 + 1, n.id*10 + 2}
+	found, err := ctx.Value(loaderKey{}).(*Loader[int, int]).LoadMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	children := []*testNode{}
+	for _, id := range ids {
+		if _, ok := found[id]; ok {
+			children = append(children, &testNode{id})
+		}
+	}
+	return children, nil
+}
+
+func TestExecute(t *testing.T) {
+	tt := []struct {
+		name   string
+		req    Request
+		limits Limits
+		// the JSON response
+		want string
+	}{
+		{
+			name: "variables, aliases and fragments",
+			req: Request{
+				Query:     `query ($id: Int!) { node(id: $id) { ...f other: name } } fragment f on Node { id children { id } }`,
+				Variables: map[string]interface{}{"id": 1},
+			},
+			limits: Limits{MaxDepth: 3, MaxComplexity: 100},
+			want:   `{"data":{"node":{"id":1,"children":[{"id":10},{"id":11},{"id":12}],"other":"node 1"}}}`,
+		},
+		{
+			name: "missing node",
+			req:  Request{Query: `{ node(id: 100) { id } }`},
+			want: `{"data":{"node":null}}`,
+		},
+		{
+			name:   "too deep",
+			req:    Request{Query: `{ node(id: 1) { children { children { id } } } }`},
+			limits: Limits{MaxDepth: 3},
+			want:   `{"errors":[{"message":"Field \"id\" has depth 4 that exceeds max depth 3","locations":[{"line":1,"column":39}]}]}`,
+		},
+		{
+			name:   "too complex",
+			req:    Request{Query: `{ node(id: 1) { children { children { id } } } }`},
+			limits: Limits{MaxComplexity: 100},
+			want:   `{"errors":[{"message":"The query has a complexity of 112, over the limit of 100."}]}`,
+		},
+		{
+			name:   "invalid over the complexity limit",
+			req:    Request{Query: `{ node(id: 1) { children { children { author } } } }`},
+			limits: Limits{MaxComplexity: 100},
+			want:   `{"errors":[{"message":"Cannot query field \"author\" on type \"Node\".","locations":[{"line":1,"column":39}]}]}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSchema(testSDL, &testQuery{}, tc.limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var batches [][]int
+			resp := s.Execute(testContext(&batches), tc.req)
+			got, _ := json.Marshal(resp)
+			if string(got) != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tc.want)
+			}
+			if Executed(resp) != strings.Contains(tc.want, `"data"`) {
+				t.Errorf("got Executed %v", Executed(resp))
+			}
+		})
+	}
+}
+
+func TestExecuteBatchesLevels(t *testing.T) {
+	s, err := NewSchema(testSDL, &testQuery{}, Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batches [][]int
+	resp := s.Execute(testContext(&batches), Request{Query: `{ node(id: 1) { children { children { id } } } }`})
+	if len(resp.Errors) != 0 {
+		t.Fatal(resp.Errors)
+	}
+
+	// the children of the nodes of a level are loaded together
+	want := [][]int{{1}, {10, 11, 12}, {100, 101, 102, 110, 111, 112, 120, 121, 122}}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("got batches %v, want %v", batches, want)
+	}
+}
+
+func TestComplexity(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: testSDL})
+
+	tt := []struct {
+		name  string
+		query string
+		vars  map[string]interface{}
+		want  int
+	}{
+		{name: "field", query: `{ node(id: 1) { id } }`, want: 2},
+		{name: "list", query: `{ node(id: 1) { children { id name } } }`, want: 22},
+		{name: "nested lists", query: `{ node(id: 1) { children { children { id } } } }`, want: 112},
+		{name: "typename", query: `{ node(id: 1) { __typename id } }`, want: 2},
+		{name: "aliases", query: `{ a: node(id: 1) { id } b: node(id: 2) { id } }`, want: 4},
+		{name: "field selected twice", query: `{ node(id: 1) { id } node(id: 1) { name } }`, want: 3},
+		{name: "fragments", query: `{ node(id: 1) { ...f ... on Node { name } } } fragment f on Node { id }`, want: 3},
+		{name: "skipped", query: `query ($s: Boolean!) { node(id: 1) { id name @skip(if: $s) children @include(if: false) { id } } }`, vars: map[string]interface{}{"s": true}, want: 2},
+		{name: "not skipped", query: `query ($s: Boolean!) { node(id: 1) { id name @skip(if: $s) } }`, vars: map[string]interface{}{"s": false}, want: 3},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, errs := gqlparser.LoadQuery(schema, tc.query)
+			if errs != nil {
+				t.Fatal(errs)
+			}
+			if got := Complexity(doc.Operations[0].SelectionSet, tc.vars); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package graphql
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Loader batches the loads of values by key that the resolvers of a query
+// ask for within wait of the first one into a single call of its fetch
+// function, and keeps the values for the rest of the query. Resolvers run
+// concurrently, so it is safe for concurrent use; a loader is made for every
+// request.
+type Loader[K comparable, V any] struct {
+	fetch func(keys []K) (map[K]V, error)
+	wait  time.Duration
+	max   int
+
+	mu      sync.Mutex
+	loads   map[K]*load[V]
+	pending []K
+	timer   *time.Timer
+}
+
+// load is the value of a key, set when done is closed
+type load[V any] struct {
+	done  chan struct{}
+	value V
+	ok    bool
+	err   error
+}
+
+// NewLoader returns a loader getting the values of keys from fetch, which
+// leaves out of its result the keys without a value. A batch is fetched wait
+// after its first key is asked for, or as soon as it has max keys.
+func NewLoader[K comparable, V any](wait time.Duration, max int, fetch func(keys []K) (map[K]V, error)) *Loader[K, V] {
+	return &Loader[K, V]{fetch: fetch, wait: wait, max: max, loads: map[K]*load[V]{}}
+}
+
+// Load returns the value of key, and whether it has one
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, bool, error) {
+	ld := l.queue([]K{key})[0]
+	if err := ld.wait(ctx); err != nil {
+		var zero V
+		return zero, false, err
+	}
+	return ld.value, ld.ok, ld.err
+}
+
+// LoadMany returns the values of those of keys which have one
+func (l *Loader[K, V]) LoadMany(ctx context.Context, keys []K) (map[K]V, error) {
+	vs := make(map[K]V, len(keys))
+	for i, ld := range l.queue(keys) {
+		if err := ld.wait(ctx); err != nil {
+			return nil, err
+		}
+		if ld.err != nil {
+			return nil, ld.err
+		}
+		if ld.ok {
+			vs[keys[i]] = ld.value
+		}
+	}
+	return vs, nil
+}
+
+// queue returns the loads of keys, adding those not asked for yet to the
+// pending batch
+func (l *Loader[K, V]) queue(keys []K) []*load[V] {
+	lds := make([]*load[V], len(keys))
+	full := false
+
+	l.mu.Lock()
+	for i, k := range keys {
+		ld, ok := l.loads[k]
+		if !ok {
+			ld = &load[V]{done: make(chan struct{})}
+			l.loads[k] = ld
+			l.pending = append(l.pending, k)
+			if len(l.pending) == 1 {
+				l.timer = time.AfterFunc(l.wait, l.dispatch)
+			}
+			full = full || len(l.pending) >= l.max
+		}
+		lds[i] = ld
+	}
+	l.mu.Unlock()
+
+	if full {
+		l.dispatch()
+	}
+	return lds
+}
+
+// dispatch fetches the pending keys
+func (l *Loader[K, V]) dispatch() {
+	l.mu.Lock()
+	keys := l.pending
+	l.pending = nil
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	lds := make([]*load[V], len(keys))
+	for i, k := range keys {
+		lds[i] = l.loads[k]
+	}
+	l.mu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+
+	vs, err := l.fetch(keys)
+	for i, k := range keys {
+		if err != nil {
+			lds[i].err = err
+		} else {
+			lds[i].value, lds[i].ok = vs[k]
+		}
+		close(lds[i].done)
+	}
+}
+
+func (ld *load[V]) wait(ctx context.Context) error {
+	select {
+	case <-ld.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,100 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder fetches the squares of the positive keys and records its batches
+type recorder struct {
+	mu      sync.Mutex
+	batches [][]int
+	err     error
+}
+
+func (r *recorder) fetch(keys []int) (map[int]int, error) {
+	sorted := append([]int(nil), keys...)
+	sort.Ints(sorted)
+	r.mu.Lock()
+	r.batches = append(r.batches, sorted)
+	r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	m := map[int]int{}
+	for _, k := range keys {
+		if k > 0 {
+			m[k] = k * k
+		}
+	}
+	return m, nil
+}
+
+func TestLoaderBatchesConcurrentLoads(t *testing.T) {
+	r := &recorder{}
+	l := NewLoader(50*time.Millisecond, 100, r.fetch)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for _, k := range []int{3, 1, 2, 1, -1} {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			v, ok, err := l.Load(ctx, k)
+			if err != nil || ok != (k > 0) || v != k*k && ok {
+				t.Errorf("Load(%d) = %d, %v, %v", k, v, ok, err)
+			}
+		}(k)
+	}
+	wg.Wait()
+
+	// the values are kept for the rest of the query
+	vs, err := l.LoadMany(ctx, []int{1, 2, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int{1: 1, 2: 4, 4: 16}; !reflect.DeepEqual(vs, want) {
+		t.Errorf("got %v, want %v", vs, want)
+	}
+	if want := [][]int{{-1, 1, 2, 3}, {4}}; !reflect.DeepEqual(r.batches, want) {
+		t.Errorf("got batches %v, want %v", r.batches, want)
+	}
+}
+
+func TestLoaderFetchesFullBatches(t *testing.T) {
+	r := &recorder{}
+	// never dispatched by the timer within the test
+	l := NewLoader(time.Hour, 2, r.fetch)
+
+	vs, err := l.LoadMany(context.Background(), []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 2 || len(r.batches) != 1 {
+		t.Errorf("got %v in batches %v", vs, r.batches)
+	}
+}
+
+func TestLoaderErrors(t *testing.T) {
+	r := &recorder{err: errors.New("connection refused")}
+	l := NewLoader(time.Millisecond, 100, r.fetch)
+
+	if _, _, err := l.Load(context.Background(), 1); err != r.err {
+		t.Errorf("got error %v, want %v", err, r.err)
+	}
+	if _, err := l.LoadMany(context.Background(), []int{1}); err != r.err {
+		t.Errorf("got error %v, want %v", err, r.err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := NewLoader(time.Hour, 100, r.fetch)
+	if _, _, err := slow.Load(ctx, 1); err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/logging"
 	"github.com/sg83/go-microservice/article-api/utils"
 	"go.uber.org/zap"
 )
@@ -25,10 +28,15 @@ func NewArticles(l *zap.Logger, db data.ArticlesData, v *data.Validation) *Artic
 
 // Get retrieves an article by ID.
 //
-// swagger:operation GET /articles/{id} articles Get
+// swagger:operation GET /v1/articles/{id} articles Get
 //
 // ---
 // parameters:
+//   - name: If-Modified-Since
+//     in: header
+//     description: Only return the article if it changed since this date
+//     required: false
+//     type: string
 //   - name: id
 //     in: path
 //     description: ID of the article to retrieve
@@ -41,80 +49,109 @@ func NewArticles(l *zap.Logger, db data.ArticlesData, v *data.Validation) *Artic
 //	  description: Article retrieved successfully
 //	  schema:
 //	    "$ref": "#/definitions/Article"
+//	'304':
+//	  description: Article not modified since If-Modified-Since
+//	'400':
+//	  description: Invalid article id
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
 //	'404':
 //	  description: Article not found
 //	  schema:
-//	    "$ref": "#/definitions/GenericError"
+//	    "$ref": "#/definitions/Problem"
 //	'500':
 //	  description: Internal server error
 //	  schema:
-//	    "$ref": "#/definitions/GenericError"
+//	    "$ref": "#/definitions/Problem"
 func (a *Articles) Get(w http.ResponseWriter, r *http.Request) {
 
+	l := logging.FromContext(r.Context(), a.l)
+
 	vars := mux.Vars(r)
-	a.l.Info("Get article", zap.String("id", vars["id"]))
+	l.Info("Get article", zap.String("id", vars["id"]))
 
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
-		a.l.Fatal("Could not convert id to int")
+		l.Error("Could not convert id to int", zap.Error(err))
+		writeError(w, r, l, &data.InvalidError{Reason: "article id " + vars["id"] + " is out of range", Err: err})
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-
-	article, err := a.db.GetArticleByID(id)
+	article, err := a.db.GetArticleByID(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Article not found", http.StatusNotFound)
-		w.WriteHeader(http.StatusInternalServerError)
-		utils.ToJSON(&utils.GenericError{Message: err.Error()}, w)
+		writeError(w, r, l, err)
 		return
 	}
 
+	var modified time.Time
+	if article.UpdatedAt != nil {
+		modified = *article.UpdatedAt
+	}
+	if notModified(w, r, modified, articleMaxAge) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	err = utils.ToJSON(article, w)
 	if err != nil {
 		// we should never be here but log the error just incase
-		a.l.Error("Unable to serialize product", zap.String(" Error: ", err.Error()))
+		l.Error("Unable to serialize product", zap.String(" Error: ", err.Error()))
 		return
 	}
 }
 
 // Create adds a new article.
 //
-// swagger:operation POST /articles articles Create
+// swagger:operation POST /v1/articles articles Create
 //
 // ---
-// parameters:
-//   - name: article
-//     in: body
-//     description: Article to create
-//     required: true
-//     schema:
-//     "$ref": "#/definitions/Article"
 //
-// responses:
+//	parameters:
+//	  - name: article
+//	    in: body
+//	    description: Article to create
+//	    required: true
+//	    schema:
+//	      "$ref": "#/definitions/Article"
 //
-//	'200':
-//	  description: Article created successfully
-//	'400':
-//	  description: Invalid request payload
-//	  schema:
-//	    "$ref": "#/definitions/GenericError"
-//	'500':
-//	  description: Internal server error
-//	  schema:
-//	    "$ref": "#/definitions/GenericError"
+//	responses:
+//	  '200':
+//	    description: Article created successfully
+//	  '400':
+//	    description: Invalid request payload
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
+//	  '409':
+//	    description: Article conflicts with an existing one
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
+//	  '422':
+//	    description: Article failed validation
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
+//	  '500':
+//	    description: Internal server error
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
 func (a *Articles) Create(w http.ResponseWriter, r *http.Request) {
 
-	a.l.Info("Create article", zap.Any("article:", r.Context().Value(KeyArticle{})))
+	l := logging.FromContext(r.Context(), a.l)
+
+	l.Info("Create article", zap.Any("article:", r.Context().Value(KeyArticle{})))
 
 	// fetch the article from the context
 	article, ok := r.Context().Value(KeyArticle{}).(*data.Article)
 	if !ok {
 		// handle the case where the value is not of the expected type
-		a.l.Error("Error fetching object from context")
+		l.Error("Error fetching object from context")
+		writeError(w, r, l, errors.New("article missing from request context"))
 		return
 	}
 
-	a.l.Info("Inserting ", zap.Any("article: ", article))
-	a.db.AddArticle(*article)
+	l.Info("Inserting ", zap.Any("article: ", article))
+	err := a.db.AddArticle(r.Context(), *article)
+	if err != nil {
+		writeError(w, r, l, err)
+		return
+	}
 }
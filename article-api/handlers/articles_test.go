@@ -2,23 +2,24 @@ package handlers
 
 import (
 	"encoding/json"
-	"errors"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sg83/go-microservice/article-api/data"
 	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/stretchr/testify/mock"
 	"go.uber.org/zap"
 )
 
 func TestGetArticle(t *testing.T) {
 
-	notFoundErr := errors.New("Article not found")
+	notFoundErr := &data.NotFoundError{Resource: "article", Key: "3"}
 
 	tt := []struct {
 		id      int
@@ -76,7 +77,7 @@ func TestGetArticle(t *testing.T) {
 
 		// create a mock Articles struct with a mock database interface
 		mockdb := new(mocks.ArticlesData)
-		mockdb.On("GetArticleByID", tc.id).Return(tc.article, tc.err)
+		mockdb.On("GetArticleByID", mock.Anything, tc.id).Return(tc.article, tc.err)
 		articles := &Articles{logger, mockdb, nil}
 
 		//Hack to try to fake gorilla/mux vars
@@ -108,3 +109,49 @@ func TestGetArticle(t *testing.T) {
 		t.Logf("Test completed for article id %d", tc.id)
 	}
 }
+
+func TestGetArticleNotModified(t *testing.T) {
+	// sub-second precision is dropped from Last-Modified
+	updated := time.Date(2023, 2, 20, 8, 0, 0, 500, time.UTC)
+	article := &data.Article{ID: 1, Title: "Article1", Body: "Body", Date: "2023-02-20", UpdatedAt: &updated}
+
+	tt := []struct {
+		name            string
+		ifModifiedSince string
+		status          int
+	}{
+		{"no condition", "", http.StatusOK},
+		{"unchanged", "Mon, 20 Feb 2023 08:00:00 GMT", http.StatusNotModified},
+		{"newer copy", "Mon, 20 Feb 2023 09:00:00 GMT", http.StatusNotModified},
+		{"stale copy", "Mon, 20 Feb 2023 07:59:59 GMT", http.StatusOK},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			mockdb := mocks.NewArticlesData(t)
+			mockdb.On("GetArticleByID", mock.Anything, 1).Return(article, nil)
+			articles := &Articles{zap.NewNop(), mockdb, nil}
+
+			req := httptest.NewRequest(http.MethodGet, "/articles/1", nil)
+			if tc.ifModifiedSince != "" {
+				req.Header.Set("If-Modified-Since", tc.ifModifiedSince)
+			}
+			req = mux.SetURLVars(req, map[string]string{"id": "1"})
+			w := httptest.NewRecorder()
+			articles.Get(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("Expected status code %d but got %d", tc.status, w.Code)
+			}
+			if got := w.Header().Get("Last-Modified"); got != "Mon, 20 Feb 2023 08:00:00 GMT" {
+				t.Errorf("Unexpected Last-Modified %q", got)
+			}
+			if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Unexpected Vary %q", got)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "public, max-age=300" {
+				t.Errorf("Unexpected Cache-Control %q", got)
+			}
+		})
+	}
+}
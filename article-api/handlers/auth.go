@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"net/http"
+)
+
+// ScopeAdmin grants exports and imports
+const ScopeAdmin = "admin"
+
+// APIKeys holds the scopes granted by every API key
+type APIKeys struct {
+	// by digest of the key, so that lookups do not leak keys through timing
+	scopes map[[sha256.Size]byte][]string
+}
+
+// NewAPIKeys creates the set of API keys from the scopes of every key
+func NewAPIKeys(keys map[string][]string) *APIKeys {
+	k := &APIKeys{scopes: map[[sha256.Size]byte][]string{}}
+	for key, scopes := range keys {
+		k.scopes[sha256.Sum256([]byte(key))] = scopes
+	}
+	return k
+}
+
+// Known reports whether key is one of the keys, a nil set knowing none
+func (k *APIKeys) Known(key string) bool {
+	if k == nil {
+		return false
+	}
+	_, known := k.scopes[sha256.Sum256([]byte(key))]
+	return known
+}
+
+// Grants reports whether key is known and whether it grants scope
+func (k *APIKeys) Grants(key string, scope string) (known bool, granted bool) {
+	scopes, known := k.scopes[sha256.Sum256([]byte(key))]
+	for _, s := range scopes {
+		if s == scope {
+			return true, true
+		}
+	}
+	return known, false
+}
+
+// MiddlewareRequireScope only lets through requests whose X-API-Key header
+// holds a key granting scope. Requests without a known key are refused with
+// 401 Unauthorized, those whose key lacks the scope with 403 Forbidden.
+func MiddlewareRequireScope(keys *APIKeys, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(APIKeyHeader)
+			known, granted := keys.Grants(key, scope)
+			switch {
+			case key == "" || !known:
+				rw.Header().Set("WWW-Authenticate", `APIKey header="`+APIKeyHeader+`"`)
+				writeProblem(rw, r, http.StatusUnauthorized, problemUnauthorized,
+					"A valid API key is required in the "+APIKeyHeader+" header.")
+			case !granted:
+				writeProblem(rw, r, http.StatusForbidden, problemForbidden,
+					"The API key does not grant the "+scope+" scope.")
+			default:
+				next.ServeHTTP(rw, r)
+			}
+		})
+	}
+}
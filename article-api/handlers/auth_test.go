@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddlewareRequireScope(t *testing.T) {
+	keys := NewAPIKeys(map[string][]string{"admin-key": {ScopeAdmin}, "other-key": {"reports"}})
+	h := MiddlewareRequireScope(keys, ScopeAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tt := []struct {
+		name   string
+		key    string
+		status int
+	}{
+		{"admin key", "admin-key", http.StatusOK},
+		{"missing key", "", http.StatusUnauthorized},
+		{"unknown key", "guess", http.StatusUnauthorized},
+		{"key without the scope", "other-key", http.StatusForbidden},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodGet, "/export", nil)
+		if tc.key != "" {
+			req.Header.Set(APIKeyHeader, tc.key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status code %d but got %d", tc.name, tc.status, w.Code)
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); (tc.status == http.StatusUnauthorized) != (challenge != "") {
+			t.Errorf("%s: unexpected WWW-Authenticate %q", tc.name, challenge)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"go.uber.org/zap"
+)
+
+// NDJSONContentType is the media type of newline delimited JSON bodies
+const NDJSONContentType = "application/x-ndjson"
+
+// bulkBatchSize is the number of articles inserted per transaction in the
+// best effort mode
+const bulkBatchSize = 500
+
+// Modes of a bulk import
+const (
+	// every article is added, or none is
+	bulkAtomic = "atomic"
+	// the valid articles are added, batch by batch
+	bulkBestEffort = "best-effort"
+)
+
+// Statuses of the items of a bulk import
+const (
+	itemCreated = "created"
+	itemInvalid = "invalid"
+	itemFailed  = "failed"
+	itemSkipped = "skipped"
+)
+
+// CreateBulk adds many articles at once.
+//
+// swagger:operation POST /v1/articles:bulk articles CreateBulk
+//
+// ---
+//
+//	consumes:
+//	  - application/json
+//	  - application/x-ndjson
+//
+//	parameters:
+//	  - name: mode
+//	    in: query
+//	    description: atomic (default) adds every article or none, best-effort adds the valid ones
+//	    required: false
+//	    type: string
+//	  - name: articles
+//	    in: body
+//	    description: A JSON array of articles, or one article per line with application/x-ndjson
+//	    required: true
+//	    schema:
+//	      type: array
+//	      items:
+//	        "$ref": "#/definitions/Article"
+//
+//	responses:
+//	  '200':
+//	    description: Outcome of every article, and in the best-effort mode of the articles read before the body turned out to be malformed or too large
+//	    schema:
+//	      "$ref": "#/definitions/BulkReport"
+//	  '400':
+//	    description: Malformed request body or invalid mode
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
+//	  '422':
+//	    description: Some articles are invalid and none was added, in atomic mode
+//	    schema:
+//	      "$ref": "#/definitions/BulkReport"
+//	  '500':
+//	    description: Internal server error
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
+func (a *Articles) CreateBulk(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context(), a.l)
+
+	mode := r.URL.Query().Get("mode")
+	switch mode {
+	case "":
+		mode = bulkAtomic
+	case bulkAtomic, bulkBestEffort:
+	default:
+		writeError(w, r, l, &data.InvalidError{Reason: "mode " + mode + " is not one of atomic or best-effort"})
+		return
+	}
+
+	vr := utils.NewArrayReader(r.Body)
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == NDJSONContentType {
+		vr = utils.NewLinesReader(r.Body)
+	}
+	langs := AcceptedLanguages(r.Header.Get("Accept-Language"))
+
+	report := &data.BulkReport{Mode: mode, Items: []data.BulkItem{}}
+	var batch []data.Article
+	// whether batches were added while reading, in the best-effort mode
+	added := false
+	for {
+		raw, err := vr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			l.Error("Reading articles", zap.Error(err))
+			if !added {
+				writeError(w, r, l, err)
+				return
+			}
+			// the articles already added are reported rather than answering
+			// with an error, after which the client would add them again
+			report.Error = "the request could not be read past article " + strconv.Itoa(len(report.Items)) + ": " + err.Error()
+			break
+		}
+
+		item := data.BulkItem{Index: len(report.Items), Status: itemSkipped}
+		article := data.Article{}
+		if err := utils.FromJSON(&article, bytes.NewReader(raw)); err != nil {
+			item.Status, item.Error = itemInvalid, err.Error()
+		} else if errs := a.v.Validate(&article, langs...); len(errs) != 0 {
+			item.Status, item.Error, item.Errors = itemInvalid, "the article has invalid fields", fieldErrors(errs)
+		} else {
+			batch = append(batch, article)
+		}
+		report.Items = append(report.Items, item)
+
+		if mode == bulkBestEffort && len(batch) == bulkBatchSize {
+			a.addBatch(r, report, batch)
+			batch = batch[:0]
+			added = true
+		}
+	}
+
+	if len(report.Items) == 0 {
+		writeError(w, r, l, &data.InvalidError{Reason: "the request holds no articles"})
+		return
+	}
+
+	status := http.StatusOK
+	switch {
+	case mode == bulkBestEffort:
+		a.addBatch(r, report, batch)
+	case len(batch) < len(report.Items):
+		// some articles are invalid, the others are skipped
+		status = http.StatusUnprocessableEntity
+	default:
+		ids, err := a.db.AddArticles(r.Context(), batch)
+		if err != nil {
+			writeError(w, r, l, err)
+			return
+		}
+		for i := range report.Items {
+			report.Items[i].Status, report.Items[i].ID = itemCreated, ids[i]
+		}
+	}
+
+	for _, item := range report.Items {
+		switch item.Status {
+		case itemCreated:
+			report.Created++
+		case itemInvalid, itemFailed:
+			report.Failed++
+		}
+	}
+	l.Info("Bulk import", zap.String("mode", mode), zap.Int("created", report.Created), zap.Int("failed", report.Failed))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := utils.ToJSON(report, w); err != nil {
+		l.Error("Unable to serialize bulk report", zap.Error(err))
+	}
+}
+
+// addBatch adds the batch of valid articles, the last ones of the report
+// still skipped, in a transaction. If the batch is refused because of one
+// of its articles they are added one by one so that only the culprits
+// fail.
+func (a *Articles) addBatch(r *http.Request, report *data.BulkReport, batch []data.Article) {
+	if len(batch) == 0 {
+		return
+	}
+	var pending []int
+	for i := range report.Items {
+		if report.Items[i].Status == itemSkipped {
+			pending = append(pending, i)
+		}
+	}
+
+	ids, err := a.db.AddArticles(r.Context(), batch)
+	if err == nil {
+		for i, id := range ids {
+			report.Items[pending[i]].Status, report.Items[pending[i]].ID = itemCreated, id
+		}
+		return
+	}
+
+	l := logging.FromContext(r.Context(), a.l)
+	if !isDataError(err) || len(batch) == 1 {
+		l.Error("Adding articles failed", zap.Error(err))
+		for _, i := range pending {
+			report.Items[i].Status, report.Items[i].Error = itemFailed, failureReason(err)
+		}
+		return
+	}
+	l.Warn("Batch refused, adding articles one by one", zap.Error(err))
+	for i, article := range batch {
+		ids, err := a.db.AddArticles(r.Context(), []data.Article{article})
+		if err != nil {
+			report.Items[pending[i]].Status, report.Items[pending[i]].Error = itemFailed, failureReason(err)
+			continue
+		}
+		report.Items[pending[i]].Status, report.Items[pending[i]].ID = itemCreated, ids[0]
+	}
+}
+
+// isDataError reports whether err is caused by the data written rather than
+// by the database
+func isDataError(err error) bool {
+	var (
+		conflict *data.ConflictError
+		invalid  *data.InvalidError
+	)
+	return errors.As(err, &conflict) || errors.As(err, &invalid)
+}
+
+// failureReason explains to the client why an article was not added,
+// without leaking internal errors
+func failureReason(err error) string {
+	if isDataError(err) {
+		return err.Error()
+	}
+	return "the article could not be stored"
+}
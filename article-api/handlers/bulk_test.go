@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func TestCreateBulk(t *testing.T) {
+	first := data.Article{Title: "First", Body: "Body", Date: "2016-09-22", Tags: []string{"health"}}
+	second := data.Article{Title: "Second", Body: "Body", Date: "2016-09-23"}
+	const (
+		firstJSON   = `{"title": "First", "body": "Body", "date": "2016-09-22", "tags": ["health"]}`
+		secondJSON  = `{"title": "Second", "body": "Body", "date": "2016-09-23"}`
+		invalidJSON = `{"title": "Invalid", "body": "Body"}`
+	)
+	conflict := &data.ConflictError{Resource: "article", Reason: "duplicate title"}
+
+	tt := []struct {
+		name        string
+		mode        string
+		contentType string
+		body        string
+		setup       func(db *mocks.ArticlesData)
+		status      int
+		problem     string
+		statuses    []string
+		ids         []int
+	}{
+		{
+			name: "atomic",
+			body: "[" + firstJSON + ", " + secondJSON + "]",
+			setup: func(db *mocks.ArticlesData) {
+				db.On("AddArticles", mock.Anything, []data.Article{first, second}).Return([]int{7, 8}, nil).Once()
+			},
+			status:   http.StatusOK,
+			statuses: []string{itemCreated, itemCreated},
+			ids:      []int{7, 8},
+		},
+		{
+			name:     "atomic with an invalid article",
+			body:     "[" + firstJSON + ", " + invalidJSON + "]",
+			status:   http.StatusUnprocessableEntity,
+			statuses: []string{itemSkipped, itemInvalid},
+		},
+		{
+			name: "atomic refused by the database",
+			body: "[" + firstJSON + "]",
+			setup: func(db *mocks.ArticlesData) {
+				db.On("AddArticles", mock.Anything, []data.Article{first}).Return(nil, conflict).Once()
+			},
+			status:  http.StatusConflict,
+			problem: problemConflict,
+		},
+		{
+			name:        "best effort ndjson",
+			mode:        bulkBestEffort,
+			contentType: "application/x-ndjson",
+			body:        firstJSON + "\n" + invalidJSON + "\n{\"title\" 1}\n\n" + secondJSON + "\n",
+			setup: func(db *mocks.ArticlesData) {
+				db.On("AddArticles", mock.Anything, []data.Article{first, second}).Return([]int{7, 8}, nil).Once()
+			},
+			status:   http.StatusOK,
+			statuses: []string{itemCreated, itemInvalid, itemInvalid, itemCreated},
+			ids:      []int{7, 0, 0, 8},
+		},
+		{
+			name: "best effort batch refused",
+			mode: bulkBestEffort,
+			body: "[" + firstJSON + ", " + secondJSON + "]",
+			setup: func(db *mocks.ArticlesData) {
+				db.On("AddArticles", mock.Anything, []data.Article{first, second}).Return(nil, conflict).Once()
+				db.On("AddArticles", mock.Anything, []data.Article{first}).Return(nil, conflict).Once()
+				db.On("AddArticles", mock.Anything, []data.Article{second}).Return([]int{9}, nil).Once()
+			},
+			status:   http.StatusOK,
+			statuses: []string{itemFailed, itemCreated},
+			ids:      []int{0, 9},
+		},
+		{
+			name: "best effort database down",
+			mode: bulkBestEffort,
+			body: "[" + firstJSON + ", " + secondJSON + "]",
+			setup: func(db *mocks.ArticlesData) {
+				db.On("AddArticles", mock.Anything, []data.Article{first, second}).Return(nil, errors.New("connection refused")).Once()
+			},
+			status:   http.StatusOK,
+			statuses: []string{itemFailed, itemFailed},
+		},
+		{name: "malformed array", body: "[" + firstJSON + ", {", status: http.StatusBadRequest, problem: problemMalformed},
+		{name: "not an array", body: firstJSON, status: http.StatusBadRequest, problem: problemMalformed},
+		{name: "empty", body: "[]", status: http.StatusBadRequest, problem: problemInvalid},
+		{name: "unknown mode", mode: "some", body: "[" + firstJSON + "]", status: http.StatusBadRequest, problem: problemInvalid},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := new(mocks.ArticlesData)
+			if tc.setup != nil {
+				tc.setup(db)
+			}
+			a := NewArticles(zap.NewNop(), db, data.NewValidation())
+
+			target := "/articles:bulk"
+			if tc.mode != "" {
+				target += "?mode=" + tc.mode
+			}
+			req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			w := httptest.NewRecorder()
+			a.CreateBulk(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("Expected status code %d but got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			db.AssertExpectations(t)
+
+			if tc.problem != "" {
+				p := &utils.Problem{}
+				if err := json.NewDecoder(w.Body).Decode(p); err != nil || p.Type != tc.problem {
+					t.Errorf("Expected problem %s, got %q", tc.problem, w.Body.String())
+				}
+				return
+			}
+
+			report := &data.BulkReport{}
+			if err := json.NewDecoder(w.Body).Decode(report); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Items) != len(tc.statuses) {
+				t.Fatalf("Expected %d items but got %+v", len(tc.statuses), report.Items)
+			}
+			created, failed := 0, 0
+			for i, item := range report.Items {
+				if item.Index != i || item.Status != tc.statuses[i] {
+					t.Errorf("Expected item %d to be %s but got %+v", i, tc.statuses[i], item)
+				}
+				if tc.ids != nil && item.ID != tc.ids[i] {
+					t.Errorf("Expected item %d to have id %d but got %d", i, tc.ids[i], item.ID)
+				}
+				if item.Status != itemCreated && item.Status != itemSkipped && item.Error == "" {
+					t.Errorf("Expected item %d to explain its failure", i)
+				}
+				switch item.Status {
+				case itemCreated:
+					created++
+				case itemInvalid, itemFailed:
+					failed++
+				}
+			}
+			if report.Created != created || report.Failed != failed {
+				t.Errorf("Expected %d created and %d failed but got %+v", created, failed, report)
+			}
+		})
+	}
+}
+
+func TestCreateBulkBestEffortReadError(t *testing.T) {
+	const articleJSON = `{"title": "Title", "body": "Body", "date": "2016-09-22"}`
+	valid := make([]string, bulkBatchSize+1)
+	for i := range valid {
+		valid[i] = articleJSON
+	}
+
+	tt := []struct {
+		name        string
+		contentType string
+		body        string
+		limit       int64
+		err         string
+	}{
+		{
+			name:        "malformed frame",
+			contentType: "application/json",
+			body:        "[" + strings.Join(valid, ", ") + ", {",
+			err:         "the request could not be read past article 501",
+		},
+		{
+			name:        "body too large",
+			contentType: NDJSONContentType,
+			body:        strings.Join(valid, "\n") + "\n" + articleJSON + "\n",
+			// cuts the article after the valid ones
+			limit: int64((len(articleJSON)+1)*len(valid) + 10),
+			err:   "request body too large",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := new(mocks.ArticlesData)
+			ids := make([]int, bulkBatchSize)
+			for i := range ids {
+				ids[i] = i + 1
+			}
+			db.On("AddArticles", mock.Anything, mock.MatchedBy(func(as []data.Article) bool { return len(as) == bulkBatchSize })).Return(ids, nil).Once()
+			db.On("AddArticles", mock.Anything, mock.MatchedBy(func(as []data.Article) bool { return len(as) == 1 })).Return([]int{bulkBatchSize + 1}, nil).Once()
+			a := NewArticles(zap.NewNop(), db, data.NewValidation())
+
+			req := httptest.NewRequest(http.MethodPost, "/articles:bulk?mode="+bulkBestEffort, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			// streamed, so that the limit is only hit while reading
+			req.ContentLength = -1
+			w := httptest.NewRecorder()
+			var h http.Handler = http.HandlerFunc(a.CreateBulk)
+			if tc.limit != 0 {
+				h = MiddlewareMaxBodySize(tc.limit)(h)
+			}
+			h.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			db.AssertExpectations(t)
+			report := &data.BulkReport{}
+			if err := json.NewDecoder(w.Body).Decode(report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Created != bulkBatchSize+1 || len(report.Items) != bulkBatchSize+1 {
+				t.Errorf("Expected %d articles created, got %d of %d", bulkBatchSize+1, report.Created, len(report.Items))
+			}
+			if !strings.Contains(report.Error, tc.err) {
+				t.Errorf("Expected error %q, got %q", tc.err, report.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tt := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0", ""},
+		{"*", "br"},
+		{"*;q=0.1, gzip;q=0.5", "gzip"},
+		{"GZIP;Q=1", "gzip"},
+	}
+
+	for _, tc := range tt {
+		if got := negotiateEncoding(tc.header); got != tc.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestMiddlewareCompress(t *testing.T) {
+	large := strings.Repeat(`{"title":"Article"}`, 100)
+
+	tt := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		encoding       string
+		vary           bool
+	}{
+		{"gzip", "gzip", "application/json", large, "gzip", true},
+		{"brotli preferred", "gzip, br", "application/json", large, "br", true},
+		{"not accepted", "", "application/json", large, "", true},
+		{"below min size", "gzip", "application/json", `{"id":1}`, "", true},
+		{"not compressible", "gzip", "image/png", large, "", false},
+		{"event stream", "gzip", "text/event-stream", large, "", false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			h := MiddlewareCompress(256)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tc.contentType)
+				w.WriteHeader(http.StatusCreated)
+				// written in pieces to cross the threshold mid response
+				for i := 0; i < len(tc.body); i += 100 {
+					end := i + 100
+					if end > len(tc.body) {
+						end = len(tc.body)
+					}
+					io.WriteString(w, tc.body[i:end])
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/articles/1", nil)
+			if tc.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != http.StatusCreated {
+				t.Errorf("Expected status code %d but got %d", http.StatusCreated, w.Code)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tc.encoding {
+				t.Fatalf("Expected Content-Encoding %q but got %q", tc.encoding, got)
+			}
+			if got := w.Header().Get("Vary") == "Accept-Encoding"; got != tc.vary {
+				t.Errorf("Expected Vary: Accept-Encoding to be %v, headers %v", tc.vary, w.Header())
+			}
+
+			var r io.Reader = w.Body
+			switch tc.encoding {
+			case "gzip":
+				gr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = gr
+			case "br":
+				r = brotli.NewReader(w.Body)
+			}
+			body, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tc.body {
+				t.Errorf("Expected body of %d bytes but got %d", len(tc.body), len(body))
+			}
+		})
+	}
+}
+
+func TestMiddlewareCompressNotModified(t *testing.T) {
+	h := MiddlewareCompress(0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/articles/1", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotModified, w.Code)
+	}
+	if w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 0 {
+		t.Errorf("Expected an empty uncompressed response, got %v %q", w.Header(), w.Body.String())
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long clients and shared caches may reuse a response without
+// revalidating it
+const (
+	articleMaxAge = 5 * time.Minute
+	tagMaxAge     = time.Minute
+)
+
+// notModified sets the caching headers of a response whose content last
+// changed at modified, which may be zero if unknown. It reports whether the
+// request's If-Modified-Since makes the body unnecessary, in which case 304
+// Not Modified has been written. As RFC 9110 requires, If-Modified-Since is
+// ignored when the request has an If-None-Match, which noneMatch answers.
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time, maxAge time.Duration) bool {
+	h := w.Header()
+	h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge/time.Second)))
+	addVary(h, "Accept-Encoding")
+	if modified.IsZero() {
+		return false
+	}
+
+	// HTTP dates have a resolution of one second
+	modified = modified.UTC().Truncate(time.Second)
+	h.Set("Last-Modified", modified.Format(http.TimeFormat))
+
+	if r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.After(ims) {
+		return false
+	}
+	h.Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// noneMatch sets the caching headers of a response whose content is
+// identified by the parts of etag, for responses whose last modification
+// does not tell every change apart. It reports whether the request's
+// If-None-Match makes the body unnecessary, in which case 304 Not Modified
+// has been written. The tag is weak since compression changes the bytes.
+func noneMatch(w http.ResponseWriter, r *http.Request, maxAge time.Duration, etag ...string) bool {
+	h := w.Header()
+	h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge/time.Second)))
+	addVary(h, "Accept-Encoding")
+
+	sum := sha256.Sum256([]byte(strings.Join(etag, "\x00")))
+	tag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	h.Set("ETag", tag)
+
+	for _, t := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		// weak comparison, ignoring the W/ prefix
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == strings.TrimPrefix(tag, "W/") {
+			h.Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"go.uber.org/zap"
+)
+
+// Problem types returned by the API, relative to its base URL. Problems
+// that only restate the status code use about:blank.
+const (
+	problemBlank        = "about:blank"
+	problemUnauthorized = "/problems/unauthorized"
+	problemForbidden    = "/problems/forbidden"
+	problemNotFound     = "/problems/not-found"
+	problemConflict     = "/problems/conflict"
+	problemInvalid      = "/problems/invalid-request"
+	problemValidation   = "/problems/validation-failed"
+	problemMalformed    = "/problems/malformed-body"
+	problemTooLarge     = "/problems/body-too-large"
+	problemMediaType    = "/problems/unsupported-media-type"
+	problemRateLimited  = "/problems/rate-limited"
+	problemKeyReused    = "/problems/idempotency-key-reused"
+	problemKeyPending   = "/problems/idempotency-key-in-use"
+)
+
+// newProblem returns a problem about the request r, titled after its type
+// or else its status
+func newProblem(r *http.Request, status int, typ string, detail string) *utils.Problem {
+	title := http.StatusText(status)
+	switch typ {
+	case problemUnauthorized:
+		title = "Authentication required"
+	case problemForbidden:
+		title = "Insufficient scope"
+	case problemNotFound:
+		title = "Resource not found"
+	case problemConflict:
+		title = "Conflict with existing data"
+	case problemInvalid:
+		title = "Invalid request"
+	case problemValidation:
+		title = "Validation failed"
+	case problemMalformed:
+		title = "Malformed request body"
+	case problemTooLarge:
+		title = "Request body too large"
+	case problemMediaType:
+		title = "Unsupported media type"
+	case problemRateLimited:
+		title = "Rate limit exceeded"
+	case problemKeyReused:
+		title = "Idempotency key reused"
+	case problemKeyPending:
+		title = "Idempotency key in use"
+	}
+	return &utils.Problem{
+		Type:      typ,
+		Title:     title,
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.RequestURI(),
+		RequestID: logging.RequestID(r.Context()),
+	}
+}
+
+// writeProblem sends a problem about the request r
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, typ string, detail string) {
+	utils.WriteProblem(w, newProblem(r, status, typ, detail))
+}
+
+// writeError sends the problem matching err, mapping the domain errors of
+// the data package to their status. Other errors are logged and reported as
+// internal errors without details.
+func writeError(w http.ResponseWriter, r *http.Request, l *zap.Logger, err error) {
+	var (
+		notFound   *data.NotFoundError
+		conflict   *data.ConflictError
+		invalid    *data.InvalidError
+		validation data.ValidationErrors
+		malformed  *utils.JSONError
+		tooLarge   *http.MaxBytesError
+	)
+
+	var p *utils.Problem
+	switch {
+	case errors.As(err, &notFound):
+		p = newProblem(r, http.StatusNotFound, problemNotFound, err.Error())
+	case errors.As(err, &conflict):
+		p = newProblem(r, http.StatusConflict, problemConflict, err.Error())
+	case errors.As(err, &invalid):
+		p = newProblem(r, http.StatusBadRequest, problemInvalid, err.Error())
+	case errors.As(err, &validation):
+		p = newProblem(r, http.StatusUnprocessableEntity, problemValidation, "The request body has invalid fields.")
+		p.Errors = fieldErrors(validation)
+	case errors.As(err, &malformed):
+		p = newProblem(r, http.StatusBadRequest, problemMalformed, err.Error())
+	case errors.As(err, &tooLarge):
+		p = newProblem(r, http.StatusRequestEntityTooLarge, problemTooLarge, bodyTooLarge(tooLarge.Limit))
+	default:
+		logging.FromContext(r.Context(), l).Error("Request failed", zap.Error(err))
+		p = newProblem(r, http.StatusInternalServerError, problemBlank, "")
+	}
+	utils.WriteProblem(w, p)
+}
+
+// fieldErrors describes the fields that failed validation to the client
+func fieldErrors(errs data.ValidationErrors) []utils.FieldError {
+	var fes []utils.FieldError
+	for _, fe := range errs {
+		fes = append(fes, utils.FieldError{
+			Field:   fe.Path(),
+			Rule:    fe.Rule(),
+			Param:   fe.Param(),
+			Message: fe.Message(),
+		})
+	}
+	return fes
+}
+
+func bodyTooLarge(limit int64) string {
+	return "The request body must not exceed " + strconv.FormatInt(limit, 10) + " bytes."
+}
+
+// NotFound answers requests for unknown routes
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, problemBlank, "No route matches "+r.URL.Path)
+}
+
+// MethodNotAllowed answers requests using a method a route does not support
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, problemBlank, r.Method+" is not supported by "+r.URL.Path)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"go.uber.org/zap"
+)
+
+func TestWriteError(t *testing.T) {
+	v := data.NewValidation()
+	validationErrs := v.Validate(&data.Article{Body: "body", Date: "2016-09-22"})
+
+	tt := []struct {
+		name     string
+		err      error
+		status   int
+		typ      string
+		detail   string
+		nbFields int
+	}{
+		{
+			name:   "not found",
+			err:    &data.NotFoundError{Resource: "article", Key: "7"},
+			status: http.StatusNotFound,
+			typ:    problemNotFound,
+			detail: `article "7" not found`,
+		},
+		{
+			name:   "wrapped not found",
+			err:    fmt.Errorf("loading: %w", &data.NotFoundError{Resource: "article", Key: "7"}),
+			status: http.StatusNotFound,
+			typ:    problemNotFound,
+			detail: `loading: article "7" not found`,
+		},
+		{
+			name:   "conflict",
+			err:    &data.ConflictError{Resource: "article", Reason: "duplicate id"},
+			status: http.StatusConflict,
+			typ:    problemConflict,
+			detail: "article conflicts with existing data: duplicate id",
+		},
+		{
+			name:   "invalid",
+			err:    &data.InvalidError{Reason: "bad date"},
+			status: http.StatusBadRequest,
+			typ:    problemInvalid,
+			detail: "bad date",
+		},
+		{
+			name:   "malformed body",
+			err:    &utils.JSONError{Offset: 12, Message: "unexpected data after the JSON value"},
+			status: http.StatusBadRequest,
+			typ:    problemMalformed,
+			detail: "unexpected data after the JSON value at byte 12",
+		},
+		{
+			name:   "body too large",
+			err:    &http.MaxBytesError{Limit: 1024},
+			status: http.StatusRequestEntityTooLarge,
+			typ:    problemTooLarge,
+			detail: "The request body must not exceed 1024 bytes.",
+		},
+		{
+			name:     "validation",
+			err:      validationErrs,
+			status:   http.StatusUnprocessableEntity,
+			typ:      problemValidation,
+			detail:   "The request body has invalid fields.",
+			nbFields: 1,
+		},
+		{
+			name:   "internal errors are not leaked",
+			err:    errors.New("pq: password authentication failed"),
+			status: http.StatusInternalServerError,
+			typ:    problemBlank,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/articles/7?full=1", nil)
+			req = req.WithContext(logging.WithRequestID(req.Context(), "req-1"))
+			w := httptest.NewRecorder()
+			writeError(w, req, zap.NewNop(), tc.err)
+
+			if w.Code != tc.status {
+				t.Errorf("Expected status code %d but got %d", tc.status, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != utils.ProblemContentType {
+				t.Errorf("Expected content type %s but got %s", utils.ProblemContentType, ct)
+			}
+
+			p := &utils.Problem{}
+			if err := json.NewDecoder(w.Body).Decode(p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Type != tc.typ || p.Status != tc.status || p.Detail != tc.detail || p.Title == "" {
+				t.Errorf("Unexpected problem %+v", p)
+			}
+			if p.Instance != "/articles/7?full=1" || p.RequestID != "req-1" {
+				t.Errorf("Expected instance and request id to be set, got %+v", p)
+			}
+			if len(p.Errors) != tc.nbFields {
+				t.Errorf("Expected %d field errors but got %v", tc.nbFields, p.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/events"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"go.uber.org/zap"
+)
+
+// LastEventIDHeader is the header clients resuming an event stream send
+const LastEventIDHeader = "Last-Event-ID"
+
+// eventsRetry is how long clients wait before reconnecting to a stream
+// that ended, in milliseconds
+const eventsRetry = 2000
+
+// Events streams the article events to clients as server-sent events
+type Events struct {
+	l   *zap.Logger
+	bus *events.Bus
+	// time between comments keeping idle streams open
+	keepAlive time.Duration
+	// streams are ended after this long, when positive, for clients to
+	// reconnect before the server write timeout cuts them
+	maxDuration time.Duration
+}
+
+func NewEvents(l *zap.Logger, bus *events.Bus, keepAlive, maxDuration time.Duration) *Events {
+	return &Events{l, bus, keepAlive, maxDuration}
+}
+
+// Stream sends the article events as they are recorded.
+//
+// swagger:operation GET /v1/events events Stream
+//
+// ---
+// produces:
+//   - text/event-stream
+//
+// parameters:
+//   - name: Last-Event-ID
+//     in: header
+//     description: Id of the last event received, the events recorded since are sent first
+//     required: false
+//     type: integer
+//   - name: tag
+//     in: query
+//     description: Only send the events of articles with this tag, may be repeated
+//     required: false
+//     type: string
+//
+// responses:
+//
+//	'200':
+//	  description: A stream of events, each with its id, type and the Event as data
+//	'400':
+//	  description: Invalid Last-Event-ID
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'503':
+//	  description: The server is shutting down
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+func (e *Events) Stream(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context(), e.l)
+
+	after := int64(-1)
+	if v := r.Header.Get(LastEventIDHeader); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			writeError(w, r, l, &data.InvalidError{Reason: "Last-Event-ID " + strconv.Quote(v) + " is not an event id", Err: err})
+			return
+		}
+		after = id
+	}
+	tags := r.URL.Query()["tag"]
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, l, errors.New("response writer does not support flushing"))
+		return
+	}
+	sub, err := e.bus.Subscribe(r.Context(), tags)
+	if errors.Is(err, events.ErrClosed) {
+		writeProblem(w, r, http.StatusServiceUnavailable, problemBlank, "The server is shutting down.")
+		return
+	}
+	if err != nil {
+		// the client went away
+		return
+	}
+	defer e.bus.Unsubscribe(sub)
+	l.Info("Streaming events", zap.Int64("after", after), zap.Strings("tags", tags))
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	// keep proxies such as nginx from buffering the stream
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	sw := &sseWriter{w: w, last: after}
+	sw.write("retry: " + strconv.Itoa(eventsRetry) + "\n\n")
+	flusher.Flush()
+
+	// the events the client missed are sent first, then the live ones
+	if after >= 0 {
+		err := e.bus.Replay(r.Context(), after, sub.Start, tags, func(ev events.Event) error {
+			sw.event(ev)
+			return sw.err
+		})
+		if err != nil {
+			if r.Context().Err() == nil {
+				l.Error("Replaying events failed", zap.Error(err))
+			}
+			return
+		}
+		flusher.Flush()
+	}
+
+	keepAlive := time.NewTicker(e.keepAlive)
+	defer keepAlive.Stop()
+	var end <-chan time.Time
+	if e.maxDuration > 0 {
+		t := time.NewTimer(e.maxDuration)
+		defer t.Stop()
+		end = t.C
+	}
+	for sw.err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-end:
+			return
+		case ev, ok := <-sub.C:
+			if !ok {
+				// the client lagged behind or the server is shutting down,
+				// it resumes from the last event it got
+				return
+			}
+			sw.event(ev)
+		case <-keepAlive.C:
+			sw.write(": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// sseWriter writes server-sent events, remembering the first error
+type sseWriter struct {
+	w    http.ResponseWriter
+	last int64
+	err  error
+}
+
+func (sw *sseWriter) write(s string) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write([]byte(s))
+	}
+}
+
+// event writes ev unless it was already sent
+func (sw *sseWriter) event(ev events.Event) {
+	if ev.ID <= sw.last {
+		return
+	}
+	b, err := json.Marshal(ev.Event)
+	if err != nil {
+		sw.err = err
+		return
+	}
+	sw.write("id: " + strconv.FormatInt(ev.ID, 10) + "\nevent: " + ev.Type + "\ndata: " + string(b) + "\n\n")
+	sw.last = ev.ID
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/events"
+	"go.uber.org/zap"
+)
+
+// outbox is an in-memory events.Source
+type outbox struct {
+	mu     sync.Mutex
+	events []data.Event
+}
+
+func (o *outbox) add(tags ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	id := int64(len(o.events) + 1)
+	payload, _ := json.Marshal(map[string]interface{}{"id": id, "tags": tags})
+	o.events = append(o.events, data.Event{ID: id, Type: data.EventArticleUpdated, CreatedAt: time.Now(), Data: payload})
+}
+
+func (o *outbox) GetEventsSince(ctx context.Context, after int64, limit int) ([]data.Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var es []data.Event
+	for _, e := range o.events {
+		if e.ID > after && len(es) < limit {
+			es = append(es, e)
+		}
+	}
+	return es, nil
+}
+
+func (o *outbox) LastEventID(ctx context.Context) (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return int64(len(o.events)), nil
+}
+
+// readEventIDs reads the ids of the first n events of an event stream
+func readEventIDs(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+	var ids []string
+	for len(ids) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Reading the stream failed after %v: %v", ids, err)
+		}
+		if strings.HasPrefix(line, "id: ") {
+			ids = append(ids, strings.TrimSpace(line[len("id: "):]))
+		}
+	}
+	return ids
+}
+
+func TestStreamEvents(t *testing.T) {
+	tt := []struct {
+		name        string
+		lastEventID string
+		query       string
+		ids         []string
+	}{
+		{"live", "", "", []string{"4", "5", "6"}},
+		{"tag", "", "?tag=science", []string{"5"}},
+		{"tags", "", "?tag=science&tag=health", []string{"4", "5"}},
+		{"resume", "1", "", []string{"2", "3", "4", "5", "6"}},
+		{"resume with tag", "0", "?tag=health", []string{"1", "4"}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			o := &outbox{}
+			o.add("health")
+			o.add("science")
+			o.add()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			bus := events.NewBus(zap.NewNop(), o, time.Hour)
+			go bus.Run(ctx)
+			srv := httptest.NewServer(http.HandlerFunc(NewEvents(zap.NewNop(), bus, time.Hour, 0).Stream))
+			defer srv.Close()
+
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events"+tc.query, nil)
+			if tc.lastEventID != "" {
+				req.Header.Set(LastEventIDHeader, tc.lastEventID)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status code 200 but got %d", resp.StatusCode)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Expected content type text/event-stream but got %q", ct)
+			}
+
+			// the subscription is made once the stream is answered
+			o.add("health", "fitness")
+			o.add("science")
+			o.add()
+			bus.Notify()
+
+			ids := readEventIDs(t, bufio.NewReader(resp.Body), len(tc.ids))
+			if strings.Join(ids, ",") != strings.Join(tc.ids, ",") {
+				t.Errorf("Expected events %v but got %v", tc.ids, ids)
+			}
+		})
+	}
+}
+
+func TestStreamEventsErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := events.NewBus(zap.NewNop(), &outbox{}, time.Hour)
+	go bus.Run(ctx)
+	e := NewEvents(zap.NewNop(), bus, time.Hour, 0)
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set(LastEventIDHeader, "abc")
+	w := httptest.NewRecorder()
+	e.Stream(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 but got %d", w.Code)
+	}
+	checkProblem(t, w, problemInvalid)
+
+	bus.Close()
+	w = httptest.NewRecorder()
+	e.Stream(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503 but got %d", w.Code)
+	}
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/export"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"go.uber.org/zap"
+)
+
+// Export streams every article, or those matching the filters, in id order.
+//
+// swagger:operation GET /v1/export articles Export
+//
+// ---
+// produces:
+//   - application/x-ndjson
+//   - text/csv
+//   - application/gzip
+//
+// parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: API key granting the admin scope
+//     required: true
+//     type: string
+//   - name: format
+//     in: query
+//     description: ndjson (default), csv or tar.gz
+//     required: false
+//     type: string
+//   - name: from
+//     in: query
+//     description: Only export articles dated on or after this date, formatted as YYYY-MM-DD
+//     required: false
+//     type: string
+//   - name: to
+//     in: query
+//     description: Only export articles dated on or before this date, formatted as YYYY-MM-DD
+//     required: false
+//     type: string
+//   - name: tag
+//     in: query
+//     description: Only export articles with this tag
+//     required: false
+//     type: string
+//
+// responses:
+//
+//	'200':
+//	  description: The articles, in the requested format
+//	'400':
+//	  description: Invalid format or date
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'401':
+//	  description: Missing or unknown API key
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'403':
+//	  description: The API key does not grant the admin scope
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'500':
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+func (a *Articles) Export(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context(), a.l)
+
+	q := r.URL.Query()
+	format := export.NDJSON
+	if v := q.Get("format"); v != "" {
+		f, err := export.ParseFormat(v)
+		if err != nil {
+			writeError(w, r, l, &data.InvalidError{Reason: err.Error(), Err: err})
+			return
+		}
+		format = f
+	}
+	filter := data.ExportFilter{From: q.Get("from"), To: q.Get("to"), Tag: q.Get("tag")}
+	l.Info("Export articles", zap.String("format", string(format)), zap.Any("filter", filter))
+
+	// the response starts with the first article, so that errors found
+	// before it can still be reported as problems
+	var ew export.Writer
+	start := func() {
+		h := w.Header()
+		h.Set("Content-Type", format.ContentType())
+		h.Set("Content-Disposition", `attachment; filename="articles-`+time.Now().UTC().Format("20060102")+"."+string(format)+`"`)
+		h.Set("Cache-Control", "no-store")
+		ew = export.NewWriter(w, format)
+	}
+	err := a.db.ExportArticles(r.Context(), filter, func(ar data.Article) error {
+		if ew == nil {
+			start()
+		}
+		return ew.Write(ar)
+	})
+	if err != nil && ew == nil {
+		writeError(w, r, l, err)
+		return
+	}
+	if err == nil {
+		if ew == nil {
+			start()
+		}
+		err = ew.Close()
+	}
+	if err != nil {
+		// the export is incomplete, abort the response so that the client
+		// does not take it for a whole one
+		l.Error("Export failed", zap.Error(err))
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// Import adds the articles of an export, keeping their ids and replacing
+// the stored articles with the same ids. Every article is imported or none.
+//
+// swagger:operation POST /v1/import articles Import
+//
+// ---
+// consumes:
+//   - application/x-ndjson
+//   - text/csv
+//   - application/gzip
+//
+// parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: API key granting the admin scope
+//     required: true
+//     type: string
+//   - name: articles
+//     in: body
+//     description: An export, in the format given by the Content-Type header
+//     required: true
+//
+// responses:
+//
+//	'200':
+//	  description: Number of articles imported
+//	  schema:
+//	    "$ref": "#/definitions/ImportReport"
+//	'400':
+//	  description: The export is malformed
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'401':
+//	  description: Missing or unknown API key
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'403':
+//	  description: The API key does not grant the admin scope
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'413':
+//	  description: The export is too large
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'422':
+//	  description: Some articles are invalid and none was imported
+//	  schema:
+//	    "$ref": "#/definitions/ImportReport"
+//	'500':
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+func (a *Articles) Import(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context(), a.l)
+
+	format, ok := export.FormatOf(r.Header.Get("Content-Type"))
+	if !ok {
+		format = export.NDJSON
+	}
+	l.Info("Import articles", zap.String("format", string(format)))
+
+	body := &bodyReader{r: r.Body}
+	iv := &importValidator{
+		read:  export.NewReader(body, format).Read,
+		v:     a.v,
+		langs: AcceptedLanguages(r.Header.Get("Accept-Language")),
+	}
+	n, err := a.db.ImportArticles(r.Context(), iv.next)
+	if errors.Is(err, errImportInvalid) {
+		l.Info("Import refused", zap.Int("failed", iv.report.Failed))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		if err := utils.ToJSON(&iv.report, w); err != nil {
+			l.Error("Unable to serialize import report", zap.Error(err))
+		}
+		return
+	}
+	if err != nil {
+		// failing to read the body, e.g. because it is too large, explains
+		// the decoding error
+		if body.err != nil {
+			err = body.err
+		}
+		writeError(w, r, l, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := utils.ToJSON(&data.ImportReport{Imported: n}, w); err != nil {
+		l.Error("Unable to serialize import report", zap.Error(err))
+	}
+}
+
+// importMaxItems is the number of invalid articles reported by an import
+const importMaxItems = 100
+
+// errImportInvalid rolls an import back once its articles were all read
+// and some turned out to be invalid
+var errImportInvalid = errors.New("the import holds invalid articles")
+
+// importValidator validates the articles of an import as they are read.
+// After the first invalid article the valid ones are no longer passed on,
+// and the rest of the export is only read to report the other invalid
+// articles.
+type importValidator struct {
+	read   func() (data.Article, error)
+	v      *data.Validation
+	langs  []string
+	index  int
+	report data.ImportReport
+}
+
+func (iv *importValidator) next() (data.Article, error) {
+	for {
+		article, err := iv.read()
+		if err == io.EOF && iv.report.Failed > 0 {
+			return article, errImportInvalid
+		}
+		if err != nil {
+			return article, err
+		}
+
+		index := iv.index
+		iv.index++
+		errs := iv.v.Validate(&article, iv.langs...)
+		if len(errs) == 0 {
+			if iv.report.Failed == 0 {
+				return article, nil
+			}
+			continue
+		}
+		iv.report.Failed++
+		if len(iv.report.Items) < importMaxItems {
+			iv.report.Items = append(iv.report.Items, data.BulkItem{
+				Index:  index,
+				Status: itemInvalid,
+				ID:     article.ID,
+				Error:  "the article has invalid fields",
+				Errors: fieldErrors(errs),
+			})
+		}
+	}
+}
+
+// bodyReader remembers the first error reading a request body
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
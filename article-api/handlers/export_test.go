@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/export"
+	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+var exportArticles = []data.Article{
+	{ID: 3, Title: "Article3", Date: "2016-09-22", Body: "Body", Tags: []string{"health"}},
+	{ID: 5, Title: "Article5", Date: "2016-09-23", Body: "Body, with a comma"},
+}
+
+// exportAll stands in for the database, sending every article to fn
+func exportAll(articles []data.Article) func(context.Context, data.ExportFilter, func(data.Article) error) error {
+	return func(ctx context.Context, f data.ExportFilter, fn func(data.Article) error) error {
+		for _, a := range articles {
+			if err := fn(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestExport(t *testing.T) {
+	for _, f := range export.Formats {
+		t.Run(string(f), func(t *testing.T) {
+			filter := data.ExportFilter{From: "2016-09-01", Tag: "health"}
+			db := new(mocks.ArticlesData)
+			db.On("ExportArticles", mock.Anything, filter, mock.Anything).Return(exportAll(exportArticles))
+			a := NewArticles(zap.NewNop(), db, data.NewValidation())
+
+			req := httptest.NewRequest(http.MethodGet, "/export?format="+string(f)+"&from=2016-09-01&tag=health", nil)
+			w := httptest.NewRecorder()
+			a.Export(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status code 200 but got %d", w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != f.ContentType() {
+				t.Errorf("Expected content type %s but got %s", f.ContentType(), ct)
+			}
+			if cd := w.Header().Get("Content-Disposition"); !strings.HasSuffix(cd, "."+string(f)+`"`) {
+				t.Errorf("Unexpected content disposition %q", cd)
+			}
+
+			var got []data.Article
+			r := export.NewReader(w.Body, f)
+			for {
+				ar, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, ar)
+			}
+			if !reflect.DeepEqual(got, exportArticles) {
+				t.Errorf("Expected %+v but got %+v", exportArticles, got)
+			}
+		})
+	}
+}
+
+func TestExportErrors(t *testing.T) {
+	invalidDate := &data.InvalidError{Reason: `date "2016" is not formatted as YYYY-MM-DD`}
+
+	db := new(mocks.ArticlesData)
+	db.On("ExportArticles", mock.Anything, data.ExportFilter{From: "2016"}, mock.Anything).Return(invalidDate)
+	db.On("ExportArticles", mock.Anything, data.ExportFilter{Tag: "broken"}, mock.Anything).Return(
+		func(ctx context.Context, f data.ExportFilter, fn func(data.Article) error) error {
+			fn(exportArticles[0])
+			return errors.New("connection reset")
+		})
+	a := NewArticles(zap.NewNop(), db, data.NewValidation())
+
+	for _, target := range []string{"/export?format=xml", "/export?from=2016"} {
+		w := httptest.NewRecorder()
+		a.Export(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status code 400 but got %d", target, w.Code)
+		}
+	}
+
+	// failing once articles were sent aborts the response
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("Expected the response to be aborted, got %v", r)
+		}
+	}()
+	a.Export(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/export?tag=broken", nil))
+}
+
+// importAll stands in for the database, reading every article
+func importAll(got *[]data.Article) func(context.Context, func() (data.Article, error)) (int, error) {
+	return func(ctx context.Context, next func() (data.Article, error)) (int, error) {
+		for {
+			a, err := next()
+			if err == io.EOF {
+				return len(*got), nil
+			}
+			if err != nil {
+				return 0, err
+			}
+			*got = append(*got, a)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	for _, f := range export.Formats {
+		t.Run(string(f), func(t *testing.T) {
+			var body bytes.Buffer
+			ew := export.NewWriter(&body, f)
+			for _, ar := range exportArticles {
+				ew.Write(ar)
+			}
+			ew.Close()
+
+			var got []data.Article
+			db := new(mocks.ArticlesData)
+			db.On("ImportArticles", mock.Anything, mock.Anything).Return(importAll(&got))
+			a := NewArticles(zap.NewNop(), db, data.NewValidation())
+
+			req := httptest.NewRequest(http.MethodPost, "/import", &body)
+			req.Header.Set("Content-Type", f.ContentType())
+			w := httptest.NewRecorder()
+			a.Import(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status code 200 but got %d: %s", w.Code, w.Body.String())
+			}
+			report := &data.ImportReport{}
+			if err := json.NewDecoder(w.Body).Decode(report); err != nil || report.Imported != 2 {
+				t.Errorf("Expected 2 articles imported, got %q", w.Body.String())
+			}
+			if !reflect.DeepEqual(got, exportArticles) {
+				t.Errorf("Expected %+v but got %+v", exportArticles, got)
+			}
+		})
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	tt := []struct {
+		name    string
+		body    string
+		limit   int64
+		status  int
+		problem string
+	}{
+		{name: "malformed", body: `{"id": 1, "author": "me"}`, status: http.StatusBadRequest, problem: problemInvalid},
+		{name: "too large", body: `{"id": 1, "title": "` + strings.Repeat("a", 100) + `"}`, limit: 50, status: http.StatusRequestEntityTooLarge, problem: problemTooLarge},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []data.Article
+			db := new(mocks.ArticlesData)
+			db.On("ImportArticles", mock.Anything, mock.Anything).Return(importAll(&got))
+			a := NewArticles(zap.NewNop(), db, data.NewValidation())
+
+			req := httptest.NewRequest(http.MethodPost, "/import", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", NDJSONContentType)
+			w := httptest.NewRecorder()
+			if tc.limit > 0 {
+				req.Body = http.MaxBytesReader(w, req.Body, tc.limit)
+			}
+			a.Import(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("Expected status code %d but got %d", tc.status, w.Code)
+			}
+			p := &utils.Problem{}
+			if err := json.NewDecoder(w.Body).Decode(p); err != nil || p.Type != tc.problem {
+				t.Errorf("Expected problem %s, got %q", tc.problem, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	valid := `{"id": 1, "title": "Title", "date": "2016-09-22", "body": "Body"}`
+	tt := []struct {
+		name    string
+		body    string
+		failed  int
+		indexes []int
+	}{
+		{name: "first", body: `{"id": 1, "date": "2016-09-22", "body": "Body"}` + "\n" + valid, failed: 1, indexes: []int{0}},
+		{name: "after valid ones", body: valid + "\n" + valid + "\n" + `{"id": 3, "title": "Title", "date": "2016-13-22", "body": "Body"}`, failed: 1, indexes: []int{2}},
+		{name: "several", body: valid + "\n" + `{"id": 2, "title": "Title", "date": "2016-09-22"}` + "\n" + valid + "\n" + `{"id": 4}`, failed: 2, indexes: []int{1, 3}},
+		{name: "capped", body: strings.Repeat(`{"id": 1}`+"\n", importMaxItems+5), failed: importMaxItems + 5},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []data.Article
+			db := new(mocks.ArticlesData)
+			db.On("ImportArticles", mock.Anything, mock.Anything).Return(importAll(&got))
+			a := NewArticles(zap.NewNop(), db, data.NewValidation())
+
+			req := httptest.NewRequest(http.MethodPost, "/import", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", NDJSONContentType)
+			w := httptest.NewRecorder()
+			a.Import(w, req)
+
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("Expected status code 422 but got %d: %s", w.Code, w.Body.String())
+			}
+			report := &data.ImportReport{}
+			if err := json.NewDecoder(w.Body).Decode(report); err != nil {
+				t.Fatalf("Unable to decode the report %q: %v", w.Body.String(), err)
+			}
+			if report.Imported != 0 || report.Failed != tc.failed {
+				t.Errorf("Expected 0 imported and %d failed, got %+v", tc.failed, report)
+			}
+			if tc.indexes == nil && len(report.Items) != importMaxItems {
+				t.Errorf("Expected %d items, got %d", importMaxItems, len(report.Items))
+			}
+			for i, index := range tc.indexes {
+				if i >= len(report.Items) || report.Items[i].Index != index || report.Items[i].Status != itemInvalid || len(report.Items[i].Errors) == 0 {
+					t.Errorf("Expected article %d to be invalid, got %+v", index, report.Items)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,418 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/graphql"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"go.uber.org/zap"
+)
+
+// maxGraphQLIDs is the largest number of articles a query may ask for by id,
+// and the largest batch a loader fetches
+const maxGraphQLIDs = 100
+
+// graphqlBatchWait is how long a loader waits for the other resolvers of a
+// level of a query to ask for their keys before fetching a batch
+const graphqlBatchWait = 2 * time.Millisecond
+
+// errGraphQLInternal replaces the errors of the data layer that are not
+// meant for clients
+var errGraphQLInternal = errors.New("internal error")
+
+// GraphQL answers GraphQL queries for articles, their tags and the summaries
+// of the tags, loading what a level of a query needs in a single batch
+type GraphQL struct {
+	l      *zap.Logger
+	db     data.ArticlesData
+	schema *graphql.Schema
+}
+
+func NewGraphQL(l *zap.Logger, db data.ArticlesData, limits graphql.Limits) *GraphQL {
+	return &GraphQL{l: l, db: db, schema: graphql.MustNewSchema(graphqlSchema, &queryResolver{}, limits)}
+}
+
+// Query executes a GraphQL query, sent as a JSON body or, in a GET, as the
+// query, operationName and variables parameters.
+//
+// swagger:operation GET /v1/graphql graphql QueryGet
+//
+// ---
+// parameters:
+//   - name: query
+//     in: query
+//     description: The query
+//     required: true
+//     type: string
+//   - name: operationName
+//     in: query
+//     description: The name of the operation to run, when the query has several
+//     required: false
+//     type: string
+//   - name: variables
+//     in: query
+//     description: The values of the variables of the operation, as a JSON object
+//     required: false
+//     type: string
+//
+// responses:
+//
+//	'200':
+//	  description: The query was executed, the errors of the fields that failed are listed with the data
+//	  schema:
+//	    type: object
+//	'400':
+//	  description: The query is malformed, invalid, or over the depth or complexity limits
+//	  schema:
+//	    type: object
+//
+// swagger:operation POST /v1/graphql graphql Query
+//
+// ---
+//
+//	consumes:
+//	  - application/json
+//
+//	produces:
+//	  - application/json
+//
+//	parameters:
+//	  - name: body
+//	    in: body
+//	    description: The query, the name of the operation to run and the values of its variables
+//	    required: true
+//	    schema:
+//	      type: object
+//	      required:
+//	        - query
+//	      properties:
+//	        query:
+//	          type: string
+//	        operationName:
+//	          type: string
+//	        variables:
+//	          type: object
+//
+//	responses:
+//	  '200':
+//	    description: The query was executed, the errors of the fields that failed are listed with the data
+//	    schema:
+//	      type: object
+//	  '400':
+//	    description: The query is malformed, invalid, or over the depth or complexity limits
+//	    schema:
+//	      type: object
+func (g *GraphQL) Query(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context(), g.l)
+
+	var req graphqlRequest
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if vs := q.Get("variables"); vs != "" {
+			if err := json.Unmarshal([]byte(vs), &req.Variables); err != nil {
+				writeProblem(w, r, http.StatusBadRequest, problemInvalid, "The variables parameter must be a JSON object.")
+				return
+			}
+		}
+	} else if err := utils.FromJSON(&req, r.Body); err != nil {
+		writeError(w, r, l, err)
+		return
+	}
+	if req.Query == "" {
+		writeProblem(w, r, http.StatusBadRequest, problemInvalid, "The request has no query.")
+		return
+	}
+
+	ctx := withLoaders(r.Context(), g.newLoaders(r.Context(), l))
+	resp := g.schema.Execute(ctx, req.Request)
+
+	status := http.StatusOK
+	if !graphql.Executed(resp) {
+		l.Info("GraphQL query rejected", zap.String("error", resp.Errors[0].Message))
+		status = http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := utils.ToJSON(resp, w); err != nil {
+		l.Error("Unable to serialize the GraphQL response", zap.Error(err))
+	}
+}
+
+// graphqlRequest is the body of a POST to /graphql
+type graphqlRequest struct {
+	graphql.Request
+	// Extensions are accepted but ignored
+	Extensions json.RawMessage `json:"extensions,omitempty"`
+}
+
+// graphqlSchema is the schema of the queries. Dates of summaries are
+// formatted as YYYYMMDD. The summary of a tag is that of the date of the
+// article or summary it was found on unless another is asked for.
+const graphqlSchema = `
+schema {
+  query: Query
+}
+type Query {
+  article(id: Int!): Article
+  articles(ids: [Int!]!): [Article]!
+  tagSummary(tag: String!, date: String!): TagSummary
+}
+type Article {
+  id: Int!
+  title: String!
+  date: String!
+  body: String!
+  updatedAt: String
+  tags: [Tag!]!
+}
+type Tag {
+  name: String!
+  summary(date: String): TagSummary
+}
+type TagSummary {
+  tag: String!
+  date: String!
+  count: Int!
+  articleIds: [Int!]!
+  articles: [Article!]!
+  relatedTags: [Tag!]!
+}
+`
+
+// loaders batch the reads of a query
+type loaders struct {
+	articles  *graphql.Loader[int, *data.Article]
+	summaries *graphql.Loader[data.TagDate, []int]
+}
+
+type loadersKey struct{}
+
+func withLoaders(ctx context.Context, ls *loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, ls)
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// newLoaders returns the loaders of a query, reading with ctx
+func (g *GraphQL) newLoaders(ctx context.Context, l *zap.Logger) *loaders {
+	return &loaders{
+		articles: graphql.NewLoader(graphqlBatchWait, maxGraphQLIDs, func(ids []int) (map[int]*data.Article, error) {
+			as, err := g.db.GetArticlesByIDs(ctx, ids)
+			if err != nil {
+				l.Error("Loading articles", zap.Ints("ids", ids), zap.Error(err))
+				return nil, errGraphQLInternal
+			}
+			m := make(map[int]*data.Article, len(as))
+			for i := range as {
+				m[as[i].ID] = &as[i]
+			}
+			return m, nil
+		}),
+		summaries: graphql.NewLoader(graphqlBatchWait, maxGraphQLIDs, func(keys []data.TagDate) (map[data.TagDate][]int, error) {
+			ids, err := g.db.GetArticlesForTagsAndDates(ctx, keys)
+			if err != nil {
+				l.Error("Loading tag summaries", zap.Any("keys", keys), zap.Error(err))
+				return nil, errGraphQLInternal
+			}
+			m := make(map[data.TagDate][]int, len(keys))
+			for i, k := range keys {
+				if len(ids[i]) != 0 {
+					m[k] = ids[i]
+				}
+			}
+			return m, nil
+		}),
+	}
+}
+
+// queryResolver resolves the fields of Query
+type queryResolver struct{}
+
+func (*queryResolver) Article(ctx context.Context, args struct{ ID int32 }) (*articleResolver, error) {
+	if args.ID < 1 {
+		return nil, &data.InvalidError{Reason: "article id must be positive"}
+	}
+	a, ok, err := loadersFrom(ctx).articles.Load(ctx, int(args.ID))
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &articleResolver{a}, nil
+}
+
+// Articles returns the articles of ids, missing ones being null in the
+// position of their id
+func (*queryResolver) Articles(ctx context.Context, args struct{ IDs []int32 }) ([]*articleResolver, error) {
+	if len(args.IDs) > maxGraphQLIDs {
+		return nil, &data.InvalidError{Reason: "at most " + strconv.Itoa(maxGraphQLIDs) + " articles can be asked for at once"}
+	}
+	ids := make([]int, len(args.IDs))
+	for i, id := range args.IDs {
+		ids[i] = int(id)
+	}
+	as, err := loadersFrom(ctx).articles.LoadMany(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]*articleResolver, len(ids))
+	for i, id := range ids {
+		if a, ok := as[id]; ok {
+			rs[i] = &articleResolver{a}
+		}
+	}
+	return rs, nil
+}
+
+func (*queryResolver) TagSummary(ctx context.Context, args struct{ Tag, Date string }) (*summaryResolver, error) {
+	return loadSummary(ctx, args.Tag, args.Date)
+}
+
+// articleResolver resolves the fields of Article
+type articleResolver struct {
+	a *data.Article
+}
+
+func (r *articleResolver) ID() int32     { return int32(r.a.ID) }
+func (r *articleResolver) Title() string { return r.a.Title }
+func (r *articleResolver) Date() string  { return r.a.Date }
+func (r *articleResolver) Body() string  { return r.a.Body }
+
+func (r *articleResolver) UpdatedAt() *string {
+	if r.a.UpdatedAt == nil {
+		return nil
+	}
+	u := r.a.UpdatedAt.UTC().Format(time.RFC3339)
+	return &u
+}
+
+func (r *articleResolver) Tags() []*tagResolver {
+	tags := make([]*tagResolver, len(r.a.Tags))
+	for i, t := range r.a.Tags {
+		tags[i] = &tagResolver{name: t, date: summaryDate(r.a.Date)}
+	}
+	return tags
+}
+
+// tagResolver resolves the fields of Tag, as found on an article or among
+// the related tags of a summary, with the date its summary is given for
+// when none is asked
+type tagResolver struct {
+	name string
+	date string
+}
+
+func (r *tagResolver) Name() string { return r.name }
+
+func (r *tagResolver) Summary(ctx context.Context, args struct{ Date *string }) (*summaryResolver, error) {
+	date := r.date
+	if args.Date != nil {
+		date = *args.Date
+	}
+	return loadSummary(ctx, r.name, date)
+}
+
+// summaryResolver resolves the fields of TagSummary, the summary of a tag on
+// a date (YYYYMMDD)
+type summaryResolver struct {
+	tag  string
+	date string
+	ids  []int
+}
+
+func (r *summaryResolver) Tag() string  { return r.tag }
+func (r *summaryResolver) Date() string { return r.date }
+func (r *summaryResolver) Count() int32 { return int32(len(r.ids)) }
+
+func (r *summaryResolver) ArticleIDs() []int32 {
+	ids := make([]int32, len(r.ids))
+	for i, id := range r.ids {
+		ids[i] = int32(id)
+	}
+	return ids
+}
+
+func (r *summaryResolver) Articles(ctx context.Context) ([]*articleResolver, error) {
+	as, err := r.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]*articleResolver, len(as))
+	for i, a := range as {
+		rs[i] = &articleResolver{a}
+	}
+	return rs, nil
+}
+
+// RelatedTags returns the tags of the articles of the summary, loaded with
+// the articles of every other summary of the level instead of article by
+// article
+func (r *summaryResolver) RelatedTags(ctx context.Context) ([]*tagResolver, error) {
+	as, err := r.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return relatedTags(r, as), nil
+}
+
+// load returns the articles of the summary which still exist, in order
+func (r *summaryResolver) load(ctx context.Context) ([]*data.Article, error) {
+	byID, err := loadersFrom(ctx).articles.LoadMany(ctx, r.ids)
+	if err != nil {
+		return nil, err
+	}
+	as := make([]*data.Article, 0, len(r.ids))
+	for _, id := range r.ids {
+		if a, ok := byID[id]; ok {
+			as = append(as, a)
+		}
+	}
+	return as, nil
+}
+
+// loadSummary loads the summary of tag on date, formatted as YYYYMMDD
+func loadSummary(ctx context.Context, tag, date string) (*summaryResolver, error) {
+	if !data.ValidTagDate(date) {
+		return nil, &data.InvalidError{Reason: "date " + date + " is not a valid YYYYMMDD date"}
+	}
+	ids, ok, err := loadersFrom(ctx).summaries.Load(ctx, data.TagDate{Tag: tag, Date: date})
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &summaryResolver{tag: tag, date: date, ids: ids}, nil
+}
+
+// relatedTags returns the tags of the articles of s other than its own, in
+// the order the articles and their tags come in, like the REST summary
+func relatedTags(s *summaryResolver, as []*data.Article) []*tagResolver {
+	tags := []*tagResolver{}
+	seen := map[string]bool{s.tag: true}
+	for _, a := range as {
+		for _, t := range a.Tags {
+			if !seen[t] {
+				seen[t] = true
+				tags = append(tags, &tagResolver{name: t, date: s.date})
+			}
+		}
+	}
+	return tags
+}
+
+// summaryDate converts the date of an article to that of the summaries of
+// its tags, YYYYMMDD
+func summaryDate(date string) string {
+	if len(date) > len("2006-01-02") {
+		date = date[:len("2006-01-02")]
+	}
+	return strings.ReplaceAll(date, "-", "")
+}
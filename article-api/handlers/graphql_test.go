@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/graphql"
+	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func TestGraphQLQuery(t *testing.T) {
+	articles := map[int]data.Article{
+		1: {ID: 1, Title: "Title 1", Date: "2016-09-22", Body: "Body 1", Tags: []string{"health", "fitness"}},
+		2: {ID: 2, Title: "Title 2", Date: "2016-09-22", Body: "Body 2", Tags: []string{"health", "science"}},
+		3: {ID: 3, Title: "Title 3", Date: "2016-09-22", Body: "Body 3", Tags: []string{"fitness", "yoga"}},
+	}
+	// the loaders batch the keys that concurrent resolvers ask for, in no
+	// particular order
+	byIDs := func(_ context.Context, ids []int) []data.Article {
+		var as []data.Article
+		for _, id := range ids {
+			if a, ok := articles[id]; ok {
+				as = append(as, a)
+			}
+		}
+		return as
+	}
+	ids := func(want ...int) interface{} {
+		return mock.MatchedBy(func(got []int) bool {
+			return assert.ElementsMatch(new(testing.T), want, got)
+		})
+	}
+	summaries := map[data.TagDate][]int{
+		{Tag: "health", Date: "20160922"}:  {1, 2},
+		{Tag: "fitness", Date: "20160922"}: {1, 3},
+	}
+	forTags := func(_ context.Context, keys []data.TagDate) [][]int {
+		ids := make([][]int, len(keys))
+		for i, k := range keys {
+			ids[i] = summaries[k]
+		}
+		return ids
+	}
+	keys := func(want ...data.TagDate) interface{} {
+		return mock.MatchedBy(func(got []data.TagDate) bool {
+			return assert.ElementsMatch(new(testing.T), want, got)
+		})
+	}
+	dbErr := errors.New("connection refused")
+
+	tt := []struct {
+		name   string
+		method string
+		query  string
+		vars   map[string]interface{}
+		setup  func(db *mocks.ArticlesData)
+		status int
+		want   string
+		// calls expected of the data layer, by method
+		calls map[string]int
+	}{
+		{
+			name:   "article with its tags, their summaries and related tags",
+			method: http.MethodPost,
+			query: `query ($id: Int!) {
+				article(id: $id) {
+					title
+					tags { name summary { count articleIds relatedTags { name } } }
+				}
+			}`,
+			vars: map[string]interface{}{"id": 1},
+			setup: func(db *mocks.ArticlesData) {
+				db.On("GetArticlesByIDs", mock.Anything, ids(1)).Return(byIDs, nil).Once()
+				db.On("GetArticlesForTagsAndDates", mock.Anything, keys(data.TagDate{Tag: "health", Date: "20160922"}, data.TagDate{Tag: "fitness", Date: "20160922"})).Return(forTags, nil).Once()
+				db.On("GetArticlesByIDs", mock.Anything, ids(2, 3)).Return(byIDs, nil).Once()
+			},
+			status: http.StatusOK,
+			want: `{"data":{"article":{"title":"Title 1","tags":[` +
+				`{"name":"health","summary":{"count":2,"articleIds":[1,2],"relatedTags":[{"name":"fitness"},{"name":"science"}]}},` +
+				`{"name":"fitness","summary":{"count":2,"articleIds":[1,3],"relatedTags":[{"name":"health"},{"name":"yoga"}]}}]}}}`,
+			calls: map[string]int{"GetArticlesByIDs": 2, "GetArticlesForTagsAndDates": 1},
+		},
+		{
+			name:   "tag summary by GET",
+			method: http.MethodGet,
+			query:  `{ tagSummary(tag: "health", date: "20160922") { tag articles { id } } missing: tagSummary(tag: "none", date: "20160922") { tag } }`,
+			setup: func(db *mocks.ArticlesData) {
+				db.On("GetArticlesForTagsAndDates", mock.Anything, keys(data.TagDate{Tag: "health", Date: "20160922"}, data.TagDate{Tag: "none", Date: "20160922"})).Return(forTags, nil).Once()
+				db.On("GetArticlesByIDs", mock.Anything, ids(1, 2)).Return(byIDs, nil).Once()
+			},
+			status: http.StatusOK,
+			want:   `{"data":{"tagSummary":{"tag":"health","articles":[{"id":1},{"id":2}]},"missing":null}}`,
+			calls:  map[string]int{"GetArticlesByIDs": 1, "GetArticlesForTagsAndDates": 1},
+		},
+		{
+			name:   "missing articles",
+			method: http.MethodPost,
+			query:  `{ articles(ids: [3, 9]) { id } }`,
+			setup: func(db *mocks.ArticlesData) {
+				db.On("GetArticlesByIDs", mock.Anything, ids(3, 9)).Return(byIDs, nil).Once()
+			},
+			status: http.StatusOK,
+			want:   `{"data":{"articles":[{"id":3},null]}}`,
+			calls:  map[string]int{"GetArticlesByIDs": 1},
+		},
+		{
+			name:   "invalid date",
+			method: http.MethodPost,
+			query:  `{ tagSummary(tag: "health", date: "2016-09-22") { count } }`,
+			status: http.StatusOK,
+			want:   `{"errors":[{"message":"date 2016-09-22 is not a valid YYYYMMDD date","path":["tagSummary"]}],"data":{"tagSummary":null}}`,
+		},
+		{
+			name:   "data layer failing",
+			method: http.MethodPost,
+			query:  `{ article(id: 1) { id } }`,
+			setup: func(db *mocks.ArticlesData) {
+				db.On("GetArticlesByIDs", mock.Anything, []int{1}).Return(nil, dbErr).Once()
+			},
+			status: http.StatusOK,
+			want:   `{"errors":[{"message":"internal error","path":["article"]}],"data":{"article":null}}`,
+			calls:  map[string]int{"GetArticlesByIDs": 1},
+		},
+		{
+			name:   "invalid query",
+			method: http.MethodPost,
+			query:  `{ article(id: 1) { author } }`,
+			status: http.StatusBadRequest,
+			want:   `{"errors":[{"message":"Cannot query field \"author\" on type \"Article\".","locations":[{"line":1,"column":20}]}]}`,
+		},
+		{
+			name:   "query over the depth limit",
+			method: http.MethodPost,
+			query:  `{ article(id: 1) { tags { summary { relatedTags { summary { articles { id } } } } } } }`,
+			status: http.StatusBadRequest,
+			want:   `{"errors":[{"message":"Field \"articles\" has depth 6 that exceeds max depth 5","locations":[{"line":1,"column":61}]}]}`,
+		},
+		{
+			name:   "query over the complexity limit",
+			method: http.MethodPost,
+			query:  `{ articles(ids: [1]) { tags { summary { relatedTags { name } } } } }`,
+			status: http.StatusBadRequest,
+			want:   `{"errors":[{"message":"The query has a complexity of 1211, over the limit of 1000."}]}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &mocks.ArticlesData{}
+			if tc.setup != nil {
+				tc.setup(db)
+			}
+			h := NewGraphQL(zap.NewNop(), db, graphql.Limits{MaxDepth: 5, MaxComplexity: 1000})
+
+			var req *http.Request
+			if tc.method == http.MethodGet {
+				req = httptest.NewRequest(tc.method, "/graphql?query="+url.QueryEscape(tc.query), nil)
+			} else {
+				body, _ := json.Marshal(map[string]interface{}{"query": tc.query, "variables": tc.vars})
+				req = httptest.NewRequest(tc.method, "/graphql", strings.NewReader(string(body)))
+			}
+			w := httptest.NewRecorder()
+			h.Query(w, req)
+
+			if w.Code != tc.status {
+				t.Errorf("got status %d, want %d", w.Code, tc.status)
+			}
+			if got := strings.TrimSpace(w.Body.String()); got != tc.want {
+				t.Errorf("got body\n%s\nwant\n%s", got, tc.want)
+			}
+			db.AssertExpectations(t)
+			for method, n := range tc.calls {
+				db.AssertNumberOfCalls(t, method, n)
+			}
+			db.AssertNotCalled(t, "GetRelatedTagsForTag", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestGraphQLQueryMalformed(t *testing.T) {
+	tt := []struct {
+		name   string
+		target string
+		body   string
+	}{
+		{name: "no query", target: "/graphql", body: `{"variables":{}}`},
+		{name: "unknown field", target: "/graphql", body: `{"query":"{ article(id: 1) { id } }","vars":{}}`},
+		{name: "not JSON", target: "/graphql", body: `query { article(id: 1) { id } }`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewGraphQL(zap.NewNop(), &mocks.ArticlesData{}, graphql.Limits{})
+			w := httptest.NewRecorder()
+			h.Query(w, httptest.NewRequest(http.MethodPost, tc.target, strings.NewReader(tc.body)))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("got content type %q, want a problem", ct)
+			}
+		})
+	}
+}
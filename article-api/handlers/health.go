@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/utils"
+	"go.uber.org/zap"
+)
+
+// checkTimeout bounds how long a single readiness check may take
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency of the service is usable
+type Check func(ctx context.Context) error
+
+// CheckResult is the outcome of a single health check
+type CheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthStatus is the body returned by the health endpoints
+type HealthStatus struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+const (
+	statusOK      = "ok"
+	statusFailing = "failing"
+)
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Health serves the liveness and readiness endpoints
+type Health struct {
+	l            *zap.Logger
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+func NewHealth(l *zap.Logger) *Health {
+	return &Health{l: l}
+}
+
+// AddCheck registers a dependency that must be healthy for the service to be ready
+func (h *Health) AddCheck(name string, c Check) {
+	h.checks = append(h.checks, namedCheck{name, c})
+}
+
+// Shutdown marks the service as shutting down, readiness fails from then on
+func (h *Health) Shutdown() {
+	h.shuttingDown.Store(true)
+}
+
+// Live reports that the process is up and able to serve requests
+//
+// swagger:operation GET /healthz health Live
+//
+// ---
+// responses:
+//
+//	'200':
+//	  description: The process is alive
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	utils.ToJSON(&HealthStatus{Status: statusOK}, w)
+}
+
+// Ready reports whether the service can handle traffic, running every
+// registered check concurrently
+//
+// swagger:operation GET /readyz health Ready
+//
+// ---
+// responses:
+//
+//	'200':
+//	  description: All dependencies are healthy
+//	'503':
+//	  description: At least one dependency is failing or the service is shutting down
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	status := HealthStatus{Status: statusOK, Checks: map[string]CheckResult{}}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+			res := CheckResult{Status: statusOK}
+			if err := c.check(ctx); err != nil {
+				res = CheckResult{Status: statusFailing, Error: err.Error()}
+			}
+			mu.Lock()
+			status.Checks[c.name] = res
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	if h.shuttingDown.Load() {
+		status.Checks["shutdown"] = CheckResult{Status: statusFailing, Error: "server is shutting down"}
+	} else {
+		status.Checks["shutdown"] = CheckResult{Status: statusOK}
+	}
+
+	for _, res := range status.Checks {
+		if res.Status != statusOK {
+			status.Status = statusFailing
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status != statusOK {
+		h.l.Warn("Readiness check failed", zap.Any("checks", status.Checks))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	utils.ToJSON(&status, w)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestHealth(t *testing.T) {
+
+	okCheck := func(ctx context.Context) error { return nil }
+	failCheck := func(ctx context.Context) error { return errors.New("connection refused") }
+
+	tt := []struct {
+		name     string
+		checks   map[string]Check
+		shutdown bool
+		status   int
+		failing  []string
+	}{
+		{
+			name:   "all checks pass",
+			checks: map[string]Check{"database": okCheck, "migrations": okCheck},
+			status: 200,
+		},
+		{
+			name:    "database down",
+			checks:  map[string]Check{"database": failCheck, "migrations": okCheck},
+			status:  503,
+			failing: []string{"database"},
+		},
+		{
+			name:     "shutting down",
+			checks:   map[string]Check{"database": okCheck},
+			shutdown: true,
+			status:   503,
+			failing:  []string{"shutdown"},
+		},
+	}
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer logger.Sync()
+
+	for _, tc := range tt {
+		h := NewHealth(logger)
+		for name, c := range tc.checks {
+			h.AddCheck(name, c)
+		}
+		if tc.shutdown {
+			h.Shutdown()
+		}
+
+		// liveness does not depend on the checks
+		w := httptest.NewRecorder()
+		h.Live(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected liveness status 200 but got %d", tc.name, w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		h.Ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != tc.status {
+			t.Errorf("%s: expected readiness status %d but got %d", tc.name, tc.status, w.Code)
+		}
+
+		status := HealthStatus{}
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatalf("%s: error decoding response body: %v", tc.name, err)
+		}
+		for _, name := range tc.failing {
+			if status.Checks[name].Status != statusFailing {
+				t.Errorf("%s: expected check %s to be failing but got %v", tc.name, name, status.Checks[name])
+			}
+		}
+	}
+}
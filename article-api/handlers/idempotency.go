@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
+	"github.com/sg83/go-microservice/article-api/idempotency"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"go.uber.org/zap"
+)
+
+// IdempotencyKeyHeader is the header clients name a request with so that
+// retrying it does not apply it twice
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	// maxIdempotencyKeyLen is the longest key accepted
+	maxIdempotencyKeyLen = 255
+	// pendingTTL is how long a key stays claimed by a request that never
+	// completes, e.g. because its replica stopped
+	pendingTTL = time.Minute
+	// storeTimeout bounds storing a response, which is done even when the
+	// client has gone away since that is when it is going to retry
+	storeTimeout = 5 * time.Second
+)
+
+// unstoredHeaders describe the exchange rather than the response and are
+// not replayed
+var unstoredHeaders = []string{
+	"Content-Encoding", "Content-Length", "Date", "Vary", "Retry-After",
+	"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", RequestIDHeader,
+}
+
+// MiddlewareIdempotency stores for ttl the response to every request sent
+// with an Idempotency-Key header, along with a fingerprint of the request.
+// Retries with the same key, from the same client, get the stored response
+// back with an Idempotent-Replayed header instead of being handled again.
+// Reusing a key for a different request is refused with 422 Unprocessable
+// Entity, and retrying while the first request is still being handled with
+// 409 Conflict. Server errors are not stored so that they can be retried.
+// If the store fails requests are handled as if they had no key.
+func MiddlewareIdempotency(l *zap.Logger, s idempotency.Store, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			if !validIdempotencyKey(key) {
+				writeProblem(rw, r, http.StatusBadRequest, problemInvalid,
+					"The Idempotency-Key header must be at most 255 printable ASCII characters.")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(rw, r, l, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			log := logging.FromContext(r.Context(), l)
+			fp := fingerprint(r, body)
+			// scoped to the client, whose made up API keys are ignored and
+			// known ones only stored as a digest, see ClientKey
+			key = clientKey(r) + ":" + key
+			rec, err := s.Reserve(r.Context(), key, fp, pendingTTL)
+			if err != nil {
+				log.Warn("Idempotency store failed, handling request", zap.Error(err))
+				next.ServeHTTP(rw, r)
+				return
+			}
+			if rec != nil {
+				switch {
+				case rec.Fingerprint != fp:
+					writeProblem(rw, r, http.StatusUnprocessableEntity, problemKeyReused,
+						"The Idempotency-Key was already used for a different request.")
+				case !rec.Done:
+					rw.Header().Set("Retry-After", "1")
+					writeProblem(rw, r, http.StatusConflict, problemKeyPending,
+						"A request with this Idempotency-Key is still being handled.")
+				default:
+					log.Info("Replaying response", zap.Int("status", rec.Status))
+					replay(rw, rec)
+				}
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+			defer cancel()
+
+			// release the key if the handler panics
+			handled := false
+			defer func() {
+				if !handled {
+					s.Release(ctx, key)
+				}
+			}()
+
+			res := idempotency.Record{Fingerprint: fp, Status: http.StatusOK}
+			w := httpsnoop.Wrap(rw, httpsnoop.Hooks{
+				WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+					return func(code int) {
+						if res.Header == nil {
+							res.Status, res.Header = code, storedHeader(rw.Header())
+						}
+						next(code)
+					}
+				},
+				Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+					return func(b []byte) (int, error) {
+						if res.Header == nil {
+							res.Header = storedHeader(rw.Header())
+						}
+						res.Body = append(res.Body, b...)
+						return next(b)
+					}
+				},
+			})
+			next.ServeHTTP(w, r)
+			handled = true
+
+			if res.Header == nil {
+				res.Header = storedHeader(rw.Header())
+			}
+			if res.Status >= http.StatusInternalServerError {
+				err = s.Release(ctx, key)
+			} else {
+				err = s.Complete(ctx, key, res, ttl)
+			}
+			if err != nil {
+				log.Warn("Could not store idempotent response", zap.Error(err))
+			}
+		})
+	}
+}
+
+// validIdempotencyKey reports whether key is short and printable ASCII
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// fingerprint is a digest of what makes a request: its method, path and
+// body. The path is taken without the version prefix of its route, so that
+// retrying a request through the deprecated alias of its route, or under
+// another version, is recognised as the same request.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+unversionedPath(r)+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// unversionedPath returns the path of r without the /v{n} prefix the
+// template of its route starts with, if any
+func unversionedPath(r *http.Request) string {
+	cr := mux.CurrentRoute(r)
+	if cr == nil {
+		return r.URL.Path
+	}
+	tpl, err := cr.GetPathTemplate()
+	if err != nil {
+		return r.URL.Path
+	}
+	if !strings.HasPrefix(tpl, "/v") {
+		return r.URL.Path
+	}
+	version := tpl[2:]
+	if i := strings.IndexByte(version, '/'); i >= 0 {
+		version = version[:i]
+	}
+	if version == "" || strings.Trim(version, "0123456789") != "" {
+		return r.URL.Path
+	}
+	return strings.TrimPrefix(r.URL.Path, "/v"+version)
+}
+
+// storedHeader copies the headers of a response worth replaying
+func storedHeader(h http.Header) http.Header {
+	c := h.Clone()
+	for _, k := range unstoredHeaders {
+		c.Del(k)
+	}
+	return c
+}
+
+// replay sends a stored response
+func replay(rw http.ResponseWriter, rec *idempotency.Record) {
+	h := rw.Header()
+	for k, v := range rec.Header {
+		h[k] = v
+	}
+	h.Set("Idempotent-Replayed", "true")
+	rw.WriteHeader(rec.Status)
+	rw.Write(rec.Body)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sg83/go-microservice/article-api/idempotency"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"go.uber.org/zap"
+)
+
+// recordingStore records the keys records are reserved under
+type recordingStore struct {
+	idempotency.Store
+	keys []string
+}
+
+func (s *recordingStore) Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*idempotency.Record, error) {
+	s.keys = append(s.keys, key)
+	return s.Store.Reserve(ctx, key, fingerprint, ttl)
+}
+
+func TestMiddlewareIdempotency(t *testing.T) {
+	store := &recordingStore{Store: idempotency.NewMemory()}
+	calls := 0
+	keys := NewAPIKeys(map[string][]string{"secret": {ScopeAdmin}})
+	h := MiddlewareClient(keys, nil)(MiddlewareIdempotency(zap.NewNop(), store, time.Hour)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("RateLimit-Remaining", "3")
+			if strings.Contains(r.URL.RawQuery, "fail") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"n":1}`))
+		})))
+
+	// a request claimed by another replica, still being handled
+	store.Reserve(context.Background(), "ip:203.0.113.7:pending", fingerprint(httptest.NewRequest(http.MethodPost, "/articles", nil), []byte("{}")), time.Minute)
+
+	tt := []struct {
+		name     string
+		key      string
+		apiKey   string
+		query    string
+		body     string
+		status   int
+		problem  string
+		replayed bool
+		calls    int
+	}{
+		{name: "no key", body: `{}`, status: http.StatusCreated, calls: 1},
+		{name: "no key again", body: `{}`, status: http.StatusCreated, calls: 2},
+		{name: "first request", key: "k1", body: `{}`, status: http.StatusCreated, calls: 3},
+		{name: "retry", key: "k1", body: `{}`, status: http.StatusCreated, replayed: true, calls: 3},
+		{name: "made up api key", key: "k1", apiKey: "made-up", body: `{}`, status: http.StatusCreated, replayed: true, calls: 3},
+		{name: "different body", key: "k1", body: `{"a":1}`, status: http.StatusUnprocessableEntity, problem: problemKeyReused, calls: 3},
+		{name: "other client", key: "k1", apiKey: "secret", body: `{}`, status: http.StatusCreated, calls: 4},
+		{name: "pending", key: "pending", body: `{}`, status: http.StatusConflict, problem: problemKeyPending, calls: 4},
+		{name: "server error", key: "k2", query: "fail", body: `{}`, status: http.StatusInternalServerError, calls: 5},
+		{name: "server error retried", key: "k2", query: "fail", body: `{}`, status: http.StatusInternalServerError, calls: 6},
+		{name: "invalid key", key: strings.Repeat("k", 256), body: `{}`, status: http.StatusBadRequest, problem: problemInvalid, calls: 6},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodPost, "/articles?"+tc.query, strings.NewReader(tc.body))
+		req.RemoteAddr = "203.0.113.7:4000"
+		if tc.key != "" {
+			req.Header.Set(IdempotencyKeyHeader, tc.key)
+		}
+		if tc.apiKey != "" {
+			req.Header.Set(APIKeyHeader, tc.apiKey)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status code %d but got %d", tc.name, tc.status, w.Code)
+		}
+		if calls != tc.calls {
+			t.Errorf("%s: expected the handler to be called %d times but got %d", tc.name, tc.calls, calls)
+		}
+		if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != tc.replayed {
+			t.Errorf("%s: expected replayed %v but got %v", tc.name, tc.replayed, replayed)
+		}
+		if tc.replayed {
+			if w.Body.String() != `{"n":1}` || w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("%s: unexpected replayed response %q %v", tc.name, w.Body.String(), w.Header())
+			}
+			if w.Header().Get("RateLimit-Remaining") != "" {
+				t.Errorf("%s: expected rate limit headers not to be replayed", tc.name)
+			}
+		}
+		if tc.problem != "" {
+			p := &utils.Problem{}
+			if err := json.NewDecoder(w.Body).Decode(p); err != nil || p.Type != tc.problem {
+				t.Errorf("%s: expected problem %s, got %q", tc.name, tc.problem, w.Body.String())
+			}
+		}
+	}
+
+	for _, k := range store.keys {
+		if strings.Contains(k, "secret") || strings.Contains(k, "made-up") {
+			t.Errorf("the record %s is stored under an API key", k)
+		}
+	}
+}
+
+func TestMiddlewareIdempotencyVersions(t *testing.T) {
+	calls := 0
+	mw := MiddlewareIdempotency(zap.NewNop(), idempotency.NewMemory(), time.Hour)
+	sm := mux.NewRouter()
+	for _, prefix := range []string{"/v1", "/v2", ""} {
+		r := sm.NewRoute().Subrouter()
+		if prefix != "" {
+			r = sm.PathPrefix(prefix).Subrouter()
+		}
+		r.Use(mw)
+		r.HandleFunc("/articles", func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusCreated)
+		}).Methods(http.MethodPost)
+	}
+
+	// the deprecated alias and every version are the same route
+	for i, path := range []string{"/articles", "/v1/articles", "/v2/articles"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`))
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, req)
+
+		if w.Code != http.StatusCreated {
+			t.Errorf("%s: expected status code %d but got %d: %s", path, http.StatusCreated, w.Code, w.Body.String())
+		}
+		if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != (i > 0) {
+			t.Errorf("%s: expected replayed %v but got %v", path, i > 0, replayed)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the handler to be called once but got %d", calls)
+	}
+}
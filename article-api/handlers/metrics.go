@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics returns the handler exposing the metrics of the service, in the
+// Prometheus text format. It is served on GET /metrics of its own address,
+// kept off the public network, so it is not part of the API document.
+func Metrics() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
@@ -2,9 +2,23 @@ package handlers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/gorilla/mux"
 
 	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/logging"
 	"github.com/sg83/go-microservice/article-api/utils"
 	"go.uber.org/zap"
 )
@@ -13,26 +27,22 @@ import (
 func (a *Articles) MiddlewareValidateArticle(next http.Handler) http.Handler {
 	a.l.Info("Validating article")
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		rw.Header().Add("Content-Type", "application/json")
+		l := logging.FromContext(r.Context(), a.l)
 
 		article := &data.Article{}
 
 		err := utils.FromJSON(article, r.Body)
 		if err != nil {
-			a.l.Error("Deserializing article ", zap.String("Error: ", err.Error()))
-			rw.WriteHeader(http.StatusBadRequest)
-			utils.ToJSON(&utils.GenericError{Message: err.Error()}, rw)
+			l.Error("Deserializing article ", zap.String("Error: ", err.Error()))
+			writeError(rw, r, l, err)
 			return
 		}
 
 		// validate the product
-		errs := a.v.Validate(article)
+		errs := a.v.Validate(article, AcceptedLanguages(r.Header.Get("Accept-Language"))...)
 		if len(errs) != 0 {
-			a.l.Error("Validating article", zap.Strings("Errors: ", errs.Errors()))
-
-			// return the validation messages as an array
-			rw.WriteHeader(http.StatusUnprocessableEntity)
-			utils.ToJSON(&utils.ValidationError{Messages: errs.Errors()}, rw)
+			l.Error("Validating article", zap.Strings("Errors: ", errs.Errors()))
+			writeError(rw, r, l, errs)
 			return
 		}
 
@@ -44,3 +54,272 @@ func (a *Articles) MiddlewareValidateArticle(next http.Handler) http.Handler {
 		next.ServeHTTP(rw, r)
 	})
 }
+
+// MiddlewareMaxBodySize rejects request bodies larger than n bytes with
+// 413 Payload Too Large, either upfront from their Content-Length or once
+// reading them goes past the limit
+func MiddlewareMaxBodySize(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				writeProblem(rw, r, http.StatusRequestEntityTooLarge, problemTooLarge, bodyTooLarge(n))
+				return
+			}
+			r.Body = http.MaxBytesReader(rw, r.Body, n)
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// MiddlewareContentType rejects requests whose body is not of one of the
+// given media types with 415 Unsupported Media Type
+func MiddlewareContentType(mediaTypes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			for _, allowed := range mediaTypes {
+				if err == nil && mt == allowed {
+					next.ServeHTTP(rw, r)
+					return
+				}
+			}
+			rw.Header().Set("Accept", strings.Join(mediaTypes, ", "))
+			writeProblem(rw, r, http.StatusUnsupportedMediaType, problemMediaType,
+				"The request body must be one of "+strings.Join(mediaTypes, ", ")+".")
+		})
+	}
+}
+
+// AcceptedLanguages returns the locales of an Accept-Language header value
+// in order of preference, each followed by its base language, e.g. fr_ca
+// and fr for fr-CA
+func AcceptedLanguages(header string) []string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+	var langs []lang
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if p := strings.TrimSpace(params); strings.HasPrefix(p, "q=") {
+			if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			langs = append(langs, lang{tag, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	var locales []string
+	for _, l := range langs {
+		locale := strings.ToLower(strings.ReplaceAll(l.tag, "-", "_"))
+		locales = append(locales, locale)
+		if base, _, ok := strings.Cut(locale, "_"); ok {
+			locales = append(locales, base)
+		}
+	}
+	return locales
+}
+
+// RequestIDHeader is the header used to pass the request id between
+// clients, this service and its logs
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the length of a request id accepted from a client
+const maxRequestIDLength = 128
+
+// MiddlewareRequestLogger assigns every request an id, taken from the
+// X-Request-ID header when the client supplies a sane one, echoes it in the
+// response, stores a logger tagged with it in the request context and writes
+// one access log line once the request has been served
+func MiddlewareRequestLogger(l *zap.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			id := RequestID(r.Header.Get(RequestIDHeader))
+			rw.Header().Set(RequestIDHeader, id)
+
+			ctx := logging.WithRequestID(r.Context(), id)
+			ctx = logging.WithLogger(ctx, l.With(zap.String("request_id", id)))
+			r = r.WithContext(ctx)
+
+			m := httpsnoop.CaptureMetrics(next, rw, r)
+
+			route := "unmatched"
+			if cr := mux.CurrentRoute(r); cr != nil {
+				if tpl, err := cr.GetPathTemplate(); err == nil {
+					route = tpl
+				}
+			}
+
+			logging.FromContext(ctx, l).Info("Access",
+				zap.String("method", r.Method),
+				zap.String("route", route),
+				zap.Int("status", m.Code),
+				zap.Int64("bytes", m.Written),
+				zap.Duration("duration", m.Duration),
+				zap.String("client_ip", clientIP(r)),
+			)
+		})
+	}
+}
+
+// APIKeyHeader is the header clients authenticate with
+const APIKeyHeader = "X-API-Key"
+
+// MiddlewareClient tags the request context with the address of the
+// client, read from X-Forwarded-For when the request comes through one of
+// the trusted proxies, and with its identity, see ClientKey, so that reads
+// following its writes are served from the primary database
+func MiddlewareClient(keys *APIKeys, trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			ip := forwardedFor(r, trustedProxies)
+			client := ClientKey(keys, r.Header.Get(APIKeyHeader), ip)
+			ctx := context.WithValue(r.Context(), clientIPKey{}, ip)
+			ctx = context.WithValue(ctx, clientKeyKey{}, client)
+			ctx = data.WithClient(ctx, client)
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientKey identifies a client by its API key when keys knows it, and by
+// its address otherwise, so that sending made up keys does not give a client
+// fresh rate limits. Keys are identified by a digest, so that they are not
+// stored or logged.
+func ClientKey(keys *APIKeys, apiKey, ip string) string {
+	if apiKey != "" && keys.Known(apiKey) {
+		sum := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(sum[:16])
+	}
+	return "ip:" + ip
+}
+
+type clientKeyKey struct{}
+
+// clientKey identifies the client making the request, as found by
+// MiddlewareClient, or else by the address of the peer
+func clientKey(r *http.Request) string {
+	if c, ok := r.Context().Value(clientKeyKey{}).(string); ok {
+		return c
+	}
+	return "ip:" + clientIP(r)
+}
+
+// ParseTrustedProxies parses the addresses of trusted proxies, given as IPs
+// or CIDR ranges
+func ParseTrustedProxies(addrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, a := range addrs {
+		if !strings.Contains(a, "/") {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", a)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q", a)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// forwardedFor returns the address of the client. Starting from the peer,
+// the X-Forwarded-For entries are walked from the closest hop while the
+// previous hop is a trusted proxy, since only those can be believed.
+func forwardedFor(r *http.Request, trusted []*net.IPNet) string {
+	ip := peerIP(r)
+	if len(trusted) == 0 {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && isTrusted(ip, trusted); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// validRequestID reports whether a client supplied request id is safe to
+// reuse in headers and logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID returns id when it is a request id safe to accept from a
+// client, and a new one otherwise
+func RequestID(id string) string {
+	if !validRequestID(id) {
+		return newRequestID()
+	}
+	return id
+}
+
+// newRequestID returns a random 128 bit hex encoded id
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+type clientIPKey struct{}
+
+// clientIP returns the address of the client found by MiddlewareClient, or
+// else of the peer that sent the request
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// peerIP returns the address of the peer that sent the request
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestMiddlewareRequestLogger(t *testing.T) {
+
+	tt := []struct {
+		name      string
+		requestID string
+		generated bool
+	}{
+		{name: "client supplied id", requestID: "abc-123"},
+		{name: "missing id", requestID: "", generated: true},
+		{name: "invalid id", requestID: "has spaces in it", generated: true},
+	}
+
+	for _, tc := range tt {
+		core, logs := observer.New(zap.InfoLevel)
+		logger := zap.New(core)
+
+		var seenID string
+		sm := mux.NewRouter()
+		sm.Use(MiddlewareRequestLogger(logger))
+		sm.HandleFunc("/articles/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+			seenID = logging.RequestID(r.Context())
+			logging.FromContext(r.Context(), zap.NewNop()).Info("handler")
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte("body"))
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/articles/1", nil)
+		if tc.requestID != "" {
+			req.Header.Set(RequestIDHeader, tc.requestID)
+		}
+		w := httptest.NewRecorder()
+		sm.ServeHTTP(w, req)
+
+		id := w.Header().Get(RequestIDHeader)
+		if tc.generated {
+			if id == "" || id == tc.requestID {
+				t.Errorf("%s: expected a generated request id but got %q", tc.name, id)
+			}
+		} else if id != tc.requestID {
+			t.Errorf("%s: expected request id %q but got %q", tc.name, tc.requestID, id)
+		}
+		if seenID != id {
+			t.Errorf("%s: handler saw request id %q, response has %q", tc.name, seenID, id)
+		}
+
+		// the handler log line and the access log line both carry the id
+		entries := logs.All()
+		if len(entries) != 2 {
+			t.Fatalf("%s: expected 2 log entries but got %d", tc.name, len(entries))
+		}
+		for _, e := range entries {
+			if e.ContextMap()["request_id"] != id {
+				t.Errorf("%s: log entry %q missing request id", tc.name, e.Message)
+			}
+		}
+
+		access := entries[1].ContextMap()
+		if access["route"] != "/articles/{id:[0-9]+}" {
+			t.Errorf("%s: expected route template but got %v", tc.name, access["route"])
+		}
+		if access["status"] != int64(http.StatusTeapot) || access["bytes"] != int64(4) {
+			t.Errorf("%s: unexpected status or size in access log: %v", tc.name, access)
+		}
+	}
+}
+
+func TestMiddlewareClientForwardedFor(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tt := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		expectedIP   string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.7:4000", expectedIP: "203.0.113.7"},
+		{name: "untrusted peer", remoteAddr: "203.0.113.7:4000", forwardedFor: "198.51.100.1", expectedIP: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:4000", forwardedFor: "198.51.100.1", expectedIP: "198.51.100.1"},
+		{name: "proxy chain", remoteAddr: "10.1.2.3:4000", forwardedFor: "6.6.6.6, 198.51.100.1, 192.168.1.1", expectedIP: "198.51.100.1"},
+		{name: "only proxies", remoteAddr: "10.1.2.3:4000", forwardedFor: "10.9.9.9", expectedIP: "10.9.9.9"},
+		{name: "garbage", remoteAddr: "10.1.2.3:4000", forwardedFor: "not-an-ip", expectedIP: "10.1.2.3"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var ip string
+			h := MiddlewareClient(nil, trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip = clientIP(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/articles/1", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if ip != tc.expectedIP {
+				t.Errorf("Expected client ip %s but got %s", tc.expectedIP, ip)
+			}
+		})
+	}
+
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected an invalid range to be rejected")
+	}
+}
+
+func TestMiddlewareClientKey(t *testing.T) {
+	keys := NewAPIKeys(map[string][]string{"secret-key": {ScopeAdmin}})
+	tt := []struct {
+		name   string
+		apiKey string
+		want   string
+	}{
+		{name: "no key", want: "ip:203.0.113.7"},
+		{name: "unknown key", apiKey: "made-up-key", want: "ip:203.0.113.7"},
+		{name: "known key", apiKey: "secret-key", want: "key:"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var client string
+			h := MiddlewareClient(keys, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				client = clientKey(r)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/articles/1", nil)
+			req.RemoteAddr = "203.0.113.7:4000"
+			if tc.apiKey != "" {
+				req.Header.Set(APIKeyHeader, tc.apiKey)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !strings.HasPrefix(client, tc.want) {
+				t.Errorf("Expected client %s but got %s", tc.want, client)
+			}
+			if tc.apiKey != "" && strings.Contains(client, tc.apiKey) {
+				t.Errorf("The client %s holds the API key", client)
+			}
+		})
+	}
+}
+
+func TestMiddlewareValidateArticle(t *testing.T) {
+	tt := []struct {
+		name           string
+		body           string
+		acceptLanguage string
+		status         int
+		detail         string
+		errors         []utils.FieldError
+	}{
+		{
+			name:   "valid",
+			body:   `{"title": "Title", "body": "Body", "date": "2016-09-22"}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "malformed",
+			body:   `{"title": `,
+			status: http.StatusBadRequest,
+			detail: "the JSON value is truncated at byte 10",
+		},
+		{
+			name:   "unknown field",
+			body:   `{"title": "Title", "body": "Body", "date": "2016-09-22", "author": "me"}`,
+			status: http.StatusBadRequest,
+			detail: `unknown field "author" at byte 57`,
+		},
+		{
+			name:   "trailing data",
+			body:   `{"title": "Title", "body": "Body", "date": "2016-09-22"} {}`,
+			status: http.StatusBadRequest,
+			detail: "unexpected data after the JSON value at byte 56",
+		},
+		{
+			name:   "invalid tag",
+			body:   `{"title": "Title", "body": "Body", "date": "2016-09-22", "tags": ["ok", "not ok"]}`,
+			status: http.StatusUnprocessableEntity,
+			errors: []utils.FieldError{{Field: "tags[1]", Rule: "tag", Message: "tags[1] must be 1 to 32 letters, digits, hyphens or underscores"}},
+		},
+		{
+			name:   "title too long",
+			body:   `{"title": "` + strings.Repeat("a", 501) + `", "body": "Body", "date": "2016-09-22"}`,
+			status: http.StatusUnprocessableEntity,
+			errors: []utils.FieldError{{Field: "title", Rule: "max", Param: "500", Message: "title must be a maximum of 500 characters in length"}},
+		},
+		{
+			name:   "missing title",
+			body:   `{"body": "Body", "date": "2016-09-22"}`,
+			status: http.StatusUnprocessableEntity,
+			errors: []utils.FieldError{{Field: "title", Rule: "required", Message: "title is a required field"}},
+		},
+		{
+			name:           "missing title in french",
+			body:           `{"body": "Body", "date": "2016-09-22"}`,
+			acceptLanguage: "fr-CA, en;q=0.5",
+			status:         http.StatusUnprocessableEntity,
+			errors:         []utils.FieldError{{Field: "title", Rule: "required", Message: "title est un champ obligatoire"}},
+		},
+	}
+
+	a := NewArticles(zap.NewNop(), nil, data.NewValidation())
+	h := a.MiddlewareValidateArticle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/articles", strings.NewReader(tc.body))
+			if tc.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tc.acceptLanguage)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("Expected status code %d but got %d", tc.status, w.Code)
+			}
+			if tc.status == http.StatusOK {
+				return
+			}
+
+			p := &utils.Problem{}
+			if err := json.NewDecoder(w.Body).Decode(p); err != nil {
+				t.Fatal(err)
+			}
+			if tc.detail != "" && p.Detail != tc.detail {
+				t.Errorf("Expected detail %q but got %q", tc.detail, p.Detail)
+			}
+			if !reflect.DeepEqual(p.Errors, tc.errors) {
+				t.Errorf("Expected field errors %+v but got %+v", tc.errors, p.Errors)
+			}
+		})
+	}
+}
+
+func TestMiddlewareBodyLimits(t *testing.T) {
+	const valid = `{"title": "Title", "body": "Body", "date": "2016-09-22"}`
+
+	tt := []struct {
+		name        string
+		contentType string
+		body        string
+		chunked     bool
+		status      int
+		problem     string
+	}{
+		{name: "valid", contentType: "application/json", body: valid, status: http.StatusOK},
+		{name: "with charset", contentType: "application/json; charset=utf-8", body: valid, status: http.StatusOK},
+		{name: "missing content type", body: valid, status: http.StatusUnsupportedMediaType, problem: problemMediaType},
+		{name: "wrong content type", contentType: "text/plain", body: valid, status: http.StatusUnsupportedMediaType, problem: problemMediaType},
+		{
+			name:        "too large",
+			contentType: "application/json",
+			body:        `{"title": "Title", "body": "` + strings.Repeat("a", 200) + `", "date": "2016-09-22"}`,
+			status:      http.StatusRequestEntityTooLarge,
+			problem:     problemTooLarge,
+		},
+		{
+			name:        "too large without length",
+			contentType: "application/json",
+			body:        `{"title": "Title", "body": "` + strings.Repeat("a", 200) + `", "date": "2016-09-22"}`,
+			chunked:     true,
+			status:      http.StatusRequestEntityTooLarge,
+			problem:     problemTooLarge,
+		},
+	}
+
+	a := NewArticles(zap.NewNop(), nil, data.NewValidation())
+	sm := mux.NewRouter()
+	sm.Use(MiddlewareContentType("application/json"), MiddlewareMaxBodySize(128), a.MiddlewareValidateArticle)
+	sm.HandleFunc("/articles", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/articles", strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			sm.ServeHTTP(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("Expected status code %d but got %d", tc.status, w.Code)
+			}
+			if tc.problem == "" {
+				return
+			}
+			p := &utils.Problem{}
+			if err := json.NewDecoder(w.Body).Decode(p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Type != tc.problem {
+				t.Errorf("Expected problem %s but got %s", tc.problem, p.Type)
+			}
+		})
+	}
+}
+
+func TestAcceptedLanguages(t *testing.T) {
+	got := AcceptedLanguages("en;q=0.3, fr-CA, pt-BR;q=0.8, *;q=0.1, de;q=0")
+	want := []string{"fr_ca", "fr", "pt_br", "pt", "en"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v but got %v", want, got)
+	}
+}
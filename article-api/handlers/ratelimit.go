@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/ratelimit"
+	"go.uber.org/zap"
+)
+
+var rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "article_api",
+	Subsystem: "ratelimit",
+	Name:      "rejected_total",
+	Help:      "Requests rejected because the client exceeded its rate limit, by route group.",
+}, []string{"group"})
+
+// MiddlewareRateLimit limits the requests every client makes to the routes
+// of group, identified by its API key or else its address. The state of its
+// bucket is sent in the RateLimit-* headers, and once it is empty requests
+// are answered with 429 Too Many Requests and a Retry-After header. If the
+// limiter fails requests are let through.
+func MiddlewareRateLimit(l *zap.Logger, lim ratelimit.Limiter, group string, limit ratelimit.Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			res, err := lim.Allow(r.Context(), group+":"+clientKey(r), limit)
+			if err != nil {
+				logging.FromContext(r.Context(), l).Warn("Rate limiter failed, allowing request", zap.Error(err))
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			h := rw.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", seconds(res.Reset))
+			if res.Allowed {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			rateLimited.WithLabelValues(group).Inc()
+			logging.FromContext(r.Context(), l).Info("Rate limit exceeded",
+				zap.String("group", group), zap.Duration("retry_after", res.RetryAfter))
+
+			h.Set("Retry-After", seconds(res.RetryAfter))
+			writeProblem(rw, r, http.StatusTooManyRequests, problemRateLimited,
+				"Too many requests, retry in "+seconds(res.RetryAfter)+" seconds.")
+		})
+	}
+}
+
+// seconds formats d as a whole number of seconds, rounded up so that a
+// client waiting that long is not refused again
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
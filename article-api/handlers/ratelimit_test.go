@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/ratelimit"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"go.uber.org/zap"
+)
+
+// failingLimiter stands in for a shared backend that is down
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(ctx context.Context, key string, l ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	limit := ratelimit.Limit{Requests: 1, Period: time.Minute, Burst: 2}
+	keys := NewAPIKeys(map[string][]string{"secret": {ScopeAdmin}})
+	h := MiddlewareClient(keys, nil)(MiddlewareRateLimit(zap.NewNop(), ratelimit.NewMemory(), "write", limit)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	tt := []struct {
+		name      string
+		apiKey    string
+		status    int
+		remaining string
+	}{
+		{"first request", "", http.StatusOK, "1"},
+		{"second request", "", http.StatusOK, "0"},
+		{"bucket empty", "", http.StatusTooManyRequests, "0"},
+		{"made up key", "made-up", http.StatusTooManyRequests, "0"},
+		{"other client", "secret", http.StatusOK, "1"},
+	}
+
+	for _, tc := range tt {
+		req := httptest.NewRequest(http.MethodPost, "/articles", nil)
+		req.RemoteAddr = "203.0.113.7:4000"
+		if tc.apiKey != "" {
+			req.Header.Set(APIKeyHeader, tc.apiKey)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status code %d but got %d", tc.name, tc.status, w.Code)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("%s: expected RateLimit-Limit 2 but got %q", tc.name, got)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != tc.remaining {
+			t.Errorf("%s: expected RateLimit-Remaining %s but got %q", tc.name, tc.remaining, got)
+		}
+
+		if tc.status == http.StatusTooManyRequests {
+			if got := w.Header().Get("Retry-After"); got != "60" {
+				t.Errorf("%s: expected Retry-After 60 but got %q", tc.name, got)
+			}
+			body := &utils.Problem{}
+			if err := json.NewDecoder(w.Body).Decode(body); err != nil || body.Status != http.StatusTooManyRequests {
+				t.Errorf("%s: expected a problem, got %q", tc.name, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestMiddlewareRateLimitFailsOpen(t *testing.T) {
+	h := MiddlewareRateLimit(zap.NewNop(), failingLimiter{}, "read", ratelimit.Limit{Requests: 1, Period: time.Second, Burst: 1})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/articles/1", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, w.Code)
+	}
+	if w.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("Expected no rate limit headers, got %v", w.Header())
+	}
+}
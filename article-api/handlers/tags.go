@@ -2,49 +2,183 @@ package handlers
 
 import (
 	"net/http"
-	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/logging"
 	"github.com/sg83/go-microservice/article-api/utils"
 	"go.uber.org/zap"
 )
 
+// GetTagSummary returns the number of articles having a tag on a day, the
+// ids of the last ones and the other tags they have.
+//
+// swagger:operation GET /v1/tags/{tag}/{date} tags GetTagSummary
+//
+// ---
+// parameters:
+//   - name: If-None-Match
+//     in: header
+//     description: Only return the summary if its ETag is none of these
+//     required: false
+//     type: string
+//   - name: If-Modified-Since
+//     in: header
+//     description: Only return the summary if one of its articles changed since this date, ignored with If-None-Match
+//     required: false
+//     type: string
+//   - name: tag
+//     in: path
+//     description: Name of the tag
+//     required: true
+//     type: string
+//   - name: date
+//     in: path
+//     description: Day of the summary, formatted as YYYYMMDD
+//     required: true
+//     type: string
+//
+// responses:
+//
+//	'200':
+//	  description: Tag summary retrieved successfully
+//	  schema:
+//	    "$ref": "#/definitions/Tag"
+//	'304':
+//	  description: Tag summary unchanged, its ETag matches If-None-Match or its articles are not modified since If-Modified-Since
+//	'400':
+//	  description: Invalid date
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'404':
+//	  description: No article has the tag on that day
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'500':
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
 func (a *Articles) GetTagSummary(w http.ResponseWriter, r *http.Request) {
-	a.l.Info("Get tag summary")
+	a.writeTagSummary(w, r, func(t data.Tag) interface{} { return t })
+}
+
+// GetTagSummaryV2 returns the summary of a tag like GetTagSummary, with the
+// ids of the articles as strings.
+//
+// swagger:operation GET /v2/tags/{tag}/{date} tags GetTagSummaryV2
+//
+// ---
+// parameters:
+//   - name: If-None-Match
+//     in: header
+//     description: Only return the summary if its ETag is none of these
+//     required: false
+//     type: string
+//   - name: If-Modified-Since
+//     in: header
+//     description: Only return the summary if one of its articles changed since this date, ignored with If-None-Match
+//     required: false
+//     type: string
+//   - name: tag
+//     in: path
+//     description: Name of the tag
+//     required: true
+//     type: string
+//   - name: date
+//     in: path
+//     description: Day of the summary, formatted as YYYYMMDD
+//     required: true
+//     type: string
+//
+// responses:
+//
+//	'200':
+//	  description: Tag summary retrieved successfully
+//	  schema:
+//	    "$ref": "#/definitions/TagV2"
+//	'304':
+//	  description: Tag summary unchanged, its ETag matches If-None-Match or its articles are not modified since If-Modified-Since
+//	'400':
+//	  description: Invalid date
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'404':
+//	  description: No article has the tag on that day
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'500':
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+func (a *Articles) GetTagSummaryV2(w http.ResponseWriter, r *http.Request) {
+	a.writeTagSummary(w, r, func(t data.Tag) interface{} { return t.V2() })
+}
+
+// writeTagSummary writes the summary of the tag and date of the request, in
+// the representation returned by present
+func (a *Articles) writeTagSummary(w http.ResponseWriter, r *http.Request, present func(data.Tag) interface{}) {
+	l := logging.FromContext(r.Context(), a.l)
+
+	l.Info("Get tag summary")
 	vars := mux.Vars(r)
 	tag := vars["tag"]
 	dateStr := vars["date"]
 
-	a.l.Info("Get tag summary", zap.String("tag:", tag), zap.String("date:", dateStr))
+	l.Info("Get tag summary", zap.String("tag:", tag), zap.String("date:", dateStr))
 
-	re := regexp.MustCompile(`^(20[0-2][0-3]|1[2-9]|[2-9]\d)(\d{2})(0[1-9]|1[0-2])(0[1-9]|[12]\d|3[01])$`)
-	if !re.MatchString(dateStr) {
-		a.l.Error("Date is not valid", zap.String("date:", dateStr))
-		http.Error(w, "Date is not valid", http.StatusBadRequest)
+	if !data.ValidTagDate(dateStr) {
+		l.Error("Date is not valid", zap.String("date:", dateStr))
+		writeError(w, r, l, &data.InvalidError{Reason: "date " + dateStr + " is not a valid YYYYMMDD date"})
 		return
 	}
 
-	articlesIds, err := a.db.GetArticlesForTagAndDate(tag, dateStr)
-
-	if (err != nil) || (len(articlesIds) == 0) {
-		a.l.Error("Articles with given tag not found")
-		http.Error(w, "Articles with given tag not found", http.StatusNotFound)
-		w.WriteHeader(http.StatusInternalServerError)
-		utils.ToJSON(&utils.GenericError{Message: "Articles with given tag not found"}, w)
+	articlesIds, err := a.db.GetArticlesForTagAndDate(r.Context(), tag, dateStr)
+	if err != nil {
+		writeError(w, r, l, err)
 		return
 	}
-	a.l.Info("Get tag summary", zap.Any("Articles with tag:", articlesIds))
+	if len(articlesIds) == 0 {
+		l.Error("Articles with given tag not found")
+		writeError(w, r, l, &data.NotFoundError{Resource: "articles with tag", Key: tag + " on " + dateStr})
+		return
+	}
+	l.Info("Get tag summary", zap.Any("Articles with tag:", articlesIds))
+
+	// the related tags are only worth looking up if the client's copy is
+	// stale. The last modification alone misses the articles that lost the
+	// tag, so the ETag identifies the summary by its articles as well, and
+	// Last-Modified serves the clients that only revalidate by date.
+	modified, err := a.db.GetTagLastModified(r.Context(), tag, dateStr)
+	if err != nil {
+		l.Warn("Could not get last modification of tag", zap.Error(err))
+		notModified(w, r, time.Time{}, tagMaxAge)
+	} else {
+		etag := []string{modified.UTC().Format(time.RFC3339Nano)}
+		for _, id := range articlesIds {
+			etag = append(etag, strconv.Itoa(id))
+		}
+		// set ahead of noneMatch so that its 304 carries it too
+		if !modified.IsZero() {
+			w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		}
+		if noneMatch(w, r, tagMaxAge, etag...) || notModified(w, r, modified, tagMaxAge) {
+			return
+		}
+	}
 
-	relatedTags, err := a.db.GetRelatedTagsForTag(tag, articlesIds)
-	if (err != nil) || (len(relatedTags) == 0) {
-		a.l.Error("Related tags not found")
-		http.Error(w, "Related tags not found", http.StatusNotFound)
-		w.WriteHeader(http.StatusInternalServerError)
-		utils.ToJSON(&utils.GenericError{Message: "Related tags not found"}, w)
+	relatedTags, err := a.db.GetRelatedTagsForTag(r.Context(), tag, articlesIds)
+	if err != nil {
+		writeError(w, r, l, err)
+		return
+	}
+	if len(relatedTags) == 0 {
+		l.Error("Related tags not found")
+		writeError(w, r, l, &data.NotFoundError{Resource: "related tags of", Key: tag + " on " + dateStr})
 		return
 	}
-	a.l.Info("Get tag summary", zap.Any("Related tags:", relatedTags))
+	l.Info("Get tag summary", zap.Any("Related tags:", relatedTags))
 
 	tagSummary := data.Tag{
 		Tag:         tag,
@@ -54,10 +188,10 @@ func (a *Articles) GetTagSummary(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	err = utils.ToJSON(tagSummary, w)
+	err = utils.ToJSON(present(tagSummary), w)
 	if err != nil {
 		// we should never be here but log the error just incase
-		a.l.Error("Unable to serialize tagSummary", zap.String(" Error: ", err.Error()))
+		l.Error("Unable to serialize tagSummary", zap.String(" Error: ", err.Error()))
 		return
 	}
 }
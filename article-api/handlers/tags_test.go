@@ -7,11 +7,14 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sg83/go-microservice/article-api/data"
 	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/stretchr/testify/mock"
 	"go.uber.org/zap"
 )
 
@@ -81,8 +84,9 @@ func TestGetTagSummary(t *testing.T) {
 
 		// create a mock Articles struct with a mock database interface
 		mockdb := new(mocks.ArticlesData)
-		mockdb.On("GetArticlesForTagAndDate", tc.tag_name, tc.date).Return(tc.tagSummary.Articles, nil)
-		mockdb.On("GetRelatedTagsForTag", tc.tag_name, tc.tagSummary.Articles).Return(tc.tagSummary.RelatedTags, err)
+		mockdb.On("GetArticlesForTagAndDate", mock.Anything, tc.tag_name, tc.date).Return(tc.tagSummary.Articles, nil)
+		mockdb.On("GetTagLastModified", mock.Anything, tc.tag_name, tc.date).Return(time.Time{}, nil)
+		mockdb.On("GetRelatedTagsForTag", mock.Anything, tc.tag_name, tc.tagSummary.Articles).Return(tc.tagSummary.RelatedTags, err)
 
 		articles := &Articles{logger, mockdb, nil}
 
@@ -116,3 +120,102 @@ func TestGetTagSummary(t *testing.T) {
 		t.Logf("Test completed for tag %s", tc.tag_name)
 	}
 }
+
+func TestGetTagSummaryNotModified(t *testing.T) {
+	modified := time.Date(2022, 5, 12, 10, 30, 0, 0, time.UTC)
+
+	// summary returns the response to a request for the summary of the
+	// articles ids last modified at m, with the If-None-Match header inm and
+	// the If-Modified-Since header ims
+	summary := func(ids []int, m time.Time, inm, ims string) *httptest.ResponseRecorder {
+		mockdb := &mocks.ArticlesData{}
+		mockdb.On("GetArticlesForTagAndDate", mock.Anything, "health", "20220512").Return(ids, nil)
+		mockdb.On("GetTagLastModified", mock.Anything, "health", "20220512").Return(m, nil)
+		mockdb.On("GetRelatedTagsForTag", mock.Anything, "health", ids).Return([]string{"yoga"}, nil)
+		articles := &Articles{zap.NewNop(), mockdb, nil}
+
+		req := httptest.NewRequest(http.MethodGet, "/tags/health/20220512", nil)
+		if inm != "" {
+			req.Header.Set("If-None-Match", inm)
+		}
+		if ims != "" {
+			req.Header.Set("If-Modified-Since", ims)
+		}
+		req = mux.SetURLVars(req, map[string]string{"tag": "health", "date": "20220512"})
+		w := httptest.NewRecorder()
+		articles.GetTagSummary(w, req)
+		return w
+	}
+	etag := summary([]int{1, 3}, modified, "", "").Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("Expected a weak ETag but got %q", etag)
+	}
+
+	tt := []struct {
+		name     string
+		ids      []int
+		modified time.Time
+		inm      string
+		ims      string
+		status   int
+	}{
+		{"no condition", []int{1, 3}, modified, "", "", http.StatusOK},
+		{"unchanged", []int{1, 3}, modified, etag, "", http.StatusNotModified},
+		{"unchanged, strong comparison", []int{1, 3}, modified, strings.TrimPrefix(etag, "W/"), "", http.StatusNotModified},
+		{"one of several", []int{1, 3}, modified, `"other", ` + etag, "", http.StatusNotModified},
+		{"any", []int{1, 3}, modified, "*", "", http.StatusNotModified},
+		{"article written since", []int{1, 3}, modified.Add(time.Hour), etag, "", http.StatusOK},
+		// the remaining articles were not written since
+		{"article lost the tag", []int{1}, modified, etag, "", http.StatusOK},
+		{"other etag", []int{1, 3}, modified, `W/"other"`, "", http.StatusOK},
+		{"not modified since", []int{1, 3}, modified, "", "Thu, 12 May 2022 10:30:00 GMT", http.StatusNotModified},
+		{"not modified since a later date", []int{1, 3}, modified, "", "Thu, 12 May 2022 11:00:00 GMT", http.StatusNotModified},
+		{"modified since", []int{1, 3}, modified.Add(time.Hour), "", "Thu, 12 May 2022 10:30:00 GMT", http.StatusOK},
+		{"invalid date", []int{1, 3}, modified, "", "yesterday", http.StatusOK},
+		// If-None-Match takes precedence over If-Modified-Since
+		{"other etag, not modified since", []int{1, 3}, modified, `W/"other"`, "Thu, 12 May 2022 10:30:00 GMT", http.StatusOK},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := summary(tc.ids, tc.modified, tc.inm, tc.ims)
+			if w.Code != tc.status {
+				t.Fatalf("Expected status code %d but got %d", tc.status, w.Code)
+			}
+			unchanged := tc.modified.Equal(modified) && len(tc.ids) == 2
+			if got := w.Header().Get("ETag"); (got == etag) != unchanged {
+				t.Errorf("Unexpected ETag %q", got)
+			}
+			if got, want := w.Header().Get("Last-Modified"), tc.modified.Format(http.TimeFormat); got != want {
+				t.Errorf("Expected Last-Modified %q but got %q", want, got)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "public, max-age=60" {
+				t.Errorf("Unexpected Cache-Control %q", got)
+			}
+			if tc.status == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Errorf("Expected no body but got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetTagSummaryV2(t *testing.T) {
+	mockdb := mocks.NewArticlesData(t)
+	mockdb.On("GetArticlesForTagAndDate", mock.Anything, "health", "20220512").Return([]int{1, 3}, nil)
+	mockdb.On("GetTagLastModified", mock.Anything, "health", "20220512").Return(time.Time{}, nil)
+	mockdb.On("GetRelatedTagsForTag", mock.Anything, "health", []int{1, 3}).Return([]string{"yoga"}, nil)
+	articles := &Articles{zap.NewNop(), mockdb, nil}
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/tags/health/20220512", nil)
+	req = mux.SetURLVars(req, map[string]string{"tag": "health", "date": "20220512"})
+	w := httptest.NewRecorder()
+	articles.GetTagSummaryV2(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, w.Code)
+	}
+	expected := `{"tag":"health","count":2,"articles":["1","3"],"related_tags":["yoga"]}`
+	if got := strings.TrimSpace(w.Body.String()); got != expected {
+		t.Errorf("Expected tag summary %s but got %s", expected, got)
+	}
+}
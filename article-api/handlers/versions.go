@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var legacyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "article_api",
+	Subsystem: "legacy",
+	Name:      "requests_total",
+	Help:      "Requests served by the deprecated unversioned routes, by method and route.",
+}, []string{"method", "route"})
+
+// MiddlewareDeprecation marks the responses of deprecated routes, aliases of
+// the routes under successor, with a Deprecation header holding the date
+// they were deprecated on, a Sunset header holding the date they will be
+// removed on unless it is zero, and a Link to the same route under
+// successor. Their requests are counted so that clients still using them
+// can be told apart before the sunset.
+func MiddlewareDeprecation(deprecated, sunset time.Time, successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			h := rw.Header()
+			h.Set("Deprecation", "@"+strconv.FormatInt(deprecated.Unix(), 10))
+			if !sunset.IsZero() {
+				h.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			h.Add("Link", "<"+successor+r.URL.EscapedPath()+`>; rel="successor-version"`)
+
+			// the template, not the path, keeps the number of series bounded
+			route := "unknown"
+			if cr := mux.CurrentRoute(r); cr != nil {
+				if tpl, err := cr.GetPathTemplate(); err == nil {
+					route = tpl
+				}
+			}
+			legacyRequests.WithLabelValues(r.Method, route).Inc()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
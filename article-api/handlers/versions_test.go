@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMiddlewareDeprecation(t *testing.T) {
+	deprecated := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	tt := []struct {
+		name   string
+		sunset time.Time
+		path   string
+		want   map[string]string
+	}{
+		{
+			name: "no sunset",
+			path: "/articles/1",
+			want: map[string]string{
+				"Deprecation": "@1792368000",
+				"Sunset":      "",
+				"Link":        `</v1/articles/1>; rel="successor-version"`,
+			},
+		},
+		{
+			name:   "sunset",
+			sunset: time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC),
+			path:   "/tags/health%2Ffitness/20160922",
+			want: map[string]string{
+				"Deprecation": "@1792368000",
+				"Sunset":      "Thu, 01 Apr 2027 00:00:00 GMT",
+				"Link":        `</v1/tags/health%2Ffitness/20160922>; rel="successor-version"`,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			sm := mux.NewRouter().UseEncodedPath()
+			sm.HandleFunc("/articles/{id}", func(w http.ResponseWriter, r *http.Request) {})
+			sm.HandleFunc("/tags/{tag}/{date}", func(w http.ResponseWriter, r *http.Request) {})
+			sm.Use(MiddlewareDeprecation(deprecated, tc.sunset, "/v1"))
+
+			w := httptest.NewRecorder()
+			sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d but got %d", http.StatusOK, w.Code)
+			}
+			for k, v := range tc.want {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("Expected %s %q but got %q", k, v, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"go.uber.org/zap"
+)
+
+// Number of dead letters listed by default, and at most
+const (
+	defaultDeadLetters = 100
+	maxDeadLetters     = 1000
+)
+
+// Webhooks manages the webhooks article events are delivered to
+type Webhooks struct {
+	l  *zap.Logger
+	db data.WebhooksData
+	v  *data.Validation
+}
+
+func NewWebhooks(l *zap.Logger, db data.WebhooksData, v *data.Validation) *Webhooks {
+	return &Webhooks{l, db, v}
+}
+
+// List returns every webhook, without their secrets.
+//
+// swagger:operation GET /v1/webhooks webhooks ListWebhooks
+//
+// ---
+// parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: API key granting the admin scope
+//     required: true
+//     type: string
+//
+// responses:
+//
+//	'200':
+//	  description: The webhooks, in id order
+//	  schema:
+//	    type: array
+//	    items:
+//	      "$ref": "#/definitions/Webhook"
+//	'401':
+//	  description: Missing or unknown API key
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'403':
+//	  description: The API key does not grant the admin scope
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'500':
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+func (wh *Webhooks) List(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context(), wh.l)
+
+	whs, err := wh.db.GetWebhooks(r.Context())
+	if err != nil {
+		writeError(w, r, l, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := utils.ToJSON(whs, w); err != nil {
+		l.Error("Unable to serialize webhooks", zap.Error(err))
+	}
+}
+
+// Create registers a webhook. Events recorded from then on are posted to
+// its URL, signed with its secret.
+//
+// swagger:operation POST /v1/webhooks webhooks CreateWebhook
+//
+// ---
+//
+//	parameters:
+//	  - name: X-API-Key
+//	    in: header
+//	    description: API key granting the admin scope
+//	    required: true
+//	    type: string
+//	  - name: webhook
+//	    in: body
+//	    description: Webhook to register, its secret is generated when missing
+//	    required: true
+//	    schema:
+//	      "$ref": "#/definitions/Webhook"
+//
+//	responses:
+//	  '201':
+//	    description: Webhook registered, with its secret
+//	    schema:
+//	      "$ref": "#/definitions/Webhook"
+//	  '400':
+//	    description: Malformed request body
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
+//	  '401':
+//	    description: Missing or unknown API key
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
+//	  '403':
+//	    description: The API key does not grant the admin scope
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
+//	  '422':
+//	    description: Webhook failed validation
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
+//	  '500':
+//	    description: Internal server error
+//	    schema:
+//	      "$ref": "#/definitions/Problem"
+func (wh *Webhooks) Create(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context(), wh.l)
+
+	hook := data.Webhook{}
+	if err := utils.FromJSON(&hook, r.Body); err != nil {
+		writeError(w, r, l, err)
+		return
+	}
+	if errs := wh.v.Validate(&hook, AcceptedLanguages(r.Header.Get("Accept-Language"))...); len(errs) != 0 {
+		writeError(w, r, l, errs)
+		return
+	}
+	hook.ID, hook.CreatedAt = 0, nil
+	if hook.Secret == "" {
+		secret, err := newSecret()
+		if err != nil {
+			writeError(w, r, l, err)
+			return
+		}
+		hook.Secret = secret
+	}
+	if hook.Events == nil {
+		hook.Events = []string{}
+	}
+
+	if err := wh.db.AddWebhook(r.Context(), &hook); err != nil {
+		writeError(w, r, l, err)
+		return
+	}
+	l.Info("Registered webhook", zap.Int("id", hook.ID), zap.String("url", hook.URL))
+
+	w.Header().Set("Content-Type", "application/json")
+	// under the version of the API the webhook was registered through
+	w.Header().Set("Location", r.URL.Path+"/"+strconv.Itoa(hook.ID))
+	w.WriteHeader(http.StatusCreated)
+	if err := utils.ToJSON(&hook, w); err != nil {
+		l.Error("Unable to serialize webhook", zap.Error(err))
+	}
+}
+
+// Delete removes a webhook, its pending deliveries are dropped.
+//
+// swagger:operation DELETE /v1/webhooks/{id} webhooks DeleteWebhook
+//
+// ---
+// parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: API key granting the admin scope
+//     required: true
+//     type: string
+//   - name: id
+//     in: path
+//     description: ID of the webhook to remove
+//     required: true
+//     type: integer
+//
+// responses:
+//
+//	'204':
+//	  description: Webhook removed
+//	'401':
+//	  description: Missing or unknown API key
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'403':
+//	  description: The API key does not grant the admin scope
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'404':
+//	  description: Webhook not found
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'500':
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+func (wh *Webhooks) Delete(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context(), wh.l)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, l, &data.InvalidError{Reason: "webhook id " + mux.Vars(r)["id"] + " is out of range", Err: err})
+		return
+	}
+	if err := wh.db.DeleteWebhook(r.Context(), id); err != nil {
+		writeError(w, r, l, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeadLetters returns the deliveries that failed too many times to be
+// attempted again, the most recently failed first.
+//
+// swagger:operation GET /v1/webhooks/dead-letters webhooks DeadLetters
+//
+// ---
+// parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: API key granting the admin scope
+//     required: true
+//     type: string
+//   - name: limit
+//     in: query
+//     description: Max number of deliveries returned, 100 by default and 1000 at most
+//     required: false
+//     type: integer
+//
+// responses:
+//
+//	'200':
+//	  description: The dead deliveries, with their event and last error
+//	  schema:
+//	    type: array
+//	    items:
+//	      "$ref": "#/definitions/Delivery"
+//	'400':
+//	  description: Invalid limit
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'401':
+//	  description: Missing or unknown API key
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'403':
+//	  description: The API key does not grant the admin scope
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'500':
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+func (wh *Webhooks) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context(), wh.l)
+
+	limit := defaultDeadLetters
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDeadLetters {
+			writeError(w, r, l, &data.InvalidError{Reason: "limit " + v + " is not a number from 1 to " + strconv.Itoa(maxDeadLetters)})
+			return
+		}
+		limit = n
+	}
+
+	ds, err := wh.db.GetDeadLetters(r.Context(), limit)
+	if err != nil {
+		writeError(w, r, l, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := utils.ToJSON(ds, w); err != nil {
+		l.Error("Unable to serialize dead letters", zap.Error(err))
+	}
+}
+
+// Retry queues a dead delivery again, with a fresh number of attempts.
+//
+// swagger:operation POST /v1/webhooks/dead-letters/{id}/retry webhooks RetryDeadLetter
+//
+// ---
+// parameters:
+//   - name: X-API-Key
+//     in: header
+//     description: API key granting the admin scope
+//     required: true
+//     type: string
+//   - name: id
+//     in: path
+//     description: ID of the dead delivery
+//     required: true
+//     type: integer
+//
+// responses:
+//
+//	'202':
+//	  description: Delivery queued
+//	'401':
+//	  description: Missing or unknown API key
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'403':
+//	  description: The API key does not grant the admin scope
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'404':
+//	  description: No dead delivery has this id
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+//	'500':
+//	  description: Internal server error
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+func (wh *Webhooks) Retry(w http.ResponseWriter, r *http.Request) {
+	l := logging.FromContext(r.Context(), wh.l)
+
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, r, l, &data.InvalidError{Reason: "delivery id " + mux.Vars(r)["id"] + " is out of range", Err: err})
+		return
+	}
+	if err := wh.db.RetryDeadLetter(r.Context(), id); err != nil {
+		writeError(w, r, l, err)
+		return
+	}
+	l.Info("Retrying dead letter", zap.Int64("id", id))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// newSecret returns a random webhook secret
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/sg83/go-microservice/article-api/utils"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+// checkProblem fails the test unless w holds a problem of type typ
+func checkProblem(t *testing.T, w *httptest.ResponseRecorder, typ string) {
+	t.Helper()
+	p := &utils.Problem{}
+	if err := json.NewDecoder(w.Body).Decode(p); err != nil || p.Type != typ {
+		t.Errorf("Expected problem %s, got %q", typ, w.Body.String())
+	}
+}
+
+func TestCreateWebhook(t *testing.T) {
+	tt := []struct {
+		name    string
+		body    string
+		secret  string
+		events  []string
+		status  int
+		problem string
+	}{
+		{
+			name:   "every event",
+			body:   `{"url": "https://example.com/hooks"}`,
+			events: []string{},
+			status: http.StatusCreated,
+		},
+		{
+			name:   "given secret and events",
+			body:   `{"url": "https://example.com/hooks", "events": ["article.created"], "secret": "0123456789abcdef"}`,
+			secret: "0123456789abcdef",
+			events: []string{data.EventArticleCreated},
+			status: http.StatusCreated,
+		},
+		{
+			name:    "invalid url",
+			body:    `{"url": "example.com/hooks"}`,
+			status:  http.StatusUnprocessableEntity,
+			problem: problemValidation,
+		},
+		{
+			name:    "unknown event",
+			body:    `{"url": "https://example.com/hooks", "events": ["article.read"]}`,
+			status:  http.StatusUnprocessableEntity,
+			problem: problemValidation,
+		},
+		{
+			name:    "unknown field",
+			body:    `{"url": "https://example.com/hooks", "active": true}`,
+			status:  http.StatusBadRequest,
+			problem: problemMalformed,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := new(mocks.WebhooksData)
+			db.On("AddWebhook", mock.Anything, mock.Anything).Return(func(ctx context.Context, wh *data.Webhook) error {
+				created := time.Date(2023, 4, 5, 10, 4, 11, 0, time.UTC)
+				wh.ID, wh.CreatedAt = 7, &created
+				return nil
+			})
+			wh := NewWebhooks(zap.NewNop(), db, data.NewValidation())
+
+			req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			wh.Create(w, req)
+
+			if w.Code != tc.status {
+				t.Fatalf("Expected status code %d but got %d: %s", tc.status, w.Code, w.Body.String())
+			}
+			if tc.problem != "" {
+				checkProblem(t, w, tc.problem)
+				db.AssertNotCalled(t, "AddWebhook", mock.Anything, mock.Anything)
+				return
+			}
+
+			if loc := w.Header().Get("Location"); loc != "/webhooks/7" {
+				t.Errorf("Expected location /webhooks/7 but got %q", loc)
+			}
+			got := data.Webhook{}
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != 7 || got.URL != "https://example.com/hooks" || len(got.Events) != len(tc.events) {
+				t.Errorf("Unexpected webhook %+v", got)
+			}
+			switch {
+			case tc.secret != "" && got.Secret != tc.secret:
+				t.Errorf("Expected secret %q but got %q", tc.secret, got.Secret)
+			case tc.secret == "" && len(got.Secret) != 64:
+				t.Errorf("Expected a generated secret but got %q", got.Secret)
+			}
+		})
+	}
+}
+
+func TestListWebhooks(t *testing.T) {
+	whs := []data.Webhook{{ID: 1, URL: "https://example.com/hooks", Events: []string{}}}
+	db := new(mocks.WebhooksData)
+	db.On("GetWebhooks", mock.Anything).Return(whs, nil)
+	wh := NewWebhooks(zap.NewNop(), db, data.NewValidation())
+
+	w := httptest.NewRecorder()
+	wh.List(w, httptest.NewRequest(http.MethodGet, "/webhooks", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200 but got %d", w.Code)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != `[{"id":1,"url":"https://example.com/hooks","events":[]}]` {
+		t.Errorf("Unexpected body %s", body)
+	}
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	db := new(mocks.WebhooksData)
+	db.On("DeleteWebhook", mock.Anything, 1).Return(nil)
+	db.On("DeleteWebhook", mock.Anything, 2).Return(&data.NotFoundError{Resource: "webhook", Key: "2"})
+	wh := NewWebhooks(zap.NewNop(), db, data.NewValidation())
+
+	for id, status := range map[string]int{"1": http.StatusNoContent, "2": http.StatusNotFound} {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/webhooks/"+id, nil), map[string]string{"id": id})
+		w := httptest.NewRecorder()
+		wh.Delete(w, req)
+		if w.Code != status {
+			t.Errorf("Deleting webhook %s: expected status code %d but got %d", id, status, w.Code)
+		}
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	dead := []data.Delivery{{
+		ID: 3, WebhookID: 1, Status: data.DeliveryDead, Attempts: 8, LastError: "webhook answered 500 Internal Server Error",
+		Event: data.Event{ID: 9, Type: data.EventArticleCreated, Data: json.RawMessage(`{"id":1}`)},
+	}}
+
+	tt := []struct {
+		query  string
+		limit  int
+		status int
+	}{
+		{"", defaultDeadLetters, http.StatusOK},
+		{"?limit=10", 10, http.StatusOK},
+		{"?limit=0", 0, http.StatusBadRequest},
+		{"?limit=1001", 0, http.StatusBadRequest},
+		{"?limit=all", 0, http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.query, func(t *testing.T) {
+			db := new(mocks.WebhooksData)
+			db.On("GetDeadLetters", mock.Anything, tc.limit).Return(dead, nil)
+			wh := NewWebhooks(zap.NewNop(), db, data.NewValidation())
+
+			w := httptest.NewRecorder()
+			wh.DeadLetters(w, httptest.NewRequest(http.MethodGet, "/webhooks/dead-letters"+tc.query, nil))
+
+			if w.Code != tc.status {
+				t.Fatalf("Expected status code %d but got %d", tc.status, w.Code)
+			}
+			if tc.status != http.StatusOK {
+				checkProblem(t, w, problemInvalid)
+				return
+			}
+			var got []data.Delivery
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0].ID != 3 || got[0].Event.ID != 9 || got[0].LastError != dead[0].LastError {
+				t.Errorf("Unexpected dead letters %+v", got)
+			}
+		})
+	}
+}
+
+func TestRetryDeadLetter(t *testing.T) {
+	db := new(mocks.WebhooksData)
+	db.On("RetryDeadLetter", mock.Anything, int64(3)).Return(nil)
+	db.On("RetryDeadLetter", mock.Anything, int64(4)).Return(&data.NotFoundError{Resource: "dead letter", Key: "4"})
+	wh := NewWebhooks(zap.NewNop(), db, data.NewValidation())
+
+	for id, status := range map[string]int{"3": http.StatusAccepted, "4": http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/dead-letters/"+id+"/retry", nil)
+		w := httptest.NewRecorder()
+		wh.Retry(w, mux.SetURLVars(req, map[string]string{"id": id}))
+		if w.Code != status {
+			t.Errorf("Retrying dead letter %s: expected status code %d but got %d", id, status, w.Code)
+		}
+	}
+}
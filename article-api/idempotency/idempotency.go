@@ -0,0 +1,104 @@
+// Package idempotency remembers the responses of requests sent with an
+// idempotency key, so that a client retrying the request gets the first
+// response back instead of having it applied twice. Records are kept in
+// process or shared between replicas through a Redis protocol server.
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Record is what is known of a key
+type Record struct {
+	// digest of the request that claimed the key
+	Fingerprint string `json:"fingerprint"`
+	// false while the first request is being handled
+	Done bool `json:"done"`
+	// the response to the first request, once done
+	Status int         `json:"status,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// Store keeps the records of idempotency keys
+type Store interface {
+	// Reserve claims key for the request with the given fingerprint for
+	// ttl. If the key is already claimed its record is returned and the
+	// key is left untouched, otherwise the returned record is nil.
+	Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*Record, error)
+	// Complete stores the response to the request that claimed key, for ttl
+	Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error
+	// Release forgets key, so that the request can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// sweepInterval is how often the memory store forgets expired records
+const sweepInterval = time.Minute
+
+// Memory keeps the records in process, keys are only honoured when retries
+// reach the same replica
+type Memory struct {
+	mu        sync.Mutex
+	records   map[string]memoryRecord
+	lastSweep time.Time
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+type memoryRecord struct {
+	Record
+	expires time.Time
+}
+
+// NewMemory creates an in process store
+func NewMemory() *Memory {
+	return &Memory{records: map[string]memoryRecord{}, now: time.Now}
+}
+
+func (m *Memory) Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		m.sweep(now)
+		m.lastSweep = now
+	}
+
+	if r, ok := m.records[key]; ok && now.Before(r.expires) {
+		rec := r.Record
+		return &rec, nil
+	}
+	m.records[key] = memoryRecord{Record{Fingerprint: fingerprint}, now.Add(ttl)}
+	return nil, nil
+}
+
+func (m *Memory) Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec.Done = true
+	m.records[key] = memoryRecord{rec, m.now().Add(ttl)}
+	return nil
+}
+
+func (m *Memory) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
+
+// sweep drops the expired records. m.mu must be held.
+func (m *Memory) sweep(now time.Time) {
+	for k, r := range m.records {
+		if !now.Before(r.expires) {
+			delete(m.records, k)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/redis"
+	"github.com/sg83/go-microservice/article-api/redis/redistest"
+)
+
+// testStore runs the same scenario against every store
+func testStore(t *testing.T, s Store) {
+	t.Helper()
+	ctx := context.Background()
+
+	rec, err := s.Reserve(ctx, "a", "fp1", time.Minute)
+	if err != nil || rec != nil {
+		t.Fatalf("Expected the key to be reserved, got %+v, %v", rec, err)
+	}
+
+	// a concurrent retry sees the pending request
+	rec, err = s.Reserve(ctx, "a", "fp1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec == nil || rec.Done || rec.Fingerprint != "fp1" {
+		t.Fatalf("Expected a pending record, got %+v", rec)
+	}
+
+	done := Record{
+		Fingerprint: "fp1",
+		Status:      http.StatusCreated,
+		Header:      http.Header{"Content-Type": {"application/json"}},
+		Body:        []byte(`{"id":1}`),
+	}
+	if err := s.Complete(ctx, "a", done, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	rec, err = s.Reserve(ctx, "a", "fp2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done.Done = true
+	if !reflect.DeepEqual(rec, &done) {
+		t.Errorf("Expected the stored response %+v but got %+v", done, rec)
+	}
+
+	// released keys can be claimed again
+	if _, err := s.Reserve(ctx, "b", "fp1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Release(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	rec, err = s.Reserve(ctx, "b", "fp1", time.Minute)
+	if err != nil || rec != nil {
+		t.Errorf("Expected a released key to be reserved again, got %+v, %v", rec, err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+
+	testStore(t, m)
+
+	// records expire
+	now = now.Add(2 * time.Hour)
+	rec, err := m.Reserve(context.Background(), "a", "fp2", time.Minute)
+	if err != nil || rec != nil {
+		t.Errorf("Expected an expired key to be reserved again, got %+v, %v", rec, err)
+	}
+	if len(m.records) != 1 {
+		t.Errorf("Expected expired records to be swept, %d left", len(m.records))
+	}
+}
+
+func TestRedis(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c := redis.New(redis.Options{Addr: s.Addr()})
+	defer c.Close()
+
+	testStore(t, NewRedis(c, "idempotency:"))
+
+	keys := s.Keys()
+	if len(keys) != 2 || keys[0] != "idempotency:a" || keys[1] != "idempotency:b" {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+}
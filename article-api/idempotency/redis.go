@@ -0,0 +1,77 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/redis"
+)
+
+// Redis keeps the records on a Redis protocol server shared by replicas,
+// JSON encoded
+type Redis struct {
+	c      *redis.Client
+	prefix string
+}
+
+// NewRedis creates a store keeping its records under keys starting with
+// prefix
+func NewRedis(c *redis.Client, prefix string) *Redis {
+	return &Redis{c: c, prefix: prefix}
+}
+
+func (r *Redis) Reserve(ctx context.Context, key string, fingerprint string, ttl time.Duration) (*Record, error) {
+	b, err := json.Marshal(Record{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+
+	// the key may expire between SET and GET, try again once if so
+	for i := 0; i < 2; i++ {
+		_, err := r.c.Do(ctx, "SET", r.prefix+key, b, "NX", "PX", millis(ttl))
+		if err == nil {
+			return nil, nil
+		}
+		if err != redis.Nil {
+			return nil, err
+		}
+
+		v, err := redis.String(r.c.Do(ctx, "GET", r.prefix+key))
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec := &Record{}
+		if err := json.Unmarshal([]byte(v), rec); err != nil {
+			return nil, err
+		}
+		return rec, nil
+	}
+	return nil, redis.Nil
+}
+
+func (r *Redis) Complete(ctx context.Context, key string, rec Record, ttl time.Duration) error {
+	rec.Done = true
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = r.c.Do(ctx, "SET", r.prefix+key, b, "PX", millis(ttl))
+	return err
+}
+
+func (r *Redis) Release(ctx context.Context, key string) error {
+	_, err := r.c.Do(ctx, "DEL", r.prefix+key)
+	return err
+}
+
+// millis converts ttl to a PX argument, which must be positive
+func millis(ttl time.Duration) int64 {
+	if ms := ttl.Milliseconds(); ms > 0 {
+		return ms
+	}
+	return 1
+}
@@ -0,0 +1,34 @@
+// Package backoff spaces out the retries of the database, webhook and client
+// calls the same way.
+package backoff
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Delay returns the delay before retry n (starting at 0), doubling from
+// initial up to max with jitter so that callers do not retry in lockstep
+func Delay(n int, initial, max time.Duration) time.Duration {
+	d := initial
+	for i := 0; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Sleep waits for d or until ctx is done
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package backoff
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	initial, max := 100*time.Millisecond, time.Second
+
+	for n := 0; n < 10; n++ {
+		d := Delay(n, initial, max)
+		if d > max {
+			t.Errorf("Delay(%d) = %v exceeds max %v", n, d, max)
+		}
+		if d < initial/2 {
+			t.Errorf("Delay(%d) = %v is below half the initial delay", n, d)
+		}
+	}
+}
+
+func TestSleep(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tt := []struct {
+		name string
+		ctx  context.Context
+		d    time.Duration
+		err  error
+	}{
+		{"waits", context.Background(), time.Millisecond, nil},
+		{"stops when the context is done", ctx, time.Hour, context.Canceled},
+	}
+
+	for _, tc := range tt {
+		if err := Sleep(tc.ctx, tc.d); err != tc.err {
+			t.Errorf("%s: expected %v but got %v", tc.name, tc.err, err)
+		}
+	}
+}
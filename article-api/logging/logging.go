@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/sg83/go-microservice/article-api/tracing"
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// WithLogger returns a copy of ctx carrying the request-scoped logger l
+func WithLogger(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the request-scoped logger stored in ctx, or fallback
+// if there is none, annotated with the trace and span ids found in ctx
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	l, ok := ctx.Value(loggerKey{}).(*zap.Logger)
+	if !ok {
+		l = fallback
+	}
+
+	fields := tracing.Fields(ctx)
+	if fields == nil {
+		return l
+	}
+	return l.With(fields...)
+}
+
+// WithRequestID returns a copy of ctx carrying the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id stored in ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
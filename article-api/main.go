@@ -3,88 +3,315 @@ package main
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	gohandlers "github.com/gorilla/handlers"
-	"github.com/gorilla/mux"
+	"github.com/sg83/go-microservice/article-api/cache"
+	"github.com/sg83/go-microservice/article-api/config"
 	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/events"
+	"github.com/sg83/go-microservice/article-api/graphql"
 	"github.com/sg83/go-microservice/article-api/handlers"
+	"github.com/sg83/go-microservice/article-api/idempotency"
+	"github.com/sg83/go-microservice/article-api/openapi"
+	"github.com/sg83/go-microservice/article-api/ratelimit"
+	"github.com/sg83/go-microservice/article-api/redis"
+	"github.com/sg83/go-microservice/article-api/rpc"
+	"github.com/sg83/go-microservice/article-api/server"
+	"github.com/sg83/go-microservice/article-api/tracing"
+	"github.com/sg83/go-microservice/article-api/webhook"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
-var bindAddress = ":8080"
-
 func main() {
 
+	// Load configuration from defaults, config file, environment and flags
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize logger
-	logger, err := zap.NewProduction()
+	level, _ := cfg.LogLevel()
+	zc := zap.NewProductionConfig()
+	zc.Level = zap.NewAtomicLevelAt(level)
+	logger, err := zc.Build()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer logger.Sync()
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter)
+	if err != nil {
+		logger.Fatal("Could not initialize tracing", zap.Error(err))
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Error("Error shutting down tracing", zap.Error(err))
+		}
+	}()
+
 	//Initialize data validator
 	v := data.NewValidation()
 
 	//Connect to database
-	db := data.NewDB(logger)
+	db := data.NewDB(logger, cfg.Database)
 	defer db.Close()
 
+	// Redis is only connected to when a feature needs state shared between replicas
+	rc := redis.New(redis.Options{
+		Addr:     cfg.Redis.Address,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+		PoolSize: cfg.Redis.PoolSize,
+		Timeout:  time.Duration(cfg.Redis.Timeout),
+	})
+	defer rc.Close()
+
+	// background work is stopped when the server shuts down
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Cache reads in front of the database
+	var store data.ArticlesData = db
+	if cfg.Cache.Enabled {
+		var cs cache.Store = cache.NewLRU(cfg.Cache.MaxEntries)
+		if cfg.Cache.Backend == "redis" {
+			cs, err = cache.NewTiered(bgCtx, logger, cs, cache.NewRedisStore(rc, "article-api:cache:"),
+				time.Duration(cfg.Cache.LocalTTL), rc, cfg.Cache.Channel)
+			if err != nil {
+				logger.Fatal("Could not connect to the redis cache", zap.Error(err))
+			}
+		}
+		store = cache.New(logger, db, cs, cache.Options{
+			ArticleTTL:  time.Duration(cfg.Cache.ArticleTTL),
+			TagTTL:      time.Duration(cfg.Cache.TagTTL),
+			LoadTimeout: time.Duration(cfg.Cache.LoadTimeout),
+		})
+	}
+
 	//Create handlers
-	ah := handlers.NewArticles(logger, db, v)
+	ah := handlers.NewArticles(logger, store, v)
+	wh := handlers.NewWebhooks(logger, db, v)
+	hh := handlers.NewHealth(logger)
+	hh.AddCheck("database", db.Ping)
+	hh.AddCheck("migrations", db.CheckMigrations)
 
 	// CORS
-	ch := gohandlers.CORS(gohandlers.AllowedOrigins([]string{"*"}))
+	ch := gohandlers.CORS(gohandlers.AllowedOrigins(cfg.CORS.AllowedOrigins))
+
+	// Clients are identified by the address X-Forwarded-For gives when sent by a trusted proxy
+	trusted, err := handlers.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		logger.Fatal("Invalid trusted proxies", zap.Error(err))
+	}
+
+	rt := server.Routes{
+		Articles: ah,
+		Webhooks: wh,
+		Health:   hh,
+		Trusted:  trusted,
+	}
+
+	// Rate limit every client, separately for reads and writes
+	if cfg.RateLimit.Enabled {
+		rt.Limiter = ratelimit.NewMemory()
+		if cfg.RateLimit.Backend == "redis" {
+			rt.Limiter = ratelimit.NewRedis(rc, "article-api:ratelimit:")
+		}
+	}
+
+	// Retried writes with the same Idempotency-Key get the first response back
+	if cfg.Idempotency.Enabled {
+		rt.Idempotency = idempotency.NewMemory()
+		if cfg.Idempotency.Backend == "redis" {
+			rt.Idempotency = idempotency.NewRedis(rc, "article-api:idempotency:")
+		}
+	}
+
+	// exports, imports and webhooks are restricted to admin keys
+	keys := map[string][]string{}
+	for _, k := range cfg.Auth.Keys {
+		keys[k.Key] = k.Scopes
+	}
+	apiKeys := handlers.NewAPIKeys(keys)
+	rt.APIKeys = apiKeys
 
-	//Create a new serve mux
-	sm := mux.NewRouter()
+	// Deliver the article events recorded with every write to the webhooks
+	if cfg.Webhooks.Enabled {
+		d := webhook.NewDispatcher(logger, db, webhook.Options{
+			Interval:       time.Duration(cfg.Webhooks.Interval),
+			Timeout:        time.Duration(cfg.Webhooks.Timeout),
+			BatchSize:      cfg.Webhooks.BatchSize,
+			MaxAttempts:    cfg.Webhooks.MaxAttempts,
+			InitialBackoff: time.Duration(cfg.Webhooks.InitialBackoff),
+			MaxBackoff:     time.Duration(cfg.Webhooks.MaxBackoff),
+		})
+		go d.Run(bgCtx)
+	}
 
-	//Register handlers for the API's
-	getR := sm.Methods(http.MethodGet).Subrouter()
-	getR.HandleFunc("/articles/{id:[0-9]+}", ah.Get)
-	getR.HandleFunc("/tags/{tag}/{date}", ah.GetTagSummary)
+	// Remove the old events from the outbox, which every write adds to
+	// whether or not webhooks or event streams are enabled
+	if cfg.Webhooks.Retention > 0 {
+		go webhook.Prune(bgCtx, logger, db, time.Duration(cfg.Webhooks.Retention))
+	}
 
-	postR := sm.Methods(http.MethodPost).Subrouter()
-	postR.HandleFunc("/articles", ah.Create)
-	postR.Use(ah.MiddlewareValidateArticle)
+	// Stream the article events, fanned out from the outbox by a single bus
+	var bus *events.Bus
+	if cfg.Events.Enabled {
+		bus = events.NewBus(logger, db, time.Duration(cfg.Events.PollInterval))
+		db.OnEvents(bus.Notify)
+		go bus.Run(bgCtx)
+
+		// streams end before the write timeout cuts them, clients then
+		// reconnect and resume from the last event they got
+		var maxDuration time.Duration
+		if wt := time.Duration(cfg.Server.WriteTimeout); wt > time.Second {
+			maxDuration = wt - time.Second
+		}
+		rt.Events = handlers.NewEvents(logger, bus, time.Duration(cfg.Events.KeepAlive), maxDuration)
+	}
+
+	// Answer queries for articles, tags and their summaries in one round trip
+	if cfg.GraphQL.Enabled {
+		rt.GraphQL = handlers.NewGraphQL(logger, store, graphql.Limits{
+			MaxDepth:      cfg.GraphQL.MaxDepth,
+			MaxComplexity: cfg.GraphQL.MaxComplexity,
+		})
+	}
+
+	// Serve the OpenAPI document and a page browsing it
+	if cfg.Docs.Enabled {
+		rt.Docs, err = openapi.NewHandler(cfg.Docs.SwaggerUIURL)
+		if err != nil {
+			logger.Fatal("Could not render the docs page", zap.Error(err))
+		}
+	}
+
+	sm, err := server.NewRouter(logger, cfg, rt)
+	if err != nil {
+		logger.Fatal("Could not create the router", zap.Error(err))
+	}
+
+	var handler http.Handler = sm
+	if cfg.Server.Compression {
+		handler = handlers.MiddlewareCompress(cfg.Server.CompressionMinSize)(handler)
+	}
 
 	//Create a new server
 	s := http.Server{
-		Addr:    bindAddress, // configure the bind address
-		Handler: ch(sm),      // set the default handler
+		Addr:    cfg.Server.Address, // configure the bind address
+		Handler: ch(handler),        // set the default handler
 		ErrorLog: zap.NewStdLog(logger.With(
 			zap.String("source", "http-server"),
 			zap.String("type", "error-log"),
 		)), // set the logger for the server
-		ReadTimeout:  5 * time.Second,   // max time to read request from the client
-		WriteTimeout: 10 * time.Second,  // max time to write response to the client
-		IdleTimeout:  120 * time.Second, // max time for connections using TCP Keep-Alive
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout),  // max time to read request from the client
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout), // max time to write response to the client
+		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout),  // max time for connections using TCP Keep-Alive
+	}
+
+	// open event streams would otherwise hold up the shutdown
+	if bus != nil {
+		s.RegisterOnShutdown(bus.Close)
 	}
 
 	// start the server
 	go func() {
-		logger.Info("Starting server on port ", zap.String("address", bindAddress))
+		logger.Info("Starting server on port ", zap.String("address", cfg.Server.Address))
 
 		err := s.ListenAndServe()
-		if err != nil {
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("Error starting server", zap.Error(err))
 			os.Exit(1)
 		}
 	}()
 
+	// serve the metrics apart from the API, so that they are not public
+	var ms *http.Server
+	if cfg.Server.MetricsAddress != "" {
+		ms = &http.Server{
+			Addr:              cfg.Server.MetricsAddress,
+			Handler:           handlers.Metrics(),
+			ReadHeaderTimeout: time.Duration(cfg.Server.ReadTimeout),
+		}
+		go func() {
+			logger.Info("Starting metrics server", zap.String("address", cfg.Server.MetricsAddress))
+			if err := ms.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Error serving metrics", zap.Error(err))
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// serve the gRPC API on its own address, from the same store
+	var gs *grpc.Server
+	if cfg.GRPC.Enabled {
+		rl := rpc.RateLimit{Limiter: rt.Limiter, Read: server.Limit(cfg.RateLimit.Read), Write: server.Limit(cfg.RateLimit.Write)}
+		gs = rpc.New(logger, store, v, apiKeys, rl)
+		lis, err := net.Listen("tcp", cfg.GRPC.Address)
+		if err != nil {
+			logger.Fatal("Could not listen for gRPC", zap.Error(err))
+		}
+		go func() {
+			logger.Info("Starting gRPC server", zap.String("address", cfg.GRPC.Address))
+			if err := gs.Serve(lis); err != nil {
+				logger.Error("Error serving gRPC", zap.Error(err))
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// trap sigterm or interupt and gracefully shutdown the server
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	// Block until a signal is received.
 	sig := <-c
 	logger.Info("Got signal:", zap.Any("signal", sig))
 
-	// gracefully shutdown the server, waiting max 30 seconds for current operations to complete
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	// fail readiness first so load balancers stop sending new requests, and
+	// keep serving until they noticed, unless signalled again
+	hh.Shutdown()
+	if d := time.Duration(cfg.Server.ShutdownDelay); d > 0 {
+		logger.Info("Waiting for load balancers to stop sending requests", zap.Duration("delay", d))
+		select {
+		case <-time.After(d):
+		case <-c:
+		}
+	}
+
+	// gracefully shutdown the server, waiting for current operations to complete
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
 	s.Shutdown(ctx)
+	if gs != nil {
+		stopGRPC(ctx, gs)
+	}
+	// the metrics are served until the end, to be scraped while draining
+	if ms != nil {
+		ms.Shutdown(ctx)
+	}
+}
 
+// stopGRPC waits for the calls in flight to complete, cancelling those
+// still running once ctx is done
+func stopGRPC(ctx context.Context, gs *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		gs.Stop()
+	}
 }
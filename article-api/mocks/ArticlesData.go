@@ -3,6 +3,9 @@
 package mocks
 
 import (
+	context "context"
+	time "time"
+
 	"github.com/sg83/go-microservice/article-api/data"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -12,13 +15,13 @@ type ArticlesData struct {
 	mock.Mock
 }
 
-// AddArticle provides a mock function with given fields: ar
-func (_m *ArticlesData) AddArticle(ar data.Article) error {
-	ret := _m.Called(ar)
+// AddArticle provides a mock function with given fields: ctx, ar
+func (_m *ArticlesData) AddArticle(ctx context.Context, ar data.Article) error {
+	ret := _m.Called(ctx, ar)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(data.Article) error); ok {
-		r0 = rf(ar)
+	if rf, ok := ret.Get(0).(func(context.Context, data.Article) error); ok {
+		r0 = rf(ctx, ar)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -26,30 +29,96 @@ func (_m *ArticlesData) AddArticle(ar data.Article) error {
 	return r0
 }
 
+// AddArticles provides a mock function with given fields: ctx, ars
+func (_m *ArticlesData) AddArticles(ctx context.Context, ars []data.Article) ([]int, error) {
+	ret := _m.Called(ctx, ars)
+
+	var r0 []int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []data.Article) ([]int, error)); ok {
+		return rf(ctx, ars)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []data.Article) []int); ok {
+		r0 = rf(ctx, ars)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]int)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []data.Article) error); ok {
+		r1 = rf(ctx, ars)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Close provides a mock function with given fields:
 func (_m *ArticlesData) Close() {
 	_m.Called()
 }
 
-// GetArticleByID provides a mock function with given fields: id
-func (_m *ArticlesData) GetArticleByID(id int) (*data.Article, error) {
-	ret := _m.Called(id)
+// ExportArticles provides a mock function with given fields: ctx, f, fn
+func (_m *ArticlesData) ExportArticles(ctx context.Context, f data.ExportFilter, fn func(data.Article) error) error {
+	ret := _m.Called(ctx, f, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, data.ExportFilter, func(data.Article) error) error); ok {
+		r0 = rf(ctx, f, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetArticleByID provides a mock function with given fields: ctx, id
+func (_m *ArticlesData) GetArticleByID(ctx context.Context, id int) (*data.Article, error) {
+	ret := _m.Called(ctx, id)
 
 	var r0 *data.Article
 	var r1 error
-	if rf, ok := ret.Get(0).(func(int) (*data.Article, error)); ok {
-		return rf(id)
+	if rf, ok := ret.Get(0).(func(context.Context, int) (*data.Article, error)); ok {
+		return rf(ctx, id)
 	}
-	if rf, ok := ret.Get(0).(func(int) *data.Article); ok {
-		r0 = rf(id)
+	if rf, ok := ret.Get(0).(func(context.Context, int) *data.Article); ok {
+		r0 = rf(ctx, id)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*data.Article)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(int) error); ok {
-		r1 = rf(id)
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetArticlesByIDs provides a mock function with given fields: ctx, ids
+func (_m *ArticlesData) GetArticlesByIDs(ctx context.Context, ids []int) ([]data.Article, error) {
+	ret := _m.Called(ctx, ids)
+
+	var r0 []data.Article
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []int) ([]data.Article, error)); ok {
+		return rf(ctx, ids)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []int) []data.Article); ok {
+		r0 = rf(ctx, ids)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]data.Article)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []int) error); ok {
+		r1 = rf(ctx, ids)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -57,25 +126,25 @@ func (_m *ArticlesData) GetArticleByID(id int) (*data.Article, error) {
 	return r0, r1
 }
 
-// GetArticlesForTagAndDate provides a mock function with given fields: tag, date
-func (_m *ArticlesData) GetArticlesForTagAndDate(tag string, date string) ([]int, error) {
-	ret := _m.Called(tag, date)
+// GetArticlesForTagAndDate provides a mock function with given fields: ctx, tag, date
+func (_m *ArticlesData) GetArticlesForTagAndDate(ctx context.Context, tag string, date string) ([]int, error) {
+	ret := _m.Called(ctx, tag, date)
 
 	var r0 []int
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string, string) ([]int, error)); ok {
-		return rf(tag, date)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) ([]int, error)); ok {
+		return rf(ctx, tag, date)
 	}
-	if rf, ok := ret.Get(0).(func(string, string) []int); ok {
-		r0 = rf(tag, date)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) []int); ok {
+		r0 = rf(ctx, tag, date)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]int)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(tag, date)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, tag, date)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -83,25 +152,75 @@ func (_m *ArticlesData) GetArticlesForTagAndDate(tag string, date string) ([]int
 	return r0, r1
 }
 
-// GetRelatedTagsForTag provides a mock function with given fields: tag, articles
-func (_m *ArticlesData) GetRelatedTagsForTag(tag string, articles []int) ([]string, error) {
-	ret := _m.Called(tag, articles)
+// GetArticlesForTagsAndDates provides a mock function with given fields: ctx, keys
+func (_m *ArticlesData) GetArticlesForTagsAndDates(ctx context.Context, keys []data.TagDate) ([][]int, error) {
+	ret := _m.Called(ctx, keys)
+
+	var r0 [][]int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []data.TagDate) ([][]int, error)); ok {
+		return rf(ctx, keys)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []data.TagDate) [][]int); ok {
+		r0 = rf(ctx, keys)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([][]int)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []data.TagDate) error); ok {
+		r1 = rf(ctx, keys)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetRelatedTagsForTag provides a mock function with given fields: ctx, tag, articles
+func (_m *ArticlesData) GetRelatedTagsForTag(ctx context.Context, tag string, articles []int) ([]string, error) {
+	ret := _m.Called(ctx, tag, articles)
 
 	var r0 []string
 	var r1 error
-	if rf, ok := ret.Get(0).(func(string, []int) ([]string, error)); ok {
-		return rf(tag, articles)
+	if rf, ok := ret.Get(0).(func(context.Context, string, []int) ([]string, error)); ok {
+		return rf(ctx, tag, articles)
 	}
-	if rf, ok := ret.Get(0).(func(string, []int) []string); ok {
-		r0 = rf(tag, articles)
+	if rf, ok := ret.Get(0).(func(context.Context, string, []int) []string); ok {
+		r0 = rf(ctx, tag, articles)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]string)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(string, []int) error); ok {
-		r1 = rf(tag, articles)
+	if rf, ok := ret.Get(1).(func(context.Context, string, []int) error); ok {
+		r1 = rf(ctx, tag, articles)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTagLastModified provides a mock function with given fields: ctx, tag, date
+func (_m *ArticlesData) GetTagLastModified(ctx context.Context, tag string, date string) (time.Time, error) {
+	ret := _m.Called(ctx, tag, date)
+
+	var r0 time.Time
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (time.Time, error)); ok {
+		return rf(ctx, tag, date)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) time.Time); ok {
+		r0 = rf(ctx, tag, date)
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, tag, date)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -114,6 +233,30 @@ type mockConstructorTestingTNewArticlesData interface {
 	Cleanup(func())
 }
 
+// ImportArticles provides a mock function with given fields: ctx, next
+func (_m *ArticlesData) ImportArticles(ctx context.Context, next func() (data.Article, error)) (int, error) {
+	ret := _m.Called(ctx, next)
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, func() (data.Article, error)) (int, error)); ok {
+		return rf(ctx, next)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, func() (data.Article, error)) int); ok {
+		r0 = rf(ctx, next)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, func() (data.Article, error)) error); ok {
+		r1 = rf(ctx, next)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewArticlesData creates a new instance of ArticlesData. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 func NewArticlesData(t mockConstructorTestingTNewArticlesData) *ArticlesData {
 	mock := &ArticlesData{}
@@ -0,0 +1,21 @@
+//go:build ignore
+
+// gen writes the OpenAPI document of the API to openapi.json
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/sg83/go-microservice/article-api/openapi"
+)
+
+func main() {
+	b, err := openapi.Generate(openapi.SourceDirs...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("openapi.json", b, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
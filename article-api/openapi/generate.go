@@ -0,0 +1,393 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// apiKeyHeader is the header parameter of the annotations turned into
+	// the apiKey security scheme
+	apiKeyHeader = "X-API-Key"
+	apiKeyScheme = "apiKey"
+
+	definitionsPrefix = "#/definitions/"
+	schemasPrefix     = "#/components/schemas/"
+	problemRef        = definitionsPrefix + "Problem"
+)
+
+// SourceDirs are the directories, relative to this package, of the annotated
+// handlers and of the models
+var SourceDirs = []string{".", "../handlers", "../data", "../utils"}
+
+// Versions are the prefixes of the versions of the REST API, oldest first.
+// Each version serves the operations of the one before it that it does not
+// annotate itself, unchanged, and the operations of the first one are also
+// served without prefix, deprecated, while the legacy routes are enabled.
+var Versions = []string{"/v1", "/v2"}
+
+var operationLine = regexp.MustCompile(`^swagger:operation\s+([A-Z]+)\s+(\S+)\s+(\S+)\s+(\S+)\s*$`)
+
+// annotation is a swagger:operation block of the doc comment of a handler
+type annotation struct {
+	method, path, tag, id string
+	spec                  swaggerOperation
+}
+
+// swaggerOperation is the swagger 2 YAML following the --- of an annotation
+type swaggerOperation struct {
+	Consumes   []string                   `yaml:"consumes"`
+	Produces   []string                   `yaml:"produces"`
+	Parameters []swaggerParameter         `yaml:"parameters"`
+	Responses  map[string]swaggerResponse `yaml:"responses"`
+}
+
+type swaggerParameter struct {
+	Name        string  `yaml:"name"`
+	In          string  `yaml:"in"`
+	Description string  `yaml:"description"`
+	Required    bool    `yaml:"required"`
+	Type        string  `yaml:"type"`
+	Format      string  `yaml:"format"`
+	Schema      *Schema `yaml:"schema"`
+}
+
+type swaggerResponse struct {
+	Description string  `yaml:"description"`
+	Schema      *Schema `yaml:"schema"`
+}
+
+// Generate returns the document of the operations annotated in the Go
+// sources of dirs, with the schemas of the models they refer to, as
+// indented JSON
+func Generate(dirs ...string) ([]byte, error) {
+	doc := &Document{
+		OpenAPI: "3.0.3",
+		Info: Info{
+			Title:       "Article API",
+			Description: "Articles, their tags and the daily summaries of the tags. /v2 only changes the tag summary, whose article ids are strings; its other operations, GraphQL and the event stream included, are those of /v1.",
+			Version:     "1.0.0",
+		},
+		Paths:      map[string]*PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}},
+	}
+
+	fset := token.NewFileSet()
+	s := newSchemas()
+	var funcs []*ast.FuncDecl
+	for _, dir := range dirs {
+		pkgs, err := parser.ParseDir(fset, dir, func(fi fs.FileInfo) bool {
+			return !strings.HasSuffix(fi.Name(), "_test.go")
+		}, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		for name, pkg := range pkgs {
+			for _, f := range pkg.Files {
+				s.addDocs(name, f)
+				for _, d := range f.Decls {
+					if fd, ok := d.(*ast.FuncDecl); ok && fd.Doc != nil {
+						funcs = append(funcs, fd)
+					}
+				}
+			}
+		}
+	}
+
+	for _, fd := range funcs {
+		summary, annotations, err := parseDoc(fd.Doc.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fset.Position(fd.Pos()), err)
+		}
+		for _, a := range annotations {
+			if err := doc.add(a, summary); err != nil {
+				return nil, fmt.Errorf("%s: %w", fset.Position(fd.Pos()), err)
+			}
+		}
+	}
+
+	if err := doc.addVersions(Versions); err != nil {
+		return nil, err
+	}
+
+	for _, m := range models {
+		s.of(reflect.TypeOf(m))
+	}
+	doc.Components.Schemas = s.defs
+	if err := doc.checkRefs(); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// parseDoc splits the text of a doc comment into its summary, the text
+// before the first annotation, and its annotations
+func parseDoc(text string) (string, []annotation, error) {
+	var summary []string
+	var annotations []annotation
+	var block []string
+	flush := func() error {
+		if len(annotations) == 0 {
+			return nil
+		}
+		a := &annotations[len(annotations)-1]
+		for i, l := range block {
+			if strings.TrimSpace(l) != "---" {
+				continue
+			}
+			if err := yaml.Unmarshal([]byte(strings.Join(block[i+1:], "\n")), &a.spec); err != nil {
+				return fmt.Errorf("swagger:operation %s %s: %w", a.method, a.path, err)
+			}
+			break
+		}
+		block = nil
+		return nil
+	}
+
+	for _, l := range strings.Split(text, "\n") {
+		if m := operationLine.FindStringSubmatch(l); m != nil {
+			if err := flush(); err != nil {
+				return "", nil, err
+			}
+			annotations = append(annotations, annotation{method: m[1], path: m[2], tag: m[3], id: m[4]})
+			continue
+		}
+		if len(annotations) == 0 {
+			if l = strings.TrimSpace(l); l != "" {
+				summary = append(summary, l)
+			}
+			continue
+		}
+		// code blocks are indented with tabs, which YAML does not allow
+		n := len(l) - len(strings.TrimLeft(l, "\t"))
+		block = append(block, strings.Repeat("  ", n)+l[n:])
+	}
+	if err := flush(); err != nil {
+		return "", nil, err
+	}
+	return strings.Join(summary, " "), annotations, nil
+}
+
+// add converts a swagger 2 annotation to an OpenAPI 3 operation of doc
+func (doc *Document) add(a annotation, summary string) error {
+	item := doc.Paths[a.path]
+	if item == nil {
+		item = &PathItem{}
+		doc.Paths[a.path] = item
+	}
+	method := strings.ToLower(a.method)
+	if _, ok := (*item)[method]; ok {
+		return fmt.Errorf("%s %s is annotated twice", a.method, a.path)
+	}
+
+	consumes := a.spec.Consumes
+	if len(consumes) == 0 {
+		consumes = []string{"application/json"}
+	}
+	produces := a.spec.Produces
+	if len(produces) == 0 {
+		produces = []string{"application/json"}
+	}
+
+	op := &Operation{
+		Tags:        []string{a.tag},
+		Summary:     summary,
+		OperationID: a.id,
+		Responses:   map[string]*Response{},
+	}
+	for _, p := range a.spec.Parameters {
+		switch {
+		case p.In == "body":
+			op.RequestBody = &RequestBody{
+				Description: p.Description,
+				Required:    p.Required,
+				Content:     content(consumes, p.Schema),
+			}
+		case p.In == "header" && p.Name == apiKeyHeader:
+			op.Security = []map[string][]string{{apiKeyScheme: {}}}
+			doc.Components.SecuritySchemes = map[string]*SecurityScheme{
+				apiKeyScheme: {Type: "apiKey", In: "header", Name: apiKeyHeader, Description: p.Description},
+			}
+		default:
+			op.Parameters = append(op.Parameters, &Parameter{
+				Name:        p.Name,
+				In:          p.In,
+				Description: p.Description,
+				Required:    p.Required,
+				Schema:      &Schema{Type: p.Type, Format: p.Format},
+			})
+		}
+	}
+	for code, r := range a.spec.Responses {
+		resp := &Response{Description: r.Description}
+		switch {
+		case r.Schema != nil && r.Schema.Ref == problemRef:
+			resp.Content = content([]string{"application/problem+json"}, r.Schema)
+		case r.Schema != nil || len(a.spec.Produces) != 0 && strings.HasPrefix(code, "2"):
+			resp.Content = content(produces, r.Schema)
+		}
+		op.Responses[code] = resp
+	}
+	(*item)[method] = op
+	return nil
+}
+
+// addVersions adds to doc the operations every version inherits from the
+// one before it, and the legacy aliases of the operations of the first
+// version
+func (doc *Document) addVersions(versions []string) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	for i, v := range versions[1:] {
+		prev := versions[i]
+		suffix := strings.ToUpper(v[1:2]) + v[2:]
+		for _, path := range doc.pathsUnder(prev) {
+			for method, op := range *doc.Paths[path] {
+				to := v + strings.TrimPrefix(path, prev)
+				if doc.Paths[to] != nil && (*doc.Paths[to])[method] != nil {
+					continue
+				}
+				// GetV2 becomes GetV3, Get becomes GetV2
+				inherited := *op
+				inherited.OperationID = strings.TrimSuffix(op.OperationID, strings.ToUpper(prev[1:2])+prev[2:]) + suffix
+				inherited.Description = "Served unchanged from " + path + ", whose request and response " + v + " keeps."
+				doc.set(to, method, &inherited)
+			}
+		}
+	}
+
+	first := versions[0]
+	for _, path := range doc.pathsUnder(first) {
+		for method, op := range *doc.Paths[path] {
+			to := strings.TrimPrefix(path, first)
+			if doc.Paths[to] != nil && (*doc.Paths[to])[method] != nil {
+				return fmt.Errorf("%s %s is annotated but is the legacy alias of %s", strings.ToUpper(method), to, path)
+			}
+			legacy := *op
+			legacy.OperationID = op.OperationID + "Legacy"
+			legacy.Description = "Deprecated alias of " + path + ", removed after the date in the Sunset header."
+			legacy.Deprecated = true
+			doc.set(to, method, &legacy)
+		}
+	}
+
+	ids := map[string]string{}
+	for path, item := range doc.Paths {
+		for method, op := range *item {
+			at := strings.ToUpper(method) + " " + path
+			if other, ok := ids[op.OperationID]; ok {
+				return fmt.Errorf("%s and %s have the same operation id %s", other, at, op.OperationID)
+			}
+			ids[op.OperationID] = at
+		}
+	}
+	return nil
+}
+
+// pathsUnder returns the sorted paths of doc under prefix
+func (doc *Document) pathsUnder(prefix string) []string {
+	var paths []string
+	for p := range doc.Paths {
+		if strings.HasPrefix(p, prefix+"/") {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// set sets the operation of doc for method and path
+func (doc *Document) set(path, method string, op *Operation) {
+	item := doc.Paths[path]
+	if item == nil {
+		item = &PathItem{}
+		doc.Paths[path] = item
+	}
+	(*item)[method] = op
+}
+
+// content returns the media types with schema s, its refs pointing to the
+// components
+func content(types []string, s *Schema) map[string]MediaType {
+	rewriteRefs(s)
+	c := map[string]MediaType{}
+	for _, t := range types {
+		c[t] = MediaType{Schema: s}
+	}
+	return c
+}
+
+func rewriteRefs(s *Schema) {
+	if s == nil {
+		return
+	}
+	if strings.HasPrefix(s.Ref, definitionsPrefix) {
+		s.Ref = schemasPrefix + strings.TrimPrefix(s.Ref, definitionsPrefix)
+	}
+	rewriteRefs(s.Items)
+	for _, p := range s.Properties {
+		rewriteRefs(p)
+	}
+}
+
+// checkRefs returns an error when an operation refers to a schema that is
+// not in the components
+func (doc *Document) checkRefs() error {
+	missing := map[string]bool{}
+	var check func(s *Schema)
+	check = func(s *Schema) {
+		if s == nil {
+			return
+		}
+		if name := strings.TrimPrefix(s.Ref, schemasPrefix); s.Ref != "" && doc.Components.Schemas[name] == nil {
+			missing[s.Ref] = true
+		}
+		check(s.Items)
+		for _, p := range s.Properties {
+			check(p)
+		}
+	}
+	for _, item := range doc.Paths {
+		for _, op := range *item {
+			if op.RequestBody != nil {
+				for _, mt := range op.RequestBody.Content {
+					check(mt.Schema)
+				}
+			}
+			for _, r := range op.Responses {
+				for _, mt := range r.Content {
+					check(mt.Schema)
+				}
+			}
+		}
+	}
+	if len(missing) != 0 {
+		refs := make([]string, 0, len(missing))
+		for r := range missing {
+			refs = append(refs, r)
+		}
+		sort.Strings(refs)
+		return fmt.Errorf("unknown models %s, add them to the models of the openapi package", strings.Join(refs, ", "))
+	}
+	return nil
+}
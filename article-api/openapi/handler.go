@@ -0,0 +1,169 @@
+package openapi
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/sg83/go-microservice/article-api/handlers"
+)
+
+//go:generate go run gen.go
+
+//go:embed openapi.json
+var spec []byte
+
+//go:embed docs.html
+var docsPage string
+
+var docsTemplate = template.Must(template.New("docs").Parse(docsPage))
+
+//go:embed swagger-ui
+var embedded embed.FS
+
+// swaggerUI holds the files of swagger-ui-dist vendored by make swagger-ui
+var swaggerUI, _ = fs.Sub(embedded, "swagger-ui")
+
+// swaggerUIAssets are the files of swagger-ui-dist the docs page loads, by
+// name, with their content type
+var swaggerUIAssets = map[string]string{
+	"swagger-ui.css":       "text/css; charset=utf-8",
+	"swagger-ui-bundle.js": "text/javascript; charset=utf-8",
+}
+
+// Embedded returns the document embedded in the binary
+func Embedded() (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Handler serves the document and a Swagger UI page browsing it
+type Handler struct {
+	page []byte
+	// the Swagger UI files served with the page, nil when it loads them
+	// from another site
+	assets map[string][]byte
+}
+
+// NewHandler returns a handler whose docs page loads Swagger UI from the
+// copy embedded in the binary, or when swaggerUIURL is set from that
+// directory of the swagger-ui-dist package, which must hold the same version
+// as the embedded copy since the page pins its files by hash
+func NewHandler(swaggerUIURL string) (*Handler, error) {
+	return newHandler(swaggerUIURL, swaggerUI)
+}
+
+func newHandler(swaggerUIURL string, files fs.FS) (*Handler, error) {
+	assets := map[string][]byte{}
+	integrity := map[string]string{}
+	for name := range swaggerUIAssets {
+		b, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, fmt.Errorf("swagger UI is not embedded, run make swagger-ui: %w", err)
+		}
+		assets[name] = b
+		sum := sha512.Sum384(b)
+		integrity[name] = "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	h := &Handler{}
+	page := struct {
+		Base      string
+		Remote    bool
+		Integrity map[string]string
+	}{Base: swaggerUIURL, Remote: true, Integrity: integrity}
+	if swaggerUIURL == "" {
+		h.assets = assets
+		// relative to /docs
+		page.Base, page.Remote = "docs", false
+	}
+
+	var b bytes.Buffer
+	if err := docsTemplate.Execute(&b, page); err != nil {
+		return nil, err
+	}
+	h.page = b.Bytes()
+	return h, nil
+}
+
+// Spec returns the OpenAPI document of the API.
+//
+// swagger:operation GET /openapi.json docs Spec
+//
+// ---
+// produces:
+//   - application/json
+//
+// responses:
+//
+//	'200':
+//	  description: The OpenAPI 3 document
+func (h *Handler) Spec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// Docs returns a page to browse the document and try the API.
+//
+// swagger:operation GET /docs docs Docs
+//
+// ---
+// produces:
+//   - text/html
+//
+// responses:
+//
+//	'200':
+//	  description: The Swagger UI page
+func (h *Handler) Docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(h.page)
+}
+
+// Asset returns a file of the Swagger UI embedded in the binary, loaded by
+// the docs page.
+//
+// swagger:operation GET /docs/{asset} docs Asset
+//
+// ---
+// produces:
+//   - text/css
+//   - text/javascript
+//
+// parameters:
+//   - name: asset
+//     in: path
+//     description: swagger-ui.css or swagger-ui-bundle.js
+//     required: true
+//     type: string
+//
+// responses:
+//
+//	'200':
+//	  description: The file
+//	'404':
+//	  description: Swagger UI is loaded from another site, or no such file
+//	  schema:
+//	    "$ref": "#/definitions/Problem"
+func (h *Handler) Asset(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["asset"]
+	b, ok := h.assets[name]
+	if !ok {
+		handlers.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", swaggerUIAssets[name])
+	// the files only change with the binary
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(b)
+}
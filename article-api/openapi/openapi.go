@@ -0,0 +1,102 @@
+// Package openapi generates the OpenAPI 3 document of the REST API from the
+// swagger:operation annotations of the handlers and the Go types of the
+// models, and serves it with a docs UI.
+//
+// The document is generated with go generate and embedded in the binary, a
+// test fails when it is out of date.
+package openapi
+
+// Document is an OpenAPI 3 document, with the parts of the specification
+// the API uses
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem is the operations of a path, by lower case method
+type PathItem map[string]*Operation
+
+// Operation is a method of a path
+type Operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+// Parameter is a path, query or header parameter of an operation
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is the body of a request, by media type
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body of a media type, any body when it has
+// none
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Components holds the schemas of the models and the security schemes
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is a way for clients to authenticate
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Schema describes a value. It is also read from the YAML of the
+// annotations, where refs point to #/definitions/ as in swagger 2.
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty" yaml:"$ref"`
+	Type        string             `json:"type,omitempty" yaml:"type"`
+	Format      string             `json:"format,omitempty" yaml:"format"`
+	Description string             `json:"description,omitempty" yaml:"description"`
+	Properties  map[string]*Schema `json:"properties,omitempty" yaml:"properties"`
+	Required    []string           `json:"required,omitempty" yaml:"required"`
+	Items       *Schema            `json:"items,omitempty" yaml:"items"`
+	Pattern     string             `json:"pattern,omitempty" yaml:"pattern"`
+	MinLength   *int               `json:"minLength,omitempty" yaml:"minLength"`
+	MaxLength   *int               `json:"maxLength,omitempty" yaml:"maxLength"`
+	Minimum     *float64           `json:"minimum,omitempty" yaml:"minimum"`
+	Maximum     *float64           `json:"maximum,omitempty" yaml:"maximum"`
+	MinItems    *int               `json:"minItems,omitempty" yaml:"minItems"`
+	MaxItems    *int               `json:"maxItems,omitempty" yaml:"maxItems"`
+	UniqueItems bool               `json:"uniqueItems,omitempty" yaml:"uniqueItems"`
+	ReadOnly    bool               `json:"readOnly,omitempty" yaml:"readOnly"`
+}
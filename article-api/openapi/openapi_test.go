@@ -0,0 +1,336 @@
+package openapi
+
+import (
+	"bytes"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSpecUpToDate(t *testing.T) {
+	b, err := Generate(SourceDirs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, spec) {
+		t.Error("openapi.json is out of date, run go generate ./openapi")
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tt := []struct {
+		name string
+		text string
+		// checks the operations added, by method and path
+		check func(t *testing.T, doc *Document)
+		err   string
+	}{
+		{
+			name: "body, api key and problem",
+			text: `Create adds a webhook.
+
+swagger:operation POST /webhooks webhooks CreateWebhook
+
+---
+parameters:
+
+	- name: X-API-Key
+	  in: header
+	  required: true
+	  type: string
+	- name: webhook
+	  in: body
+	  required: true
+	  schema:
+	    "$ref": "#/definitions/Webhook"
+
+responses:
+
+	'201':
+	  description: Created
+	  schema:
+	    "$ref": "#/definitions/Webhook"
+	'400':
+	  description: Malformed
+	  schema:
+	    "$ref": "#/definitions/Problem"
+`,
+			check: func(t *testing.T, doc *Document) {
+				op := (*doc.Paths["/webhooks"])["post"]
+				if op.Summary != "Create adds a webhook." || op.OperationID != "CreateWebhook" {
+					t.Errorf("got summary %q and id %q", op.Summary, op.OperationID)
+				}
+				if len(op.Parameters) != 0 || len(op.Security) != 1 {
+					t.Errorf("got parameters %v and security %v, want the API key as security", op.Parameters, op.Security)
+				}
+				if s := op.RequestBody.Content["application/json"].Schema; s == nil || s.Ref != "#/components/schemas/Webhook" {
+					t.Errorf("got request body %+v", op.RequestBody)
+				}
+				if _, ok := op.Responses["400"].Content["application/problem+json"]; !ok {
+					t.Errorf("got 400 content %v, want a problem", op.Responses["400"].Content)
+				}
+				if _, ok := op.Responses["201"].Content["application/json"]; !ok {
+					t.Errorf("got 201 content %v, want JSON", op.Responses["201"].Content)
+				}
+			},
+		},
+		{
+			name: "several operations",
+			text: `Query runs a query.
+
+swagger:operation GET /graphql graphql QueryGet
+
+---
+parameters:
+  - name: query
+    in: query
+    required: true
+    type: string
+
+responses:
+
+	'200':
+	  description: Done
+
+swagger:operation POST /graphql graphql Query
+
+---
+produces:
+  - application/json
+
+responses:
+
+	'200':
+	  description: Done
+`,
+			check: func(t *testing.T, doc *Document) {
+				item := *doc.Paths["/graphql"]
+				if get := item["get"]; get == nil || len(get.Parameters) != 1 || get.Parameters[0].Schema.Type != "string" {
+					t.Errorf("got GET %+v", get)
+				}
+				if post := item["post"]; post == nil || post.Summary != "Query runs a query." || post.Responses["200"].Content == nil {
+					t.Errorf("got POST %+v", post)
+				}
+			},
+		},
+		{
+			name: "invalid YAML",
+			text: "swagger:operation GET /articles articles List\n\n---\nresponses:\n  '200': [\n",
+			err:  "swagger:operation GET /articles",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := &Document{Paths: map[string]*PathItem{}}
+			summary, annotations, err := parseDoc(tc.text)
+			for _, a := range annotations {
+				if err == nil {
+					err = doc.add(a, summary)
+				}
+			}
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.check(t, doc)
+		})
+	}
+}
+
+func TestDocs(t *testing.T) {
+	vendored := fstest.MapFS{
+		"swagger-ui.css":       {Data: []byte("body {}")},
+		"swagger-ui-bundle.js": {Data: []byte("var SwaggerUIBundle")},
+	}
+
+	tt := []struct {
+		name  string
+		url   string
+		files fs.FS
+		// substring of the page, "" when the handler cannot be created
+		page string
+		// the assets served
+		assets map[string]string
+	}{
+		{
+			name:   "cdn",
+			url:    "https://unpkg.com/swagger-ui-dist@5.18.2",
+			files:  vendored,
+			page:   `href="https://unpkg.com/swagger-ui-dist@5.18.2/swagger-ui.css" integrity="sha384-JvbluEOKMBmUtNHx346xlZFWqKqtOmexOupPSHRCR0NbwTey4wjq9itKKoSWuGsH" crossorigin="anonymous"`,
+			assets: map[string]string{"swagger-ui.css": ""},
+		},
+		{
+			name:  "cdn but not vendored",
+			url:   "https://unpkg.com/swagger-ui-dist@5.18.2",
+			files: fstest.MapFS{"README.md": {}},
+		},
+		{
+			name:   "embedded",
+			files:  vendored,
+			page:   `src="docs/swagger-ui-bundle.js"></script>`,
+			assets: map[string]string{"swagger-ui.css": "body {}", "swagger-ui-bundle.js": "var SwaggerUIBundle", "README.md": ""},
+		},
+		{
+			name:  "embedded but not vendored",
+			files: fstest.MapFS{"README.md": {}},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := newHandler(tc.url, tc.files)
+			if tc.page == "" {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			h.Docs(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+				t.Errorf("got content type %q", ct)
+			}
+			if !strings.Contains(w.Body.String(), tc.page) {
+				t.Errorf("the page does not load Swagger UI with %s:\n%s", tc.page, w.Body.String())
+			}
+
+			for name, want := range tc.assets {
+				r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/docs/"+name, nil), map[string]string{"asset": name})
+				w := httptest.NewRecorder()
+				h.Asset(w, r)
+				switch {
+				case want == "" && w.Code != http.StatusNotFound:
+					t.Errorf("%s: got status %d, want 404", name, w.Code)
+				case want != "" && (w.Code != http.StatusOK || w.Body.String() != want):
+					t.Errorf("%s: got status %d and %q, want %q", name, w.Code, w.Body.String(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestDocsEmbedded(t *testing.T) {
+	h, err := NewHandler("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, ct := range swaggerUIAssets {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/docs/"+name, nil), map[string]string{"asset": name})
+		w := httptest.NewRecorder()
+		h.Asset(w, r)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != ct || w.Body.Len() < 100000 {
+			t.Errorf("%s: got status %d, content type %q and %d bytes", name, w.Code, w.Header().Get("Content-Type"), w.Body.Len())
+		}
+	}
+}
+
+func TestAddVersions(t *testing.T) {
+	op := func(id string) *Operation { return &Operation{OperationID: id} }
+	tt := []struct {
+		name  string
+		paths map[string]*PathItem
+		// operation ids by method and path, "" when it must be absent
+		want map[string]string
+		// descriptions by method and path
+		descriptions map[string]string
+		err          string
+	}{
+		{
+			name: "inherited and legacy",
+			paths: map[string]*PathItem{
+				"/v1/articles/{id}":     {"get": op("Get")},
+				"/v1/tags/{tag}":        {"get": op("GetTag")},
+				"/v2/tags/{tag}":        {"get": op("GetTagV2")},
+				"/v3/articles/{id}":     {"delete": op("DeleteV3")},
+				"/healthz":              {"get": op("Live")},
+				"/v1beta/articles/{id}": {"get": op("Beta")},
+			},
+			want: map[string]string{
+				"GET /v2/articles/{id}":    "GetV2",
+				"GET /v3/articles/{id}":    "GetV3",
+				"DELETE /v3/articles/{id}": "DeleteV3",
+				"GET /v2/tags/{tag}":       "GetTagV2",
+				"GET /v3/tags/{tag}":       "GetTagV3",
+				"GET /articles/{id}":       "GetLegacy",
+				"GET /tags/{tag}":          "GetTagLegacy",
+				"DELETE /articles/{id}":    "",
+				"GET /v2/healthz":          "",
+				"GET /beta/articles/{id}":  "",
+			},
+			descriptions: map[string]string{
+				"GET /v2/articles/{id}": "Served unchanged from /v1/articles/{id}, whose request and response /v2 keeps.",
+				"GET /v3/tags/{tag}":    "Served unchanged from /v2/tags/{tag}, whose request and response /v3 keeps.",
+				"GET /v2/tags/{tag}":    "",
+				"GET /articles/{id}":    "Deprecated alias of /v1/articles/{id}, removed after the date in the Sunset header.",
+			},
+		},
+		{
+			name: "annotated legacy path",
+			paths: map[string]*PathItem{
+				"/v1/articles/{id}": {"get": op("Get")},
+				"/articles/{id}":    {"get": op("GetOld")},
+			},
+			err: "GET /articles/{id} is annotated but is the legacy alias of /v1/articles/{id}",
+		},
+		{
+			name: "duplicate operation id",
+			paths: map[string]*PathItem{
+				"/v1/articles/{id}": {"get": op("Get")},
+				"/healthz":          {"get": op("GetLegacy")},
+			},
+			err: "the same operation id GetLegacy",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := &Document{Paths: tc.paths}
+			err := doc.addVersions([]string{"/v1", "/v2", "/v3"})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for at, id := range tc.want {
+				method, path, _ := strings.Cut(at, " ")
+				var got *Operation
+				if item := doc.Paths[path]; item != nil {
+					got = (*item)[strings.ToLower(method)]
+				}
+				switch {
+				case id == "" && got != nil:
+					t.Errorf("%s: got %s, want none", at, got.OperationID)
+				case id != "" && (got == nil || got.OperationID != id):
+					t.Errorf("%s: got %+v, want %s", at, got, id)
+				case got != nil && got.Deprecated != strings.HasSuffix(id, "Legacy"):
+					t.Errorf("%s: deprecated is %v", at, got.Deprecated)
+				}
+			}
+			for at, want := range tc.descriptions {
+				method, path, _ := strings.Cut(at, " ")
+				if got := (*doc.Paths[path])[strings.ToLower(method)].Description; got != want {
+					t.Errorf("%s: got description %q, want %q", at, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package openapi
+
+import (
+	"encoding/json"
+	"go/ast"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/utils"
+)
+
+// models are the types the annotations refer to in #/definitions/, by their
+// name. The types of their fields are added with them.
+var models = []interface{}{
+	data.Article{},
+	data.Tag{},
+	data.TagV2{},
+	data.Webhook{},
+	data.Delivery{},
+	utils.Problem{},
+	data.BulkReport{},
+	data.ImportReport{},
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// fieldAnnotation is a go-swagger annotation of the doc comment of a field,
+// such as "max length: 500"
+var fieldAnnotation = regexp.MustCompile(`^(required|read only|unique|pattern|min length|max length|min items|max items|min|max):\s*(.*)$`)
+
+// schemas builds the schemas of Go types, described by the doc comments of
+// their fields
+type schemas struct {
+	// doc comments of the fields, by package.Type.Field
+	docs map[string]string
+	defs map[string]*Schema
+}
+
+func newSchemas() *schemas {
+	return &schemas{docs: map[string]string{}, defs: map[string]*Schema{}}
+}
+
+// addDocs keeps the doc comments of the fields of the structs of f, a file
+// of package pkg
+func (s *schemas) addDocs(pkg string, f *ast.File) {
+	ast.Inspect(f, func(n ast.Node) bool {
+		ts, ok := n.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		st, ok := ts.Type.(*ast.StructType)
+		if !ok {
+			return false
+		}
+		for _, field := range st.Fields.List {
+			if field.Doc == nil {
+				continue
+			}
+			for _, name := range field.Names {
+				s.docs[pkg+"."+ts.Name.Name+"."+name.Name] = field.Doc.Text()
+			}
+		}
+		return false
+	})
+}
+
+// of returns the schema of t, a ref for structs which are added to the
+// definitions
+func (s *schemas) of(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		// any JSON value
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.of(t.Elem())
+	case reflect.Struct:
+		if _, ok := s.defs[t.Name()]; !ok {
+			// reserved first for the types referring to themselves
+			s.defs[t.Name()] = &Schema{}
+			s.defs[t.Name()] = s.object(t)
+		}
+		return &Schema{Ref: schemasPrefix + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: s.of(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	}
+	return &Schema{}
+}
+
+// object returns the schema of the struct t, its properties named as they
+// are encoded to JSON
+func (s *schemas) object(t reflect.Type) *Schema {
+	obj := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		p := s.of(f.Type)
+		doc := s.docs[path.Base(t.PkgPath())+"."+t.Name()+"."+f.Name]
+		// the siblings of a ref are ignored
+		if p.Ref == "" && doc != "" {
+			if describe(p, doc) {
+				obj.Required = append(obj.Required, name)
+			}
+		}
+		obj.Properties[name] = p
+	}
+	return obj
+}
+
+// describe sets the description and the constraints of p from the doc
+// comment of its field, reporting whether the field is required
+func describe(p *Schema, doc string) bool {
+	var text []string
+	required := false
+	for _, l := range strings.Split(doc, "\n") {
+		l = strings.TrimSpace(l)
+		m := fieldAnnotation.FindStringSubmatch(l)
+		if m == nil {
+			if l != "" {
+				text = append(text, l)
+			}
+			continue
+		}
+		v := strings.TrimSpace(m[2])
+		switch m[1] {
+		case "required":
+			required = v == "true"
+		case "read only":
+			p.ReadOnly = v == "true"
+		case "unique":
+			p.UniqueItems = v == "true"
+		case "pattern":
+			p.Pattern = v
+		case "min length":
+			p.MinLength = intValue(v)
+		case "max length":
+			p.MaxLength = intValue(v)
+		case "min items":
+			p.MinItems = intValue(v)
+		case "max items":
+			p.MaxItems = intValue(v)
+		case "min":
+			p.Minimum = floatValue(v)
+		case "max":
+			p.Maximum = floatValue(v)
+		}
+	}
+	p.Description = strings.Join(text, " ")
+	return required
+}
+
+func intValue(v string) *int {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+func floatValue(v string) *float64 {
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
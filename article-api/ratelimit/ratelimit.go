@@ -0,0 +1,125 @@
+// Package ratelimit implements token bucket rate limiting of clients, kept
+// in process or shared between replicas through a Redis protocol server.
+//
+// Buckets are tracked with the generic cell rate algorithm: instead of a
+// token count each key stores the theoretical arrival time (TAT) at which
+// its bucket would be full again, which needs a single value per key.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket holding up to Burst requests and refilled with
+// Requests tokens every Period
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// params returns the time in milliseconds it takes to refill one token and
+// the size of the bucket, both at least 1
+func (l Limit) params() (interval int64, burst int64) {
+	d := l.Period
+	if l.Requests > 0 {
+		d /= time.Duration(l.Requests)
+	}
+	interval, burst = d.Milliseconds(), int64(l.Burst)
+	if interval <= 0 {
+		interval = 1
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return interval, burst
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed bool
+	// size of the bucket
+	Limit int
+	// tokens left after this request
+	Remaining int
+	// time until the bucket is full again
+	Reset time.Duration
+	// time until a request is allowed again, zero if this one was
+	RetryAfter time.Duration
+}
+
+// Limiter takes tokens from the bucket identified by key
+type Limiter interface {
+	Allow(ctx context.Context, key string, l Limit) (Result, error)
+}
+
+// take applies a request made at now to a bucket whose TAT is tat, both in
+// milliseconds, returning the new TAT. It is mirrored by the Lua script of
+// the Redis limiter.
+func take(tat int64, now int64, l Limit) (int64, Result) {
+	interval, burst := l.params()
+	if tat < now {
+		tat = now
+	}
+	newTat := tat + interval
+	allowAt := newTat - interval*burst
+	if now < allowAt {
+		return tat, Result{
+			Limit:      int(burst),
+			Reset:      time.Duration(tat-now) * time.Millisecond,
+			RetryAfter: time.Duration(allowAt-now) * time.Millisecond,
+		}
+	}
+	return newTat, Result{
+		Allowed:   true,
+		Limit:     int(burst),
+		Remaining: int((now - allowAt) / interval),
+		Reset:     time.Duration(newTat-now) * time.Millisecond,
+	}
+}
+
+// sweepInterval is how often the memory limiter forgets full buckets
+const sweepInterval = time.Minute
+
+// Memory keeps the buckets in process, it is only accurate when a single
+// replica serves the clients
+type Memory struct {
+	mu        sync.Mutex
+	tats      map[string]int64
+	lastSweep time.Time
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewMemory creates an in process limiter
+func NewMemory() *Memory {
+	return &Memory{tats: map[string]int64{}, now: time.Now}
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, l Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= sweepInterval {
+		m.sweep(now.UnixMilli())
+		m.lastSweep = now
+	}
+
+	tat, res := take(m.tats[key], now.UnixMilli(), l)
+	m.tats[key] = tat
+	return res, nil
+}
+
+// sweep drops the buckets that are full again, they behave as missing ones.
+// m.mu must be held.
+func (m *Memory) sweep(now int64) {
+	for k, tat := range m.tats {
+		if tat <= now {
+			delete(m.tats, k)
+		}
+	}
+}
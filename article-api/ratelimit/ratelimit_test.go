@@ -0,0 +1,213 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/redis"
+	"github.com/sg83/go-microservice/article-api/redis/redistest"
+)
+
+// vectors are requests made at now to a bucket whose TAT is tat, refilled
+// with 1 token per second up to 3, with their expected outcome. Both take
+// and the Lua script of the Redis limiter must agree with them.
+var vectors = []struct {
+	name      string
+	tat       int64
+	now       int64
+	newTat    int64
+	allowed   bool
+	remaining int
+	reset     time.Duration
+	retry     time.Duration
+}{
+	{"new bucket", 0, 10000, 11000, true, 2, time.Second, 0},
+	{"second request", 11000, 10000, 12000, true, 1, 2 * time.Second, 0},
+	{"last token", 12000, 10000, 13000, true, 0, 3 * time.Second, 0},
+	{"empty", 13000, 10000, 13000, false, 0, 3 * time.Second, time.Second},
+	{"empty, half refilled", 13000, 10500, 13000, false, 0, 2500 * time.Millisecond, 500 * time.Millisecond},
+	{"refilled one", 13000, 11000, 14000, true, 0, 3 * time.Second, 0},
+	{"full again", 13000, 20000, 21000, true, 2, time.Second, 0},
+}
+
+var vectorLimit = Limit{Requests: 1, Period: time.Second, Burst: 3}
+
+func TestTake(t *testing.T) {
+	for _, tc := range vectors {
+		t.Run(tc.name, func(t *testing.T) {
+			newTat, res := take(tc.tat, tc.now, vectorLimit)
+			want := Result{Allowed: tc.allowed, Limit: 3, Remaining: tc.remaining, Reset: tc.reset, RetryAfter: tc.retry}
+			if res != want || newTat != tc.newTat {
+				t.Errorf("Expected %+v and TAT %d but got %+v and %d", want, tc.newTat, res, newTat)
+			}
+		})
+	}
+}
+
+// TestScript runs the Lua script of the Redis limiter on the server at
+// $REDIS_ADDR, which the test server cannot stand in for, with the vectors
+// take is tested with
+func TestScript(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR is not set")
+	}
+	c := redis.New(redis.Options{Addr: addr})
+	defer c.Close()
+	testVectors(t, c)
+}
+
+func TestRedisVectors(t *testing.T) {
+	c := redis.New(redis.Options{Addr: newServer(t).Addr()})
+	defer c.Close()
+	testVectors(t, c)
+}
+
+// testVectors checks that the Redis limiter using c answers the vectors
+func testVectors(t *testing.T, c *redis.Client) {
+	ctx := context.Background()
+	prefix := "article-api-test:ratelimit:" + strconv.FormatInt(time.Now().UnixNano(), 10) + ":"
+	for i, tc := range vectors {
+		t.Run(tc.name, func(t *testing.T) {
+			key := prefix + strconv.Itoa(i)
+			if tc.tat != 0 {
+				if _, err := c.Do(ctx, "SET", key, tc.tat, "PX", 60000); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := NewRedis(c, prefix)
+			r.now = func() time.Time { return time.UnixMilli(tc.now) }
+			res, err := r.Allow(ctx, strconv.Itoa(i), vectorLimit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Result{Allowed: tc.allowed, Limit: 3, Remaining: tc.remaining, Reset: tc.reset, RetryAfter: tc.retry}
+			if res != want {
+				t.Errorf("Expected %+v but got %+v", want, res)
+			}
+			v, err := c.Do(ctx, "GET", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tat, _ := redis.Int(v, nil); tc.allowed && tat != tc.newTat {
+				t.Errorf("Expected TAT %d to be stored but got %v", tc.newTat, v)
+			}
+		})
+	}
+}
+
+// exhaust takes tokens until the limiter refuses and returns how many were
+// allowed
+func exhaust(t *testing.T, lim Limiter, key string, l Limit) int {
+	t.Helper()
+	for n := 0; n < 100; n++ {
+		res, err := lim.Allow(context.Background(), key, l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			if res.RetryAfter <= 0 {
+				t.Errorf("Expected a positive retry after, got %v", res.RetryAfter)
+			}
+			return n
+		}
+	}
+	t.Fatal("Limiter never refused")
+	return 0
+}
+
+func TestMemory(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	l := Limit{Requests: 10, Period: time.Minute, Burst: 5}
+
+	if n := exhaust(t, m, "a", l); n != 5 {
+		t.Errorf("Expected 5 requests allowed but got %d", n)
+	}
+	// buckets are per key
+	if n := exhaust(t, m, "b", l); n != 5 {
+		t.Errorf("Expected 5 requests allowed for another key but got %d", n)
+	}
+
+	// one token every 6 seconds
+	now = now.Add(6 * time.Second)
+	if n := exhaust(t, m, "a", l); n != 1 {
+		t.Errorf("Expected 1 request allowed after refill but got %d", n)
+	}
+
+	// full buckets are forgotten
+	now = now.Add(time.Hour)
+	m.Allow(context.Background(), "c", l)
+	if len(m.tats) != 1 {
+		t.Errorf("Expected full buckets to be swept, %d left", len(m.tats))
+	}
+}
+
+// newServer returns a test server emulating the script of the Redis
+// limiter with take, which TestScript checks against the real script since
+// the test server cannot run Lua
+func newServer(t *testing.T) *redistest.Server {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	s.RegisterScript(script, func(db redistest.ScriptDB, keys []string, args []string) interface{} {
+		now, _ := strconv.ParseInt(args[0], 10, 64)
+		interval, _ := strconv.ParseInt(args[1], 10, 64)
+		burst, _ := strconv.Atoi(args[2])
+		v, _ := db.Get(keys[0])
+		tat, _ := strconv.ParseInt(v, 10, 64)
+
+		l := Limit{Requests: 1, Period: time.Duration(interval) * time.Millisecond, Burst: burst}
+		newTat, res := take(tat, now, l)
+		allowed := int64(0)
+		if res.Allowed {
+			allowed = 1
+			db.Set(keys[0], strconv.FormatInt(newTat, 10), time.Duration(newTat-now)*time.Millisecond)
+		}
+		return []interface{}{allowed, int64(res.Remaining), res.Reset.Milliseconds(), res.RetryAfter.Milliseconds()}
+	})
+	return s
+}
+
+func TestRedis(t *testing.T) {
+	s := newServer(t)
+	c := redis.New(redis.Options{Addr: s.Addr()})
+	defer c.Close()
+
+	now := time.Unix(1700000000, 0)
+	newLimiter := func() *Redis {
+		r := NewRedis(c, "ratelimit:")
+		r.now = func() time.Time { return now }
+		return r
+	}
+	l := Limit{Requests: 10, Period: time.Minute, Burst: 5}
+
+	// two replicas share the bucket
+	r1, r2 := newLimiter(), newLimiter()
+	if n := exhaust(t, r1, "a", l); n != 5 {
+		t.Errorf("Expected 5 requests allowed but got %d", n)
+	}
+	if n := exhaust(t, r2, "a", l); n != 0 {
+		t.Errorf("Expected the other replica to refuse but %d were allowed", n)
+	}
+
+	now = now.Add(6 * time.Second)
+	res, err := r2.Allow(context.Background(), "a", l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || res.Remaining != 0 || res.Limit != 5 {
+		t.Errorf("Expected one request allowed after refill, got %+v", res)
+	}
+
+	if keys := s.Keys(); len(keys) != 1 || keys[0] != "ratelimit:a" {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+}
@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/redis"
+)
+
+// script is take in Lua, run atomically on the server. The current time is
+// passed by the caller so the replicas' clocks must be synchronized.
+const script = `
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+  tat = now
+end
+local newTat = tat + interval
+local allowAt = newTat - interval * burst
+if now < allowAt then
+  return {0, 0, tat - now, allowAt - now}
+end
+redis.call('SET', KEYS[1], newTat, 'PX', newTat - now)
+return {1, math.floor((now - allowAt) / interval), newTat - now, 0}
+`
+
+// Redis keeps the buckets on a Redis protocol server shared by replicas
+type Redis struct {
+	c      *redis.Client
+	prefix string
+	script *redis.Script
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewRedis creates a limiter storing its buckets under keys starting with
+// prefix
+func NewRedis(c *redis.Client, prefix string) *Redis {
+	return &Redis{c: c, prefix: prefix, script: redis.NewScript(script), now: time.Now}
+}
+
+func (r *Redis) Allow(ctx context.Context, key string, l Limit) (Result, error) {
+	interval, burst := l.params()
+	reply, err := r.script.Run(ctx, r.c, []string{r.prefix + key}, r.now().UnixMilli(), interval, burst)
+	if err != nil {
+		return Result{}, err
+	}
+	vals, ok := reply.([]interface{})
+	if !ok || len(vals) != 4 {
+		return Result{}, fmt.Errorf("ratelimit: unexpected script reply %v", reply)
+	}
+	var n [4]int64
+	for i, v := range vals {
+		if n[i], err = redis.Int(v, nil); err != nil {
+			return Result{}, err
+		}
+	}
+
+	return Result{
+		Allowed:    n[0] == 1,
+		Limit:      int(burst),
+		Remaining:  int(n[1]),
+		Reset:      time.Duration(n[2]) * time.Millisecond,
+		RetryAfter: time.Duration(n[3]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,398 @@
+// Package redis is a minimal client for servers speaking the Redis
+// serialization protocol (RESP2), covering the commands used by the cache
+// and the rate limiter.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/internal/backoff"
+)
+
+// Nil is returned when the server replies with a nil bulk string or array,
+// for example GET on a missing key
+var Nil = errors.New("redis: nil")
+
+// ErrClosed is returned by commands issued after Close
+var ErrClosed = errors.New("redis: client is closed")
+
+// Error is an error reply sent by the server
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Options configures the connection to the server
+type Options struct {
+	// host:port of the server
+	Addr     string
+	Password string
+	DB       int
+	// max number of connections used for commands, subscriptions use
+	// their own connection
+	PoolSize int
+	// timeout for dialing, and for a command when ctx has no deadline
+	Timeout time.Duration
+}
+
+// Client is a pool of connections to a server, safe for concurrent use
+type Client struct {
+	o   Options
+	sem chan struct{}
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+// New creates a client, connections are opened lazily
+func New(o Options) *Client {
+	if o.PoolSize <= 0 {
+		o.PoolSize = 10
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 3 * time.Second
+	}
+	return &Client{o: o, sem: make(chan struct{}, o.PoolSize)}
+}
+
+// Do sends a command and returns its reply: a string for simple and bulk
+// strings, an int64 for integers, a []interface{} for arrays, or an error.
+// Error replies are returned as Error, nil replies as Nil.
+func (c *Client) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.deadline(ctx, cn)
+	reply, err := cn.do(args...)
+
+	// error replies leave the connection usable, anything else may not
+	var rerr Error
+	broken := err != nil && err != Nil && !errors.As(err, &rerr)
+	c.put(cn, broken)
+	return reply, err
+}
+
+// Close closes the idle connections, connections in use are closed when
+// they are returned
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.nc.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.sem
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	cn, err := c.dial(ctx)
+	if err != nil {
+		<-c.sem
+		return nil, err
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn, broken bool) {
+	c.mu.Lock()
+	if broken || c.closed {
+		cn.nc.Close()
+	} else {
+		c.idle = append(c.idle, cn)
+	}
+	c.mu.Unlock()
+	<-c.sem
+}
+
+func (c *Client) deadline(ctx context.Context, cn *conn) {
+	d, ok := ctx.Deadline()
+	if !ok {
+		d = time.Now().Add(c.o.Timeout)
+	}
+	cn.nc.SetDeadline(d)
+}
+
+// dial opens a connection, authenticating and selecting the database
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	d := net.Dialer{Timeout: c.o.Timeout}
+	nc, err := d.DialContext(ctx, "tcp", c.o.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	c.deadline(ctx, cn)
+
+	if c.o.Password != "" {
+		if _, err := cn.do("AUTH", c.o.Password); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if c.o.DB != 0 {
+		if _, err := cn.do("SELECT", c.o.DB); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (cn *conn) do(args ...interface{}) (interface{}, error) {
+	if err := cn.write(args...); err != nil {
+		return nil, err
+	}
+	return cn.read()
+}
+
+// write sends the command as an array of bulk strings
+func (cn *conn) write(args ...interface{}) error {
+	fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		var s string
+		switch v := a.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("redis: unsupported argument type %T", a)
+		}
+		fmt.Fprintf(cn.w, "$%d\r\n%s\r\n", len(s), s)
+	}
+	return cn.w.Flush()
+}
+
+// read parses a single reply
+func (cn *conn) read() (interface{}, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, Nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, Nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := cn.read()
+			if err != nil && err != Nil {
+				var rerr Error
+				if !errors.As(err, &rerr) {
+					return nil, err
+				}
+				item = rerr
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// String converts a reply to a string
+func String(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("redis: unexpected reply type %T for string", reply)
+	}
+	return s, nil
+}
+
+// Int converts a reply to an int64
+func Int(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, fmt.Errorf("redis: unexpected reply type %T for int", reply)
+}
+
+// Subscribe listens on channel and calls fn with the payload of every
+// message until ctx is done, reconnecting with backoff whenever the
+// connection is lost. The first subscription is confirmed before Subscribe
+// returns so that no message published afterwards is missed.
+func (c *Client) Subscribe(ctx context.Context, channel string, fn func(payload string)) error {
+	cn, err := c.subscribe(ctx, channel)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			c.listen(ctx, cn, fn)
+			if ctx.Err() != nil {
+				return
+			}
+
+			for n := 0; ; n++ {
+				if backoff.Sleep(ctx, backoff.Delay(n, 100*time.Millisecond, 5*time.Second)) != nil {
+					return
+				}
+				if cn, err = c.subscribe(ctx, channel); err == nil {
+					break
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *Client) subscribe(ctx context.Context, channel string) (*conn, error) {
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.deadline(ctx, cn)
+	if _, err := cn.do("SUBSCRIBE", channel); err != nil {
+		cn.nc.Close()
+		return nil, err
+	}
+	// messages may be far apart
+	cn.nc.SetDeadline(time.Time{})
+	return cn, nil
+}
+
+// listen delivers messages from a subscribed connection until it fails or
+// ctx is done
+func (c *Client) listen(ctx context.Context, cn *conn, fn func(payload string)) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			cn.nc.Close()
+		case <-stop:
+		}
+	}()
+	defer cn.nc.Close()
+
+	for {
+		reply, err := cn.read()
+		if err != nil {
+			return
+		}
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 3 || msg[0] != "message" {
+			continue
+		}
+		if payload, ok := msg[2].(string); ok {
+			fn(payload)
+		}
+	}
+}
+
+// Script is a Lua script run on the server, sent by its SHA1 digest once the
+// server has cached it
+type Script struct {
+	src string
+	sha string
+}
+
+// NewScript prepares the script with the given source
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+// Run evaluates the script with the given keys and arguments, sending its
+// source only if the server does not know it yet
+func (s *Script) Run(ctx context.Context, c *Client, keys []string, args ...interface{}) (interface{}, error) {
+	cmd := make([]interface{}, 0, 3+len(keys)+len(args))
+	cmd = append(cmd, "EVALSHA", s.sha, len(keys))
+	for _, k := range keys {
+		cmd = append(cmd, k)
+	}
+	cmd = append(cmd, args...)
+
+	reply, err := c.Do(ctx, cmd...)
+	var rerr Error
+	if errors.As(err, &rerr) && strings.HasPrefix(string(rerr), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", s.src
+		reply, err = c.Do(ctx, cmd...)
+	}
+	return reply, err
+}
@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/redis/redistest"
+)
+
+func TestClient(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c := New(Options{Addr: s.Addr(), PoolSize: 2})
+	defer c.Close()
+	ctx := context.Background()
+
+	if _, err := String(c.Do(ctx, "GET", "missing")); err != Nil {
+		t.Errorf("expected Nil for a missing key but got %v", err)
+	}
+
+	if _, err := c.Do(ctx, "SET", "k", []byte("v\r\nwith newline"), "PX", 60000); err != nil {
+		t.Fatal(err)
+	}
+	v, err := String(c.Do(ctx, "GET", "k"))
+	if err != nil || v != "v\r\nwith newline" {
+		t.Errorf("expected stored value but got %q, %v", v, err)
+	}
+
+	n, err := Int(c.Do(ctx, "INCR", "counter"))
+	if err != nil || n != 1 {
+		t.Errorf("expected INCR to return 1 but got %d, %v", n, err)
+	}
+
+	var rerr Error
+	if _, err := c.Do(ctx, "NOPE"); !errors.As(err, &rerr) {
+		t.Errorf("expected an error reply but got %v", err)
+	}
+
+	// the connection is still usable after an error reply
+	if n, err := Int(c.Do(ctx, "DEL", "k", "counter")); err != nil || n != 2 {
+		t.Errorf("expected 2 keys deleted but got %d, %v", n, err)
+	}
+}
+
+func TestSubscribeReconnects(t *testing.T) {
+	s, err := redistest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c := New(Options{Addr: s.Addr()})
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan string, 10)
+	if err := c.Subscribe(ctx, "events", func(p string) { received <- p }); err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(p string) {
+		t.Helper()
+		if _, err := c.Do(ctx, "PUBLISH", "events", p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(p string) {
+		t.Helper()
+		select {
+		case got := <-received:
+			if got != p {
+				t.Errorf("expected message %q but got %q", p, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for message %q", p)
+		}
+	}
+
+	publish("first")
+	expect("first")
+
+	// after the connection drops the subscription is restored
+	s.DropConnections()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n, _ := Int(c.Do(ctx, "PUBLISH", "events", "second"))
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription was not restored")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	expect("second")
+}
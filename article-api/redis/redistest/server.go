@@ -0,0 +1,514 @@
+// Package redistest provides an in-process server speaking the Redis
+// protocol for tests, implementing the subset of commands used by this
+// service.
+package redistest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory Redis protocol server listening on localhost
+type Server struct {
+	l net.Listener
+
+	mu     sync.Mutex
+	data   map[string]entry
+	subs   map[string]map[*client]bool
+	conns  map[*client]bool
+	closed bool
+
+	// Lua cannot be run here, scripts are emulated by Go funcs registered
+	// by their source, and known by their digest once sent with EVAL
+	scripts map[string]ScriptFunc
+	loaded  map[string]bool
+
+	wg sync.WaitGroup
+}
+
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+type client struct {
+	nc net.Conn
+	r  *bufio.Reader
+	wm sync.Mutex
+	w  *bufio.Writer
+}
+
+// NewServer starts a server on a random local port
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		l:       l,
+		data:    map[string]entry{},
+		subs:    map[string]map[*client]bool{},
+		conns:   map[*client]bool{},
+		scripts: map[string]ScriptFunc{},
+		loaded:  map[string]bool{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the host:port the server listens on
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Close stops the server and drops every connection
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	s.mu.Unlock()
+
+	s.l.Close()
+	s.wg.Wait()
+}
+
+// ScriptFunc emulates a Lua script. It runs atomically with access to the
+// data and returns the reply, built from the same types as the replies of
+// Do on the client.
+type ScriptFunc func(db ScriptDB, keys []string, args []string) interface{}
+
+// ScriptDB is the view of the data given to a ScriptFunc
+type ScriptDB interface {
+	// Get returns the value of a key that has not expired
+	Get(key string) (string, bool)
+	// Set stores value under key, expiring after ttl if it is positive
+	Set(key string, value string, ttl time.Duration)
+}
+
+// RegisterScript makes EVAL of the Lua script src run fn instead. As on a
+// real server EVALSHA fails with NOSCRIPT until the script was sent once.
+func (s *Server) RegisterScript(src string, fn ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[digest(src)] = fn
+}
+
+func digest(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// scriptDB gives scripts access to the data while s.mu is held
+type scriptDB struct{ s *Server }
+
+func (db scriptDB) Get(key string) (string, bool) {
+	e, ok := db.s.lookup(key)
+	return e.value, ok
+}
+
+func (db scriptDB) Set(key string, value string, ttl time.Duration) {
+	e := entry{value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	db.s.data[key] = e
+}
+
+// DropConnections closes every client connection, simulating a restart
+// that keeps the data
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.nc.Close()
+	}
+}
+
+// Keys returns the sorted names of the keys that have not expired
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for k := range s.data {
+		if _, ok := s.lookup(k); ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c *client) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		for _, subs := range s.subs {
+			delete(subs, c)
+		}
+		s.mu.Unlock()
+		c.nc.Close()
+	}()
+
+	for {
+		args, err := readCommand(c.r)
+		if err != nil {
+			return
+		}
+		reply := s.exec(c, args)
+		if reply == nil {
+			continue
+		}
+		if err := c.send(reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("expected array, got %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+// replies are encoded from these types
+type (
+	simple  string
+	errResp string
+	nilResp struct{}
+)
+
+func (c *client) send(reply interface{}) error {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	encode(c.w, reply)
+	return c.w.Flush()
+}
+
+func encode(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case simple:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case errResp:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case nilResp:
+		w.WriteString("$-1\r\n")
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			encode(w, item)
+		}
+	}
+}
+
+var errSyntax = errResp("ERR syntax error")
+
+// lookup returns the live entry for key, removing it if it expired.
+// s.mu must be held.
+func (s *Server) lookup(key string) (entry, bool) {
+	e, ok := s.data[key]
+	if ok && !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		delete(s.data, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+func (s *Server) exec(c *client, args []string) interface{} {
+	if len(args) == 0 {
+		return errSyntax
+	}
+
+	cmd := strings.ToUpper(args[0])
+	if cmd == "SUBSCRIBE" {
+		return s.subscribe(c, args[1:])
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch cmd {
+	case "PING":
+		return simple("PONG")
+	case "AUTH", "SELECT":
+		return simple("OK")
+	case "GET":
+		if len(args) != 2 {
+			return errSyntax
+		}
+		e, ok := s.lookup(args[1])
+		if !ok {
+			return nilResp{}
+		}
+		return e.value
+	case "SET":
+		return s.set(args[1:])
+	case "DEL":
+		n := 0
+		for _, k := range args[1:] {
+			if _, ok := s.lookup(k); ok {
+				delete(s.data, k)
+				n++
+			}
+		}
+		return n
+	case "INCR":
+		if len(args) != 2 {
+			return errSyntax
+		}
+		e, _ := s.lookup(args[1])
+		n, err := strconv.ParseInt(orZero(e.value), 10, 64)
+		if err != nil {
+			return errResp("ERR value is not an integer or out of range")
+		}
+		e.value = strconv.FormatInt(n+1, 10)
+		s.data[args[1]] = e
+		return n + 1
+	case "PEXPIRE":
+		if len(args) != 3 {
+			return errSyntax
+		}
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errSyntax
+		}
+		e, ok := s.lookup(args[1])
+		if !ok {
+			return 0
+		}
+		e.expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		s.data[args[1]] = e
+		return 1
+	case "PTTL":
+		if len(args) != 2 {
+			return errSyntax
+		}
+		e, ok := s.lookup(args[1])
+		if !ok {
+			return -2
+		}
+		if e.expires.IsZero() {
+			return -1
+		}
+		return int64(time.Until(e.expires) / time.Millisecond)
+	case "SCAN":
+		return s.scan(args[1:])
+	case "EVAL", "EVALSHA":
+		return s.eval(cmd, args[1:])
+	case "PUBLISH":
+		if len(args) != 3 {
+			return errSyntax
+		}
+		return s.publish(args[1], args[2])
+	}
+	return errResp(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+}
+
+func orZero(s string) string {
+	if s == "" {
+		return "0"
+	}
+	return s
+}
+
+// set implements SET key value [PX ms|EX s] [NX|XX]. s.mu must be held.
+func (s *Server) set(args []string) interface{} {
+	if len(args) < 2 {
+		return errSyntax
+	}
+	e := entry{value: args[1]}
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "PX", "EX":
+			if i+1 >= len(args) {
+				return errSyntax
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return errResp("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Millisecond
+			if strings.ToUpper(args[i]) == "EX" {
+				unit = time.Second
+			}
+			e.expires = time.Now().Add(time.Duration(n) * unit)
+			i++
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return errSyntax
+		}
+	}
+
+	_, exists := s.lookup(args[0])
+	if (nx && exists) || (xx && !exists) {
+		return nilResp{}
+	}
+	s.data[args[0]] = e
+	return simple("OK")
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT n], returning every
+// match in a single batch. s.mu must be held.
+func (s *Server) scan(args []string) interface{} {
+	if len(args) < 1 {
+		return errSyntax
+	}
+	pattern := "*"
+	for i := 1; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+		default:
+			return errSyntax
+		}
+	}
+
+	keys := []interface{}{}
+	for k := range s.data {
+		if _, ok := s.lookup(k); !ok {
+			continue
+		}
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	}
+	return []interface{}{"0", keys}
+}
+
+// eval implements EVAL script numkeys [key ...] [arg ...] and its EVALSHA
+// variant for registered scripts. s.mu must be held.
+func (s *Server) eval(cmd string, args []string) interface{} {
+	if len(args) < 2 {
+		return errSyntax
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 || n > len(args)-2 {
+		return errResp("ERR Number of keys can't be greater than number of args")
+	}
+
+	sha := strings.ToLower(args[0])
+	if cmd == "EVAL" {
+		sha = digest(args[0])
+		if s.scripts[sha] == nil {
+			return errResp("ERR scripts must be registered with the test server")
+		}
+		s.loaded[sha] = true
+	}
+	if !s.loaded[sha] {
+		return errResp("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return s.scripts[sha](scriptDB{s}, args[2:2+n], args[2+n:])
+}
+
+// publish delivers the message to every subscriber. s.mu must be held.
+func (s *Server) publish(channel string, payload string) int {
+	n := 0
+	for c := range s.subs[channel] {
+		if c.send([]interface{}{"message", channel, payload}) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) subscribe(c *client, channels []string) interface{} {
+	if len(channels) == 0 {
+		return errResp("ERR wrong number of arguments for 'subscribe' command")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, ch := range channels {
+		if s.subs[ch] == nil {
+			s.subs[ch] = map[*client]bool{}
+		}
+		s.subs[ch][c] = true
+		if err := c.send([]interface{}{"subscribe", ch, i + 1}); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
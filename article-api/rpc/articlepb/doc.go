@@ -0,0 +1,5 @@
+// Package articlepb holds the protobuf messages and gRPC service generated
+// from articles.proto.
+package articlepb
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative rpc/articlepb/articles.proto
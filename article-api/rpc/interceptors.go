@@ -0,0 +1,221 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/handlers"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/ratelimit"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "article_api",
+		Subsystem: "grpc",
+		Name:      "requests_total",
+		Help:      "gRPC calls handled, by method and status code.",
+	}, []string{"method", "code"})
+	durations = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "article_api",
+		Subsystem: "grpc",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle gRPC calls, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// interceptor runs around every call, unary or streaming, calling next
+// with the context the call goes on with
+type interceptor func(ctx context.Context, method string, next func(context.Context) error) error
+
+// unary chains the interceptors around unary calls, the first one being the
+// outermost
+func unary(is ...interceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var resp interface{}
+		err := chain(ctx, info.FullMethod, is, func(ctx context.Context) error {
+			var err error
+			resp, err = handler(ctx, req)
+			return err
+		})
+		return resp, err
+	}
+}
+
+// stream chains the interceptors around streaming calls, the first one
+// being the outermost
+func stream(is ...interceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return chain(ss.Context(), info.FullMethod, is, func(ctx context.Context) error {
+			return handler(srv, &serverStream{ss, ctx})
+		})
+	}
+}
+
+func chain(ctx context.Context, method string, is []interceptor, call func(context.Context) error) error {
+	if len(is) == 0 {
+		return call(ctx)
+	}
+	return is[0](ctx, method, func(ctx context.Context) error {
+		return chain(ctx, method, is[1:], call)
+	})
+}
+
+// serverStream is a stream going on with another context
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// metrics counts the calls by method and code and records their duration
+func metrics(ctx context.Context, method string, next func(context.Context) error) error {
+	start := time.Now()
+	err := next(ctx)
+	requests.WithLabelValues(method, status.Code(err).String()).Inc()
+	durations.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// logger assigns every call an id, taken from the x-request-id metadata
+// when the client supplies a sane one, echoes it in the response header,
+// stores a logger tagged with it in the context and writes one access log
+// line once the call has been handled. The context also identifies the
+// client like the HTTP routes do, so that reads following its writes are
+// served from the primary database.
+func logger(l *zap.Logger, keys *handlers.APIKeys) interceptor {
+	return func(ctx context.Context, method string, next func(context.Context) error) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		id := handlers.RequestID(first(md, handlers.RequestIDHeader))
+		grpc.SetHeader(ctx, metadata.Pairs(handlers.RequestIDHeader, id))
+
+		ip := peerIP(ctx)
+		ctx = logging.WithRequestID(ctx, id)
+		ctx = logging.WithLogger(ctx, l.With(zap.String("request_id", id)))
+		ctx = data.WithClient(ctx, clientKey(ctx, keys))
+
+		start := time.Now()
+		err := next(ctx)
+		logging.FromContext(ctx, l).Info("Access",
+			zap.String("method", method),
+			zap.String("code", status.Code(err).String()),
+			zap.Duration("duration", time.Since(start)),
+			zap.String("client_ip", ip),
+		)
+		return err
+	}
+}
+
+// RateLimit limits the unary calls of every client with the buckets of the
+// HTTP routes, so that a client gets one quota whichever API it calls
+type RateLimit struct {
+	// nil disables rate limiting
+	Limiter     ratelimit.Limiter
+	Read, Write ratelimit.Limit
+}
+
+// rateLimitGroups maps the unary methods to the rate limit group of the
+// routes they mirror
+var rateLimitGroups = map[string]string{
+	"/article.v1.Articles/GetArticle":    "read",
+	"/article.v1.Articles/GetTagSummary": "read",
+	"/article.v1.Articles/CreateArticle": "write",
+}
+
+// rateLimit refuses the calls of clients that ran out of tokens with
+// ResourceExhausted, telling them how long to wait in the retry-after
+// header. Methods outside rateLimitGroups are let through, as are calls
+// made while the limiter fails.
+func rateLimit(l *zap.Logger, keys *handlers.APIKeys, rl RateLimit) interceptor {
+	return func(ctx context.Context, method string, next func(context.Context) error) error {
+		group, ok := rateLimitGroups[method]
+		if !ok || rl.Limiter == nil {
+			return next(ctx)
+		}
+		limit := rl.Read
+		if group == "write" {
+			limit = rl.Write
+		}
+		res, err := rl.Limiter.Allow(ctx, group+":"+clientKey(ctx, keys), limit)
+		if err != nil {
+			logging.FromContext(ctx, l).Warn("Rate limiter failed, allowing call", zap.Error(err))
+			return next(ctx)
+		}
+		if res.Allowed {
+			return next(ctx)
+		}
+
+		retry := strconv.FormatInt(int64((res.RetryAfter+time.Second-1)/time.Second), 10)
+		logging.FromContext(ctx, l).Info("Rate limit exceeded",
+			zap.String("group", group), zap.Duration("retry_after", res.RetryAfter))
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", retry))
+		return status.Error(codes.ResourceExhausted, "Too many requests, retry in "+retry+" seconds.")
+	}
+}
+
+// auth only lets through calls to the methods listed in scopes whose
+// x-api-key metadata holds a key granting the method's scope. Calls without
+// a known key are refused with Unauthenticated, those whose key lacks the
+// scope with PermissionDenied.
+func auth(keys *handlers.APIKeys, scopes map[string]string) interceptor {
+	return func(ctx context.Context, method string, next func(context.Context) error) error {
+		scope, ok := scopes[method]
+		if !ok {
+			return next(ctx)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		key := first(md, handlers.APIKeyHeader)
+		known, granted := keys.Grants(key, scope)
+		switch {
+		case key == "" || !known:
+			return status.Error(codes.Unauthenticated, "A valid API key is required in the "+strings.ToLower(handlers.APIKeyHeader)+" metadata.")
+		case !granted:
+			return status.Error(codes.PermissionDenied, "The API key does not grant the "+scope+" scope.")
+		}
+		return next(ctx)
+	}
+}
+
+// clientKey identifies the client of a call by its API key when it is one
+// of keys, or else by its address
+func clientKey(ctx context.Context, keys *handlers.APIKeys) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return handlers.ClientKey(keys, first(md, handlers.APIKeyHeader), peerIP(ctx))
+}
+
+// first returns the first value of the metadata key k
+func first(md metadata.MD, k string) string {
+	if vs := md.Get(k); len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// peerIP returns the address of the client
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
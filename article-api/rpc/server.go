@@ -0,0 +1,206 @@
+// Package rpc serves the articles over gRPC, backed by the same data layer
+// as the REST handlers and answering the same way: the same validation, the
+// same errors mapped to gRPC status codes, and the same scopes required.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/handlers"
+	"github.com/sg83/go-microservice/article-api/logging"
+	"github.com/sg83/go-microservice/article-api/rpc/articlepb"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements the Articles gRPC service
+type Server struct {
+	articlepb.UnimplementedArticlesServer
+
+	l  *zap.Logger
+	db data.ArticlesData
+	v  *data.Validation
+}
+
+// NewServer returns the Articles service reading and writing db
+func NewServer(l *zap.Logger, db data.ArticlesData, v *data.Validation) *Server {
+	return &Server{l: l, db: db, v: v}
+}
+
+// New returns a gRPC server serving the Articles service with the logging,
+// metrics and auth interceptors, and rate limiting the unary calls with rl.
+// ListArticles requires a key granting the admin scope, like the export it
+// mirrors.
+func New(l *zap.Logger, db data.ArticlesData, v *data.Validation, keys *handlers.APIKeys, rl RateLimit) *grpc.Server {
+	scopes := map[string]string{
+		"/article.v1.Articles/ListArticles": handlers.ScopeAdmin,
+	}
+	is := []interceptor{metrics, logger(l, keys), auth(keys, scopes)}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unary(append(is, rateLimit(l, keys, rl))...)),
+		grpc.ChainStreamInterceptor(stream(is...)),
+	)
+	articlepb.RegisterArticlesServer(s, NewServer(l, db, v))
+	return s
+}
+
+// GetArticle returns an article by id
+func (s *Server) GetArticle(ctx context.Context, req *articlepb.GetArticleRequest) (*articlepb.Article, error) {
+	l := logging.FromContext(ctx, s.l)
+	l.Info("Get article", zap.Int64("id", req.Id))
+
+	if req.Id < 1 || req.Id > maxID {
+		return nil, toStatus(l, &data.InvalidError{Reason: "article id " + strconv.FormatInt(req.Id, 10) + " is out of range"})
+	}
+	a, err := s.db.GetArticleByID(ctx, int(req.Id))
+	if err != nil {
+		return nil, toStatus(l, err)
+	}
+	return toProto(a), nil
+}
+
+// maxID is the largest article id, that of a postgres integer
+const maxID = 1<<31 - 1
+
+// CreateArticle validates and adds an article
+func (s *Server) CreateArticle(ctx context.Context, req *articlepb.CreateArticleRequest) (*articlepb.CreateArticleResponse, error) {
+	l := logging.FromContext(ctx, s.l)
+
+	a := fromProto(req.Article)
+	if errs := s.v.Validate(a, acceptedLanguages(ctx)...); len(errs) != 0 {
+		l.Error("Validating article", zap.Strings("Errors: ", errs.Errors()))
+		return nil, toStatus(l, errs)
+	}
+
+	l.Info("Inserting ", zap.Any("article: ", a))
+	if err := s.db.AddArticle(ctx, *a); err != nil {
+		return nil, toStatus(l, err)
+	}
+	return &articlepb.CreateArticleResponse{}, nil
+}
+
+// ListArticles streams the articles matching the request in id order
+func (s *Server) ListArticles(req *articlepb.ListArticlesRequest, srv articlepb.Articles_ListArticlesServer) error {
+	ctx := srv.Context()
+	l := logging.FromContext(ctx, s.l)
+	l.Info("List articles", zap.String("from", req.From), zap.String("to", req.To), zap.String("tag", req.Tag))
+
+	f := data.ExportFilter{From: req.From, To: req.To, Tag: req.Tag}
+	err := s.db.ExportArticles(ctx, f, func(a data.Article) error {
+		return srv.Send(toProto(&a))
+	})
+	if err != nil {
+		return toStatus(l, err)
+	}
+	return nil
+}
+
+// GetTagSummary returns the articles and related tags of a tag on a day
+func (s *Server) GetTagSummary(ctx context.Context, req *articlepb.GetTagSummaryRequest) (*articlepb.Tag, error) {
+	l := logging.FromContext(ctx, s.l)
+	l.Info("Get tag summary", zap.String("tag:", req.Tag), zap.String("date:", req.Date))
+
+	if !data.ValidTagDate(req.Date) {
+		return nil, toStatus(l, &data.InvalidError{Reason: "date " + req.Date + " is not a valid YYYYMMDD date"})
+	}
+	ids, err := s.db.GetArticlesForTagAndDate(ctx, req.Tag, req.Date)
+	if err != nil {
+		return nil, toStatus(l, err)
+	}
+	if len(ids) == 0 {
+		return nil, toStatus(l, &data.NotFoundError{Resource: "articles with tag", Key: req.Tag + " on " + req.Date})
+	}
+	related, err := s.db.GetRelatedTagsForTag(ctx, req.Tag, ids)
+	if err != nil {
+		return nil, toStatus(l, err)
+	}
+	if len(related) == 0 {
+		return nil, toStatus(l, &data.NotFoundError{Resource: "related tags of", Key: req.Tag + " on " + req.Date})
+	}
+
+	t := &articlepb.Tag{Tag: req.Tag, Count: int64(len(ids)), RelatedTags: related}
+	for _, id := range ids {
+		t.Articles = append(t.Articles, int64(id))
+	}
+	return t, nil
+}
+
+// toProto converts an article to its message
+func toProto(a *data.Article) *articlepb.Article {
+	pa := &articlepb.Article{
+		Id:    int64(a.ID),
+		Title: a.Title,
+		Date:  a.Date,
+		Body:  a.Body,
+		Tags:  a.Tags,
+	}
+	if a.UpdatedAt != nil {
+		pa.UpdatedAt = timestamppb.New(*a.UpdatedAt)
+	}
+	return pa
+}
+
+// fromProto converts a message to the article to add, dropping the fields
+// set by the server
+func fromProto(pa *articlepb.Article) *data.Article {
+	if pa == nil {
+		return &data.Article{}
+	}
+	return &data.Article{Title: pa.Title, Date: pa.Date, Body: pa.Body, Tags: pa.Tags}
+}
+
+// acceptedLanguages reads the languages validation messages are translated
+// to from the accept-language metadata
+func acceptedLanguages(ctx context.Context) []string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var langs []string
+	for _, v := range md.Get("accept-language") {
+		langs = append(langs, handlers.AcceptedLanguages(v)...)
+	}
+	return langs
+}
+
+// toStatus returns the status matching err, mapping the domain errors of
+// the data package to their code like the REST handlers map them to their
+// status. Other errors are logged and reported as internal errors without
+// details.
+func toStatus(l *zap.Logger, err error) error {
+	var (
+		notFound   *data.NotFoundError
+		conflict   *data.ConflictError
+		invalid    *data.InvalidError
+		validation data.ValidationErrors
+	)
+
+	switch {
+	case errors.As(err, &notFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.As(err, &conflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &validation):
+		br := &errdetails.BadRequest{}
+		for _, fe := range validation {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fe.Path(),
+				Description: fe.Message(),
+			})
+		}
+		st, _ := status.New(codes.InvalidArgument, "The article has invalid fields.").WithDetails(br)
+		return st.Err()
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		l.Error("Request failed", zap.Error(err))
+		return status.Error(codes.Internal, "")
+	}
+}
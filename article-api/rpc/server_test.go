@@ -0,0 +1,507 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/config"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/export"
+	"github.com/sg83/go-microservice/article-api/handlers"
+	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/sg83/go-microservice/article-api/ratelimit"
+	"github.com/sg83/go-microservice/article-api/rpc/articlepb"
+	"github.com/sg83/go-microservice/article-api/server"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var keys = handlers.NewAPIKeys(map[string][]string{"admin-key": {handlers.ScopeAdmin}, "other-key": {"other"}})
+
+// statusCodes maps the statuses of the REST handlers to the codes of the gRPC
+// service answering the same way
+var statusCodes = map[int]codes.Code{
+	http.StatusOK:                  codes.OK,
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusUnprocessableEntity: codes.InvalidArgument,
+	http.StatusInternalServerError: codes.Internal,
+}
+
+// restAPI routes the REST handlers over db like the service does
+func restAPI(t *testing.T, db data.ArticlesData) http.Handler {
+	l := zap.NewNop()
+	sm, err := server.NewRouter(l, config.Default(), server.Routes{
+		Articles: handlers.NewArticles(l, db, data.NewValidation()),
+		Webhooks: handlers.NewWebhooks(l, &mocks.WebhooksData{}, data.NewValidation()),
+		Health:   handlers.NewHealth(l),
+		APIKeys:  keys,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sm
+}
+
+// grpcAPI serves the gRPC service over db until the test ends
+func grpcAPI(t *testing.T, db data.ArticlesData, rl RateLimit) articlepb.ArticlesClient {
+	lis := bufconn.Listen(1 << 20)
+	s := New(zap.NewNop(), db, data.NewValidation(), keys, rl)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return articlepb.NewArticlesClient(conn)
+}
+
+// fromPB converts a message of the service to the type of the matching
+// REST response
+func fromPB(m interface{}) interface{} {
+	switch m := m.(type) {
+	case *articlepb.Article:
+		a := data.Article{ID: int(m.Id), Title: m.Title, Date: m.Date, Body: m.Body, Tags: m.Tags}
+		if m.UpdatedAt != nil {
+			t := m.UpdatedAt.AsTime()
+			a.UpdatedAt = &t
+		}
+		return &a
+	case *articlepb.Tag:
+		t := data.Tag{Tag: m.Tag, Count: int(m.Count), RelatedTags: m.RelatedTags}
+		for _, id := range m.Articles {
+			t.Articles = append(t.Articles, int(id))
+		}
+		return &t
+	case []*articlepb.Article:
+		as := []data.Article{}
+		for _, a := range m {
+			as = append(as, *fromPB(a).(*data.Article))
+		}
+		return as
+	}
+	return nil
+}
+
+// exportAll stands in for the database, sending every article to fn
+func exportAll(articles []data.Article) func(context.Context, data.ExportFilter, func(data.Article) error) error {
+	return func(ctx context.Context, f data.ExportFilter, fn func(data.Article) error) error {
+		for _, a := range articles {
+			if err := fn(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// list collects the articles streamed by ListArticles
+func list(ctx context.Context, c articlepb.ArticlesClient, req *articlepb.ListArticlesRequest) (interface{}, error) {
+	s, err := c.ListArticles(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var as []*articlepb.Article
+	for {
+		a, err := s.Recv()
+		if err == io.EOF {
+			return as, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, a)
+	}
+}
+
+func TestParity(t *testing.T) {
+	updated := time.Date(2023, 4, 5, 10, 4, 11, 0, time.UTC)
+	article := &data.Article{ID: 1, Title: "Article1", Date: "2016-09-22", Body: "Body", Tags: []string{"health", "fitness"}, UpdatedAt: &updated}
+	articles := []data.Article{*article, {ID: 2, Title: "Article2", Date: "2016-09-23", Body: "Body", Tags: []string{"health"}}}
+
+	tt := []struct {
+		name string
+		mock func(db *mocks.ArticlesData)
+		// the REST request, sent with the header
+		method, path, body string
+		header             http.Header
+		// the matching call of the service
+		call func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error)
+		// the type the REST response is decoded into
+		resp func() interface{}
+	}{
+		{
+			name: "get article",
+			mock: func(db *mocks.ArticlesData) {
+				db.On("GetArticleByID", mock.Anything, 1).Return(article, nil)
+			},
+			method: http.MethodGet, path: "/articles/1",
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				return c.GetArticle(ctx, &articlepb.GetArticleRequest{Id: 1})
+			},
+			resp: func() interface{} { return &data.Article{} },
+		},
+		{
+			name: "article not found",
+			mock: func(db *mocks.ArticlesData) {
+				db.On("GetArticleByID", mock.Anything, 3).Return(nil, &data.NotFoundError{Resource: "article", Key: "3"})
+			},
+			method: http.MethodGet, path: "/articles/3",
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				return c.GetArticle(ctx, &articlepb.GetArticleRequest{Id: 3})
+			},
+		},
+		{
+			name: "get article failed",
+			mock: func(db *mocks.ArticlesData) {
+				db.On("GetArticleByID", mock.Anything, 4).Return(nil, errors.New("connection refused"))
+			},
+			method: http.MethodGet, path: "/articles/4",
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				return c.GetArticle(ctx, &articlepb.GetArticleRequest{Id: 4})
+			},
+		},
+		{
+			name: "create article",
+			mock: func(db *mocks.ArticlesData) {
+				db.On("AddArticle", mock.Anything, data.Article{Title: "Article1", Date: "2016-09-22", Body: "Body", Tags: []string{"health"}}).Return(nil)
+			},
+			method: http.MethodPost, path: "/articles", body: `{"title": "Article1", "date": "2016-09-22", "body": "Body", "tags": ["health"]}`,
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				_, err := c.CreateArticle(ctx, &articlepb.CreateArticleRequest{Article: &articlepb.Article{Title: "Article1", Date: "2016-09-22", Body: "Body", Tags: []string{"health"}}})
+				return nil, err
+			},
+		},
+		{
+			name:   "invalid article",
+			mock:   func(db *mocks.ArticlesData) {},
+			method: http.MethodPost, path: "/articles", body: `{"title": "Article1", "date": "22-09-2016", "body": "", "tags": ["health"]}`,
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				_, err := c.CreateArticle(ctx, &articlepb.CreateArticleRequest{Article: &articlepb.Article{Title: "Article1", Date: "22-09-2016", Tags: []string{"health"}}})
+				return nil, err
+			},
+		},
+		{
+			name: "conflicting article",
+			mock: func(db *mocks.ArticlesData) {
+				db.On("AddArticle", mock.Anything, mock.Anything).Return(&data.ConflictError{Resource: "article"})
+			},
+			method: http.MethodPost, path: "/articles", body: `{"title": "Article1", "date": "2016-09-22", "body": "Body"}`,
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				_, err := c.CreateArticle(ctx, &articlepb.CreateArticleRequest{Article: &articlepb.Article{Title: "Article1", Date: "2016-09-22", Body: "Body"}})
+				return nil, err
+			},
+		},
+		{
+			name: "tag summary",
+			mock: func(db *mocks.ArticlesData) {
+				db.On("GetArticlesForTagAndDate", mock.Anything, "health", "20160922").Return([]int{1, 7}, nil)
+				db.On("GetTagLastModified", mock.Anything, "health", "20160922").Return(updated, nil)
+				db.On("GetRelatedTagsForTag", mock.Anything, "health", []int{1, 7}).Return([]string{"fitness"}, nil)
+			},
+			method: http.MethodGet, path: "/tags/health/20160922",
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				return c.GetTagSummary(ctx, &articlepb.GetTagSummaryRequest{Tag: "health", Date: "20160922"})
+			},
+			resp: func() interface{} { return &data.Tag{} },
+		},
+		{
+			name:   "tag summary of invalid date",
+			mock:   func(db *mocks.ArticlesData) {},
+			method: http.MethodGet, path: "/tags/health/2016-09-22",
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				return c.GetTagSummary(ctx, &articlepb.GetTagSummaryRequest{Tag: "health", Date: "2016-09-22"})
+			},
+		},
+		{
+			name: "tag summary without articles",
+			mock: func(db *mocks.ArticlesData) {
+				db.On("GetArticlesForTagAndDate", mock.Anything, "yoga", "20160922").Return([]int{}, nil)
+			},
+			method: http.MethodGet, path: "/tags/yoga/20160922",
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				return c.GetTagSummary(ctx, &articlepb.GetTagSummaryRequest{Tag: "yoga", Date: "20160922"})
+			},
+		},
+		{
+			name: "list articles",
+			mock: func(db *mocks.ArticlesData) {
+				db.On("ExportArticles", mock.Anything, data.ExportFilter{From: "2016-09-01", Tag: "health"}, mock.Anything).Return(exportAll(articles))
+			},
+			method: http.MethodGet, path: "/export?from=2016-09-01&tag=health",
+			header: http.Header{handlers.APIKeyHeader: {"admin-key"}},
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				ctx = metadata.AppendToOutgoingContext(ctx, handlers.APIKeyHeader, "admin-key")
+				return list(ctx, c, &articlepb.ListArticlesRequest{From: "2016-09-01", Tag: "health"})
+			},
+		},
+		{
+			name:   "list articles without key",
+			mock:   func(db *mocks.ArticlesData) {},
+			method: http.MethodGet, path: "/export",
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				return list(ctx, c, &articlepb.ListArticlesRequest{})
+			},
+		},
+		{
+			name:   "list articles without scope",
+			mock:   func(db *mocks.ArticlesData) {},
+			method: http.MethodGet, path: "/export",
+			header: http.Header{handlers.APIKeyHeader: {"other-key"}},
+			call: func(ctx context.Context, c articlepb.ArticlesClient) (interface{}, error) {
+				ctx = metadata.AppendToOutgoingContext(ctx, handlers.APIKeyHeader, "other-key")
+				return list(ctx, c, &articlepb.ListArticlesRequest{})
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			restDB, grpcDB := new(mocks.ArticlesData), new(mocks.ArticlesData)
+			tc.mock(restDB)
+			tc.mock(grpcDB)
+
+			req := httptest.NewRequest(tc.method, "/v1"+tc.path, bytes.NewBufferString(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			for k, vs := range tc.header {
+				for _, v := range vs {
+					req.Header.Add(k, v)
+				}
+			}
+			w := httptest.NewRecorder()
+			restAPI(t, restDB).ServeHTTP(w, req)
+
+			got, err := tc.call(context.Background(), grpcAPI(t, grpcDB, RateLimit{}))
+			code, ok := statusCodes[w.Code]
+			if !ok {
+				t.Fatalf("Unexpected REST status code %d", w.Code)
+			}
+			if status.Code(err) != code {
+				t.Fatalf("REST answered %d but the service %v", w.Code, err)
+			}
+
+			// both went through the same calls to the data layer
+			if !reflect.DeepEqual(methods(restDB), methods(grpcDB)) {
+				t.Errorf("REST called %v but the service %v", methods(restDB), methods(grpcDB))
+			}
+
+			switch {
+			case w.Code == http.StatusUnprocessableEntity:
+				checkViolations(t, w.Body.Bytes(), err)
+			case err != nil || got == nil:
+			case reflect.TypeOf(got) == reflect.TypeOf([]*articlepb.Article{}):
+				var want []data.Article
+				r := export.NewReader(w.Body, export.NDJSON)
+				for {
+					a, err := r.Read()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					want = append(want, a)
+				}
+				if !reflect.DeepEqual(fromPB(got), want) {
+					t.Errorf("REST answered %+v but the service %+v", want, fromPB(got))
+				}
+			default:
+				want := tc.resp()
+				if err := json.Unmarshal(w.Body.Bytes(), want); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(fromPB(got), want) {
+					t.Errorf("REST answered %+v but the service %+v", want, fromPB(got))
+				}
+			}
+		})
+	}
+}
+
+// methods lists the methods of db called, in order, with their arguments
+// but the context. GetTagLastModified is left out since only the REST
+// handlers answer conditional requests.
+func methods(db *mocks.ArticlesData) []string {
+	var ms []string
+	for _, c := range db.Calls {
+		if c.Method == "GetTagLastModified" {
+			continue
+		}
+		args, _ := json.Marshal(c.Arguments[1:])
+		ms = append(ms, c.Method+string(args))
+	}
+	return ms
+}
+
+// checkViolations fails the test unless the field violations of err are
+// the field errors of the REST problem body
+func checkViolations(t *testing.T, body []byte, err error) {
+	t.Helper()
+	var p struct {
+		Errors []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range status.Convert(err).Details() {
+		if br, ok := d.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				got = append(got, v.Field+": "+v.Description)
+			}
+		}
+	}
+	var want []string
+	for _, fe := range p.Errors {
+		want = append(want, fe.Field+": "+fe.Message)
+	}
+	if len(want) == 0 || !reflect.DeepEqual(got, want) {
+		t.Errorf("REST reported %v but the service %v", want, got)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	db := new(mocks.ArticlesData)
+	db.On("GetArticleByID", mock.Anything, 1).Return(&data.Article{ID: 1}, nil)
+	c := grpcAPI(t, db, RateLimit{})
+
+	for sent, echoed := range map[string]bool{"req-1": true, "": false, "bad id": false} {
+		ctx := context.Background()
+		if sent != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, handlers.RequestIDHeader, sent)
+		}
+		var md metadata.MD
+		if _, err := c.GetArticle(ctx, &articlepb.GetArticleRequest{Id: 1}, grpc.Header(&md)); err != nil {
+			t.Fatal(err)
+		}
+		got := md.Get(handlers.RequestIDHeader)
+		switch {
+		case len(got) != 1 || got[0] == "":
+			t.Errorf("Sent %q: expected a request id but got %v", sent, got)
+		case echoed != (got[0] == sent):
+			t.Errorf("Sent %q: unexpected request id %q", sent, got[0])
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	db := new(mocks.ArticlesData)
+	db.On("GetArticleByID", mock.Anything, 1).Return(&data.Article{ID: 1}, nil)
+	db.On("ExportArticles", mock.Anything, mock.Anything, mock.Anything).Return(exportAll(nil))
+	one := ratelimit.Limit{Requests: 1, Period: time.Hour, Burst: 1}
+
+	tt := []struct {
+		name string
+		// keys sent by the first and second calls
+		first, second string
+		call          func(context.Context, articlepb.ArticlesClient) error
+		code          codes.Code
+	}{
+		{
+			name: "same address",
+			call: getArticle,
+			code: codes.ResourceExhausted,
+		},
+		{
+			name:   "same key",
+			first:  "admin-key",
+			second: "admin-key",
+			call:   getArticle,
+			code:   codes.ResourceExhausted,
+		},
+		{
+			name:   "known key after the address",
+			second: "admin-key",
+			call:   getArticle,
+			code:   codes.OK,
+		},
+		{
+			// an unknown key does not get the caller a new bucket
+			name:   "made up key after the address",
+			second: "made-up-key",
+			call:   getArticle,
+			code:   codes.ResourceExhausted,
+		},
+		{
+			name:   "streams are not limited",
+			first:  "admin-key",
+			second: "admin-key",
+			call: func(ctx context.Context, c articlepb.ArticlesClient) error {
+				s, err := c.ListArticles(ctx, &articlepb.ListArticlesRequest{})
+				if err != nil {
+					return err
+				}
+				for {
+					if _, err := s.Recv(); err != nil {
+						if err == io.EOF {
+							return nil
+						}
+						return err
+					}
+				}
+			},
+			code: codes.OK,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			c := grpcAPI(t, db, RateLimit{Limiter: ratelimit.NewMemory(), Read: one, Write: one})
+			withKey := func(key string) context.Context {
+				if key == "" {
+					return context.Background()
+				}
+				return metadata.AppendToOutgoingContext(context.Background(), handlers.APIKeyHeader, key)
+			}
+
+			if err := tc.call(withKey(tc.first), c); err != nil {
+				t.Fatalf("first call: %v", err)
+			}
+			var md metadata.MD
+			err := tc.call(withKey(tc.second), c)
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("got %v, want %v", code, tc.code)
+			}
+			if tc.code == codes.ResourceExhausted {
+				_, err := c.GetArticle(withKey(tc.second), &articlepb.GetArticleRequest{Id: 1}, grpc.Header(&md))
+				if status.Code(err) != codes.ResourceExhausted || len(md.Get("retry-after")) != 1 {
+					t.Errorf("expected a retry-after header, got %v", md)
+				}
+			}
+		})
+	}
+}
+
+func getArticle(ctx context.Context, c articlepb.ArticlesClient) error {
+	_, err := c.GetArticle(ctx, &articlepb.GetArticleRequest{Id: 1})
+	return err
+}
@@ -0,0 +1,193 @@
+// Package server routes the requests of the REST API to its handlers, for
+// the service and for the tests of the packages calling it.
+package server
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sg83/go-microservice/article-api/config"
+	"github.com/sg83/go-microservice/article-api/handlers"
+	"github.com/sg83/go-microservice/article-api/idempotency"
+	"github.com/sg83/go-microservice/article-api/openapi"
+	"github.com/sg83/go-microservice/article-api/ratelimit"
+	"github.com/sg83/go-microservice/article-api/tracing"
+	"go.uber.org/zap"
+)
+
+// Routes are what the router dispatches requests to. The handlers of the
+// disabled features are nil, Articles, Webhooks, Health and APIKeys are
+// required.
+type Routes struct {
+	Articles *handlers.Articles
+	Webhooks *handlers.Webhooks
+	Health   *handlers.Health
+	Events   *handlers.Events
+	GraphQL  *handlers.GraphQL
+	Docs     *openapi.Handler
+
+	APIKeys *handlers.APIKeys
+	// nil when rate limiting is disabled
+	Limiter ratelimit.Limiter
+	// nil when idempotency keys are disabled
+	Idempotency idempotency.Store
+	// proxies whose X-Forwarded-For header identifies clients
+	Trusted []*net.IPNet
+}
+
+// apiVersion is a version of the REST API, served under its prefix. Each
+// version serves the routes of the one before it, with the handlers of the
+// routes whose responses changed replaced.
+type apiVersion struct {
+	prefix string
+	// GET /tags/{tag}/{date}
+	tagSummary http.HandlerFunc
+}
+
+// apiVersions returns the versions of the REST API, oldest first, whose
+// prefixes are the openapi.Versions
+func apiVersions(rt Routes) []apiVersion {
+	v1 := apiVersion{prefix: "/v1", tagSummary: rt.Articles.GetTagSummary}
+
+	// the ids of the articles of tag summaries are strings. Only the tag
+	// summary changes: GraphQL and the event stream return whole articles
+	// rather than their ids, and are served as in v1.
+	v2 := v1
+	v2.prefix = "/v2"
+	v2.tagSummary = rt.Articles.GetTagSummaryV2
+
+	return []apiVersion{v1, v2}
+}
+
+// NewRouter returns the router of the REST API, every route of which is
+// documented in the OpenAPI document
+func NewRouter(logger *zap.Logger, cfg *config.Config, rt Routes) (*mux.Router, error) {
+	//Create a new serve mux
+	sm := mux.NewRouter()
+	rl := handlers.MiddlewareRequestLogger(logger)
+	cl := handlers.MiddlewareClient(rt.APIKeys, rt.Trusted)
+	sm.Use(tracing.Middleware(), cl, rl)
+	sm.NotFoundHandler = cl(rl(http.HandlerFunc(handlers.NotFound)))
+	sm.MethodNotAllowedHandler = cl(rl(http.HandlerFunc(handlers.MethodNotAllowed)))
+
+	// probes and docs are neither versioned nor rate limited. The metrics
+	// are served on their own address, see handlers.Metrics.
+	opsR := sm.Methods(http.MethodGet).Subrouter()
+	opsR.HandleFunc("/healthz", rt.Health.Live)
+	opsR.HandleFunc("/readyz", rt.Health.Ready)
+	if rt.Docs != nil {
+		opsR.HandleFunc("/openapi.json", rt.Docs.Spec)
+		opsR.HandleFunc("/docs", rt.Docs.Docs)
+		opsR.HandleFunc("/docs/{asset}", rt.Docs.Asset)
+	}
+
+	versions := apiVersions(rt)
+	for _, v := range versions {
+		mountAPI(sm.PathPrefix(v.prefix).Subrouter(), logger, cfg, rt, v)
+	}
+
+	// the routes of the first version are kept without prefix for the
+	// clients written before versioning, until they are disabled after
+	// their sunset
+	if cfg.Legacy.Enabled {
+		deprecated, sunset, err := cfg.Legacy.Dates()
+		if err != nil {
+			return nil, err
+		}
+		legacy := versions[0]
+		legacyR := sm.NewRoute().Subrouter()
+		legacyR.Use(handlers.MiddlewareDeprecation(deprecated, sunset, legacy.prefix))
+		legacy.prefix = ""
+		mountAPI(legacyR, logger, cfg, rt, legacy)
+	}
+
+	return sm, nil
+}
+
+// mountAPI registers the routes of version v of the REST API on r
+func mountAPI(r *mux.Router, logger *zap.Logger, cfg *config.Config, rt Routes, v apiVersion) {
+	//Register handlers for the API's
+	ah := rt.Articles
+	getR := r.Methods(http.MethodGet).Subrouter()
+	getR.HandleFunc("/articles/{id:[0-9]+}", ah.Get)
+	getR.HandleFunc("/tags/{tag}/{date}", v.tagSummary)
+
+	postR := r.Methods(http.MethodPost).Subrouter()
+	postR.HandleFunc("/articles", ah.Create)
+
+	// bulk imports are validated item by item by the handler
+	bulkR := r.Methods(http.MethodPost).Subrouter()
+	bulkR.HandleFunc("/articles:bulk", ah.CreateBulk)
+
+	// GraphQL queries are posted but only read
+	graphqlR := r.Methods(http.MethodPost).Subrouter()
+
+	// Rate limit every client, separately for reads and writes
+	if rt.Limiter != nil {
+		rdl := handlers.MiddlewareRateLimit(logger, rt.Limiter, "read", Limit(cfg.RateLimit.Read))
+		getR.Use(rdl)
+		graphqlR.Use(rdl)
+		wl := handlers.MiddlewareRateLimit(logger, rt.Limiter, "write", Limit(cfg.RateLimit.Write))
+		postR.Use(wl)
+		bulkR.Use(wl)
+	}
+	postR.Use(
+		handlers.MiddlewareContentType("application/json"),
+		handlers.MiddlewareMaxBodySize(int64(cfg.Server.BodyLimits.Articles)),
+	)
+	bulkR.Use(
+		handlers.MiddlewareContentType("application/json", handlers.NDJSONContentType),
+		handlers.MiddlewareMaxBodySize(int64(cfg.Server.BodyLimits.Bulk)),
+	)
+	graphqlR.Use(
+		handlers.MiddlewareContentType("application/json"),
+		handlers.MiddlewareMaxBodySize(int64(cfg.Server.BodyLimits.Articles)),
+	)
+
+	// Retried writes with the same Idempotency-Key get the first response
+	// back. Bulk imports are left out, their bodies and reports being too
+	// large to buffer and store.
+	if rt.Idempotency != nil {
+		postR.Use(handlers.MiddlewareIdempotency(logger, rt.Idempotency, time.Duration(cfg.Idempotency.TTL)))
+	}
+	postR.Use(ah.MiddlewareValidateArticle)
+
+	// exports, imports and webhooks are restricted to admin keys
+	wh := rt.Webhooks
+	adminR := r.NewRoute().Subrouter()
+	adminR.Use(handlers.MiddlewareRequireScope(rt.APIKeys, handlers.ScopeAdmin))
+	adminR.HandleFunc("/export", ah.Export).Methods(http.MethodGet)
+	importR := adminR.Methods(http.MethodPost).Subrouter()
+	importR.HandleFunc("/import", ah.Import)
+	importR.Use(
+		handlers.MiddlewareContentType(handlers.NDJSONContentType, "text/csv", "application/gzip"),
+		handlers.MiddlewareMaxBodySize(int64(cfg.Server.BodyLimits.Import)),
+	)
+	adminR.HandleFunc("/webhooks", wh.List).Methods(http.MethodGet)
+	adminR.HandleFunc("/webhooks/{id:[0-9]+}", wh.Delete).Methods(http.MethodDelete)
+	adminR.HandleFunc("/webhooks/dead-letters", wh.DeadLetters).Methods(http.MethodGet)
+	adminR.HandleFunc("/webhooks/dead-letters/{id:[0-9]+}/retry", wh.Retry).Methods(http.MethodPost)
+	webhooksR := adminR.Methods(http.MethodPost).Subrouter()
+	webhooksR.HandleFunc("/webhooks", wh.Create)
+	webhooksR.Use(
+		handlers.MiddlewareContentType("application/json"),
+		handlers.MiddlewareMaxBodySize(int64(cfg.Server.BodyLimits.Articles)),
+	)
+
+	if rt.Events != nil {
+		getR.HandleFunc("/events", rt.Events.Stream)
+	}
+
+	// Answer queries for articles, tags and their summaries in one round trip
+	if rt.GraphQL != nil {
+		getR.HandleFunc("/graphql", rt.GraphQL.Query)
+		graphqlR.HandleFunc("/graphql", rt.GraphQL.Query)
+	}
+}
+
+// Limit converts a configured rate limit
+func Limit(l config.Limit) ratelimit.Limit {
+	return ratelimit.Limit{Requests: l.Requests, Period: time.Duration(l.Period), Burst: l.Burst}
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sg83/go-microservice/article-api/config"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/graphql"
+	"github.com/sg83/go-microservice/article-api/handlers"
+	"github.com/sg83/go-microservice/article-api/idempotency"
+	"github.com/sg83/go-microservice/article-api/mocks"
+	"github.com/sg83/go-microservice/article-api/openapi"
+	"github.com/sg83/go-microservice/article-api/ratelimit"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+// pathVar matches the variables of route templates, with their pattern
+var pathVar = regexp.MustCompile(`\{(\w+)(:[^}]*)?\}`)
+
+// TestRoutesDocumented fails when a route of the router, with every feature
+// enabled, is missing from the OpenAPI document or the other way around
+func TestRoutesDocumented(t *testing.T) {
+	l := zap.NewNop()
+	v := data.NewValidation()
+	docs, err := openapi.NewHandler(config.Default().Docs.SwaggerUIURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := Routes{
+		Articles:    handlers.NewArticles(l, &mocks.ArticlesData{}, v),
+		Webhooks:    handlers.NewWebhooks(l, &mocks.WebhooksData{}, v),
+		Health:      handlers.NewHealth(l),
+		Events:      handlers.NewEvents(l, nil, time.Second, time.Minute),
+		GraphQL:     handlers.NewGraphQL(l, &mocks.ArticlesData{}, graphql.Limits{MaxDepth: 10, MaxComplexity: 1000}),
+		Docs:        docs,
+		APIKeys:     handlers.NewAPIKeys(nil),
+		Limiter:     ratelimit.NewMemory(),
+		Idempotency: idempotency.NewMemory(),
+	}
+	sm, err := NewRouter(l, config.Default(), rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routed := map[string]bool{}
+	err = sm.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		// the methods are matched by the route or by a subrouter above it
+		methods, err := route.GetMethods()
+		for i := len(ancestors) - 1; err != nil && i >= 0; i-- {
+			methods, err = ancestors[i].GetMethods()
+		}
+		if err != nil {
+			t.Errorf("route %s matches every method", tpl)
+		}
+		for _, m := range methods {
+			routed[m+" "+pathVar.ReplaceAllString(tpl, "{$1}")] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := openapi.Embedded()
+	if err != nil {
+		t.Fatal(err)
+	}
+	documented := map[string]bool{}
+	for path, item := range doc.Paths {
+		for m := range *item {
+			documented[strings.ToUpper(m)+" "+path] = true
+		}
+	}
+
+	// the metrics are served on their own address
+	if routed["GET /metrics"] {
+		t.Error("GET /metrics is routed on the API address")
+	}
+
+	for _, r := range diff(routed, documented) {
+		t.Errorf("%s is routed but not in the OpenAPI document", r)
+	}
+	for _, r := range diff(documented, routed) {
+		t.Errorf("%s is in the OpenAPI document but not routed", r)
+	}
+}
+
+// diff returns the keys of a missing from b, sorted
+func diff(a, b map[string]bool) []string {
+	var keys []string
+	for k := range a {
+		if !b[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestVersions(t *testing.T) {
+	article := &data.Article{ID: 1, Title: "Title 1", Date: "2016-09-22", Body: "Body 1", Tags: []string{"health"}}
+	tt := []struct {
+		name   string
+		legacy config.Legacy
+		path   string
+		status int
+		body   string
+		// expected headers, "" when they must be absent
+		headers map[string]string
+	}{
+		{
+			name:    "v1",
+			path:    "/v1/tags/health/20160922",
+			status:  http.StatusOK,
+			body:    `"articles":[1]`,
+			headers: map[string]string{"Deprecation": "", "Sunset": ""},
+		},
+		{
+			name:    "v2 tag summary",
+			path:    "/v2/tags/health/20160922",
+			status:  http.StatusOK,
+			body:    `"articles":["1"]`,
+			headers: map[string]string{"Deprecation": ""},
+		},
+		{
+			name:   "v2 inherits v1",
+			path:   "/v2/articles/1",
+			status: http.StatusOK,
+			body:   `"title":"Title 1"`,
+		},
+		{
+			name:   "legacy",
+			legacy: config.Legacy{Enabled: true, Deprecated: "2026-10-19", Sunset: "2027-04-01"},
+			path:   "/tags/health/20160922",
+			status: http.StatusOK,
+			body:   `"articles":[1]`,
+			headers: map[string]string{
+				"Deprecation": "@1792368000",
+				"Sunset":      "Thu, 01 Apr 2027 00:00:00 GMT",
+				"Link":        `</v1/tags/health/20160922>; rel="successor-version"`,
+			},
+		},
+		{
+			name:    "legacy not found",
+			legacy:  config.Legacy{Enabled: true, Deprecated: "2026-10-19"},
+			path:    "/articles/2",
+			status:  http.StatusNotFound,
+			headers: map[string]string{"Deprecation": "@1792368000", "Sunset": ""},
+		},
+		{
+			name:   "legacy disabled",
+			path:   "/articles/1",
+			status: http.StatusNotFound,
+		},
+		{
+			name:    "probes are not versioned",
+			legacy:  config.Legacy{Enabled: true, Deprecated: "2026-10-19"},
+			path:    "/healthz",
+			status:  http.StatusOK,
+			headers: map[string]string{"Deprecation": ""},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &mocks.ArticlesData{}
+			db.On("GetArticleByID", mock.Anything, 1).Return(article, nil)
+			db.On("GetArticleByID", mock.Anything, 2).Return(nil, &data.NotFoundError{Resource: "article", Key: "2"})
+			db.On("GetArticlesForTagAndDate", mock.Anything, "health", "20160922").Return([]int{1}, nil)
+			db.On("GetTagLastModified", mock.Anything, "health", "20160922").Return(time.Time{}, nil)
+			db.On("GetRelatedTagsForTag", mock.Anything, "health", []int{1}).Return([]string{"fitness"}, nil)
+
+			l := zap.NewNop()
+			cfg := config.Default()
+			cfg.Legacy = tc.legacy
+			rt := Routes{
+				Articles: handlers.NewArticles(l, db, data.NewValidation()),
+				Webhooks: handlers.NewWebhooks(l, &mocks.WebhooksData{}, data.NewValidation()),
+				Health:   handlers.NewHealth(l),
+				APIKeys:  handlers.NewAPIKeys(nil),
+			}
+			sm, err := NewRouter(l, cfg, rt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := httptest.NewRecorder()
+			sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if w.Code != tc.status {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tc.status, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tc.body) {
+				t.Errorf("body %s does not contain %s", w.Body.String(), tc.body)
+			}
+			for k, v := range tc.headers {
+				if got := w.Header().Get(k); got != v {
+					t.Errorf("got %s %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestIdempotentRoutes(t *testing.T) {
+	tt := []struct {
+		name     string
+		path     string
+		body     string
+		setup    func(db *mocks.ArticlesData)
+		replayed bool
+	}{
+		{
+			name:     "article",
+			path:     "/v1/articles",
+			body:     `{"title":"Title 1","date":"2016-09-22","body":"Body 1","tags":["health"]}`,
+			setup:    func(db *mocks.ArticlesData) { db.On("AddArticle", mock.Anything, mock.Anything).Return(nil).Once() },
+			replayed: true,
+		},
+		{
+			// bulk reports are too large to store, every request is handled
+			name: "bulk",
+			path: "/v1/articles:bulk",
+			body: `[{"title":"Title 1","date":"2016-09-22","body":"Body 1","tags":["health"]}]`,
+			setup: func(db *mocks.ArticlesData) {
+				db.On("AddArticles", mock.Anything, mock.Anything).Return([]int{1}, nil).Twice()
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := &mocks.ArticlesData{}
+			tc.setup(db)
+			l := zap.NewNop()
+			rt := Routes{
+				Articles:    handlers.NewArticles(l, db, data.NewValidation()),
+				Webhooks:    handlers.NewWebhooks(l, &mocks.WebhooksData{}, data.NewValidation()),
+				Health:      handlers.NewHealth(l),
+				APIKeys:     handlers.NewAPIKeys(nil),
+				Idempotency: idempotency.NewMemory(),
+			}
+			sm, err := NewRouter(l, config.Default(), rt)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var w *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+				r.Header.Set("Content-Type", "application/json")
+				r.Header.Set(handlers.IdempotencyKeyHeader, "key-1")
+				w = httptest.NewRecorder()
+				sm.ServeHTTP(w, r)
+				if w.Code >= 300 {
+					t.Fatalf("request %d: got status %d: %s", i+1, w.Code, w.Body.String())
+				}
+			}
+			if got := w.Header().Get("Idempotent-Replayed") == "true"; got != tc.replayed {
+				t.Errorf("got replayed %v, want %v", got, tc.replayed)
+			}
+			db.AssertExpectations(t)
+		})
+	}
+}
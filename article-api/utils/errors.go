@@ -1,11 +1,46 @@
 package utils
 
-// GenericError is a generic error message returned by a server
-type GenericError struct {
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProblemContentType is the media type of Problem documents
+const ProblemContentType = "application/problem+json"
+
+// Problem is an error returned by the server, as described by RFC 7807
+type Problem struct {
+	// URI reference identifying the kind of problem
+	Type string `json:"type"`
+	// short summary of the kind of problem
+	Title string `json:"title"`
+	// HTTP status code of the response
+	Status int `json:"status"`
+	// explanation specific to this occurrence
+	Detail string `json:"detail,omitempty"`
+	// URI reference of the request the problem occurred on
+	Instance string `json:"instance,omitempty"`
+	// id of the request, to find it in the logs
+	RequestID string `json:"request_id,omitempty"`
+	// the invalid fields of the request body
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a field of the request body is invalid
+type FieldError struct {
+	// location of the field in the body, e.g. tags[1]
+	Field string `json:"field"`
+	// the validation rule that failed, e.g. required or max
+	Rule string `json:"rule"`
+	// parameter of the rule, e.g. the max length
+	Param string `json:"param,omitempty"`
+	// explanation for humans, in the language asked for with Accept-Language
 	Message string `json:"message"`
 }
 
-// ValidationError is a collection of validation error messages
-type ValidationError struct {
-	Messages []string `json:"messages"`
+// WriteProblem sends p as the response
+func WriteProblem(w http.ResponseWriter, p *Problem) error {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
 }
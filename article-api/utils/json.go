@@ -1,8 +1,13 @@
 package utils
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 )
 
 // ToJSON serializes the given interface into a string based JSON format
@@ -12,9 +17,203 @@ func ToJSON(i interface{}, w io.Writer) error {
 	return e.Encode(i)
 }
 
+// JSONError is returned by FromJSON when the input is not a valid JSON
+// document for the given interface
+type JSONError struct {
+	// offset in bytes of the error in the input
+	Offset int64
+	// what is wrong, for the client
+	Message string
+	// the decoder error, if any
+	Err error
+}
+
+func (e *JSONError) Error() string {
+	return fmt.Sprintf("%s at byte %d", e.Message, e.Offset)
+}
+
+func (e *JSONError) Unwrap() error {
+	return e.Err
+}
+
 // FromJSON deserializes the object from JSON string
-// in an io.Reader to the given interface
+// in an io.Reader to the given interface. The reader must hold a single
+// JSON value and fields unknown to the interface are rejected so that
+// typos are not silently ignored. Invalid input is reported as a
+// *JSONError, errors reading r are returned as is.
 func FromJSON(i interface{}, r io.Reader) error {
-	d := json.NewDecoder(r)
-	return d.Decode(i)
+	cr := &countingReader{r: r}
+	d := json.NewDecoder(cr)
+
+	// read the value first so that errors are located in the whole input
+	var raw json.RawMessage
+	if err := d.Decode(&raw); err != nil {
+		return readError(err, cr.n)
+	}
+	end := d.InputOffset()
+	start := end - int64(len(raw))
+
+	// only whitespace may follow the value
+	var extra json.RawMessage
+	switch err := d.Decode(&extra); {
+	case err == io.EOF:
+	case err == nil || isSyntaxError(err):
+		return &JSONError{Offset: end, Message: "unexpected data after the JSON value"}
+	default:
+		return err
+	}
+
+	vd := json.NewDecoder(bytes.NewReader(raw))
+	vd.DisallowUnknownFields()
+	if err := vd.Decode(i); err != nil {
+		return valueError(raw, start, err)
+	}
+	return nil
+}
+
+// readError describes an error reading a JSON value from the input, read
+// until offset
+func readError(err error, offset int64) error {
+	var se *json.SyntaxError
+	switch {
+	case errors.As(err, &se) && se.Error() != "unexpected end of JSON input":
+		return &JSONError{Offset: se.Offset, Message: "invalid JSON: " + se.Error(), Err: err}
+	case err == io.EOF:
+		return &JSONError{Offset: offset, Message: "the body is empty", Err: err}
+	case err == io.ErrUnexpectedEOF, se != nil:
+		// the tokenizer reports the end of the input as a syntax error
+		return &JSONError{Offset: offset, Message: "the JSON value is truncated", Err: err}
+	}
+	return err
+}
+
+func isSyntaxError(err error) bool {
+	var se *json.SyntaxError
+	return errors.As(err, &se)
+}
+
+// valueError describes an error decoding raw, found at offset start of the
+// input, into the given interface
+func valueError(raw []byte, start int64, err error) error {
+	var ute *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &ute):
+		msg := fmt.Sprintf("expected %s but got a JSON %s", ute.Type, ute.Value)
+		if ute.Field != "" {
+			msg = fmt.Sprintf("field %q must be of type %s, got a JSON %s", ute.Field, ute.Type, ute.Value)
+		}
+		return &JSONError{Offset: start + ute.Offset, Message: msg, Err: err}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// the decoder does not say where the field is, point at its name
+		name := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		offset := start
+		if i := bytes.Index(raw, []byte(name)); i >= 0 {
+			offset += int64(i)
+		}
+		return &JSONError{Offset: offset, Message: "unknown field " + name, Err: err}
+	}
+	return err
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ValueReader reads a sequence of JSON values one at a time, so that long
+// sequences need not be held in memory
+type ValueReader interface {
+	// Next returns the next value, undecoded, or io.EOF after the last
+	// one. Invalid input is reported as a *JSONError.
+	Next() (json.RawMessage, error)
+}
+
+// arrayReader reads the elements of a JSON array
+type arrayReader struct {
+	cr      *countingReader
+	d       *json.Decoder
+	started bool
+	done    bool
+}
+
+// NewArrayReader reads the elements of the JSON array held by r. Elements
+// must be valid JSON but are not checked any further, and only whitespace
+// may follow the array.
+func NewArrayReader(r io.Reader) ValueReader {
+	cr := &countingReader{r: r}
+	return &arrayReader{cr: cr, d: json.NewDecoder(cr)}
+}
+
+func (a *arrayReader) Next() (json.RawMessage, error) {
+	if a.done {
+		return nil, io.EOF
+	}
+	if !a.started {
+		tok, err := a.d.Token()
+		if err != nil {
+			return nil, readError(err, a.cr.n)
+		}
+		if tok != json.Delim('[') {
+			return nil, &JSONError{Offset: a.d.InputOffset(), Message: "expected a JSON array"}
+		}
+		a.started = true
+	}
+
+	if a.d.More() {
+		var raw json.RawMessage
+		if err := a.d.Decode(&raw); err != nil {
+			return nil, readError(err, a.cr.n)
+		}
+		return raw, nil
+	}
+
+	// the closing bracket, then nothing but whitespace
+	if _, err := a.d.Token(); err != nil {
+		return nil, readError(err, a.cr.n)
+	}
+	a.done = true
+	end := a.d.InputOffset()
+	switch _, err := a.d.Token(); {
+	case err == io.EOF:
+		return nil, io.EOF
+	case err == nil || isSyntaxError(err):
+		return nil, &JSONError{Offset: end, Message: "unexpected data after the JSON value"}
+	default:
+		return nil, err
+	}
+}
+
+// linesReader reads newline delimited JSON values
+type linesReader struct {
+	r *bufio.Reader
+}
+
+// NewLinesReader reads the values of the newline delimited JSON (NDJSON)
+// held by r, one per line. Blank lines are skipped and lines are not
+// checked to be valid JSON, so that a bad line does not prevent reading
+// the next ones.
+func NewLinesReader(r io.Reader) ValueReader {
+	return &linesReader{r: bufio.NewReader(r)}
+}
+
+func (l *linesReader) Next() (json.RawMessage, error) {
+	for {
+		line, err := l.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return json.RawMessage(line), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 }
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromJSON(t *testing.T) {
+	type article struct {
+		Title string   `json:"title"`
+		Tags  []string `json:"tags"`
+	}
+
+	tt := []struct {
+		name   string
+		input  string
+		offset int64
+		msg    string
+	}{
+		{name: "valid", input: `{"title": "Title", "tags": ["a"]}`},
+		{name: "trailing whitespace", input: "{\"title\": \"Title\"}\n"},
+		{name: "empty", input: ``, offset: 0, msg: "the body is empty"},
+		{name: "truncated", input: `{"title": `, offset: 10, msg: "the JSON value is truncated"},
+		{name: "syntax error", input: `{"title" "x"}`, offset: 10, msg: `invalid JSON: invalid character '"' after object key`},
+		{name: "wrong type", input: ` {"tags": "a"}`, offset: 13, msg: `field "tags" must be of type []string, got a JSON string`},
+		{name: "unknown field", input: `{"title": "x", "author": "me"}`, offset: 15, msg: `unknown field "author"`},
+		{name: "trailing value", input: `{"title": "x"} {}`, offset: 14, msg: "unexpected data after the JSON value"},
+		{name: "trailing garbage", input: `{"title": "x"}xyz`, offset: 14, msg: "unexpected data after the JSON value"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := FromJSON(&article{}, strings.NewReader(tc.input))
+			if tc.msg == "" {
+				if err != nil {
+					t.Fatalf("Expected no error but got %v", err)
+				}
+				return
+			}
+
+			var je *JSONError
+			if !errors.As(err, &je) {
+				t.Fatalf("Expected a *JSONError but got %v", err)
+			}
+			if je.Offset != tc.offset || je.Message != tc.msg {
+				t.Errorf("Expected %q at byte %d but got %q at byte %d", tc.msg, tc.offset, je.Message, je.Offset)
+			}
+		})
+	}
+}
+
+// readAll reads every value of vr, as strings
+func readAll(vr ValueReader) ([]string, error) {
+	var values []string
+	for {
+		v, err := vr.Next()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return values, err
+		}
+		values = append(values, string(v))
+	}
+}
+
+func TestValueReaders(t *testing.T) {
+	tt := []struct {
+		name   string
+		vr     ValueReader
+		values []string
+		offset int64
+		msg    string
+	}{
+		{
+			name:   "array",
+			vr:     NewArrayReader(strings.NewReader(` [{"a": 1}, {"a": "x", "b": true}, 3]` + "\n")),
+			values: []string{`{"a": 1}`, `{"a": "x", "b": true}`, `3`},
+		},
+		{name: "empty array", vr: NewArrayReader(strings.NewReader(`[]`))},
+		{name: "not an array", vr: NewArrayReader(strings.NewReader(`{"a": 1}`)), offset: 1, msg: "expected a JSON array"},
+		{
+			name:   "bad element",
+			vr:     NewArrayReader(strings.NewReader(`[{"a": 1}, {"a" 2}]`)),
+			values: []string{`{"a": 1}`},
+			offset: 17,
+			msg:    `invalid JSON: invalid character '2' after object key`,
+		},
+		{
+			name:   "truncated array",
+			vr:     NewArrayReader(strings.NewReader(`[{"a": 1}, `)),
+			values: []string{`{"a": 1}`},
+			offset: 11,
+			msg:    "the JSON value is truncated",
+		},
+		{
+			name:   "data after the array",
+			vr:     NewArrayReader(strings.NewReader(`[1] [2]`)),
+			values: []string{`1`},
+			offset: 3,
+			msg:    "unexpected data after the JSON value",
+		},
+		{
+			name:   "lines",
+			vr:     NewLinesReader(strings.NewReader("{\"a\": 1}\n\n  {\"a\" 2}\r\n{\"a\": 3}")),
+			values: []string{`{"a": 1}`, `{"a" 2}`, `{"a": 3}`},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			values, err := readAll(tc.vr)
+			if !reflect.DeepEqual(values, tc.values) {
+				t.Errorf("Expected values %q but got %q", tc.values, values)
+			}
+			if tc.msg == "" {
+				if err != nil {
+					t.Errorf("Expected no error but got %v", err)
+				}
+				return
+			}
+			var je *JSONError
+			if !errors.As(err, &je) {
+				t.Fatalf("Expected a *JSONError but got %v", err)
+			}
+			if je.Offset != tc.offset || je.Message != tc.msg {
+				t.Errorf("Expected %q at byte %d but got %q at byte %d", tc.msg, tc.offset, je.Message, je.Offset)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pruneInterval is the time between removals of old events
+const pruneInterval = time.Hour
+
+// Pruner removes old events from the outbox
+type Pruner interface {
+	// PruneEvents removes the events recorded before t whose deliveries
+	// are done
+	PruneEvents(ctx context.Context, t time.Time) (int64, error)
+}
+
+// Prune removes the events recorded more than retention ago every hour
+// until ctx is done. It runs whether or not webhooks are dispatched, since
+// every write records an event and the outbox would otherwise keep growing.
+// Events with deliveries left to attempt are kept.
+func Prune(ctx context.Context, l *zap.Logger, p Pruner, retention time.Duration) {
+	prune(ctx, l, p, retention, pruneInterval, time.Now)
+}
+
+func prune(ctx context.Context, l *zap.Logger, p Pruner, retention, interval time.Duration, now func() time.Time) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if n, err := p.PruneEvents(ctx, now().Add(-retention)); err != nil && ctx.Err() == nil {
+			l.Error("Pruning events failed", zap.Error(err))
+		} else if n > 0 {
+			l.Info("Pruned events", zap.Int64("count", n))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pruner records the times it is asked to prune the events before
+type pruner struct {
+	before chan time.Time
+	err    error
+}
+
+func (p *pruner) PruneEvents(ctx context.Context, t time.Time) (int64, error) {
+	p.before <- t
+	return 1, p.err
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2023, 4, 5, 10, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		name string
+		err  error
+	}{
+		{name: "pruned"},
+		// a failure is retried at the next interval
+		{name: "failing", err: errors.New("connection refused")},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &pruner{before: make(chan time.Time, 100), err: tc.err}
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				prune(ctx, zap.NewNop(), p, time.Hour, time.Millisecond, func() time.Time { return now })
+				close(done)
+			}()
+
+			for i := 0; i < 2; i++ {
+				select {
+				case before := <-p.before:
+					if want := now.Add(-time.Hour); !before.Equal(want) {
+						t.Errorf("Pruned the events before %v, want %v", before, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Only pruned %d times", i)
+				}
+			}
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("prune did not return once its context was done")
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+// Package webhook delivers the article events recorded in the outbox to the
+// registered webhooks.
+//
+// Every event is posted as JSON to each webhook subscribed to its type, at
+// least once and in no particular order. Requests carry the event id in the
+// Webhook-Id header, which receivers can use to drop duplicates, and are
+// signed with the secret of the webhook: Webhook-Signature holds v1= followed
+// by the hex HMAC-SHA256 of the Webhook-Timestamp header value, a dot and the
+// body. Failed deliveries are retried with exponential backoff until they
+// are dead lettered.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sg83/go-microservice/article-api/data"
+	"github.com/sg83/go-microservice/article-api/internal/backoff"
+	"github.com/sg83/go-microservice/article-api/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Headers of deliveries
+const (
+	HeaderID        = "Webhook-Id"
+	HeaderTimestamp = "Webhook-Timestamp"
+	HeaderSignature = "Webhook-Signature"
+)
+
+const (
+	userAgent = "article-api-webhooks"
+	// leaseMargin is how long a claimed delivery is kept from other
+	// dispatchers on top of the request timeout
+	leaseMargin = 30 * time.Second
+	// maxErrorLen bounds the error stored for a failed attempt
+	maxErrorLen = 1024
+)
+
+var (
+	// ErrInvalidSignature is returned by Verify when no signature matches
+	ErrInvalidSignature = errors.New("webhook: invalid signature")
+	// ErrExpired is returned by Verify when the delivery is too old
+	ErrExpired = errors.New("webhook: timestamp outside of tolerance")
+)
+
+var tracer = tracing.Tracer("github.com/sg83/go-microservice/article-api/webhook")
+
+var attempts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "article_api",
+	Subsystem: "webhook",
+	Name:      "delivery_attempts_total",
+	Help:      "Webhook delivery attempts by result (delivered, failed or dead).",
+}, []string{"result"})
+
+// Queue holds the deliveries to attempt
+type Queue interface {
+	// ClaimDeliveries returns up to n due deliveries, that are not
+	// returned again for lease
+	ClaimDeliveries(ctx context.Context, n int, lease time.Duration) ([]data.Delivery, error)
+	CompleteDelivery(ctx context.Context, id int64) error
+	// FailDelivery schedules the delivery again at retryAt, or dead letters
+	// it when retryAt is zero
+	FailDelivery(ctx context.Context, id int64, reason string, retryAt time.Time) error
+}
+
+// Options configure a Dispatcher
+type Options struct {
+	// time between polls of the queue when it is drained
+	Interval time.Duration
+	// max time a webhook has to answer
+	Timeout time.Duration
+	// max number of deliveries attempted at once
+	BatchSize int
+	// attempts of a delivery before it is dead lettered
+	MaxAttempts int
+	// delay before the first retry, doubled on every retry up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Dispatcher posts the queued deliveries to their webhooks
+type Dispatcher struct {
+	l      *zap.Logger
+	q      Queue
+	o      Options
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewDispatcher returns a Dispatcher attempting the deliveries of q
+func NewDispatcher(l *zap.Logger, q Queue, o Options) *Dispatcher {
+	client := &http.Client{
+		Timeout: o.Timeout,
+		// a redirect is not an acknowledgement, and is not followed so that
+		// events are only sent to the registered URL
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return &Dispatcher{l: l, q: q, o: o, client: client, now: time.Now}
+}
+
+// Run attempts the due deliveries until ctx is done, polling the queue
+// every interval once it is drained
+func (d *Dispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(d.o.Interval)
+	defer t.Stop()
+
+	for {
+		n, err := d.Dispatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.l.Error("Claiming webhook deliveries failed", zap.Error(err))
+		}
+
+		// keep going while there may be more due deliveries
+		if n == d.o.BatchSize && err == nil && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Dispatch attempts a batch of due deliveries concurrently and records
+// their outcome. It returns the number of deliveries attempted.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	ds, err := d.q.ClaimDeliveries(ctx, d.o.BatchSize, d.o.Timeout+leaseMargin)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, dl := range ds {
+		wg.Add(1)
+		go func(dl data.Delivery) {
+			defer wg.Done()
+			d.attempt(ctx, dl)
+		}(dl)
+	}
+	wg.Wait()
+	return len(ds), nil
+}
+
+// attempt delivers dl and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, dl data.Delivery) {
+	l := d.l.With(zap.Int64("delivery", dl.ID), zap.Int("webhook", dl.WebhookID), zap.Int64("event", dl.Event.ID))
+
+	err := d.deliver(ctx, dl)
+	if err == nil {
+		attempts.WithLabelValues("delivered").Inc()
+		if err := d.q.CompleteDelivery(ctx, dl.ID); err != nil {
+			l.Error("Recording webhook delivery failed", zap.Error(err))
+		}
+		return
+	}
+
+	n := dl.Attempts + 1
+	var retryAt time.Time
+	if n < d.o.MaxAttempts {
+		retryAt = d.now().Add(backoff.Delay(n-1, d.o.InitialBackoff, d.o.MaxBackoff))
+		attempts.WithLabelValues("failed").Inc()
+		l.Warn("Webhook delivery failed", zap.Error(err), zap.Int("attempt", n), zap.Time("retry_at", retryAt))
+	} else {
+		attempts.WithLabelValues("dead").Inc()
+		l.Error("Webhook delivery dead lettered", zap.Error(err), zap.Int("attempt", n))
+	}
+
+	reason := err.Error()
+	if len(reason) > maxErrorLen {
+		reason = reason[:maxErrorLen]
+	}
+	if err := d.q.FailDelivery(ctx, dl.ID, reason, retryAt); err != nil {
+		l.Error("Recording webhook delivery failed", zap.Error(err))
+	}
+}
+
+// deliver posts the event of dl to its webhook, a 2xx answer acknowledges it
+func (d *Dispatcher) deliver(ctx context.Context, dl data.Delivery) (err error) {
+	ctx, span := tracer.Start(ctx, "webhook.deliver",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethod(http.MethodPost),
+			attribute.Int64("webhook.event.id", dl.Event.ID),
+			attribute.String("webhook.event.type", dl.Event.Type),
+		),
+	)
+	defer func() { tracing.EndSpan(span, err) }()
+
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(HeaderID, strconv.FormatInt(dl.Event.ID, 10))
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, Sign(dl.Secret, ts, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so that the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	span.SetAttributes(semconv.HTTPStatusCode(resp.StatusCode))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the Webhook-Signature header value of a delivery of body at
+// timestamp, the Webhook-Timestamp header value
+func Sign(secret string, timestamp string, body []byte) string {
+	return "v1=" + hex.EncodeToString(mac(secret, timestamp, body))
+}
+
+// Verify checks the signature of a delivery with the headers h and the
+// body, and that it was sent within tolerance of now unless tolerance is
+// zero. Receivers should use it before trusting a delivery.
+func Verify(secret string, h http.Header, body []byte, tolerance time.Duration) error {
+	ts := h.Get(HeaderTimestamp)
+	sent, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(sent, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return ErrExpired
+	}
+
+	expected := mac(secret, ts, body)
+	// several signatures may be sent, e.g. while a secret is rotated
+	for _, sig := range strings.Fields(strings.ReplaceAll(h.Get(HeaderSignature), ",", " ")) {
+		if !strings.HasPrefix(sig, "v1=") {
+			continue
+		}
+		if got, err := hex.DecodeString(sig[len("v1="):]); err == nil && hmac.Equal(got, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// mac is the HMAC-SHA256 of the timestamp and body with the secret
+func mac(secret string, timestamp string, body []byte) []byte {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(timestamp))
+	m.Write([]byte("."))
+	m.Write(body)
+	return m.Sum(nil)
+}
@@ -0,0 +1,280 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sg83/go-microservice/article-api/data"
+	"go.uber.org/zap"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+// queue is an in-memory Queue
+type queue struct {
+	mu      sync.Mutex
+	ds      map[int64]*data.Delivery
+	retryAt map[int64]time.Time
+	claimed map[int64]bool
+}
+
+func newQueue(ds ...data.Delivery) *queue {
+	q := &queue{ds: map[int64]*data.Delivery{}, retryAt: map[int64]time.Time{}, claimed: map[int64]bool{}}
+	for i := range ds {
+		q.ds[ds[i].ID] = &ds[i]
+	}
+	return q
+}
+
+func (q *queue) ClaimDeliveries(ctx context.Context, n int, lease time.Duration) ([]data.Delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var ds []data.Delivery
+	for id, d := range q.ds {
+		if len(ds) == n {
+			break
+		}
+		if d.Status == data.DeliveryPending && !q.claimed[id] && !q.retryAt[id].After(time.Now()) {
+			q.claimed[id] = true
+			ds = append(ds, *d)
+		}
+	}
+	return ds, nil
+}
+
+func (q *queue) CompleteDelivery(ctx context.Context, id int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ds[id].Status = data.DeliveryDelivered
+	q.ds[id].Attempts++
+	q.claimed[id] = false
+	return nil
+}
+
+func (q *queue) FailDelivery(ctx context.Context, id int64, reason string, retryAt time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d := q.ds[id]
+	d.Attempts++
+	d.LastError = reason
+	if retryAt.IsZero() {
+		d.Status = data.DeliveryDead
+	}
+	q.retryAt[id] = retryAt
+	q.claimed[id] = false
+	return nil
+}
+
+func (q *queue) get(id int64) data.Delivery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return *q.ds[id]
+}
+
+// receiver records the verified deliveries it gets, and answers with the
+// status of the event, 200 by default
+type receiver struct {
+	mu       sync.Mutex
+	events   []data.Event
+	status   map[int64]int
+	received chan struct{}
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if err := Verify(secret, r.Header, body, time.Minute); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var e data.Event
+	if err := json.Unmarshal(body, &e); err != nil || r.Header.Get(HeaderID) != strconv.FormatInt(e.ID, 10) {
+		http.Error(w, "bad event", http.StatusBadRequest)
+		return
+	}
+
+	rc.mu.Lock()
+	rc.events = append(rc.events, e)
+	status := rc.status[e.ID]
+	rc.mu.Unlock()
+	if rc.received != nil {
+		rc.received <- struct{}{}
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status == http.StatusFound {
+		w.Header().Set("Location", "/elsewhere")
+	}
+	w.WriteHeader(status)
+}
+
+func delivery(id int64, url string, attempts int) data.Delivery {
+	return data.Delivery{
+		ID:        id,
+		WebhookID: 1,
+		Status:    data.DeliveryPending,
+		Attempts:  attempts,
+		URL:       url,
+		Secret:    secret,
+		Event: data.Event{
+			ID:        id * 10,
+			Type:      data.EventArticleCreated,
+			CreatedAt: time.Now().UTC(),
+			Data:      json.RawMessage(`{"id":` + strconv.FormatInt(id, 10) + `,"title":"Title"}`),
+		},
+	}
+}
+
+func testOptions() Options {
+	return Options{
+		Interval:       10 * time.Millisecond,
+		Timeout:        time.Second,
+		BatchSize:      10,
+		MaxAttempts:    3,
+		InitialBackoff: time.Minute,
+		MaxBackoff:     time.Hour,
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	rc := &receiver{status: map[int64]int{20: http.StatusInternalServerError, 30: http.StatusServiceUnavailable, 40: http.StatusFound}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	q := newQueue(
+		delivery(1, srv.URL, 0),
+		delivery(2, srv.URL, 0),
+		delivery(3, srv.URL, 2),
+		delivery(4, srv.URL, 0),
+		delivery(5, srv.URL, 0),
+	)
+	q.ds[5].Secret = "another secret of the right size"
+
+	d := NewDispatcher(zap.NewNop(), q, testOptions())
+	n, err := d.Dispatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Fatalf("Expected 5 deliveries to be attempted but got %d", n)
+	}
+
+	tt := []struct {
+		id       int64
+		status   string
+		attempts int
+		retried  bool
+		error    string
+	}{
+		{1, data.DeliveryDelivered, 1, false, ""},
+		{2, data.DeliveryPending, 1, true, "webhook answered 500 Internal Server Error"},
+		{3, data.DeliveryDead, 3, false, "webhook answered 503 Service Unavailable"},
+		{4, data.DeliveryPending, 1, true, "webhook answered 302 Found"},
+		{5, data.DeliveryPending, 1, true, "webhook answered 401 Unauthorized"},
+	}
+	for _, tc := range tt {
+		got := q.get(tc.id)
+		if got.Status != tc.status || got.Attempts != tc.attempts || got.LastError != tc.error {
+			t.Errorf("Delivery %d: expected %s after %d attempts with error %q but got %s after %d with %q",
+				tc.id, tc.status, tc.attempts, tc.error, got.Status, got.Attempts, got.LastError)
+		}
+		retryAt := q.retryAt[tc.id]
+		if tc.retried != !retryAt.IsZero() {
+			t.Errorf("Delivery %d: expected retry %t but got %v", tc.id, tc.retried, retryAt)
+		}
+		// the first retry is after 30s to 1m
+		if tc.retried && (time.Until(retryAt) < 29*time.Second || time.Until(retryAt) > time.Minute) {
+			t.Errorf("Delivery %d: retry at %v is not within the first backoff", tc.id, retryAt)
+		}
+	}
+
+	// retried deliveries are not due yet
+	n, err = d.Dispatch(context.Background())
+	if err != nil || n != 0 {
+		t.Errorf("Expected no due delivery but got %d, %v", n, err)
+	}
+	if len(rc.events) != 4 {
+		t.Errorf("Expected 4 verified deliveries but got %d", len(rc.events))
+	}
+}
+
+func TestRun(t *testing.T) {
+	rc := &receiver{received: make(chan struct{}, 10)}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	// more deliveries than a batch are all attempted
+	var ds []data.Delivery
+	for i := int64(1); i <= 5; i++ {
+		ds = append(ds, delivery(i, srv.URL, 0))
+	}
+	q := newQueue(ds...)
+	o := testOptions()
+	o.BatchSize = 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewDispatcher(zap.NewNop(), q, o).Run(ctx)
+		close(done)
+	}()
+	for i := 0; i < 5; i++ {
+		select {
+		case <-rc.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Only %d deliveries were received", i)
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return once its context was done")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	headers := func(ts string, sig string) http.Header {
+		h := http.Header{}
+		h.Set(HeaderTimestamp, ts)
+		h.Set(HeaderSignature, sig)
+		return h
+	}
+
+	tt := []struct {
+		name      string
+		header    http.Header
+		body      []byte
+		tolerance time.Duration
+		err       error
+	}{
+		{"valid", headers(now, Sign(secret, now, body)), body, time.Minute, nil},
+		{"one of several signatures", headers(now, "v1=00ff, "+Sign(secret, now, body)), body, time.Minute, nil},
+		{"tampered body", headers(now, Sign(secret, now, body)), []byte(`{"id":2}`), time.Minute, ErrInvalidSignature},
+		{"other secret", headers(now, Sign("other", now, body)), body, time.Minute, ErrInvalidSignature},
+		{"other timestamp", headers(now, Sign(secret, old, body)), body, time.Minute, ErrInvalidSignature},
+		{"expired", headers(old, Sign(secret, old, body)), body, time.Minute, ErrExpired},
+		{"no tolerance", headers(old, Sign(secret, old, body)), body, 0, nil},
+		{"missing timestamp", headers("", Sign(secret, "", body)), body, 0, ErrInvalidSignature},
+		{"unknown version", headers(now, strings.Replace(Sign(secret, now, body), "v1", "v2", 1)), body, 0, ErrInvalidSignature},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := Verify(secret, tc.header, tc.body, tc.tolerance); err != tc.err {
+				t.Errorf("Expected %v but got %v", tc.err, err)
+			}
+		})
+	}
+}